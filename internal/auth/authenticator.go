@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidCredentials means the authenticator knows the user but the
+	// password is wrong, or the user may not sign in.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnknownUser means the authenticator doesn't know the user, so the
+	// next one in a Chain may.
+	ErrUnknownUser = errors.New("unknown user")
+)
+
+// Identity is who an Authenticator found behind a username and password.
+type Identity struct {
+	Username string
+	Role     string
+	// Source names the authenticator that vouched for the user, "local" or
+	// "ldap".
+	Source string
+}
+
+// Authenticator checks a username and password. Errors other than
+// ErrInvalidCredentials and ErrUnknownUser mean it couldn't tell, such as
+// when a directory is unreachable.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (Identity, error)
+}
+
+// LocalUser is what the Local authenticator needs to know of an account.
+type LocalUser struct {
+	PasswordHash string
+	Role         string
+	Disabled     bool
+}
+
+// Local checks passwords against the Argon2 hashes of local accounts.
+type Local struct {
+	// Lookup returns the account of a username, or ErrUnknownUser.
+	Lookup func(username string) (LocalUser, error)
+	// AdminsOnly limits local sign-in to admins, the break-glass accounts
+	// for when another authenticator is the main one.
+	AdminsOnly bool
+}
+
+func (l Local) Authenticate(_ context.Context, username, password string) (Identity, error) {
+	u, err := l.Lookup(username)
+	if err != nil {
+		return Identity{}, err
+	}
+	if u.Disabled || (l.AdminsOnly && u.Role != RoleAdmin) {
+		return Identity{}, ErrInvalidCredentials
+	}
+	ok, err := VerifyPassword(u.PasswordHash, password)
+	if err != nil {
+		return Identity{}, fmt.Errorf("verify password: %w", err)
+	}
+	if !ok {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return Identity{Username: username, Role: u.Role, Source: "local"}, nil
+}
+
+// Chain tries each authenticator in turn and returns the first identity
+// found. When none succeeds it reports ErrInvalidCredentials if any knew the
+// user, otherwise the last failure that wasn't ErrUnknownUser.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, username, password string) (Identity, error) {
+	err := ErrUnknownUser
+	invalid := false
+	for _, a := range c {
+		id, aerr := a.Authenticate(ctx, username, password)
+		switch {
+		case aerr == nil:
+			return id, nil
+		case errors.Is(aerr, ErrInvalidCredentials):
+			invalid = true
+		case !errors.Is(aerr, ErrUnknownUser):
+			err = aerr
+		}
+	}
+	if invalid {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return Identity{}, err
+}
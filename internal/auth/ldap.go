@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultLDAPUserFilter  = "(uid={username})"
+	defaultLDAPGroupFilter = "(member={dn})"
+	defaultLDAPCacheTTL    = 5 * time.Minute
+	defaultLDAPTimeout     = 10 * time.Second
+
+	// LocalFallbackAdmins lets only local admins sign in with a local
+	// password while LDAP is on; LocalFallbackAll lets every local user.
+	LocalFallbackAdmins = "admins"
+	LocalFallbackAll    = "all"
+)
+
+// LDAPConfig configures directory sign-in. It lives in the config file, not
+// the admin settings, because it holds the service account's password.
+type LDAPConfig struct {
+	// URL is ldap://host[:port] or ldaps://host[:port]; empty turns LDAP off.
+	URL string `json:"url"`
+	// StartTLS upgrades an ldap:// connection before anything is sent.
+	StartTLS bool `json:"start_tls"`
+	// CACertFile is a PEM bundle to verify the server with instead of the
+	// system roots.
+	CACertFile         string `json:"ca_cert_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// BindDN and BindPassword are the service account users are looked up
+	// with; empty binds anonymously.
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	UserBaseDN   string `json:"user_base_dn"`
+	// UserFilter finds a user by {username}, (uid={username}) by default;
+	// Active Directory wants (sAMAccountName={username}).
+	UserFilter string `json:"user_filter"`
+	// GroupBaseDN, when set, is searched with GroupFilter for the user's
+	// groups, in addition to any memberOf values of the user entry.
+	GroupBaseDN string `json:"group_base_dn"`
+	GroupFilter string `json:"group_filter"`
+	// AdminGroups make their members admins; UserGroups, when set, are the
+	// only groups whose members may sign in. Groups are given as DNs or
+	// common names.
+	AdminGroups []string `json:"admin_groups"`
+	UserGroups  []string `json:"user_groups"`
+	// CacheTTL is how long a user's DN and role are remembered, so signing
+	// in again only needs a bind. "0" turns the cache off.
+	CacheTTL string `json:"cache_ttl"`
+	Timeout  string `json:"timeout"`
+	// LocalFallback is which local accounts still sign in with a local
+	// password: LocalFallbackAdmins (the default) or LocalFallbackAll.
+	LocalFallback string `json:"local_fallback"`
+}
+
+// Enabled reports whether LDAP is configured.
+func (c LDAPConfig) Enabled() bool {
+	return strings.TrimSpace(c.URL) != ""
+}
+
+// Validate checks the parts of c that can be checked without a server.
+func (c LDAPConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if !strings.HasPrefix(c.URL, "ldap://") && !strings.HasPrefix(c.URL, "ldaps://") {
+		return fmt.Errorf("ldap url must start with ldap:// or ldaps://")
+	}
+	if c.StartTLS && strings.HasPrefix(c.URL, "ldaps://") {
+		return fmt.Errorf("ldap start_tls only applies to ldap:// urls")
+	}
+	if strings.TrimSpace(c.UserBaseDN) == "" {
+		return fmt.Errorf("ldap user_base_dn is required")
+	}
+	for _, f := range []struct{ name, value string }{{"cache_ttl", c.CacheTTL}, {"timeout", c.Timeout}} {
+		if f.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.value); err != nil || d < 0 {
+			return fmt.Errorf("ldap %s must be a duration", f.name)
+		}
+	}
+	switch c.LocalFallback {
+	case "", LocalFallbackAdmins, LocalFallbackAll:
+	default:
+		return fmt.Errorf("ldap local_fallback must be %q or %q", LocalFallbackAdmins, LocalFallbackAll)
+	}
+	return nil
+}
+
+// LDAP authenticates users by binding to a directory as them, after finding
+// their entry with a service account. Roles come from group membership.
+type LDAP struct {
+	cfg       LDAPConfig
+	tlsConfig *tls.Config
+	cacheTTL  time.Duration
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	cache map[string]ldapLookup
+}
+
+// ldapLookup is what a user search found.
+type ldapLookup struct {
+	dn      string
+	role    string
+	expires time.Time
+}
+
+// NewLDAP checks cfg and loads its CA bundle.
+func NewLDAP(cfg LDAPConfig) (*LDAP, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l := &LDAP{cfg: cfg, cacheTTL: defaultLDAPCacheTTL, timeout: defaultLDAPTimeout, cache: map[string]ldapLookup{}}
+	if cfg.CacheTTL != "" {
+		l.cacheTTL, _ = time.ParseDuration(cfg.CacheTTL)
+	}
+	if cfg.Timeout != "" {
+		l.timeout, _ = time.ParseDuration(cfg.Timeout)
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url: %w", err)
+	}
+	// StartTLS, unlike ldaps://, doesn't fill in the name to verify.
+	l.tlsConfig = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ldap ca_cert_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldap ca_cert_file holds no certificates")
+		}
+		l.tlsConfig.RootCAs = pool
+	}
+	return l, nil
+}
+
+func (l *LDAP) Authenticate(ctx context.Context, username, password string) (Identity, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" || password == "" {
+		// An empty password would be an unauthenticated bind, which
+		// servers accept without checking anything.
+		return Identity{}, ErrInvalidCredentials
+	}
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	found, ok := l.cached(username)
+	if !ok {
+		if found, err = l.lookup(conn, username); err != nil {
+			return Identity{}, err
+		}
+	}
+	if err := conn.Bind(found.dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Identity{}, ErrInvalidCredentials
+		}
+		return Identity{}, fmt.Errorf("ldap bind: %w", err)
+	}
+	if !ok {
+		l.remember(username, found)
+	}
+	return Identity{Username: username, Role: found.role, Source: "ldap"}, nil
+}
+
+func (l *LDAP) dial(ctx context.Context) (*ldap.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(l.cfg.URL, ldap.DialWithTLSConfig(l.tlsConfig), ldap.DialWithDialer(&net.Dialer{Timeout: l.timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("ldap connect: %w", err)
+	}
+	conn.SetTimeout(l.timeout)
+	if l.cfg.StartTLS {
+		if err := conn.StartTLS(l.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (l *LDAP) bindService(conn *ldap.Conn) error {
+	var err error
+	if l.cfg.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(l.cfg.BindDN, l.cfg.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+// lookup finds the user's entry and groups as the service account and works
+// out their role.
+func (l *LDAP) lookup(conn *ldap.Conn, username string) (ldapLookup, error) {
+	if err := l.bindService(conn); err != nil {
+		return ldapLookup{}, err
+	}
+	filter := strings.ReplaceAll(orDefault(l.cfg.UserFilter, defaultLDAPUserFilter), "{username}", ldap.EscapeFilter(username))
+	res, err := conn.Search(ldap.NewSearchRequest(l.cfg.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(l.timeout.Seconds()), false, filter, []string{"memberOf"}, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return ldapLookup{}, fmt.Errorf("ldap user search: %w", err)
+	}
+	if res == nil || len(res.Entries) == 0 {
+		return ldapLookup{}, ErrUnknownUser
+	}
+	if len(res.Entries) > 1 {
+		return ldapLookup{}, fmt.Errorf("ldap user search: %q matches more than one entry", username)
+	}
+	entry := res.Entries[0]
+	groups := entry.GetAttributeValues("memberOf")
+	if l.cfg.GroupBaseDN != "" {
+		filter := strings.NewReplacer("{dn}", ldap.EscapeFilter(entry.DN), "{username}", ldap.EscapeFilter(username)).
+			Replace(orDefault(l.cfg.GroupFilter, defaultLDAPGroupFilter))
+		gres, err := conn.Search(ldap.NewSearchRequest(l.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, int(l.timeout.Seconds()), false, filter, []string{"dn"}, nil))
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return ldapLookup{}, fmt.Errorf("ldap group search: %w", err)
+		}
+		if gres != nil {
+			for _, g := range gres.Entries {
+				groups = append(groups, g.DN)
+			}
+		}
+	}
+
+	found := ldapLookup{dn: entry.DN, role: RoleUser}
+	if inGroups(groups, l.cfg.AdminGroups) {
+		found.role = RoleAdmin
+	} else if len(l.cfg.UserGroups) > 0 && !inGroups(groups, l.cfg.UserGroups) {
+		return ldapLookup{}, fmt.Errorf("%w: not in an allowed group", ErrInvalidCredentials)
+	}
+	return found, nil
+}
+
+func (l *LDAP) cached(username string) (ldapLookup, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.cache[username]
+	if !ok || time.Now().After(v.expires) {
+		delete(l.cache, username)
+		return ldapLookup{}, false
+	}
+	return v, true
+}
+
+func (l *LDAP) remember(username string, v ldapLookup) {
+	if l.cacheTTL <= 0 {
+		return
+	}
+	v.expires = time.Now().Add(l.cacheTTL)
+	l.mu.Lock()
+	l.cache[username] = v
+	l.mu.Unlock()
+}
+
+// inGroups reports whether any of the user's group DNs is one of wanted,
+// which are DNs or common names.
+func inGroups(groups, wanted []string) bool {
+	for _, g := range groups {
+		dn, err := ldap.ParseDN(g)
+		if err != nil {
+			continue
+		}
+		cn := ""
+		if len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			cn = dn.RDNs[0].Attributes[0].Value
+		}
+		for _, w := range wanted {
+			if strings.EqualFold(w, cn) {
+				return true
+			}
+			if wdn, err := ldap.ParseDN(w); err == nil && wdn.EqualFold(dn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func orDefault(v, def string) string {
+	if strings.TrimSpace(v) == "" {
+		return def
+	}
+	return v
+}
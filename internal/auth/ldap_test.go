@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+)
+
+// testDirectory is an in-process LDAP server holding entries by DN. Entries
+// bind with their userPassword attribute; filters support &, |, equality
+// and presence.
+type testDirectory struct {
+	entries  map[string]map[string][]string
+	tls      *tls.Config
+	searches atomic.Int32
+}
+
+func startTestDirectory(t *testing.T, entries map[string]map[string][]string) (url, caFile string, d *testDirectory) {
+	t.Helper()
+	cert, caPEM := selfSignedCert(t)
+	d = &testDirectory{entries: entries, tls: &tls.Config{Certificates: []tls.Certificate{cert}}}
+
+	srv, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, _ := gldap.NewMux()
+	_ = mux.Bind(d.bind)
+	_ = mux.Search(d.search)
+	_ = mux.ExtendedOperation(d.startTLS, gldap.ExtendedOperationStartTLS)
+	_ = srv.Router(mux)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	go func() { _ = srv.Run(addr) }()
+	t.Cleanup(func() { _ = srv.Stop() })
+	for deadline := time.Now().Add(5 * time.Second); !srv.Ready(); {
+		if time.Now().After(deadline) {
+			t.Fatal("test directory did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	caFile = filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return "ldap://" + addr, caFile, d
+}
+
+func (d *testDirectory) bind(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+	defer func() { _ = w.Write(resp) }()
+	m, err := r.GetSimpleBindMessage()
+	if err != nil {
+		return
+	}
+	if m.UserName == "" && m.Password == "" {
+		resp.SetResultCode(gldap.ResultSuccess)
+		return
+	}
+	if e, ok := d.entries[m.UserName]; ok && len(e["userPassword"]) > 0 && e["userPassword"][0] == string(m.Password) {
+		resp.SetResultCode(gldap.ResultSuccess)
+	}
+}
+
+func (d *testDirectory) startTLS(w *gldap.ResponseWriter, r *gldap.Request) {
+	res := r.NewExtendedResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+	res.SetResponseName(gldap.ExtendedOperationStartTLS)
+	if err := w.Write(res); err != nil {
+		return
+	}
+	_ = r.StartTLS(d.tls)
+}
+
+func (d *testDirectory) search(w *gldap.ResponseWriter, r *gldap.Request) {
+	done := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+	defer func() { _ = w.Write(done) }()
+	m, err := r.GetSearchMessage()
+	if err != nil {
+		done.SetResultCode(gldap.ResultOperationsError)
+		return
+	}
+	d.searches.Add(1)
+	for dn, attrs := range d.entries {
+		if !strings.HasSuffix(strings.ToLower(dn), strings.ToLower(m.BaseDN)) || !matchFilter(m.Filter, dn, attrs) {
+			continue
+		}
+		entry := r.NewSearchResponseEntry(dn)
+		for name, values := range attrs {
+			if name != "userPassword" {
+				entry.AddAttribute(name, values)
+			}
+		}
+		_ = w.Write(entry)
+	}
+}
+
+func matchFilter(f, dn string, attrs map[string][]string) bool {
+	f = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(f), "("), ")")
+	switch {
+	case strings.HasPrefix(f, "&"), strings.HasPrefix(f, "|"):
+		and := f[0] == '&'
+		for _, sub := range splitFilters(f[1:]) {
+			if matchFilter(sub, dn, attrs) != and {
+				return !and
+			}
+		}
+		return and
+	}
+	name, value, _ := strings.Cut(f, "=")
+	for _, v := range attrs[name] {
+		if value == "*" || strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitFilters(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				out = append(out, s[start:i+1])
+			}
+		}
+	}
+	return out
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func testEntries() map[string]map[string][]string {
+	return map[string]map[string][]string{
+		"cn=svc,dc=example,dc=org":              {"userPassword": {"svc-secret"}},
+		"uid=alice,ou=people,dc=example,dc=org": {"uid": {"alice"}, "userPassword": {"alice-pw"}},
+		"uid=bob,ou=people,dc=example,dc=org":   {"uid": {"bob"}, "userPassword": {"bob-pw"}, "memberOf": {"cn=staff,ou=groups,dc=example,dc=org"}},
+		"uid=carol,ou=people,dc=example,dc=org": {"uid": {"carol"}, "userPassword": {"carol-pw"}},
+		"cn=admins,ou=groups,dc=example,dc=org": {"member": {"uid=alice,ou=people,dc=example,dc=org"}},
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	url, caFile, dir := startTestDirectory(t, testEntries())
+	l, err := NewLDAP(LDAPConfig{
+		URL:          url,
+		StartTLS:     true,
+		CACertFile:   caFile,
+		BindDN:       "cn=svc,dc=example,dc=org",
+		BindPassword: "svc-secret",
+		UserBaseDN:   "ou=people,dc=example,dc=org",
+		GroupBaseDN:  "ou=groups,dc=example,dc=org",
+		AdminGroups:  []string{"admins"},
+		UserGroups:   []string{"cn=staff,ou=groups,dc=example,dc=org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	id, err := l.Authenticate(ctx, "Alice", "alice-pw")
+	if err != nil || id.Username != "alice" || id.Role != RoleAdmin || id.Source != "ldap" {
+		t.Fatalf("alice = %+v, %v", id, err)
+	}
+	if id, err := l.Authenticate(ctx, "bob", "bob-pw"); err != nil || id.Role != RoleUser {
+		t.Fatalf("bob = %+v, %v", id, err)
+	}
+	for _, c := range []struct {
+		user, password string
+		want           error
+	}{
+		{"alice", "wrong", ErrInvalidCredentials},
+		{"alice", "", ErrInvalidCredentials},
+		{"carol", "carol-pw", ErrInvalidCredentials}, // not in an allowed group
+		{"dave", "dave-pw", ErrUnknownUser},
+		{"*", "alice-pw", ErrUnknownUser},
+	} {
+		if _, err := l.Authenticate(ctx, c.user, c.password); !errors.Is(err, c.want) {
+			t.Errorf("%s/%s: %v, want %v", c.user, c.password, err, c.want)
+		}
+	}
+
+	// Alice's lookup is cached: signing in again only binds.
+	before := dir.searches.Load()
+	if _, err := l.Authenticate(ctx, "alice", "alice-pw"); err != nil {
+		t.Fatal(err)
+	}
+	if dir.searches.Load() != before {
+		t.Fatal("cached sign-in searched the directory")
+	}
+	if _, err := l.Authenticate(ctx, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("cached lookup skipped the password check: %v", err)
+	}
+}
+
+func TestLDAPRequiresTrustedCertificate(t *testing.T) {
+	url, _, _ := startTestDirectory(t, testEntries())
+	l, err := NewLDAP(LDAPConfig{URL: url, StartTLS: true, UserBaseDN: "ou=people,dc=example,dc=org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Authenticate(context.Background(), "alice", "alice-pw")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("untrusted StartTLS certificate: %v", err)
+	}
+}
+
+func TestChainFallsBackToLocalAdmins(t *testing.T) {
+	hash, err := HashPassword("break-glass-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := Local{AdminsOnly: true, Lookup: func(username string) (LocalUser, error) {
+		switch username {
+		case "root":
+			return LocalUser{PasswordHash: hash, Role: RoleAdmin}, nil
+		case "clerk":
+			return LocalUser{PasswordHash: hash, Role: RoleUser}, nil
+		}
+		return LocalUser{}, ErrUnknownUser
+	}}
+	// Nothing listens on port 1: the directory is down.
+	down, err := NewLDAP(LDAPConfig{URL: "ldap://127.0.0.1:1", UserBaseDN: "dc=example,dc=org", Timeout: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{down, local}
+	ctx := context.Background()
+
+	if id, err := chain.Authenticate(ctx, "root", "break-glass-pw"); err != nil || id.Source != "local" || id.Role != RoleAdmin {
+		t.Fatalf("break-glass admin = %+v, %v", id, err)
+	}
+	if _, err := chain.Authenticate(ctx, "clerk", "break-glass-pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("local non-admin while LDAP is on: %v", err)
+	}
+	_, err = chain.Authenticate(ctx, "alice", "alice-pw")
+	if err == nil || errors.Is(err, ErrUnknownUser) || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("directory outage not reported: %v", err)
+	}
+	if !strings.Contains(err.Error(), "ldap connect") {
+		t.Fatalf("error = %v", err)
+	}
+}
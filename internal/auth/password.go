@@ -4,47 +4,135 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"golang.org/x/crypto/argon2"
 )
 
 const (
-	argonTime    uint32 = 3
-	argonMemory  uint32 = 64 * 1024
-	argonThreads uint8  = 2
-	argonKeyLen  uint32 = 32
-	saltLen             = 16
+	defaultArgonTime    uint32 = 3
+	defaultArgonMemory  uint32 = 64 * 1024
+	defaultArgonThreads uint8  = 2
+	argonKeyLen         uint32 = 32
+	saltLen                    = 16
 )
 
+// HashConfig is how passwords are hashed; zero fields take the defaults of
+// three passes over 64 MiB with two threads. MaxConcurrent bounds how many
+// hashes are computed at once, since each holds MemoryKiB of memory; it
+// defaults to the number of CPUs.
+type HashConfig struct {
+	Time          uint32 `json:"time"`
+	MemoryKiB     uint32 `json:"memory_kib"`
+	Threads       uint8  `json:"threads"`
+	MaxConcurrent int    `json:"max_concurrent"`
+}
+
+// Validate checks c the way Argon2 would.
+func (c HashConfig) Validate() error {
+	c = c.withDefaults()
+	if c.MemoryKiB < 8*uint32(c.Threads) {
+		return fmt.Errorf("password_hash memory_kib must be at least 8 per thread")
+	}
+	if c.MaxConcurrent < 0 {
+		return fmt.Errorf("password_hash max_concurrent must not be negative")
+	}
+	return nil
+}
+
+func (c HashConfig) withDefaults() HashConfig {
+	if c.Time == 0 {
+		c.Time = defaultArgonTime
+	}
+	if c.MemoryKiB == 0 {
+		c.MemoryKiB = defaultArgonMemory
+	}
+	if c.Threads == 0 {
+		c.Threads = defaultArgonThreads
+	}
+	if c.MaxConcurrent == 0 {
+		c.MaxConcurrent = runtime.NumCPU()
+	}
+	return c
+}
+
+// hasher is the hashing setup in use: the parameters new hashes get and the
+// slots bounding concurrent hashing.
+type hasher struct {
+	cfg   HashConfig
+	slots chan struct{}
+}
+
+var current atomic.Pointer[hasher]
+
+func init() {
+	ConfigureHashing(HashConfig{})
+}
+
+// ConfigureHashing sets the parameters of new password hashes and how many
+// hashes may be computed at once, process-wide. Existing hashes keep
+// verifying with the parameters they were made with.
+func ConfigureHashing(c HashConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c = c.withDefaults()
+	current.Store(&hasher{cfg: c, slots: make(chan struct{}, c.MaxConcurrent)})
+	return nil
+}
+
+// HashingConfig returns the hashing setup in use, defaults filled in.
+func HashingConfig() HashConfig {
+	return current.Load().cfg
+}
+
+// idKey is argon2.IDKey, waiting for a free slot first so that a flood of
+// sign-ins queues up instead of allocating memory for every attempt at once.
+func idKey(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	h := current.Load()
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	return argon2.IDKey(password, salt, time, memory, threads, keyLen)
+}
+
 func HashPassword(password string) (string, error) {
-	if len(password) < 8 {
-		return "", fmt.Errorf("password must be at least 8 characters")
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
 	}
 	saltRaw, err := randomBytes(saltLen)
 	if err != nil {
 		return "", err
 	}
-	hash := argon2.IDKey([]byte(password), saltRaw, argonTime, argonMemory, argonThreads, argonKeyLen)
+	c := HashingConfig()
+	hash := idKey([]byte(password), saltRaw, c.Time, c.MemoryKiB, c.Threads, argonKeyLen)
 	salt := base64.RawStdEncoding.EncodeToString(saltRaw)
 	hashB64 := base64.RawStdEncoding.EncodeToString(hash)
-	encoded := fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", argonMemory, argonTime, argonThreads, salt, hashB64)
+	encoded := fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", c.MemoryKiB, c.Time, c.Threads, salt, hashB64)
 	return encoded, nil
 }
 
-func VerifyPassword(encodedHash, password string) (bool, error) {
+// argonHash is an encoded hash taken apart.
+type argonHash struct {
+	memory   uint32
+	timeCost uint32
+	threads  uint8
+	salt     []byte
+	hash     []byte
+}
+
+func decodeHash(encodedHash string) (argonHash, error) {
+	var h argonHash
 	parts := strings.Split(encodedHash, "$")
 	if len(parts) != 6 {
-		return false, fmt.Errorf("invalid hash format")
+		return h, fmt.Errorf("invalid hash format")
 	}
 	if parts[1] != "argon2id" {
-		return false, fmt.Errorf("unsupported hash algorithm")
+		return h, fmt.Errorf("unsupported hash algorithm")
 	}
 
-	var memory uint32
-	var timeCost uint32
-	var threads uint8
 	for _, pair := range strings.Split(parts[3], ",") {
 		kvs := strings.SplitN(pair, "=", 2)
 		if len(kvs) != 2 {
@@ -54,37 +142,56 @@ func VerifyPassword(encodedHash, password string) (bool, error) {
 		case "m":
 			v, err := strconv.ParseUint(kvs[1], 10, 32)
 			if err != nil {
-				return false, err
+				return h, err
 			}
-			memory = uint32(v)
+			h.memory = uint32(v)
 		case "t":
 			v, err := strconv.ParseUint(kvs[1], 10, 32)
 			if err != nil {
-				return false, err
+				return h, err
 			}
-			timeCost = uint32(v)
+			h.timeCost = uint32(v)
 		case "p":
 			v, err := strconv.ParseUint(kvs[1], 10, 8)
 			if err != nil {
-				return false, err
+				return h, err
 			}
-			threads = uint8(v)
+			h.threads = uint8(v)
 		}
 	}
-	if memory == 0 || timeCost == 0 || threads == 0 {
-		return false, fmt.Errorf("invalid argon2 parameters")
+	if h.memory == 0 || h.timeCost == 0 || h.threads == 0 {
+		return h, fmt.Errorf("invalid argon2 parameters")
 	}
 
-	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
-	if err != nil {
-		return false, err
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, err
 	}
-	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+func VerifyPassword(encodedHash, password string) (bool, error) {
+	h, err := decodeHash(encodedHash)
 	if err != nil {
 		return false, err
 	}
-
-	candidate := argon2.IDKey([]byte(password), salt, timeCost, memory, threads, uint32(len(hash)))
-	ok := subtle.ConstantTimeCompare(candidate, hash) == 1
+	candidate := idKey([]byte(password), h.salt, h.timeCost, h.memory, h.threads, uint32(len(h.hash)))
+	ok := subtle.ConstantTimeCompare(candidate, h.hash) == 1
 	return ok, nil
 }
+
+// NeedsRehash reports whether encodedHash was made with other parameters
+// than new hashes get, so that it should be replaced the next time the
+// password is at hand.
+func NeedsRehash(encodedHash string) bool {
+	h, err := decodeHash(encodedHash)
+	if err != nil {
+		return true
+	}
+	c := HashingConfig()
+	return h.memory != c.MemoryKiB || h.timeCost != c.Time || h.threads != c.Threads ||
+		len(h.salt) != saltLen || len(h.hash) != int(argonKeyLen)
+}
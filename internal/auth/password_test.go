@@ -1,6 +1,9 @@
 package auth
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestHashAndVerifyPassword(t *testing.T) {
 	hash, err := HashPassword("correct horse battery staple")
@@ -22,3 +25,43 @@ func TestHashAndVerifyPassword(t *testing.T) {
 		t.Fatalf("expected wrong password to fail")
 	}
 }
+
+func TestRehashAfterParameterChange(t *testing.T) {
+	defer ConfigureHashing(HashConfig{})
+	if err := ConfigureHashing(HashConfig{Time: 1, MemoryKiB: 1024, Threads: 1, MaxConcurrent: 1}); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(hash) {
+		t.Fatal("fresh hash needs a rehash")
+	}
+	if err := ConfigureHashing(HashConfig{Time: 2, MemoryKiB: 2048, Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !NeedsRehash(hash) {
+		t.Fatal("hash with old parameters doesn't need a rehash")
+	}
+	// Old hashes keep verifying with the parameters they were made with.
+	if ok, err := VerifyPassword(hash, "correct horse battery staple"); !ok || err != nil {
+		t.Fatalf("verify old hash: %v, %v", ok, err)
+	}
+	if err := ConfigureHashing(HashConfig{MemoryKiB: 8, Threads: 4}); err == nil {
+		t.Fatal("too little memory per thread accepted")
+	}
+}
+
+func TestTuneHashingStaysNearTarget(t *testing.T) {
+	c, took := TuneHashing(20*time.Millisecond, 4096, 1)
+	if c.Time < 1 || c.MemoryKiB < 8 || c.MemoryKiB > 4096 || c.Threads != 1 {
+		t.Fatalf("tuned %+v", c)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Time > 1 && took > 20*time.Millisecond {
+		t.Fatalf("%+v took %s, over the target", c, took)
+	}
+}
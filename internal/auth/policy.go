@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// MinPasswordLength is the shortest password any policy allows.
+const MinPasswordLength = 8
+
+// ErrBreachedPassword means the password is on the breached-password list.
+var ErrBreachedPassword = errors.New("password appears in a list of breached passwords; choose another")
+
+// PasswordPolicy is what a new password must satisfy beyond HashPassword's
+// minimum length.
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lower case, upper case, digits and other
+	// characters the password must mix.
+	MinClasses       int
+	DisallowUsername bool
+	// BreachedList is a file of upper-case SHA-1 hashes of breached
+	// passwords, one per line sorted by hash and optionally followed by
+	// ":count", as the Pwned Passwords downloads are; or a directory of
+	// k-anonymity range files named after the first five hex digits of the
+	// hash ("ABCDE" or "ABCDE.txt") holding the remaining digits.
+	BreachedList string
+}
+
+// Check reports why password can't be a password of username, or nil.
+func (p PasswordPolicy) Check(username, password string) error {
+	want := max(p.MinLength, MinPasswordLength)
+	if len([]rune(password)) < want {
+		return fmt.Errorf("password must be at least %d characters", want)
+	}
+	if p.MinClasses > 0 {
+		var lower, upper, digit, other bool
+		for _, c := range password {
+			switch {
+			case unicode.IsLower(c):
+				lower = true
+			case unicode.IsUpper(c):
+				upper = true
+			case unicode.IsDigit(c):
+				digit = true
+			default:
+				other = true
+			}
+		}
+		n := 0
+		for _, has := range []bool{lower, upper, digit, other} {
+			if has {
+				n++
+			}
+		}
+		if n < p.MinClasses {
+			return fmt.Errorf("password must mix at least %d of lower case, upper case, digits and symbols", p.MinClasses)
+		}
+	}
+	if p.DisallowUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("password must not contain the username")
+	}
+	if p.BreachedList != "" {
+		breached, err := isBreached(p.BreachedList, password)
+		if err != nil {
+			return fmt.Errorf("check breached passwords: %w", err)
+		}
+		if breached {
+			return ErrBreachedPassword
+		}
+	}
+	return nil
+}
+
+func isBreached(list, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	info, err := os.Stat(list)
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return searchRangeFile(list, hash)
+	}
+	f, err := os.Open(list)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return searchSortedHashes(f, info.Size(), hash)
+}
+
+// searchRangeFile looks hash up in the range file of its first five digits,
+// which lists the rest of the hashes in the range like the Pwned Passwords
+// range API does.
+func searchRangeFile(dir, hash string) (bool, error) {
+	prefix, suffix := hash[:5], hash[5:]
+	f, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if strings.EqualFold(hashField(sc.Text()), suffix) {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
+
+// searchSortedHashes binary-searches a file of sorted hash lines, which can
+// be tens of gigabytes, without reading more than a few lines of it.
+func searchSortedHashes(r io.ReaderAt, size int64, hash string) (bool, error) {
+	// The line holding hash, if any, starts in [lo, hi).
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, start, err := lineFrom(r, size, mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		switch c := strings.Compare(strings.ToUpper(hashField(line)), hash); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineFrom returns the first line starting at or after off and where it
+// starts; past the last line it returns "" and size.
+func lineFrom(r io.ReaderAt, size, off int64) (string, int64, error) {
+	start := off
+	if off > 0 {
+		start = off - 1
+	}
+	br := bufio.NewReader(io.NewSectionReader(r, start, size-start))
+	if off > 0 {
+		// Skip the rest of the line off is in, unless off starts one.
+		skipped, err := br.ReadString('\n')
+		if err == io.EOF {
+			return "", size, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start += int64(len(skipped))
+	}
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	if line == "" {
+		return "", size, nil
+	}
+	return strings.TrimSuffix(line, "\n"), start, nil
+}
+
+// hashField returns the hash of a "HASH:count" line.
+func hashField(line string) string {
+	h, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return h
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	p := PasswordPolicy{MinLength: 10, MinClasses: 3, DisallowUsername: true}
+	for _, c := range []struct {
+		password string
+		ok       bool
+	}{
+		{"Short1!", false},
+		{"alllowercaseletters", false},
+		{"LowerAndUpper", false},
+		{"Lower Upper 9", true},
+		{"xAliceX-2024", false},
+		{"Tr0ub4dor&3", true},
+	} {
+		if err := p.Check("alice", c.password); (err == nil) != c.ok {
+			t.Errorf("Check(%q) = %v, want ok=%v", c.password, err, c.ok)
+		}
+	}
+	if err := (PasswordPolicy{MinLength: 4}).Check("", "1234567"); err == nil {
+		t.Error("policy went below the minimum length")
+	}
+}
+
+func TestBreachedPasswordLists(t *testing.T) {
+	breached := []string{"password123", "letmein-now", "Summer2024!"}
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(fmt.Sprintf("filler-%d", i)), i+1))
+	}
+	for _, pw := range breached {
+		lines = append(lines, sha1Hex(pw)+":42")
+	}
+	sort.Strings(lines)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pwned.txt")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ranges := filepath.Join(dir, "ranges")
+	if err := os.Mkdir(ranges, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, pw := range breached {
+		h := sha1Hex(pw)
+		if err := os.WriteFile(filepath.Join(ranges, h[:5]+".txt"), []byte("0000000000000000000000000000000000A:1\n"+h[5:]+":42\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, list := range []string{file, ranges} {
+		p := PasswordPolicy{BreachedList: list}
+		for _, pw := range breached {
+			if err := p.Check("", pw); !errors.Is(err, ErrBreachedPassword) {
+				t.Errorf("%s: %q not found: %v", list, pw, err)
+			}
+		}
+		for _, pw := range []string{"filler-not-listed", "zzzzzzzzzzzzz", "00000000"} {
+			if err := p.Check("", pw); err != nil {
+				t.Errorf("%s: %q: %v", list, pw, err)
+			}
+		}
+	}
+	// Every line of the sorted file is found, including the first and last.
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	for _, l := range lines {
+		if ok, err := searchSortedHashes(f, info.Size(), hashField(l)); !ok || err != nil {
+			t.Fatalf("%s not found: %v", l, err)
+		}
+	}
+	if err := (PasswordPolicy{BreachedList: filepath.Join(dir, "missing")}).Check("", "whatever-pw"); err == nil {
+		t.Fatal("missing list not reported")
+	}
+}
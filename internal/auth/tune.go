@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// TuneHashing finds hashing parameters that take about target per password
+// on this machine: the most memory up to maxMemoryKiB that hashes within
+// target in one pass, then as many passes as still fit. It returns them
+// with how long a hash took.
+func TuneHashing(target time.Duration, maxMemoryKiB uint32, threads uint8) (HashConfig, time.Duration) {
+	if threads == 0 {
+		threads = uint8(min(runtime.NumCPU(), 4))
+	}
+	minMemory := 8 * uint32(threads)
+	memory := max(maxMemoryKiB, minMemory)
+	password, salt := []byte("sharehere benchmark"), make([]byte, saltLen)
+	measure := func(t uint32) time.Duration {
+		start := time.Now()
+		argon2.IDKey(password, salt, t, memory, threads, argonKeyLen)
+		return time.Since(start)
+	}
+
+	took := measure(1)
+	for took > target && memory/2 >= minMemory {
+		memory /= 2
+		took = measure(1)
+	}
+	passes := uint32(1)
+	for t := uint32(2); t <= 16; t++ {
+		d := measure(t)
+		if d > target {
+			break
+		}
+		passes, took = t, d
+	}
+	return HashConfig{Time: passes, MemoryKiB: memory, Threads: threads, MaxConcurrent: runtime.NumCPU()}, took
+}
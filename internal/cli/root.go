@@ -3,6 +3,7 @@ package cli
 import (
 	"bufio"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -116,7 +117,12 @@ func NewRootCmd(v VersionInfo) *cobra.Command {
 
 	userCmd := buildUserCommands(state)
 	linkCmd := buildLinkCommands(state)
+	sessionCmd := buildSessionCommands(state)
+	maintenanceCmd := buildMaintenanceCommands(state)
 	themeCmd := buildThemeCommands(state)
+	syncCmd := buildSyncCommand()
+
+	benchHashCmd := buildBenchHashCommand(state)
 
 	versionCmd := &cobra.Command{
 		Use:   "version",
@@ -126,7 +132,7 @@ func NewRootCmd(v VersionInfo) *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(serveCmd, initCmd, configCmd, userCmd, linkCmd, themeCmd, versionCmd)
+	cmd.AddCommand(serveCmd, initCmd, configCmd, userCmd, linkCmd, sessionCmd, maintenanceCmd, themeCmd, syncCmd, benchHashCmd, versionCmd)
 	return cmd
 }
 
@@ -161,6 +167,10 @@ func loadConfig(state *rootState) (string, config.Config, error) {
 	if state.dataDir != "" {
 		cfg.DataDir = state.dataDir
 	}
+	// Every command hashing passwords, the server included, runs after this.
+	if err := auth.ConfigureHashing(cfg.PasswordHash); err != nil {
+		return "", config.Config{}, err
+	}
 	return cfgPath, cfg, nil
 }
 
@@ -223,23 +233,28 @@ func runServe(cmd *cobra.Command, state *rootState, flags *serveFlags, pathArg s
 	}
 
 	opts := server.Options{
-		RootDir:      rootPath,
-		DataDir:      cfg.DataDir,
-		Bind:         cfg.Bind,
-		Host:         cfg.Host,
-		Port:         cfg.Port,
-		BasePath:     cfg.BasePath,
-		ReadOnly:     cfg.ReadOnly,
-		AuthMode:     cfg.Auth,
-		LogLevel:     cfg.LogLevel,
-		HTTPS:        cfg.HTTPS,
-		CertFile:     cfg.CertFile,
-		KeyFile:      cfg.KeyFile,
-		OpenBrowser:  flags.open,
-		Version:      v.Version,
-		GuestMode:    cfg.GuestMode,
-		GuestModeSet: guestSet,
-		ReadOnlySet:  readonlySet,
+		RootDir:        rootPath,
+		DataDir:        cfg.DataDir,
+		Bind:           cfg.Bind,
+		Host:           cfg.Host,
+		Port:           cfg.Port,
+		BasePath:       cfg.BasePath,
+		ReadOnly:       cfg.ReadOnly,
+		AuthMode:       cfg.Auth,
+		LogLevel:       cfg.LogLevel,
+		HTTPS:          cfg.HTTPS,
+		CertFile:       cfg.CertFile,
+		KeyFile:        cfg.KeyFile,
+		OpenBrowser:    flags.open,
+		Version:        v.Version,
+		GuestMode:      cfg.GuestMode,
+		GuestModeSet:   guestSet,
+		ReadOnlySet:    readonlySet,
+		LDAP:           cfg.LDAP,
+		TrustedProxies: cfg.TrustedProxies,
+		PasskeyOrigin:  cfg.PasskeyOrigin,
+		ArchiveEntries: cfg.MaxArchiveEntries,
+		ExtractSizeMB:  cfg.MaxExtractSizeMB,
 	}
 
 	scheme := "http"
@@ -250,6 +265,9 @@ func runServe(cmd *cobra.Command, state *rootState, flags *serveFlags, pathArg s
 	fmt.Printf("Serving: %s\n", rootPath)
 	fmt.Printf("Config:  %s\n", cfgPath)
 	fmt.Printf("Data:    %s\n", cfg.DataDir)
+	if space, err := util.GetDiskSpace(rootPath); err == nil {
+		fmt.Printf("Disk:    %s free of %s\n", util.FormatBytes(space.Available), util.FormatBytes(space.Total))
+	}
 	fmt.Printf("Mode:    auth=%s guest=%s readonly=%v\n", cfg.Auth, cfg.GuestMode, cfg.ReadOnly)
 	fmt.Println("URLs:")
 	for _, u := range urls {
@@ -343,7 +361,7 @@ func runInit(state *rootState) error {
 		cfg.BasePath = config.NormalizeBasePath(askWithDefault(r, "Base path", cfg.BasePath))
 		cfg.Theme = askWithDefault(r, "Default theme", cfg.Theme)
 		cfg.DefaultShareExpiry = askWithDefault(r, "Default share expiry", cfg.DefaultShareExpiry)
-		cfg.CollisionPolicy = strings.ToLower(askWithDefault(r, "Collision policy (rename/overwrite)", cfg.CollisionPolicy))
+		cfg.CollisionPolicy = strings.ToLower(askWithDefault(r, "Collision policy (rename/timestamp/overwrite/version/reject/ask)", cfg.CollisionPolicy))
 		cfg.MaxUploadSizeMB = int64(askIntWithDefault(r, "Max upload size MB", int(cfg.MaxUploadSizeMB)))
 		cfg.AllowDelete = askBoolWithDefault(r, "Enable delete", cfg.AllowDelete)
 		cfg.AllowRename = askBoolWithDefault(r, "Enable rename/move", cfg.AllowRename)
@@ -376,6 +394,9 @@ func runInit(state *rootState) error {
 		if err != nil {
 			return err
 		}
+		if err := checkPasswordPolicy(store, username, password); err != nil {
+			return err
+		}
 		hash, err := auth.HashPassword(password)
 		if err != nil {
 			return err
@@ -387,18 +408,20 @@ func runInit(state *rootState) error {
 	}
 
 	if err := store.SetAppSettings(db.AppSettings{
-		GuestMode:          cfg.GuestMode,
-		MaxUploadSizeMB:    cfg.MaxUploadSizeMB,
-		UploadAllowRegex:   cfg.UploadAllowRegex,
-		UploadDenyRegex:    cfg.UploadDenyRegex,
-		UploadSubdir:       cfg.UploadSubdir,
-		CollisionPolicy:    cfg.CollisionPolicy,
-		DefaultShareExpiry: cfg.DefaultShareExpiry,
-		AllowDelete:        cfg.AllowDelete,
-		AllowRename:        cfg.AllowRename,
-		ReadOnly:           cfg.ReadOnly,
-		Theme:              cfg.Theme,
-		ThemeOverridesJSON: "{}",
+		GuestMode:            cfg.GuestMode,
+		MaxUploadSizeMB:      cfg.MaxUploadSizeMB,
+		UploadAllowRegex:     cfg.UploadAllowRegex,
+		UploadDenyRegex:      cfg.UploadDenyRegex,
+		UploadSubdir:         cfg.UploadSubdir,
+		CollisionPolicy:      cfg.CollisionPolicy,
+		DefaultShareExpiry:   cfg.DefaultShareExpiry,
+		AllowDelete:          cfg.AllowDelete,
+		AllowRename:          cfg.AllowRename,
+		ReadOnly:             cfg.ReadOnly,
+		Theme:                cfg.Theme,
+		ThemeOverridesJSON:   "{}",
+		PasswordMinLength:    auth.MinPasswordLength,
+		PasswordDenyUsername: true,
 	}); err != nil {
 		return err
 	}
@@ -408,6 +431,16 @@ func runInit(state *rootState) error {
 	return nil
 }
 
+// checkPasswordPolicy checks a password typed at the CLI against the password
+// policy of the server settings.
+func checkPasswordPolicy(store *db.Store, username, password string) error {
+	settings, err := store.GetAppSettings()
+	if err != nil {
+		return err
+	}
+	return settings.PasswordPolicy().Check(username, password)
+}
+
 func askWithDefault(r *bufio.Reader, label, def string) string {
 	fmt.Printf("%s [%s]: ", label, def)
 	text, _ := r.ReadString('\n')
@@ -477,9 +510,38 @@ func promptPasswordTwice(label string) (string, error) {
 	return first, nil
 }
 
+// provisionHomeDir creates username's home directory under the share root,
+// using the home directories folder from the server settings.
+func provisionHomeDir(store *db.Store, root, username string) (string, error) {
+	settings, err := store.GetAppSettings()
+	if err != nil {
+		return "", err
+	}
+	base := util.NormalizeRelPath(settings.HomeDirsPath)
+	if base == "" {
+		base = "home"
+	}
+	rel, err := util.HomeDirRel(base, username)
+	if err != nil {
+		return "", err
+	}
+	abs, err := util.SafeJoin(root, rel)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return "", err
+	}
+	if !settings.HomeDirsEnabled {
+		fmt.Println("note: home directories are turned off in the server settings")
+	}
+	return abs, nil
+}
+
 func buildUserCommands(state *rootState) *cobra.Command {
 	userCmd := &cobra.Command{Use: "user", Short: "User management"}
 	role := "user"
+	homeRoot := ""
 
 	addCmd := &cobra.Command{
 		Use:   "add <username>",
@@ -499,11 +561,14 @@ func buildUserCommands(state *rootState) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			username := strings.ToLower(strings.TrimSpace(args[0]))
+			if err := checkPasswordPolicy(store, username, pass); err != nil {
+				return err
+			}
 			hash, err := auth.HashPassword(pass)
 			if err != nil {
 				return err
 			}
-			username := strings.ToLower(strings.TrimSpace(args[0]))
 			if role != auth.RoleAdmin {
 				role = auth.RoleUser
 			}
@@ -512,10 +577,18 @@ func buildUserCommands(state *rootState) *cobra.Command {
 				return err
 			}
 			fmt.Printf("created user %s (id=%d role=%s)\n", username, id, role)
+			if homeRoot != "" {
+				home, err := provisionHomeDir(store, homeRoot, username)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("home directory: %s\n", home)
+			}
 			return nil
 		},
 	}
 	addCmd.Flags().StringVar(&role, "role", "user", "role: user|admin")
+	addCmd.Flags().StringVar(&homeRoot, "home", "", "create the user's home directory under this share root")
 
 	listCmd := &cobra.Command{
 		Use:   "list",
@@ -536,7 +609,10 @@ func buildUserCommands(state *rootState) *cobra.Command {
 			}
 			for _, u := range users {
 				status := "active"
-				if u.Disabled {
+				switch {
+				case u.Pending:
+					status = "pending"
+				case u.Disabled:
 					status = "disabled"
 				}
 				fmt.Printf("%s\t%s\t%s\n", u.Username, u.Role, status)
@@ -563,6 +639,7 @@ func buildUserCommands(state *rootState) *cobra.Command {
 		},
 	}
 
+	temporary := false
 	passwdCmd := &cobra.Command{
 		Use:   "passwd <username>",
 		Short: "Set a user password",
@@ -581,14 +658,22 @@ func buildUserCommands(state *rootState) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			if err := checkPasswordPolicy(store, args[0], pass); err != nil {
+				return err
+			}
 			hash, err := auth.HashPassword(pass)
 			if err != nil {
 				return err
 			}
+			if temporary {
+				return store.SetTemporaryPassword(args[0], hash)
+			}
 			return store.SetUserPassword(args[0], hash)
 		},
 	}
 
+	passwdCmd.Flags().BoolVar(&temporary, "temporary", false, "make the user change the password when they next sign in")
+
 	disableCmd := &cobra.Command{
 		Use:   "disable <username>",
 		Short: "Disable a user",
@@ -624,7 +709,52 @@ func buildUserCommands(state *rootState) *cobra.Command {
 		},
 	}
 
-	userCmd.AddCommand(addCmd, listCmd, removeCmd, passwdCmd, disableCmd, enableCmd)
+	inviteRole := "user"
+	inviteExpiry := "72h"
+	inviteHome := false
+	inviteCmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Create a single-use sign-up link",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, cfg, err := loadConfig(state)
+			if err != nil {
+				return err
+			}
+			store, err := db.Open(cfg.DataDir)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			d, err := time.ParseDuration(inviteExpiry)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid expiry %q", inviteExpiry)
+			}
+			if inviteRole != auth.RoleAdmin && inviteRole != auth.RoleUser {
+				return fmt.Errorf("invalid role %q", inviteRole)
+			}
+			token, err := util.RandomToken(18)
+			if err != nil {
+				return err
+			}
+			inv := db.Invitation{Token: token, Role: inviteRole, CreateHome: inviteHome, ExpiresAt: time.Now().Add(d)}
+			if err := store.CreateInvitation(inv); err != nil {
+				return err
+			}
+			_ = store.RecordAudit(nil, "admin.invitation.create", token[:8]+"…", fmt.Sprintf("role=%s expires=%s home=%t cli", inviteRole, inv.ExpiresAt.UTC().Format(time.RFC3339), inviteHome))
+			urls := util.DiscoverURLs(cfg.Bind, cfg.Port, cfg.HTTPS, config.NormalizeBasePath(cfg.BasePath))
+			fmt.Printf("Invitation for a new %s, valid until %s:\n", inviteRole, inv.ExpiresAt.Format(time.RFC1123))
+			for _, u := range urls {
+				fmt.Printf("%s/register?invite=%s\n", strings.TrimRight(u, "/"), token)
+			}
+			return nil
+		},
+	}
+	inviteCmd.Flags().StringVar(&inviteRole, "role", "user", "role of the new account: user|admin")
+	inviteCmd.Flags().StringVar(&inviteExpiry, "expiry", "72h", "how long the link works")
+	inviteCmd.Flags().BoolVar(&inviteHome, "home", false, "create the new user's home directory")
+
+	userCmd.AddCommand(addCmd, listCmd, removeCmd, passwdCmd, disableCmd, enableCmd, inviteCmd)
 	return userCmd
 }
 
@@ -632,6 +762,7 @@ func buildLinkCommands(state *rootState) *cobra.Command {
 	linkCmd := &cobra.Command{Use: "link", Short: "Share link operations"}
 	expiry := "1h"
 	mode := "browse"
+	perUploader := false
 
 	createCmd := &cobra.Command{
 		Use:   "create [path]",
@@ -659,9 +790,19 @@ func buildLinkCommands(state *rootState) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			switch mode {
+			case "browse", "download", "upload", "dropbox":
+			default:
+				return fmt.Errorf("invalid mode %q", mode)
+			}
 			if err := store.CreateShareLink(db.ShareLink{Token: token, Path: p, Mode: mode, ExpiresAt: time.Now().Add(d)}); err != nil {
 				return err
 			}
+			if mode == "dropbox" {
+				if err := store.CreateDropBoxLink(db.DropBox{Path: p, LinkToken: &token, PerUploader: perUploader}); err != nil {
+					return err
+				}
+			}
 			urls := util.DiscoverURLs(cfg.Bind, cfg.Port, cfg.HTTPS, config.NormalizeBasePath(cfg.BasePath))
 			fmt.Printf("Token: %s\n", token)
 			for _, u := range urls {
@@ -671,11 +812,177 @@ func buildLinkCommands(state *rootState) *cobra.Command {
 		},
 	}
 	createCmd.Flags().StringVar(&expiry, "expiry", "1h", "expiry duration (e.g. 1h, 24h)")
-	createCmd.Flags().StringVar(&mode, "mode", "browse", "mode: browse|download|upload")
+	createCmd.Flags().StringVar(&mode, "mode", "browse", "mode: browse|download|upload|dropbox")
+	createCmd.Flags().BoolVar(&perUploader, "per-uploader", false, "dropbox mode: put each uploader's files in their own subfolder")
 	linkCmd.AddCommand(createCmd)
 	return linkCmd
 }
 
+func buildSessionCommands(state *rootState) *cobra.Command {
+	sessionCmd := &cobra.Command{Use: "session", Short: "Signed-in session management"}
+	username := ""
+
+	// userID resolves --user to an ID, or 0 for every user.
+	userID := func(store *db.Store) (int64, error) {
+		if username == "" {
+			return 0, nil
+		}
+		u, err := store.GetUserByUsername(username)
+		if err != nil {
+			return 0, fmt.Errorf("user %q not found", username)
+		}
+		return u.ID, nil
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List active sessions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, cfg, err := loadConfig(state)
+			if err != nil {
+				return err
+			}
+			store, err := db.Open(cfg.DataDir)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			id, err := userID(store)
+			if err != nil {
+				return err
+			}
+			sessions, err := store.ListSessions(id)
+			if err != nil {
+				return err
+			}
+			for _, s := range sessions {
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\n", s.ID, s.Username, s.IP, s.LastSeenAt.Local().Format(time.RFC3339), s.UserAgent)
+			}
+			return nil
+		},
+	}
+	listCmd.Flags().StringVar(&username, "user", "", "only list this user's sessions")
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke [id]",
+		Short: "Sign out a session, or all sessions of --user",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (len(args) == 1) == (username != "") {
+				return fmt.Errorf("give either a session id or --user")
+			}
+			_, cfg, err := loadConfig(state)
+			if err != nil {
+				return err
+			}
+			store, err := db.Open(cfg.DataDir)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			if len(args) == 1 {
+				s, err := store.DeleteSessionByID(args[0], 0)
+				if errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("session %q not found", args[0])
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Printf("revoked session %s of %s\n", s.ID, s.Username)
+				return nil
+			}
+			id, err := userID(store)
+			if err != nil {
+				return err
+			}
+			n, err := store.DeleteUserSessions(id, "")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("revoked %d session(s) of %s\n", n, username)
+			return nil
+		},
+	}
+	revokeCmd.Flags().StringVar(&username, "user", "", "revoke every session of this user")
+
+	sessionCmd.AddCommand(listCmd, revokeCmd)
+	return sessionCmd
+}
+
+func buildMaintenanceCommands(state *rootState) *cobra.Command {
+	maintenanceCmd := &cobra.Command{Use: "maintenance", Short: "Database and storage cleanup"}
+	vacuum := false
+
+	runCmd := &cobra.Command{
+		Use:   "run [path]",
+		Short: "Purge expired data once, e.g. from cron; give the share root to also remove leftover .part files",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, cfg, err := loadConfig(state)
+			if err != nil {
+				return err
+			}
+			opts := server.Options{DataDir: cfg.DataDir, LogLevel: cfg.LogLevel}
+			if len(args) == 1 {
+				opts.RootDir = args[0]
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			rep, err := server.Maintain(ctx, opts, vacuum)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("sessions\t%d\nshare links\t%d\ninvitations\t%d\nlogin attempts\t%d\naudit entries\t%d\nversions\t%d\npart files\t%d\nvacuumed\t%t\n",
+				rep.Sessions, rep.ShareLinks, rep.Invitations, rep.LoginAttempts, rep.AuditLogs, rep.Versions, rep.PartFiles, rep.Vacuumed)
+			return nil
+		},
+	}
+	runCmd.Flags().BoolVar(&vacuum, "vacuum", false, "also VACUUM the database")
+
+	maintenanceCmd.AddCommand(runCmd)
+	return maintenanceCmd
+}
+
+func buildBenchHashCommand(state *rootState) *cobra.Command {
+	var (
+		target    time.Duration
+		maxMemory uint32
+		threads   uint8
+	)
+	cmd := &cobra.Command{
+		Use:   "bench-hash",
+		Short: "Recommend password hashing parameters for this machine",
+		Long: "Time Argon2id on this machine and recommend the password_hash config section that takes about --target per sign-in.\n" +
+			"Existing passwords are rehashed with the new parameters as users sign in.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if target <= 0 {
+				return fmt.Errorf("target must be positive")
+			}
+			if _, _, err := loadConfig(state); err == nil {
+				c := auth.HashingConfig()
+				fmt.Printf("current: time=%d memory_kib=%d threads=%d max_concurrent=%d\n", c.Time, c.MemoryKiB, c.Threads, c.MaxConcurrent)
+			}
+			c, took := auth.TuneHashing(target, maxMemory*1024, threads)
+			fmt.Printf("measured: time=%d memory_kib=%d threads=%d takes %s per hash\n", c.Time, c.MemoryKiB, c.Threads, took.Round(time.Millisecond))
+			if took > target {
+				fmt.Println("note: even the least memory is slower than the target on this machine")
+			}
+			snippet, err := json.MarshalIndent(map[string]auth.HashConfig{"password_hash": c}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("add to the config file (max_concurrent hashes use up to %d MiB together):\n%s\n",
+				uint64(c.MemoryKiB)*uint64(c.MaxConcurrent)/1024, snippet)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&target, "target", 500*time.Millisecond, "time one password hash should take")
+	cmd.Flags().Uint32Var(&maxMemory, "max-memory", 64, "most memory per hash, in MiB")
+	cmd.Flags().Uint8Var(&threads, "threads", 0, "threads per hash (default: CPUs, at most 4)")
+	return cmd
+}
+
 func buildThemeCommands(state *rootState) *cobra.Command {
 	themeCmd := &cobra.Command{Use: "theme", Short: "Theme management"}
 	listCmd := &cobra.Command{
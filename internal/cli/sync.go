@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewsawatzky/sharehere/internal/dirsync"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+func buildSyncCommand() *cobra.Command {
+	var (
+		remotePath string
+		direction  string
+		compare    string
+		username   string
+		prune      bool
+		dryRun     bool
+	)
+	cmd := &cobra.Command{
+		Use:   "sync <local-dir> <url>",
+		Short: "Mirror a local directory to or from a remote sharehere server",
+		Long: "Compare a local directory with a directory on a remote sharehere server and transfer only changed files.\n" +
+			"The remote directory is taken from --path or from a ?path= query in the URL.\n" +
+			"Set SHAREHERE_PASSWORD to avoid the interactive password prompt when using --user.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			localDir, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			direction = strings.ToLower(strings.TrimSpace(direction))
+			if direction != dirsync.DirectionPush && direction != dirsync.DirectionPull {
+				return fmt.Errorf("invalid direction %q (want push or pull)", direction)
+			}
+			if direction == dirsync.DirectionPush {
+				info, err := os.Stat(localDir)
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					return fmt.Errorf("%s is not a directory", localDir)
+				}
+			}
+			if !cmd.Flags().Changed("path") {
+				if u, err := url.Parse(args[1]); err == nil {
+					remotePath = u.Query().Get("path")
+				}
+			}
+			client, err := dirsync.NewClient(args[1])
+			if err != nil {
+				return err
+			}
+			if username != "" {
+				password := os.Getenv("SHAREHERE_PASSWORD")
+				if password == "" {
+					if password, err = promptPassword("Password"); err != nil {
+						return err
+					}
+				}
+				if err := client.Login(strings.ToLower(strings.TrimSpace(username)), password); err != nil {
+					return err
+				}
+			}
+			if _, err := client.Session(); err != nil {
+				return err
+			}
+			sum, err := dirsync.Run(client, dirsync.Options{
+				LocalDir:   localDir,
+				RemotePath: util.NormalizeRelPath(remotePath),
+				Direction:  direction,
+				Compare:    compare,
+				Delete:     prune,
+				DryRun:     dryRun,
+				Out:        os.Stdout,
+			})
+			if err != nil {
+				return err
+			}
+			verb := "transferred"
+			if dryRun {
+				verb = "would transfer"
+			}
+			fmt.Printf("%s %d new, %d changed, %d deleted, %d folders created (%d bytes)\n", verb, sum.Copied, sum.Updated, sum.Deleted, sum.Created, sum.Bytes)
+			for _, e := range sum.Errors {
+				fmt.Fprintf(os.Stderr, "error: %s\n", e)
+			}
+			if len(sum.Errors) > 0 {
+				return fmt.Errorf("sync finished with %d error(s)", len(sum.Errors))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&remotePath, "path", "", "remote directory relative to the share root")
+	cmd.Flags().StringVar(&direction, "direction", dirsync.DirectionPush, "direction: push|pull")
+	cmd.Flags().StringVar(&compare, "compare", dirsync.CompareMtime, "change detection: size|mtime|hash")
+	cmd.Flags().StringVar(&username, "user", "", "log in as this user (anonymous guest access if empty)")
+	cmd.Flags().BoolVar(&prune, "delete", false, "delete destination files that do not exist in the source")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print planned changes without transferring")
+	return cmd
+}
@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/util"
 )
 
 const (
@@ -22,9 +26,64 @@ const (
 	GuestUpload = "upload"
 )
 
+// Collision policies decide what happens when a file is written to a name
+// that already exists.
 const (
-	CollisionRename    = "rename"
+	// CollisionRename stores the new file as "name_1.ext", "name_2.ext", ...
+	CollisionRename = "rename"
+	// CollisionTimestamp stores the new file as "name_20060102-150405.ext".
+	CollisionTimestamp = "timestamp"
+	// CollisionOverwrite replaces the existing file.
 	CollisionOverwrite = "overwrite"
+	// CollisionVersion replaces the existing file and always keeps the old
+	// content as a version.
+	CollisionVersion = "version"
+	// CollisionReject refuses the file and reports a conflict.
+	CollisionReject = "reject"
+	// CollisionAsk reports a conflict like reject; the web UI then asks the
+	// user how to resolve it and retries with a per-file override.
+	CollisionAsk = "ask"
+)
+
+// ValidCollisionPolicy reports whether p is a known collision policy.
+func ValidCollisionPolicy(p string) bool {
+	switch p {
+	case CollisionRename, CollisionTimestamp, CollisionOverwrite, CollisionVersion, CollisionReject, CollisionAsk:
+		return true
+	}
+	return false
+}
+
+// CollisionReplaces reports whether p replaces existing files in place.
+func CollisionReplaces(p string) bool {
+	return p == CollisionOverwrite || p == CollisionVersion
+}
+
+// Passkey policies decide how passkeys take part in signing in.
+const (
+	// PasskeyOptional lets users sign in with a passkey or a password.
+	PasskeyOptional = "optional"
+	// PasskeySecondFactor makes users who have a passkey confirm a password
+	// sign-in with it.
+	PasskeySecondFactor = "second_factor"
+	// PasskeyRequired is PasskeySecondFactor for everyone: users without a
+	// passkey can only register one after a password sign-in.
+	PasskeyRequired = "required"
+)
+
+// ValidPasskeyPolicy reports whether p is a known passkey policy.
+func ValidPasskeyPolicy(p string) bool {
+	switch p {
+	case PasskeyOptional, PasskeySecondFactor, PasskeyRequired:
+		return true
+	}
+	return false
+}
+
+// Defaults for the archive limits in Config.
+const (
+	DefaultMaxArchiveEntries       = 100000
+	DefaultMaxExtractSizeMB  int64 = 16 << 10
 )
 
 type Config struct {
@@ -50,6 +109,25 @@ type Config struct {
 	CollisionPolicy  string `json:"collision_policy"`
 	AllowDelete      bool   `json:"allow_delete"`
 	AllowRename      bool   `json:"allow_rename"`
+
+	// MaxArchiveEntries and MaxExtractSizeMB bound the archives that can be
+	// browsed and extracted: how many entries one may hold, and how much one
+	// extraction may write.
+	MaxArchiveEntries int   `json:"max_archive_entries"`
+	MaxExtractSizeMB  int64 `json:"max_extract_size_mb"`
+
+	// LDAP signs users in against a directory; see auth.LDAPConfig.
+	LDAP auth.LDAPConfig `json:"ldap"`
+	// PasswordHash sets the Argon2 cost for this machine; see
+	// `sharehere bench-hash`.
+	PasswordHash auth.HashConfig `json:"password_hash"`
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// names the client; empty trusts none, not even on this machine.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// PasskeyOrigin is the address browsers use for this server, such as
+	// "https://files.example.com", which passkeys are bound to. Empty takes
+	// it from each request.
+	PasskeyOrigin string `json:"passkey_origin"`
 }
 
 func DefaultPaths() (configPath, dataDir string, err error) {
@@ -96,6 +174,8 @@ func Default(dataDir string) Config {
 		CollisionPolicy:    CollisionRename,
 		AllowDelete:        false,
 		AllowRename:        false,
+		MaxArchiveEntries:  DefaultMaxArchiveEntries,
+		MaxExtractSizeMB:   DefaultMaxExtractSizeMB,
 	}
 }
 
@@ -197,20 +277,58 @@ func Validate(cfg Config) error {
 	default:
 		return fmt.Errorf("invalid guest mode %q", cfg.GuestMode)
 	}
-	switch cfg.CollisionPolicy {
-	case CollisionRename, CollisionOverwrite:
-	default:
+	if !ValidCollisionPolicy(cfg.CollisionPolicy) {
 		return fmt.Errorf("invalid collision policy %q", cfg.CollisionPolicy)
 	}
 	if cfg.MaxUploadSizeMB <= 0 {
 		return fmt.Errorf("max upload size must be positive")
 	}
+	if cfg.MaxArchiveEntries <= 0 {
+		return fmt.Errorf("max archive entries must be positive")
+	}
+	if cfg.MaxExtractSizeMB <= 0 {
+		return fmt.Errorf("max extract size must be positive")
+	}
 	if cfg.HTTPS && (cfg.CertFile == "" || cfg.KeyFile == "") {
 		return fmt.Errorf("https enabled but cert/key missing")
 	}
+	if err := cfg.LDAP.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.PasswordHash.Validate(); err != nil {
+		return err
+	}
+	if _, err := util.ParseIPList(strings.Join(cfg.TrustedProxies, ",")); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	if cfg.PasskeyOrigin != "" {
+		if _, _, err := ParsePasskeyOrigin(cfg.PasskeyOrigin); err != nil {
+			return fmt.Errorf("passkey_origin: %w", err)
+		}
+	}
 	return nil
 }
 
+// ParsePasskeyOrigin splits a passkey origin such as
+// "https://files.example.com:8443" into the relying party ID, its host name,
+// and the origin browsers report.
+func ParsePasskeyOrigin(s string) (rpID, origin string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", errors.New("must use http or https")
+	}
+	if u.Hostname() == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", errors.New("must be a scheme and host, with an optional port")
+	}
+	if net.ParseIP(u.Hostname()) != nil {
+		return "", "", errors.New("must use a host name, not an IP address")
+	}
+	return u.Hostname(), u.Scheme + "://" + u.Host, nil
+}
+
 func ConfigPathFromEnv() (string, error) {
 	if p := strings.TrimSpace(os.Getenv("SHAREHERE_CONFIG")); p != "" {
 		return p, nil
@@ -2,6 +2,54 @@ package config
 
 import "testing"
 
+func TestValidateCollisionPolicy(t *testing.T) {
+	cfg := Default(t.TempDir())
+	for _, p := range []string{CollisionRename, CollisionTimestamp, CollisionOverwrite, CollisionVersion, CollisionReject, CollisionAsk} {
+		cfg.CollisionPolicy = p
+		if err := Validate(cfg); err != nil {
+			t.Fatalf("Validate(%q) = %v", p, err)
+		}
+	}
+	cfg.CollisionPolicy = "clobber"
+	if err := Validate(cfg); err == nil {
+		t.Fatal("expected unknown collision policy to be rejected")
+	}
+}
+
+func TestValidateArchiveLimits(t *testing.T) {
+	cfg := Default(t.TempDir())
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Validate(defaults) = %v", err)
+	}
+	bad := cfg
+	bad.MaxArchiveEntries = 0
+	if err := Validate(bad); err == nil {
+		t.Fatal("expected zero archive entries to be rejected")
+	}
+	bad = cfg
+	bad.MaxExtractSizeMB = -1
+	if err := Validate(bad); err == nil {
+		t.Fatal("expected a negative extract size to be rejected")
+	}
+}
+
+func TestParsePasskeyOrigin(t *testing.T) {
+	rpID, origin, err := ParsePasskeyOrigin("https://files.example.com:8443/")
+	if err != nil || rpID != "files.example.com" || origin != "https://files.example.com:8443" {
+		t.Fatalf("ParsePasskeyOrigin() = %q, %q, %v", rpID, origin, err)
+	}
+	for _, bad := range []string{"files.example.com", "ftp://files.example.com", "https://10.0.0.1", "https://files.example.com/sub"} {
+		if _, _, err := ParsePasskeyOrigin(bad); err == nil {
+			t.Errorf("ParsePasskeyOrigin(%q) succeeded", bad)
+		}
+	}
+	cfg := Default(t.TempDir())
+	cfg.PasskeyOrigin = "https://[::1]"
+	if err := Validate(cfg); err == nil {
+		t.Fatal("expected an IP passkey origin to be rejected")
+	}
+}
+
 func TestNormalizeBasePath(t *testing.T) {
 	tests := []struct {
 		name string
@@ -1,6 +1,9 @@
 package db
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 func (s *Store) RecordAudit(actorUserID *int64, action, target, metadata string) error {
 	_, err := s.db.Exec(`INSERT INTO audit_logs(actor_user_id, action, target, metadata) VALUES (?, ?, ?, ?)`, actorUserID, action, target, metadata)
@@ -10,6 +13,17 @@ func (s *Store) RecordAudit(actorUserID *int64, action, target, metadata string)
 	return nil
 }
 
+// PurgeAuditLogs deletes audit entries older than cutoff and returns how
+// many.
+func (s *Store) PurgeAuditLogs(cutoff time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM audit_logs WHERE created_at < ?`, sqlTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("purge audit logs: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 func (s *Store) ListAudit(limit int) ([]AuditLog, error) {
 	if limit <= 0 {
 		limit = 100
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// GetFileChecksum returns a cached digest, or sql.ErrNoRows when the file has
+// not been hashed with algo at the given size and modification time.
+func (s *Store) GetFileChecksum(path, algo string, size int64, modTime time.Time) (string, error) {
+	var digest string
+	err := s.db.QueryRow(`SELECT digest FROM file_checksums WHERE path = ? AND algo = ? AND size = ? AND mod_time = ?`,
+		path, algo, size, modTime.UnixNano()).Scan(&digest)
+	if err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+func (s *Store) PutFileChecksum(path, algo string, size int64, modTime time.Time, digest string) error {
+	_, err := s.db.Exec(`INSERT INTO file_checksums(path, algo, size, mod_time, digest, computed_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path, algo) DO UPDATE SET size = excluded.size, mod_time = excluded.mod_time, digest = excluded.digest, computed_at = CURRENT_TIMESTAMP`,
+		path, algo, size, modTime.UnixNano(), digest)
+	if err != nil {
+		return fmt.Errorf("store checksum: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ListDirUsage returns every cached directory record.
+func (s *Store) ListDirUsage() ([]DirUsage, error) {
+	rows, err := s.db.Query(`SELECT path, mod_time, own_size, own_files, subdirs FROM dir_usage`)
+	if err != nil {
+		return nil, fmt.Errorf("list dir usage: %w", err)
+	}
+	defer rows.Close()
+	var out []DirUsage
+	for rows.Next() {
+		var u DirUsage
+		var modTime int64
+		var subdirs string
+		if err := rows.Scan(&u.Path, &modTime, &u.OwnSize, &u.OwnFiles, &subdirs); err != nil {
+			return nil, err
+		}
+		u.ModTime = time.Unix(0, modTime)
+		if subdirs != "" {
+			u.Subdirs = strings.Split(subdirs, "\n")
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// PutDirUsage stores records in one transaction.
+func (s *Store) PutDirUsage(records []DirUsage) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO dir_usage(path, mod_time, own_size, own_files, subdirs, scanned_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET mod_time = excluded.mod_time, own_size = excluded.own_size,
+			own_files = excluded.own_files, subdirs = excluded.subdirs, scanned_at = CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range records {
+		if _, err := stmt.Exec(u.Path, u.ModTime.UnixNano(), u.OwnSize, u.OwnFiles, strings.Join(u.Subdirs, "\n")); err != nil {
+			return fmt.Errorf("store dir usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteDirUsage removes records for directories that no longer exist.
+func (s *Store) DeleteDirUsage(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range paths {
+		if _, err := tx.Exec(`DELETE FROM dir_usage WHERE path = ?`, p); err != nil {
+			return fmt.Errorf("delete dir usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const dropBoxColumns = `id, path, link_token, per_uploader, created_by, created_at`
+
+func scanDropBox(row interface{ Scan(...any) error }) (DropBox, error) {
+	var d DropBox
+	var token sql.NullString
+	var perUploader int
+	if err := row.Scan(&d.ID, &d.Path, &token, &perUploader, &d.CreatedBy, &d.CreatedAt); err != nil {
+		return DropBox{}, err
+	}
+	if token.Valid {
+		t := token.String
+		d.LinkToken = &t
+	}
+	d.PerUploader = perUploader == 1
+	return d, nil
+}
+
+// SetDropBoxFolder makes d.Path a folder drop box, replacing any existing
+// settings for it.
+func (s *Store) SetDropBoxFolder(d DropBox) error {
+	_, err := s.db.Exec(`INSERT INTO drop_boxes(path, link_token, per_uploader, created_by, created_at)
+		VALUES (?, NULL, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path) WHERE link_token IS NULL DO UPDATE SET per_uploader = excluded.per_uploader`,
+		d.Path, boolToInt(d.PerUploader), d.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("set drop box: %w", err)
+	}
+	return nil
+}
+
+// GetDropBoxFolder returns the folder drop box at path, or sql.ErrNoRows.
+func (s *Store) GetDropBoxFolder(path string) (DropBox, error) {
+	return scanDropBox(s.db.QueryRow(`SELECT `+dropBoxColumns+` FROM drop_boxes WHERE path = ? AND link_token IS NULL`, path))
+}
+
+func (s *Store) DeleteDropBoxFolder(path string) error {
+	if _, err := s.db.Exec(`DELETE FROM drop_boxes WHERE path = ? AND link_token IS NULL`, path); err != nil {
+		return fmt.Errorf("delete drop box: %w", err)
+	}
+	return nil
+}
+
+// ListDropBoxFolders returns every folder drop box, ordered by path.
+func (s *Store) ListDropBoxFolders() ([]DropBox, error) {
+	rows, err := s.db.Query(`SELECT ` + dropBoxColumns + ` FROM drop_boxes WHERE link_token IS NULL ORDER BY path`)
+	if err != nil {
+		return nil, fmt.Errorf("list drop boxes: %w", err)
+	}
+	defer rows.Close()
+	out := make([]DropBox, 0)
+	for rows.Next() {
+		d, err := scanDropBox(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CreateDropBoxLink(d DropBox) error {
+	_, err := s.db.Exec(`INSERT INTO drop_boxes(path, link_token, per_uploader, created_by, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`, d.Path, d.LinkToken, boolToInt(d.PerUploader), d.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("create drop box link: %w", err)
+	}
+	return nil
+}
+
+// GetDropBoxLink returns the drop box behind a share link, or sql.ErrNoRows.
+func (s *Store) GetDropBoxLink(token string) (DropBox, error) {
+	return scanDropBox(s.db.QueryRow(`SELECT `+dropBoxColumns+` FROM drop_boxes WHERE link_token = ?`, token))
+}
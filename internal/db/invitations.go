@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvitationUnusable means an invitation doesn't exist, was used or has
+// expired.
+var ErrInvitationUnusable = errors.New("invitation is invalid, used or expired")
+
+const invitationColumns = `token, role, create_home, created_by, expires_at, created_at, used_at, used_by`
+
+func scanInvitation(row interface{ Scan(...any) error }) (Invitation, error) {
+	var inv Invitation
+	var createHome int
+	var usedAt sqlNullTime
+	var usedBy sql.NullString
+	if err := row.Scan(&inv.Token, &inv.Role, &createHome, &inv.CreatedBy, &inv.ExpiresAt, &inv.CreatedAt, &usedAt, &usedBy); err != nil {
+		return Invitation{}, err
+	}
+	inv.CreateHome = createHome == 1
+	if usedAt.Valid {
+		t := usedAt.Time
+		inv.UsedAt = &t
+	}
+	if usedBy.Valid {
+		inv.UsedBy = &usedBy.String
+	}
+	return inv, nil
+}
+
+func (s *Store) CreateInvitation(inv Invitation) error {
+	_, err := s.db.Exec(`INSERT INTO invitations(token, role, create_home, created_by, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		inv.Token, inv.Role, boolToInt(inv.CreateHome), inv.CreatedBy, inv.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("create invitation: %w", err)
+	}
+	return nil
+}
+
+// GetUsableInvitation returns the invitation behind token if it can still be
+// redeemed, or ErrInvitationUnusable.
+func (s *Store) GetUsableInvitation(token string) (Invitation, error) {
+	inv, err := scanInvitation(s.db.QueryRow(`SELECT `+invitationColumns+` FROM invitations WHERE token = ?`, token))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !inv.Usable(time.Now())) {
+		return Invitation{}, ErrInvitationUnusable
+	}
+	return inv, err
+}
+
+func (s *Store) ListInvitations() ([]Invitation, error) {
+	rows, err := s.db.Query(`SELECT ` + invitationColumns + ` FROM invitations ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list invitations: %w", err)
+	}
+	defer rows.Close()
+	items := make([]Invitation, 0)
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, inv)
+	}
+	return items, rows.Err()
+}
+
+// DeleteInvitation withdraws an invitation, or reports sql.ErrNoRows.
+func (s *Store) DeleteInvitation(token string) error {
+	res, err := s.db.Exec(`DELETE FROM invitations WHERE token = ?`, token)
+	if err != nil {
+		return fmt.Errorf("delete invitation: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RedeemInvitation creates the account an invitation is for and uses the
+// invitation up, both or neither. It returns the invitation as it was.
+func (s *Store) RedeemInvitation(token, username, passwordHash string) (Invitation, int64, error) {
+	username = strings.TrimSpace(strings.ToLower(username))
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Invitation{}, 0, fmt.Errorf("redeem invitation: %w", err)
+	}
+	defer tx.Rollback()
+	inv, err := scanInvitation(tx.QueryRow(`SELECT `+invitationColumns+` FROM invitations WHERE token = ?`, token))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !inv.Usable(time.Now())) {
+		return Invitation{}, 0, ErrInvitationUnusable
+	}
+	if err != nil {
+		return Invitation{}, 0, fmt.Errorf("redeem invitation: %w", err)
+	}
+	res, err := tx.Exec(`INSERT INTO users(username, password_hash, role) VALUES (?, ?, ?)`, username, passwordHash, inv.Role)
+	if err != nil {
+		return Invitation{}, 0, fmt.Errorf("create user: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Invitation{}, 0, fmt.Errorf("user id: %w", err)
+	}
+	// The used_at check keeps two redemptions racing from both succeeding.
+	res, err = tx.Exec(`UPDATE invitations SET used_at = CURRENT_TIMESTAMP, used_by = ? WHERE token = ? AND used_at IS NULL`, username, token)
+	if err != nil {
+		return Invitation{}, 0, fmt.Errorf("redeem invitation: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Invitation{}, 0, ErrInvitationUnusable
+	}
+	if err := tx.Commit(); err != nil {
+		return Invitation{}, 0, fmt.Errorf("redeem invitation: %w", err)
+	}
+	return inv, id, nil
+}
+
+// PurgeExpiredInvitations deletes invitations that expired before cutoff,
+// used or not, and returns how many.
+func (s *Store) PurgeExpiredInvitations(cutoff time.Time) (int, error) {
+	n, err := s.deleteExpired("invitations", "token", "expires_at", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge invitations: %w", err)
+	}
+	return n, nil
+}
@@ -66,3 +66,14 @@ func (s *Store) ResetLoginAttempts(key string) error {
 	}
 	return nil
 }
+
+// PurgeLoginAttempts deletes failed-login counters untouched since cutoff and
+// returns how many. Lockouts are far shorter than any sensible cutoff.
+func (s *Store) PurgeLoginAttempts(cutoff time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM login_attempts WHERE updated_at < ?`, sqlTimestamp(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("purge login attempts: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (s *Store) CreateNotification(n Notification) error {
+	_, err := s.db.Exec(`INSERT INTO notifications(user_id, kind, message, path, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`, n.UserID, n.Kind, n.Message, n.Path)
+	if err != nil {
+		return fmt.Errorf("create notification: %w", err)
+	}
+	return nil
+}
+
+// ListNotifications returns the newest notifications of a user.
+func (s *Store) ListNotifications(userID int64, limit int) ([]Notification, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := s.db.Query(`SELECT id, user_id, kind, message, path, created_at, read_at
+		FROM notifications WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list notifications: %w", err)
+	}
+	defer rows.Close()
+	out := make([]Notification, 0)
+	for rows.Next() {
+		var n Notification
+		var read sqlNullTime
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Message, &n.Path, &n.CreatedAt, &read); err != nil {
+			return nil, err
+		}
+		if read.Valid {
+			t := read.Time
+			n.ReadAt = &t
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CountUnreadNotifications(userID int64) (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count notifications: %w", err)
+	}
+	return n, nil
+}
+
+// MarkNotificationsRead marks the given notifications of a user as read, or
+// all of them when ids is empty.
+func (s *Store) MarkNotificationsRead(userID int64, ids []int64) error {
+	query := `UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL`
+	args := []any{userID}
+	if len(ids) > 0 {
+		query += ` AND id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("mark notifications read: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const passkeySelect = `SELECT id, user_id, credential_id, name, credential, created_at, last_used_at FROM passkeys`
+
+func scanPasskey(row interface{ Scan(...any) error }) (Passkey, error) {
+	var p Passkey
+	var used sqlNullTime
+	if err := row.Scan(&p.ID, &p.UserID, &p.CredentialID, &p.Name, &p.Credential, &p.CreatedAt, &used); err != nil {
+		return Passkey{}, err
+	}
+	if used.Valid {
+		t := used.Time
+		p.LastUsedAt = &t
+	}
+	return p, nil
+}
+
+func (s *Store) CreatePasskey(p Passkey) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO passkeys(user_id, credential_id, name, credential, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`, p.UserID, p.CredentialID, p.Name, string(p.Credential))
+	if err != nil {
+		return 0, fmt.Errorf("create passkey: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) ListPasskeys(userID int64) ([]Passkey, error) {
+	rows, err := s.db.Query(passkeySelect+` WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list passkeys: %w", err)
+	}
+	defer rows.Close()
+	out := make([]Passkey, 0)
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) GetPasskeyByCredentialID(credentialID []byte) (Passkey, error) {
+	return scanPasskey(s.db.QueryRow(passkeySelect+` WHERE credential_id = ?`, credentialID))
+}
+
+// UpdatePasskeyUse stores the credential record after a login, which carries
+// the new signature counter, and marks the passkey used.
+func (s *Store) UpdatePasskeyUse(id int64, credential []byte) error {
+	_, err := s.db.Exec(`UPDATE passkeys SET credential = ?, last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, string(credential), id)
+	if err != nil {
+		return fmt.Errorf("update passkey: %w", err)
+	}
+	return nil
+}
+
+// DeletePasskey deletes a passkey of a user, or of any user when userID is
+// 0. It returns sql.ErrNoRows when there is no such passkey.
+func (s *Store) DeletePasskey(id, userID int64) error {
+	query := `DELETE FROM passkeys WHERE id = ?`
+	args := []any{id}
+	if userID != 0 {
+		query += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("delete passkey: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserPasskeys deletes all passkeys of a user and returns how many.
+func (s *Store) DeleteUserPasskeys(userID int64) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM passkeys WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("delete passkeys: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (s *Store) CountPasskeys(userID int64) (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM passkeys WHERE user_id = ?`, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count passkeys: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"fmt"
+)
+
+func (s *Store) CreateQuarantinedFile(q QuarantinedFile) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO quarantine(blob, path, size, signature, uploaded_by, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`, q.Blob, q.Path, q.Size, q.Signature, q.UploadedBy)
+	if err != nil {
+		return 0, fmt.Errorf("quarantine file: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+const quarantineSelect = `SELECT q.id, q.blob, q.path, q.size, q.signature, q.uploaded_by, u.username, q.created_at
+	FROM quarantine q LEFT JOIN users u ON u.id = q.uploaded_by`
+
+func scanQuarantinedFile(row interface{ Scan(...any) error }) (QuarantinedFile, error) {
+	var q QuarantinedFile
+	if err := row.Scan(&q.ID, &q.Blob, &q.Path, &q.Size, &q.Signature, &q.UploadedBy, &q.Username, &q.CreatedAt); err != nil {
+		return QuarantinedFile{}, err
+	}
+	return q, nil
+}
+
+// ListQuarantinedFiles returns every quarantined file, newest first.
+func (s *Store) ListQuarantinedFiles() ([]QuarantinedFile, error) {
+	rows, err := s.db.Query(quarantineSelect + ` ORDER BY q.created_at DESC, q.id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list quarantine: %w", err)
+	}
+	defer rows.Close()
+	out := make([]QuarantinedFile, 0)
+	for rows.Next() {
+		q, err := scanQuarantinedFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// GetQuarantinedFile returns one quarantined file, or sql.ErrNoRows.
+func (s *Store) GetQuarantinedFile(id int64) (QuarantinedFile, error) {
+	return scanQuarantinedFile(s.db.QueryRow(quarantineSelect+` WHERE q.id = ?`, id))
+}
+
+func (s *Store) DeleteQuarantinedFile(id int64) error {
+	if _, err := s.db.Exec(`DELETE FROM quarantine WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete quarantined file: %w", err)
+	}
+	return nil
+}
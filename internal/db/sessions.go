@@ -1,19 +1,25 @@
 package db
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"time"
 )
 
 func (s *Store) CreateSession(sess Session) error {
-	remember := 0
+	remember, allowChanges := 0, 0
 	if sess.Remember {
 		remember = 1
 	}
-	_, err := s.db.Exec(`INSERT INTO sessions(token, user_id, csrf_token, remember, ip, user_agent, expires_at, created_at, last_seen_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
-		sess.Token, sess.UserID, sess.CSRFToken, remember, sess.IP, sess.UserAgent, sess.ExpiresAt)
+	if sess.AllowChanges {
+		allowChanges = 1
+	}
+	_, err := s.db.Exec(`INSERT INTO sessions(token, user_id, csrf_token, remember, ip, user_agent, expires_at, restriction, impersonator_id, return_token, allow_changes, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		sess.Token, sess.UserID, sess.CSRFToken, remember, sess.IP, sess.UserAgent, sess.ExpiresAt, sess.Restriction,
+		sess.ImpersonatorID, sess.ReturnToken, allowChanges)
 	if err != nil {
 		return fmt.Errorf("create session: %w", err)
 	}
@@ -22,14 +28,16 @@ func (s *Store) CreateSession(sess Session) error {
 
 func (s *Store) GetSession(token string) (Session, error) {
 	var sess Session
-	var remember int
-	err := s.db.QueryRow(`SELECT token, user_id, csrf_token, remember, ip, user_agent, expires_at, created_at, last_seen_at
+	var remember, allowChanges int
+	err := s.db.QueryRow(`SELECT token, user_id, csrf_token, remember, ip, user_agent, expires_at, restriction, impersonator_id, return_token, allow_changes, created_at, last_seen_at
 		FROM sessions WHERE token = ?`, token).
-		Scan(&sess.Token, &sess.UserID, &sess.CSRFToken, &remember, &sess.IP, &sess.UserAgent, &sess.ExpiresAt, &sess.CreatedAt, &sess.LastSeenAt)
+		Scan(&sess.Token, &sess.UserID, &sess.CSRFToken, &remember, &sess.IP, &sess.UserAgent, &sess.ExpiresAt, &sess.Restriction,
+			&sess.ImpersonatorID, &sess.ReturnToken, &allowChanges, &sess.CreatedAt, &sess.LastSeenAt)
 	if err != nil {
 		return Session{}, err
 	}
 	sess.Remember = remember == 1
+	sess.AllowChanges = allowChanges == 1
 	if time.Now().After(sess.ExpiresAt) {
 		_ = s.DeleteSession(token)
 		return Session{}, sql.ErrNoRows
@@ -54,13 +62,17 @@ func (s *Store) RotateSession(oldToken string, newSession Session) error {
 	if _, err := tx.Exec(`DELETE FROM sessions WHERE token = ?`, oldToken); err != nil {
 		return err
 	}
-	remember := 0
+	remember, allowChanges := 0, 0
 	if newSession.Remember {
 		remember = 1
 	}
-	if _, err := tx.Exec(`INSERT INTO sessions(token, user_id, csrf_token, remember, ip, user_agent, expires_at, created_at, last_seen_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
-		newSession.Token, newSession.UserID, newSession.CSRFToken, remember, newSession.IP, newSession.UserAgent, newSession.ExpiresAt); err != nil {
+	if newSession.AllowChanges {
+		allowChanges = 1
+	}
+	if _, err := tx.Exec(`INSERT INTO sessions(token, user_id, csrf_token, remember, ip, user_agent, expires_at, restriction, impersonator_id, return_token, allow_changes, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		newSession.Token, newSession.UserID, newSession.CSRFToken, remember, newSession.IP, newSession.UserAgent, newSession.ExpiresAt, newSession.Restriction,
+		newSession.ImpersonatorID, newSession.ReturnToken, allowChanges); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -74,10 +86,100 @@ func (s *Store) DeleteSession(token string) error {
 	return nil
 }
 
-func (s *Store) PurgeExpiredSessions() error {
-	_, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP`)
+// LiftSessionRestriction clears restriction from every session of a user.
+func (s *Store) LiftSessionRestriction(userID int64, restriction string) error {
+	_, err := s.db.Exec(`UPDATE sessions SET restriction = '' WHERE user_id = ? AND restriction = ?`, userID, restriction)
 	if err != nil {
-		return fmt.Errorf("purge sessions: %w", err)
+		return fmt.Errorf("lift session restriction: %w", err)
 	}
 	return nil
 }
+
+// PurgeExpiredSessions deletes expired sessions and returns how many.
+func (s *Store) PurgeExpiredSessions() (int, error) {
+	n, err := s.deleteExpired("sessions", "token", "expires_at", time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("purge sessions: %w", err)
+	}
+	return n, nil
+}
+
+// SessionID returns the public ID of the session with the given token.
+func SessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ListSessions returns the unexpired sessions of signed-in users, most
+// recently used first. A userID of 0 lists the sessions of every user.
+func (s *Store) ListSessions(userID int64) ([]SessionInfo, error) {
+	tokens, sessions, err := s.listSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].ID = SessionID(tokens[i])
+	}
+	return sessions, nil
+}
+
+// DeleteSessionByID signs out the session with the given public ID. A
+// non-zero userID restricts this to that user's sessions. It returns
+// sql.ErrNoRows when no such session exists.
+func (s *Store) DeleteSessionByID(id string, userID int64) (SessionInfo, error) {
+	tokens, sessions, err := s.listSessions(userID)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	for i, token := range tokens {
+		if SessionID(token) == id {
+			sessions[i].ID = id
+			return sessions[i], s.DeleteSession(token)
+		}
+	}
+	return SessionInfo{}, sql.ErrNoRows
+}
+
+func (s *Store) listSessions(userID int64) ([]string, []SessionInfo, error) {
+	query := `SELECT s.token, s.user_id, u.username, s.remember, COALESCE(s.ip, ''), COALESCE(s.user_agent, ''), s.expires_at, s.created_at, s.last_seen_at
+		FROM sessions s JOIN users u ON u.id = s.user_id`
+	args := []any{}
+	if userID != 0 {
+		query += ` WHERE s.user_id = ?`
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY s.last_seen_at DESC`, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+	now := time.Now()
+	tokens := make([]string, 0)
+	out := make([]SessionInfo, 0)
+	for rows.Next() {
+		var si SessionInfo
+		var token string
+		var remember int
+		if err := rows.Scan(&token, &si.UserID, &si.Username, &remember, &si.IP, &si.UserAgent, &si.ExpiresAt, &si.CreatedAt, &si.LastSeenAt); err != nil {
+			return nil, nil, err
+		}
+		if now.After(si.ExpiresAt) {
+			continue
+		}
+		si.Remember = remember == 1
+		tokens = append(tokens, token)
+		out = append(out, si)
+	}
+	return tokens, out, rows.Err()
+}
+
+// DeleteUserSessions signs a user out everywhere except in the session with
+// token keep, which may be empty. It returns how many sessions ended.
+func (s *Store) DeleteUserSessions(userID int64, keep string) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ? AND token <> ?`, userID, keep)
+	if err != nil {
+		return 0, fmt.Errorf("delete user sessions: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
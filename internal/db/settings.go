@@ -3,22 +3,64 @@ package db
 import (
 	"fmt"
 	"strconv"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
 )
 
 var defaultSettings = map[string]string{
-	"guest_mode":           "read",
-	"max_upload_size_mb":   "1024",
-	"upload_allow_regex":   "",
-	"upload_deny_regex":    "",
-	"upload_subdir":        "",
-	"collision_policy":     "rename",
-	"default_share_expiry": "24h",
-	"allow_delete":         "false",
-	"allow_rename":         "false",
-	"read_only":            "false",
-	"theme":                "light",
-	"theme_overrides_json": "{}",
-	"virus_scan_command":   "",
+	"guest_mode":             "read",
+	"max_upload_size_mb":     "1024",
+	"upload_allow_regex":     "",
+	"upload_deny_regex":      "",
+	"upload_allow_types":     "",
+	"upload_deny_types":      "",
+	"upload_max_files":       "0",
+	"upload_max_depth":       "0",
+	"upload_normalize_names": "false",
+	"upload_subdir":          "",
+	"collision_policy":       "rename",
+	"default_share_expiry":   "24h",
+	"allow_delete":           "false",
+	"allow_rename":           "false",
+	"read_only":              "false",
+	"theme":                  "light",
+	"theme_overrides_json":   "{}",
+	"virus_scan_command":     "",
+	"virus_scan_clamd":       "",
+	"virus_scan_sync":        "false",
+	"versioning_enabled":     "false",
+	"version_keep":           "10",
+	"version_max_age":        "",
+	"home_dirs_enabled":      "false",
+	"home_dirs_path":         "home",
+	"users_see_only_home":    "false",
+	"maintenance_interval":   "1h",
+	"vacuum_interval":        "168h",
+	"audit_retention":        "8760h",
+	"passkey_policy":         "optional",
+	"password_min_length":    "8",
+	"password_min_classes":   "0",
+	"password_deny_username": "true",
+	"password_breached_list": "",
+	"open_registration":      "false",
+	"net_guest_browse_allow": "",
+	"net_guest_browse_deny":  "",
+	"net_guest_upload_allow": "",
+	"net_guest_upload_deny":  "",
+	"net_login_allow":        "",
+	"net_login_deny":         "",
+	"net_share_allow":        "",
+	"net_share_deny":         "",
+	"net_admin_allow":        "",
+	"net_admin_deny":         "",
+	"rate_limit_ip":          "0",
+	"rate_limit_user":        "0",
+	"rate_limit_burst":       "30",
+	"download_rate_kib":      "0",
+	"download_total_kib":     "0",
+	"upload_rate_kib":        "0",
+	"upload_total_kib":       "0",
+	"downloads_per_client":   "0",
 }
 
 func (s *Store) ensureDefaultSettings() error {
@@ -83,6 +125,33 @@ func (s *Store) GetAppSettings() (AppSettings, error) {
 	if result.UploadDenyRegex, err = read("upload_deny_regex"); err != nil {
 		return AppSettings{}, err
 	}
+	if result.UploadAllowTypes, err = read("upload_allow_types"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.UploadDenyTypes, err = read("upload_deny_types"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err := read("upload_max_files")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.UploadMaxFiles, _ = strconv.Atoi(v)
+	if result.UploadMaxFiles < 0 {
+		result.UploadMaxFiles = 0
+	}
+	v, err = read("upload_max_depth")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.UploadMaxDepth, _ = strconv.Atoi(v)
+	if result.UploadMaxDepth < 0 {
+		result.UploadMaxDepth = 0
+	}
+	v, err = read("upload_normalize_names")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.UploadNormalizeNames = parseBool(v)
 	if result.UploadSubdir, err = read("upload_subdir"); err != nil {
 		return AppSettings{}, err
 	}
@@ -92,7 +161,7 @@ func (s *Store) GetAppSettings() (AppSettings, error) {
 	if result.DefaultShareExpiry, err = read("default_share_expiry"); err != nil {
 		return AppSettings{}, err
 	}
-	v, err := read("allow_delete")
+	v, err = read("allow_delete")
 	if err != nil {
 		return AppSettings{}, err
 	}
@@ -116,24 +185,206 @@ func (s *Store) GetAppSettings() (AppSettings, error) {
 	if result.VirusScanCommand, err = read("virus_scan_command"); err != nil {
 		return AppSettings{}, err
 	}
+	if result.VirusScanClamd, err = read("virus_scan_clamd"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err = read("virus_scan_sync")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.VirusScanSync = parseBool(v)
+	v, err = read("versioning_enabled")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.VersioningEnabled = parseBool(v)
+	v, err = read("version_keep")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.VersionKeep, _ = strconv.ParseInt(v, 10, 64)
+	if result.VersionKeep < 0 {
+		result.VersionKeep = 0
+	}
+	if result.VersionMaxAge, err = read("version_max_age"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err = read("home_dirs_enabled")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.HomeDirsEnabled = parseBool(v)
+	if result.HomeDirsPath, err = read("home_dirs_path"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err = read("users_see_only_home")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.UsersSeeOnlyHome = parseBool(v)
+	if result.MaintenanceInterval, err = read("maintenance_interval"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.VacuumInterval, err = read("vacuum_interval"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.AuditRetention, err = read("audit_retention"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.PasskeyPolicy, err = read("passkey_policy"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err = read("password_min_length")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.PasswordMinLength, _ = strconv.Atoi(v)
+	v, err = read("password_min_classes")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.PasswordMinClasses, _ = strconv.Atoi(v)
+	v, err = read("password_deny_username")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.PasswordDenyUsername = parseBool(v)
+	if result.PasswordBreachedList, err = read("password_breached_list"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err = read("open_registration")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.OpenRegistration = parseBool(v)
+	if result.NetGuestBrowseAllow, err = read("net_guest_browse_allow"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetGuestBrowseDeny, err = read("net_guest_browse_deny"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetGuestUploadAllow, err = read("net_guest_upload_allow"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetGuestUploadDeny, err = read("net_guest_upload_deny"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetLoginAllow, err = read("net_login_allow"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetLoginDeny, err = read("net_login_deny"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetShareAllow, err = read("net_share_allow"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetShareDeny, err = read("net_share_deny"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetAdminAllow, err = read("net_admin_allow"); err != nil {
+		return AppSettings{}, err
+	}
+	if result.NetAdminDeny, err = read("net_admin_deny"); err != nil {
+		return AppSettings{}, err
+	}
+	v, err = read("rate_limit_ip")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.RateLimitIP, _ = strconv.Atoi(v)
+	v, err = read("rate_limit_user")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.RateLimitUser, _ = strconv.Atoi(v)
+	v, err = read("rate_limit_burst")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.RateLimitBurst, _ = strconv.Atoi(v)
+	v, err = read("download_rate_kib")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.DownloadRateKiB, _ = strconv.Atoi(v)
+	v, err = read("download_total_kib")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.DownloadTotalKiB, _ = strconv.Atoi(v)
+	v, err = read("upload_rate_kib")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.UploadRateKiB, _ = strconv.Atoi(v)
+	v, err = read("upload_total_kib")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.UploadTotalKiB, _ = strconv.Atoi(v)
+	v, err = read("downloads_per_client")
+	if err != nil {
+		return AppSettings{}, err
+	}
+	result.DownloadsPerClient, _ = strconv.Atoi(v)
 	return result, nil
 }
 
 func (s *Store) SetAppSettings(v AppSettings) error {
 	entries := map[string]string{
-		"guest_mode":           v.GuestMode,
-		"max_upload_size_mb":   strconv.FormatInt(v.MaxUploadSizeMB, 10),
-		"upload_allow_regex":   v.UploadAllowRegex,
-		"upload_deny_regex":    v.UploadDenyRegex,
-		"upload_subdir":        v.UploadSubdir,
-		"collision_policy":     v.CollisionPolicy,
-		"default_share_expiry": v.DefaultShareExpiry,
-		"allow_delete":         strconv.FormatBool(v.AllowDelete),
-		"allow_rename":         strconv.FormatBool(v.AllowRename),
-		"read_only":            strconv.FormatBool(v.ReadOnly),
-		"theme":                v.Theme,
-		"theme_overrides_json": v.ThemeOverridesJSON,
-		"virus_scan_command":   v.VirusScanCommand,
+		"guest_mode":             v.GuestMode,
+		"max_upload_size_mb":     strconv.FormatInt(v.MaxUploadSizeMB, 10),
+		"upload_allow_regex":     v.UploadAllowRegex,
+		"upload_deny_regex":      v.UploadDenyRegex,
+		"upload_allow_types":     v.UploadAllowTypes,
+		"upload_deny_types":      v.UploadDenyTypes,
+		"upload_max_files":       strconv.Itoa(v.UploadMaxFiles),
+		"upload_max_depth":       strconv.Itoa(v.UploadMaxDepth),
+		"upload_normalize_names": strconv.FormatBool(v.UploadNormalizeNames),
+		"upload_subdir":          v.UploadSubdir,
+		"collision_policy":       v.CollisionPolicy,
+		"default_share_expiry":   v.DefaultShareExpiry,
+		"allow_delete":           strconv.FormatBool(v.AllowDelete),
+		"allow_rename":           strconv.FormatBool(v.AllowRename),
+		"read_only":              strconv.FormatBool(v.ReadOnly),
+		"theme":                  v.Theme,
+		"theme_overrides_json":   v.ThemeOverridesJSON,
+		"virus_scan_command":     v.VirusScanCommand,
+		"virus_scan_clamd":       v.VirusScanClamd,
+		"virus_scan_sync":        strconv.FormatBool(v.VirusScanSync),
+		"versioning_enabled":     strconv.FormatBool(v.VersioningEnabled),
+		"version_keep":           strconv.FormatInt(v.VersionKeep, 10),
+		"version_max_age":        v.VersionMaxAge,
+		"home_dirs_enabled":      strconv.FormatBool(v.HomeDirsEnabled),
+		"home_dirs_path":         v.HomeDirsPath,
+		"users_see_only_home":    strconv.FormatBool(v.UsersSeeOnlyHome),
+		"maintenance_interval":   v.MaintenanceInterval,
+		"vacuum_interval":        v.VacuumInterval,
+		"audit_retention":        v.AuditRetention,
+		"passkey_policy":         v.PasskeyPolicy,
+		"password_min_length":    strconv.Itoa(v.PasswordMinLength),
+		"password_min_classes":   strconv.Itoa(v.PasswordMinClasses),
+		"password_deny_username": strconv.FormatBool(v.PasswordDenyUsername),
+		"password_breached_list": v.PasswordBreachedList,
+		"open_registration":      strconv.FormatBool(v.OpenRegistration),
+		"net_guest_browse_allow": v.NetGuestBrowseAllow,
+		"net_guest_browse_deny":  v.NetGuestBrowseDeny,
+		"net_guest_upload_allow": v.NetGuestUploadAllow,
+		"net_guest_upload_deny":  v.NetGuestUploadDeny,
+		"net_login_allow":        v.NetLoginAllow,
+		"net_login_deny":         v.NetLoginDeny,
+		"net_share_allow":        v.NetShareAllow,
+		"net_share_deny":         v.NetShareDeny,
+		"net_admin_allow":        v.NetAdminAllow,
+		"net_admin_deny":         v.NetAdminDeny,
+		"rate_limit_ip":          strconv.Itoa(v.RateLimitIP),
+		"rate_limit_user":        strconv.Itoa(v.RateLimitUser),
+		"rate_limit_burst":       strconv.Itoa(v.RateLimitBurst),
+		"download_rate_kib":      strconv.Itoa(v.DownloadRateKiB),
+		"download_total_kib":     strconv.Itoa(v.DownloadTotalKiB),
+		"upload_rate_kib":        strconv.Itoa(v.UploadRateKiB),
+		"upload_total_kib":       strconv.Itoa(v.UploadTotalKiB),
+		"downloads_per_client":   strconv.Itoa(v.DownloadsPerClient),
 	}
 	for k, val := range entries {
 		if err := s.SetSetting(k, val); err != nil {
@@ -142,3 +393,13 @@ func (s *Store) SetAppSettings(v AppSettings) error {
 	}
 	return nil
 }
+
+// PasswordPolicy is the policy new passwords are checked against.
+func (v AppSettings) PasswordPolicy() auth.PasswordPolicy {
+	return auth.PasswordPolicy{
+		MinLength:        v.PasswordMinLength,
+		MinClasses:       v.PasswordMinClasses,
+		DisallowUsername: v.PasswordDenyUsername,
+		BreachedList:     v.PasswordBreachedList,
+	}
+}
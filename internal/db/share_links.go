@@ -49,6 +49,16 @@ func (s *Store) RevokeShareLink(token string) error {
 	return nil
 }
 
+// PurgeExpiredShareLinks deletes links that expired before cutoff, along with
+// the drop boxes behind them, and returns how many.
+func (s *Store) PurgeExpiredShareLinks(cutoff time.Time) (int, error) {
+	n, err := s.deleteExpired("share_links", "token", "expires_at", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge share links: %w", err)
+	}
+	return n, nil
+}
+
 func (s *Store) ListShareLinks() ([]ShareLink, error) {
 	rows, err := s.db.Query(`SELECT token, path, mode, created_by, expires_at, revoked, created_at, last_accessed_at
 		FROM share_links ORDER BY created_at DESC`)
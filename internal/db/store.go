@@ -62,6 +62,61 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Checkpoint copies the write-ahead log into the database file and
+// truncates it.
+func (s *Store) Checkpoint() error {
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		return fmt.Errorf("wal checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file to give back the space of deleted rows.
+func (s *Store) Vacuum() error {
+	if _, err := s.db.Exec(`VACUUM;`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return s.Checkpoint()
+}
+
+// sqlTimestamp formats t like CURRENT_TIMESTAMP, for comparing with columns
+// filled by that default.
+func sqlTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// deleteExpired deletes the rows of table whose Go-written time column lies
+// before now. Such columns hold Go's time format, which doesn't compare
+// correctly as text, so the check happens here.
+func (s *Store) deleteExpired(table, keyCol, timeCol string, now time.Time) (int, error) {
+	rows, err := s.db.Query(`SELECT ` + keyCol + `, ` + timeCol + ` FROM ` + table)
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for rows.Next() {
+		var key string
+		var at time.Time
+		if err := rows.Scan(&key, &at); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if at.Before(now) {
+			expired = append(expired, key)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, key := range expired {
+		if _, err := s.db.Exec(`DELETE FROM `+table+` WHERE `+keyCol+` = ?`, key); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
 func (s *Store) migrate() error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS users (
@@ -116,9 +171,91 @@ func (s *Store) migrate() error {
 			locked_until DATETIME NULL,
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
+		`CREATE TABLE IF NOT EXISTS file_checksums (
+			path TEXT NOT NULL,
+			algo TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mod_time INTEGER NOT NULL,
+			digest TEXT NOT NULL,
+			computed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(path, algo)
+		);`,
+		`CREATE TABLE IF NOT EXISTS file_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			path TEXT NOT NULL,
+			blob TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mod_time INTEGER NOT NULL,
+			created_by INTEGER NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS dir_usage (
+			path TEXT PRIMARY KEY,
+			mod_time INTEGER NOT NULL,
+			own_size INTEGER NOT NULL,
+			own_files INTEGER NOT NULL,
+			subdirs TEXT NOT NULL DEFAULT '',
+			scanned_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS drop_boxes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			path TEXT NOT NULL,
+			link_token TEXT NULL UNIQUE,
+			per_uploader INTEGER NOT NULL DEFAULT 0,
+			created_by INTEGER NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(link_token) REFERENCES share_links(token) ON DELETE CASCADE,
+			FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			message TEXT NOT NULL,
+			path TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			read_at DATETIME NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS quarantine (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			blob TEXT NOT NULL UNIQUE,
+			path TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			signature TEXT NOT NULL DEFAULT '',
+			uploaded_by INTEGER NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(uploaded_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS passkeys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			credential_id BLOB NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			credential TEXT NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS invitations (
+			token TEXT PRIMARY KEY,
+			role TEXT NOT NULL,
+			create_home INTEGER NOT NULL DEFAULT 0,
+			created_by INTEGER NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			used_at DATETIME NULL,
+			used_by TEXT NULL,
+			FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+		);`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);`,
 		`CREATE INDEX IF NOT EXISTS idx_share_links_expiry ON share_links(expires_at);`,
 		`CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_logs(created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_file_versions_path ON file_versions(path, created_at);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_drop_boxes_folder ON drop_boxes(path) WHERE link_token IS NULL;`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_passkeys_user ON passkeys(user_id);`,
 	}
 
 	for _, q := range queries {
@@ -126,5 +263,34 @@ func (s *Store) migrate() error {
 			return fmt.Errorf("migrate failed: %w", err)
 		}
 	}
+
+	// Columns added to existing tables.
+	columns := []struct{ table, column, decl string }{
+		{"sessions", "restriction", `TEXT NOT NULL DEFAULT ''`},
+		{"sessions", "impersonator_id", `INTEGER NULL REFERENCES users(id) ON DELETE CASCADE`},
+		{"sessions", "return_token", `TEXT NOT NULL DEFAULT ''`},
+		{"sessions", "allow_changes", `INTEGER NOT NULL DEFAULT 0`},
+		{"users", "source", `TEXT NOT NULL DEFAULT 'local'`},
+		{"users", "must_change_password", `INTEGER NOT NULL DEFAULT 0`},
+		{"users", "pending", `INTEGER NOT NULL DEFAULT 0`},
+	}
+	for _, c := range columns {
+		if err := s.addColumn(c.table, c.column, c.decl); err != nil {
+			return fmt.Errorf("migrate failed: %w", err)
+		}
+	}
 	return nil
 }
+
+// addColumn adds a column to a table unless it is already there.
+func (s *Store) addColumn(table, column, decl string) error {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl)
+	return err
+}
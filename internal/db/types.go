@@ -2,20 +2,57 @@ package db
 
 import "time"
 
+// User is an account. Source is UserSourceLocal for accounts with a password
+// here and UserSourceLDAP for directory users, created on first sign-in.
+// MustChangePassword is set when an admin gave the user a temporary password.
+// Pending users signed up themselves and stay disabled until an admin
+// approves them.
 type User struct {
-	ID           int64     `json:"id"`
-	Username     string    `json:"username"`
-	Role         string    `json:"role"`
-	PasswordHash string    `json:"-"`
-	Disabled     bool      `json:"disabled"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID                 int64     `json:"id"`
+	Username           string    `json:"username"`
+	Role               string    `json:"role"`
+	PasswordHash       string    `json:"-"`
+	Disabled           bool      `json:"disabled"`
+	Source             string    `json:"source"`
+	MustChangePassword bool      `json:"must_change_password"`
+	Pending            bool      `json:"pending"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
+const (
+	UserSourceLocal = "local"
+	UserSourceLDAP  = "ldap"
+)
+
+// Session is a browser session, anonymous or signed in. A non-empty
+// Restriction limits a signed-in session to what it takes to lift it, such
+// as registering a passkey.
 type Session struct {
-	Token      string    `json:"token"`
-	UserID     *int64    `json:"user_id"`
-	CSRFToken  string    `json:"csrf_token"`
+	Token       string    `json:"token"`
+	UserID      *int64    `json:"user_id"`
+	CSRFToken   string    `json:"csrf_token"`
+	Remember    bool      `json:"remember"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	Restriction string    `json:"restriction"`
+	// ImpersonatorID is set on sessions an admin opened to view the app as
+	// UserID, or as a guest when UserID is nil. ReturnToken is the admin's
+	// own session, which they go back to afterwards.
+	ImpersonatorID *int64 `json:"impersonator_id,omitempty"`
+	ReturnToken    string `json:"-"`
+	AllowChanges   bool   `json:"allow_changes"`
+}
+
+// SessionInfo describes a signed-in session without its secrets. ID is
+// derived from the token, so it is stable but can't be used to sign in.
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Username   string    `json:"username"`
 	Remember   bool      `json:"remember"`
 	IP         string    `json:"ip"`
 	UserAgent  string    `json:"user_agent"`
@@ -46,19 +83,68 @@ type AuditLog struct {
 }
 
 type AppSettings struct {
-	GuestMode          string `json:"guest_mode"`
-	MaxUploadSizeMB    int64  `json:"max_upload_size_mb"`
-	UploadAllowRegex   string `json:"upload_allow_regex"`
-	UploadDenyRegex    string `json:"upload_deny_regex"`
-	UploadSubdir       string `json:"upload_subdir"`
-	CollisionPolicy    string `json:"collision_policy"`
-	DefaultShareExpiry string `json:"default_share_expiry"`
-	AllowDelete        bool   `json:"allow_delete"`
-	AllowRename        bool   `json:"allow_rename"`
-	ReadOnly           bool   `json:"read_only"`
-	Theme              string `json:"theme"`
-	ThemeOverridesJSON string `json:"theme_overrides_json"`
-	VirusScanCommand   string `json:"virus_scan_command"`
+	GuestMode            string `json:"guest_mode"`
+	MaxUploadSizeMB      int64  `json:"max_upload_size_mb"`
+	UploadAllowRegex     string `json:"upload_allow_regex"`
+	UploadDenyRegex      string `json:"upload_deny_regex"`
+	UploadAllowTypes     string `json:"upload_allow_types"`
+	UploadDenyTypes      string `json:"upload_deny_types"`
+	UploadMaxFiles       int    `json:"upload_max_files"`
+	UploadMaxDepth       int    `json:"upload_max_depth"`
+	UploadNormalizeNames bool   `json:"upload_normalize_names"`
+	UploadSubdir         string `json:"upload_subdir"`
+	CollisionPolicy      string `json:"collision_policy"`
+	DefaultShareExpiry   string `json:"default_share_expiry"`
+	AllowDelete          bool   `json:"allow_delete"`
+	AllowRename          bool   `json:"allow_rename"`
+	ReadOnly             bool   `json:"read_only"`
+	Theme                string `json:"theme"`
+	ThemeOverridesJSON   string `json:"theme_overrides_json"`
+	VirusScanCommand     string `json:"virus_scan_command"`
+	VirusScanClamd       string `json:"virus_scan_clamd"`
+	VirusScanSync        bool   `json:"virus_scan_sync"`
+	VersioningEnabled    bool   `json:"versioning_enabled"`
+	VersionKeep          int64  `json:"version_keep"`
+	VersionMaxAge        string `json:"version_max_age"`
+	HomeDirsEnabled      bool   `json:"home_dirs_enabled"`
+	HomeDirsPath         string `json:"home_dirs_path"`
+	UsersSeeOnlyHome     bool   `json:"users_see_only_home"`
+	MaintenanceInterval  string `json:"maintenance_interval"`
+	VacuumInterval       string `json:"vacuum_interval"`
+	AuditRetention       string `json:"audit_retention"`
+	PasskeyPolicy        string `json:"passkey_policy"`
+	// Password policy; see auth.PasswordPolicy.
+	PasswordMinLength    int    `json:"password_min_length"`
+	PasswordMinClasses   int    `json:"password_min_classes"`
+	PasswordDenyUsername bool   `json:"password_deny_username"`
+	PasswordBreachedList string `json:"password_breached_list"`
+	// OpenRegistration lets anyone sign up, pending an admin's approval.
+	OpenRegistration bool `json:"open_registration"`
+	// Network allow and deny lists of the guest modes, the sign-in pages,
+	// share links and the admin pages; see util.ParseIPList. An empty allow
+	// list allows every network not denied.
+	NetGuestBrowseAllow string `json:"net_guest_browse_allow"`
+	NetGuestBrowseDeny  string `json:"net_guest_browse_deny"`
+	NetGuestUploadAllow string `json:"net_guest_upload_allow"`
+	NetGuestUploadDeny  string `json:"net_guest_upload_deny"`
+	NetLoginAllow       string `json:"net_login_allow"`
+	NetLoginDeny        string `json:"net_login_deny"`
+	NetShareAllow       string `json:"net_share_allow"`
+	NetShareDeny        string `json:"net_share_deny"`
+	NetAdminAllow       string `json:"net_admin_allow"`
+	NetAdminDeny        string `json:"net_admin_deny"`
+	// Traffic limits, 0 meaning none: requests per minute per client address
+	// and per signed-in user, how many requests may come at once, download
+	// and upload KiB/s per transfer and in total, and downloads running at
+	// once per client.
+	RateLimitIP        int `json:"rate_limit_ip"`
+	RateLimitUser      int `json:"rate_limit_user"`
+	RateLimitBurst     int `json:"rate_limit_burst"`
+	DownloadRateKiB    int `json:"download_rate_kib"`
+	DownloadTotalKiB   int `json:"download_total_kib"`
+	UploadRateKiB      int `json:"upload_rate_kib"`
+	UploadTotalKiB     int `json:"upload_total_kib"`
+	DownloadsPerClient int `json:"downloads_per_client"`
 }
 
 type LoginAttempt struct {
@@ -67,3 +153,95 @@ type LoginAttempt struct {
 	LockedUntil *time.Time `json:"locked_until,omitempty"`
 	UpdatedAt  time.Time  `json:"updated_at"`
 }
+
+// DropBox makes a folder write-only for everyone but its owner and admins.
+// A folder drop box has no LinkToken; one backing a "dropbox" share link
+// carries the link's token. With PerUploader set each upload lands in its
+// own subfolder named after the uploader.
+type DropBox struct {
+	ID          int64     `json:"id"`
+	Path        string    `json:"path"`
+	LinkToken   *string   `json:"link_token,omitempty"`
+	PerUploader bool      `json:"per_uploader"`
+	CreatedBy   *int64    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Notification is a message for one user, such as files arriving in their
+// drop box.
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	Path      string     `json:"path"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// Invitation lets whoever holds Token create one account with Role, and a
+// home directory if CreateHome is set, until ExpiresAt.
+type Invitation struct {
+	Token      string     `json:"token"`
+	Role       string     `json:"role"`
+	CreateHome bool       `json:"create_home"`
+	CreatedBy  *int64     `json:"created_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	UsedBy     *string    `json:"used_by"`
+}
+
+// Usable reports whether the invitation can still be redeemed at now.
+func (inv Invitation) Usable(now time.Time) bool {
+	return inv.UsedAt == nil && now.Before(inv.ExpiresAt)
+}
+
+// Passkey is a WebAuthn credential of a user. Credential holds the verifier's
+// record of it as JSON.
+type Passkey struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	CredentialID []byte     `json:"-"`
+	Name         string     `json:"name"`
+	Credential   []byte     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// QuarantinedFile is an upload the virus scanner flagged. Blob names the file
+// inside the quarantine directory of the data dir; Path is where it was
+// uploaded to.
+type QuarantinedFile struct {
+	ID         int64     `json:"id"`
+	Blob       string    `json:"-"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Signature  string    `json:"signature"`
+	UploadedBy *int64    `json:"uploaded_by"`
+	Username   *string   `json:"username,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FileVersion is a prior copy of a file kept when it was overwritten. Blob
+// names the copy inside the versions directory of the data dir.
+type FileVersion struct {
+	ID        int64     `json:"id"`
+	Path      string    `json:"path"`
+	Blob      string    `json:"-"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	CreatedBy *int64    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DirUsage caches what a directory holds directly: the bytes and count of its
+// regular files and the names of its subdirectories. It stays valid while the
+// directory's modification time is unchanged.
+type DirUsage struct {
+	Path     string    `json:"path"`
+	ModTime  time.Time `json:"mod_time"`
+	OwnSize  int64     `json:"own_size"`
+	OwnFiles int64     `json:"own_files"`
+	Subdirs  []string  `json:"subdirs"`
+}
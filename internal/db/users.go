@@ -19,33 +19,59 @@ func (s *Store) CreateUser(username, passwordHash, role string) (int64, error) {
 	return id, nil
 }
 
-func (s *Store) GetUserByUsername(username string) (User, error) {
+// CreatePendingUser creates a signed-up user, disabled until ApproveUser.
+func (s *Store) CreatePendingUser(username, passwordHash, role string) (int64, error) {
 	username = strings.TrimSpace(strings.ToLower(username))
-	var u User
-	var disabled int
-	err := s.db.QueryRow(`SELECT id, username, password_hash, role, disabled, created_at, updated_at FROM users WHERE username = ?`, username).
-		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &disabled, &u.CreatedAt, &u.UpdatedAt)
+	res, err := s.db.Exec(`INSERT INTO users(username, password_hash, role, disabled, pending) VALUES (?, ?, ?, 1, 1)`, username, passwordHash, role)
 	if err != nil {
-		return User{}, err
+		return 0, fmt.Errorf("create user: %w", err)
 	}
-	u.Disabled = disabled == 1
-	return u, nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("user id: %w", err)
+	}
+	return id, nil
 }
 
-func (s *Store) GetUserByID(id int64) (User, error) {
-	var u User
-	var disabled int
-	err := s.db.QueryRow(`SELECT id, username, password_hash, role, disabled, created_at, updated_at FROM users WHERE id = ?`, id).
-		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &disabled, &u.CreatedAt, &u.UpdatedAt)
+// ApproveUser enables a pending user, or reports sql.ErrNoRows if there is
+// no such pending user.
+func (s *Store) ApproveUser(username string) error {
+	res, err := s.db.Exec(`UPDATE users SET disabled = 0, pending = 0, updated_at = CURRENT_TIMESTAMP WHERE username = ? AND pending = 1`,
+		strings.TrimSpace(strings.ToLower(username)))
 	if err != nil {
+		return fmt.Errorf("approve user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const userSelect = `SELECT id, username, password_hash, role, disabled, source, must_change_password, pending, created_at, updated_at FROM users`
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var u User
+	var disabled, mustChange, pending int
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &disabled, &u.Source, &mustChange, &pending, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		return User{}, err
 	}
 	u.Disabled = disabled == 1
+	u.MustChangePassword = mustChange == 1
+	u.Pending = pending == 1
 	return u, nil
 }
 
+func (s *Store) GetUserByUsername(username string) (User, error) {
+	username = strings.TrimSpace(strings.ToLower(username))
+	return scanUser(s.db.QueryRow(userSelect+` WHERE username = ?`, username))
+}
+
+func (s *Store) GetUserByID(id int64) (User, error) {
+	return scanUser(s.db.QueryRow(userSelect+` WHERE id = ?`, id))
+}
+
 func (s *Store) ListUsers() ([]User, error) {
-	rows, err := s.db.Query(`SELECT id, username, password_hash, role, disabled, created_at, updated_at FROM users ORDER BY username ASC`)
+	rows, err := s.db.Query(userSelect + ` ORDER BY username ASC`)
 	if err != nil {
 		return nil, fmt.Errorf("list users: %w", err)
 	}
@@ -53,17 +79,37 @@ func (s *Store) ListUsers() ([]User, error) {
 
 	out := make([]User, 0)
 	for rows.Next() {
-		var u User
-		var disabled int
-		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &disabled, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		u.Disabled = disabled == 1
 		out = append(out, u)
 	}
 	return out, rows.Err()
 }
 
+// SyncDirectoryUser returns the local account of a user the directory
+// vouched for, creating it on first sign-in and updating its role from the
+// directory's groups afterwards. It refuses to take over a local account of
+// the same name.
+func (s *Store) SyncDirectoryUser(username, role string) (User, error) {
+	username = strings.TrimSpace(strings.ToLower(username))
+	_, err := s.db.Exec(`INSERT INTO users(username, password_hash, role, source) VALUES (?, '', ?, ?)
+		ON CONFLICT(username) DO UPDATE SET role = excluded.role, updated_at = CURRENT_TIMESTAMP
+		WHERE users.source = excluded.source AND users.role <> excluded.role`, username, role, UserSourceLDAP)
+	if err != nil {
+		return User{}, fmt.Errorf("sync directory user: %w", err)
+	}
+	u, err := s.GetUserByUsername(username)
+	if err != nil {
+		return User{}, err
+	}
+	if u.Source != UserSourceLDAP {
+		return User{}, fmt.Errorf("sync directory user: %q is a %s account", username, u.Source)
+	}
+	return u, nil
+}
+
 func (s *Store) DeleteUser(username string) error {
 	res, err := s.db.Exec(`DELETE FROM users WHERE username = ?`, strings.TrimSpace(strings.ToLower(username)))
 	if err != nil {
@@ -76,32 +122,88 @@ func (s *Store) DeleteUser(username string) error {
 	return nil
 }
 
+// SetUserPassword changes a user's password and signs them out everywhere.
 func (s *Store) SetUserPassword(username, passwordHash string) error {
-	res, err := s.db.Exec(`UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE username = ?`, passwordHash, strings.TrimSpace(strings.ToLower(username)))
+	return s.updateUserAndRevoke(username, true, "set password",
+		`UPDATE users SET password_hash = ?, must_change_password = 0, updated_at = CURRENT_TIMESTAMP WHERE username = ?`, passwordHash)
+}
+
+// UpdatePasswordHash replaces the hash of a user's unchanged password, such
+// as one rehashed with new parameters. Sessions are left alone.
+func (s *Store) UpdatePasswordHash(id int64, passwordHash string) error {
+	if _, err := s.db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id); err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+	return nil
+}
+
+// SetTemporaryPassword is SetUserPassword for a password the user must
+// change after signing in with it.
+func (s *Store) SetTemporaryPassword(username, passwordHash string) error {
+	return s.updateUserAndRevoke(username, true, "set password",
+		`UPDATE users SET password_hash = ?, must_change_password = 1, updated_at = CURRENT_TIMESTAMP WHERE username = ?`, passwordHash)
+}
+
+// ChangeOwnPassword changes the password of a user who proved they know the
+// old one from the session keepToken. Their other sessions end; keepToken's
+// is left with restriction.
+func (s *Store) ChangeOwnPassword(userID int64, passwordHash, keepToken, restriction string) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("set password: %w", err)
+		return fmt.Errorf("change password: %w", err)
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE users SET password_hash = ?, must_change_password = 0, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
 		return sql.ErrNoRows
 	}
-	return nil
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ? AND token <> ?`, userID, keepToken); err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET restriction = ? WHERE token = ?`, restriction, keepToken); err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+	return tx.Commit()
 }
 
+// SetUserDisabled disables or enables a user, which also settles a pending
+// sign-up. Disabling signs them out everywhere.
 func (s *Store) SetUserDisabled(username string, disabled bool) error {
 	v := 0
 	if disabled {
 		v = 1
 	}
-	res, err := s.db.Exec(`UPDATE users SET disabled = ?, updated_at = CURRENT_TIMESTAMP WHERE username = ?`, v, strings.TrimSpace(strings.ToLower(username)))
+	return s.updateUserAndRevoke(username, disabled, "set disabled",
+		`UPDATE users SET disabled = ?, pending = 0, updated_at = CURRENT_TIMESTAMP WHERE username = ?`, v)
+}
+
+// updateUserAndRevoke runs an UPDATE of the named user whose last parameter
+// is the username and, if revoke is set, ends all of the user's sessions in
+// the same transaction.
+func (s *Store) updateUserAndRevoke(username string, revoke bool, what, query string, arg any) error {
+	username = strings.TrimSpace(strings.ToLower(username))
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(query, arg, username)
 	if err != nil {
-		return fmt.Errorf("set disabled: %w", err)
+		return fmt.Errorf("%s: %w", what, err)
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
 		return sql.ErrNoRows
 	}
-	return nil
+	if revoke {
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = (SELECT id FROM users WHERE username = ?)`, username); err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+	}
+	return tx.Commit()
 }
 
 func (s *Store) AdminCount() (int, error) {
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const fileVersionColumns = `id, path, blob, size, mod_time, created_by, created_at`
+
+func scanFileVersion(row interface{ Scan(...any) error }) (FileVersion, error) {
+	var v FileVersion
+	var modTime int64
+	var createdBy sql.NullInt64
+	if err := row.Scan(&v.ID, &v.Path, &v.Blob, &v.Size, &modTime, &createdBy, &v.CreatedAt); err != nil {
+		return FileVersion{}, err
+	}
+	v.ModTime = time.Unix(0, modTime)
+	if createdBy.Valid {
+		id := createdBy.Int64
+		v.CreatedBy = &id
+	}
+	return v, nil
+}
+
+func (s *Store) CreateFileVersion(v FileVersion) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO file_versions(path, blob, size, mod_time, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`, v.Path, v.Blob, v.Size, v.ModTime.UnixNano(), v.CreatedBy)
+	if err != nil {
+		return 0, fmt.Errorf("create file version: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) GetFileVersion(id int64) (FileVersion, error) {
+	return scanFileVersion(s.db.QueryRow(`SELECT `+fileVersionColumns+` FROM file_versions WHERE id = ?`, id))
+}
+
+// ListFileVersions returns the versions of path, newest first.
+func (s *Store) ListFileVersions(path string) ([]FileVersion, error) {
+	return s.queryFileVersions(`SELECT `+fileVersionColumns+` FROM file_versions WHERE path = ? ORDER BY created_at DESC, id DESC`, path)
+}
+
+// ListAllFileVersions returns every stored version, oldest first.
+func (s *Store) ListAllFileVersions() ([]FileVersion, error) {
+	return s.queryFileVersions(`SELECT ` + fileVersionColumns + ` FROM file_versions ORDER BY id`)
+}
+
+func (s *Store) queryFileVersions(query string, args ...any) ([]FileVersion, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list file versions: %w", err)
+	}
+	defer rows.Close()
+	out := make([]FileVersion, 0)
+	for rows.Next() {
+		v, err := scanFileVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) DeleteFileVersion(id int64) error {
+	if _, err := s.db.Exec(`DELETE FROM file_versions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete file version: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package dirsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Permissions mirrors the permission set reported by a sharehere server.
+type Permissions struct {
+	CanBrowse bool `json:"canBrowse"`
+	CanUpload bool `json:"canUpload"`
+	CanDelete bool `json:"canDelete"`
+	CanRename bool `json:"canRename"`
+	CanShare  bool `json:"canShare"`
+	CanAdmin  bool `json:"canAdmin"`
+	ReadOnly  bool `json:"readonly"`
+}
+
+// UploadPolicy is the subset of server settings that affects pushing files.
+type UploadPolicy struct {
+	CollisionPolicy string `json:"collisionPolicy"`
+	MaxUploadSizeMB int64  `json:"maxUploadSizeMB"`
+	UploadSubdir    string `json:"uploadSubdir"`
+}
+
+// RemoteManifest is the decoded response of /api/manifest.
+type RemoteManifest struct {
+	Path         string       `json:"path"`
+	Entries      []Entry      `json:"entries"`
+	Hashed       bool         `json:"hashed"`
+	Permissions  Permissions  `json:"permissions"`
+	UploadPolicy UploadPolicy `json:"uploadPolicy"`
+}
+
+// UploadFile describes one local file sent in a push request.
+type UploadFile struct {
+	RelPath string
+	ModTime time.Time
+	// SHA256 is sent as a checksum the server verifies before storing the
+	// file. It is only known when comparing by hash.
+	SHA256 string
+	Open   func() (io.ReadCloser, error)
+}
+
+// Client talks to a sharehere server over its HTTP API.
+type Client struct {
+	base string
+	http *http.Client
+	csrf string
+}
+
+var csrfInputRe = regexp.MustCompile(`name="_csrf" value="([^"]+)"`)
+
+// NewClient returns a client for the server rooted at baseURL, which may
+// include a reverse-proxy base path.
+func NewClient(baseURL string) (*Client, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url must use http or https")
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.Path = strings.TrimRight(u.Path, "/")
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		base: u.String(),
+		http: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// Login authenticates with a local username and password.
+func (c *Client) Login(username, password string) error {
+	res, err := c.http.Get(c.base + "/login")
+	if err != nil {
+		return fmt.Errorf("fetch login page: %w", err)
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	res.Body.Close()
+	m := csrfInputRe.FindSubmatch(body)
+	if m == nil {
+		return errors.New("login page did not include a csrf token")
+	}
+	form := url.Values{}
+	form.Set("_csrf", string(m[1]))
+	form.Set("username", username)
+	form.Set("password", password)
+	res, err = c.http.PostForm(c.base+"/login", form)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		return fmt.Errorf("login failed: %s", res.Status)
+	}
+	return nil
+}
+
+// Session loads the CSRF token and permissions of the current session.
+func (c *Client) Session() (Permissions, error) {
+	var me struct {
+		CSRFToken   string      `json:"csrfToken"`
+		Permissions Permissions `json:"permissions"`
+	}
+	if err := c.getJSON("/api/me", &me); err != nil {
+		return Permissions{}, err
+	}
+	c.csrf = me.CSRFToken
+	return me.Permissions, nil
+}
+
+// Manifest fetches the recursive listing of a remote directory.
+func (c *Client) Manifest(remotePath string, withHash bool) (RemoteManifest, error) {
+	q := url.Values{}
+	q.Set("path", remotePath)
+	if withHash {
+		q.Set("hash", "1")
+	}
+	var m RemoteManifest
+	if err := c.getJSON("/api/manifest?"+q.Encode(), &m); err != nil {
+		return RemoteManifest{}, err
+	}
+	return m, nil
+}
+
+// Download streams a remote file into w.
+func (c *Client) Download(remotePath string, w io.Writer) error {
+	res, err := c.http.Get(c.base + "/api/download?path=" + url.QueryEscape(remotePath))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// Delete removes a remote file or directory.
+func (c *Client) Delete(remotePath string) error {
+	payload, _ := json.Marshal(map[string]string{"path": remotePath})
+	req, err := http.NewRequest(http.MethodPost, c.base+"/api/delete", strings.NewReader(string(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, nil)
+}
+
+// Mkdir creates remote directories in order, so parents must come first. It
+// returns the per-directory errors reported by the server.
+func (c *Client) Mkdir(remotePaths []string) ([]string, error) {
+	payload, _ := json.Marshal(map[string][]string{"paths": remotePaths})
+	req, err := http.NewRequest(http.MethodPost, c.base+"/api/mkdir", strings.NewReader(string(payload)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		Errors []string `json:"errors"`
+	}
+	if err := c.do(req, &out); err != nil {
+		return nil, err
+	}
+	return out.Errors, nil
+}
+
+// Upload sends files to remoteDir in a single streamed multipart request.
+// Each file's directory is selected with a "path" part so nested trees only
+// need one round trip. It returns the per-file errors reported by the server.
+func (c *Client) Upload(remoteDir string, files []UploadFile) ([]string, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploadParts(mw, remoteDir, files))
+	}()
+	req, err := http.NewRequest(http.MethodPost, c.base+"/api/upload", pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var out struct {
+		Uploaded []string `json:"uploaded"`
+		Errors   []string `json:"errors"`
+	}
+	if err := c.do(req, &out); err != nil {
+		return nil, err
+	}
+	return out.Errors, nil
+}
+
+func writeUploadParts(mw *multipart.Writer, remoteDir string, files []UploadFile) error {
+	currentDir := "\x00"
+	for _, f := range files {
+		dir := joinRel(remoteDir, parentRel(f.RelPath))
+		if dir != currentDir {
+			if err := mw.WriteField("path", dir); err != nil {
+				return err
+			}
+			currentDir = dir
+		}
+		if !f.ModTime.IsZero() {
+			if err := mw.WriteField("mtime", strconv.FormatInt(f.ModTime.Unix(), 10)); err != nil {
+				return err
+			}
+		}
+		if f.SHA256 != "" {
+			if err := mw.WriteField("checksum", "sha256:"+f.SHA256); err != nil {
+				return err
+			}
+		}
+		part, err := mw.CreateFormFile("files", baseRel(f.RelPath))
+		if err != nil {
+			return err
+		}
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func (c *Client) getJSON(path string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	req.Header.Set("Accept", "application/json")
+	if req.Method != http.MethodGet && c.csrf != "" {
+		req.Header.Set("X-CSRF-Token", c.csrf)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	var payload struct {
+		Error string `json:"error"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		return fmt.Errorf("%s: %s", res.Status, payload.Error)
+	}
+	return fmt.Errorf("request failed: %s", res.Status)
+}
@@ -0,0 +1,223 @@
+// Package dirsync mirrors a local directory to or from a sharehere server.
+package dirsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CompareSize  = "size"
+	CompareMtime = "mtime"
+	CompareHash  = "hash"
+)
+
+const (
+	ActionCopy   = "copy"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionMkdir  = "mkdir"
+)
+
+// mtimeTolerance absorbs filesystems that store modification times with
+// coarse (FAT: 2s) precision.
+const mtimeTolerance = 2 * time.Second
+
+// Entry is one file or directory in a manifest, keyed by slash-separated
+// path relative to the synced directory.
+type Entry struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// Action is a single planned change on the destination side.
+type Action struct {
+	Kind  string
+	Path  string
+	IsDir bool
+	Size  int64
+}
+
+func (a Action) String() string {
+	if a.IsDir {
+		return fmt.Sprintf("%-6s %s/", a.Kind, a.Path)
+	}
+	return fmt.Sprintf("%-6s %s (%d bytes)", a.Kind, a.Path, a.Size)
+}
+
+// LocalManifest walks dir and returns its regular files and directories.
+func LocalManifest(dir string, withHash bool) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	err := filepath.WalkDir(dir, func(curr string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if curr == dir || d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, curr)
+		if err != nil {
+			return err
+		}
+		e := Entry{Path: filepath.ToSlash(rel), IsDir: d.IsDir(), ModTime: fi.ModTime().UTC()}
+		if !d.IsDir() {
+			e.Size = fi.Size()
+			if withHash {
+				if e.SHA256, err = hashFile(curr); err != nil {
+					return err
+				}
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Plan compares the source and destination trees and returns the actions
+// needed to make dst match src. Deletions are only planned when prune is set
+// and are collapsed to the top-most extraneous directory.
+func Plan(src, dst []Entry, compare string, prune bool) []Action {
+	dstByPath := make(map[string]Entry, len(dst))
+	for _, e := range dst {
+		dstByPath[e.Path] = e
+	}
+	srcByPath := make(map[string]Entry, len(src))
+	for _, e := range src {
+		srcByPath[e.Path] = e
+	}
+
+	actions := make([]Action, 0)
+	replaced := make([]string, 0)
+	for _, s := range src {
+		d, ok := dstByPath[s.Path]
+		if ok && d.IsDir != s.IsDir {
+			// Something of the other kind is in the way; remove it first.
+			replaced = append(replaced, d.Path)
+			actions = append(actions, Action{Kind: ActionDelete, Path: d.Path, IsDir: d.IsDir, Size: d.Size})
+			ok = false
+		}
+		if s.IsDir {
+			// Files create their parents, but an empty directory needs its
+			// own action.
+			if !ok {
+				actions = append(actions, Action{Kind: ActionMkdir, Path: s.Path, IsDir: true})
+			}
+			continue
+		}
+		switch {
+		case !ok:
+			actions = append(actions, Action{Kind: ActionCopy, Path: s.Path, Size: s.Size})
+		case !sameFile(s, d, compare):
+			actions = append(actions, Action{Kind: ActionUpdate, Path: s.Path, Size: s.Size})
+		}
+	}
+
+	if prune {
+		for _, d := range sortedByPath(dst) {
+			if coveredBy(replaced, d.Path) {
+				continue
+			}
+			if _, ok := srcByPath[d.Path]; ok {
+				continue
+			}
+			replaced = append(replaced, d.Path)
+			actions = append(actions, Action{Kind: ActionDelete, Path: d.Path, IsDir: d.IsDir, Size: d.Size})
+		}
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		// Deletions first so a directory can be replaced by a file; a
+		// directory sorts before everything in it.
+		if (actions[i].Kind == ActionDelete) != (actions[j].Kind == ActionDelete) {
+			return actions[i].Kind == ActionDelete
+		}
+		return actions[i].Path < actions[j].Path
+	})
+	return actions
+}
+
+func sameFile(src, dst Entry, compare string) bool {
+	if src.Size != dst.Size {
+		return false
+	}
+	switch compare {
+	case CompareSize:
+		return true
+	case CompareHash:
+		if src.SHA256 != "" && dst.SHA256 != "" {
+			return src.SHA256 == dst.SHA256
+		}
+	}
+	diff := src.ModTime.Sub(dst.ModTime)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= mtimeTolerance
+}
+
+func sortedByPath(entries []Entry) []Entry {
+	out := append([]Entry(nil), entries...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func coveredBy(dirs []string, p string) bool {
+	for _, d := range dirs {
+		if p == d || strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func joinRel(base, rel string) string {
+	return strings.Trim(path.Join("/", base, rel), "/")
+}
+
+func parentRel(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+func baseRel(p string) string {
+	return path.Base(p)
+}
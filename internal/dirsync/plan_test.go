@@ -0,0 +1,59 @@
+package dirsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Now().UTC()
+	src := []Entry{
+		{Path: "a.txt", Size: 3, ModTime: now},
+		{Path: "changed.txt", Size: 5, ModTime: now},
+		{Path: "same.txt", Size: 4, ModTime: now},
+		{Path: "dir", IsDir: true, ModTime: now},
+		{Path: "dir/b.txt", Size: 1, ModTime: now},
+		{Path: "empty", IsDir: true, ModTime: now},
+	}
+	dst := []Entry{
+		{Path: "changed.txt", Size: 5, ModTime: now.Add(-time.Hour)},
+		{Path: "same.txt", Size: 4, ModTime: now.Add(time.Second)},
+		{Path: "dir", IsDir: true, ModTime: now},
+		{Path: "old", IsDir: true, ModTime: now},
+		{Path: "old/c.txt", Size: 1, ModTime: now},
+	}
+
+	got := Plan(src, dst, CompareMtime, true)
+	want := []Action{
+		{Kind: ActionDelete, Path: "old", IsDir: true},
+		{Kind: ActionCopy, Path: "a.txt", Size: 3},
+		{Kind: ActionUpdate, Path: "changed.txt", Size: 5},
+		{Kind: ActionCopy, Path: "dir/b.txt", Size: 1},
+		{Kind: ActionMkdir, Path: "empty", IsDir: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Plan() returned %d actions, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("action %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if n := len(Plan(src, dst, CompareSize, false)); n != 3 {
+		t.Fatalf("size compare without delete planned %d actions, want 3", n)
+	}
+}
+
+func TestPlanHashCompare(t *testing.T) {
+	now := time.Now().UTC()
+	src := []Entry{{Path: "f", Size: 2, ModTime: now, SHA256: "aa"}}
+	dst := []Entry{{Path: "f", Size: 2, ModTime: now.Add(-time.Hour), SHA256: "aa"}}
+	if got := Plan(src, dst, CompareHash, false); len(got) != 0 {
+		t.Fatalf("identical hashes should not transfer, got %v", got)
+	}
+	dst[0].SHA256 = "bb"
+	if got := Plan(src, dst, CompareHash, false); len(got) != 1 || got[0].Kind != ActionUpdate {
+		t.Fatalf("differing hashes should update, got %v", got)
+	}
+}
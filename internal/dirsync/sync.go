@@ -0,0 +1,324 @@
+package dirsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+const (
+	DirectionPush = "push"
+	DirectionPull = "pull"
+)
+
+// uploadOverhead leaves headroom for multipart framing when batching files
+// under the server's per-request upload limit.
+const uploadOverhead = 64 * 1024
+
+// Options controls a single sync run.
+type Options struct {
+	LocalDir   string
+	RemotePath string
+	Direction  string
+	Compare    string
+	Delete     bool
+	DryRun     bool
+	Out        io.Writer
+}
+
+// Summary reports what a sync run did (or would do in dry-run mode).
+type Summary struct {
+	Copied  int
+	Updated int
+	Deleted int
+	Created int
+	Bytes   int64
+	Errors  []string
+}
+
+// Run mirrors opts.LocalDir and opts.RemotePath in the requested direction.
+func Run(c *Client, opts Options) (Summary, error) {
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	switch opts.Compare {
+	case "":
+		opts.Compare = CompareMtime
+	case CompareSize, CompareMtime, CompareHash:
+	default:
+		return Summary{}, fmt.Errorf("invalid compare mode %q", opts.Compare)
+	}
+	withHash := opts.Compare == CompareHash
+
+	if opts.Direction == DirectionPull {
+		if err := os.MkdirAll(opts.LocalDir, 0o755); err != nil {
+			return Summary{}, err
+		}
+	}
+	local, err := LocalManifest(opts.LocalDir, withHash)
+	if err != nil {
+		return Summary{}, fmt.Errorf("scan local dir: %w", err)
+	}
+	remote, err := c.Manifest(opts.RemotePath, withHash)
+	if err != nil {
+		return Summary{}, fmt.Errorf("fetch remote manifest: %w", err)
+	}
+	if err := checkRemoteEntries(remote.Entries); err != nil {
+		return Summary{}, err
+	}
+
+	switch opts.Direction {
+	case DirectionPush, "":
+		actions := Plan(local, remote.Entries, opts.Compare, opts.Delete)
+		return push(c, opts, local, remote, actions)
+	case DirectionPull:
+		actions := Plan(remote.Entries, local, opts.Compare, opts.Delete)
+		return pull(c, opts, remote.Entries, actions)
+	default:
+		return Summary{}, fmt.Errorf("invalid direction %q", opts.Direction)
+	}
+}
+
+func push(c *Client, opts Options, local []Entry, remote RemoteManifest, actions []Action) (Summary, error) {
+	var sum Summary
+	localByPath := make(map[string]Entry, len(local))
+	for _, e := range local {
+		localByPath[e.Path] = e
+	}
+	perms := remote.Permissions
+	policy := remote.UploadPolicy
+	overwrite := policy.CollisionPolicy == "overwrite" || policy.CollisionPolicy == "version"
+
+	var deletes, mkdirs, uploads []Action
+	for _, a := range actions {
+		switch a.Kind {
+		case ActionDelete:
+			deletes = append(deletes, a)
+		case ActionMkdir:
+			mkdirs = append(mkdirs, a)
+		case ActionUpdate:
+			if !overwrite {
+				// The server would store the new copy under a suffixed name,
+				// so clear the old file first.
+				deletes = append(deletes, Action{Kind: ActionDelete, Path: a.Path, Size: a.Size})
+			}
+			uploads = append(uploads, a)
+		default:
+			uploads = append(uploads, a)
+		}
+	}
+	if (len(uploads) > 0 || len(mkdirs) > 0) && (perms.ReadOnly || !perms.CanUpload) {
+		return sum, errors.New("server does not allow uploads for this account")
+	}
+	if len(uploads) > 0 {
+		if policy.UploadSubdir != "" {
+			return sum, fmt.Errorf("server redirects uploads into %q; push would not land in place", policy.UploadSubdir)
+		}
+	}
+	if len(deletes) > 0 && !perms.CanDelete {
+		if opts.Delete {
+			return sum, errors.New("server does not allow deletes for this account")
+		}
+		return sum, errors.New("updating existing files requires delete permission unless the server collision policy is overwrite")
+	}
+
+	for _, a := range actions {
+		fmt.Fprintln(opts.Out, a.String())
+	}
+	for _, a := range deletes {
+		if opts.DryRun {
+			continue
+		}
+		if err := c.Delete(joinRel(opts.RemotePath, a.Path)); err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("delete %s: %v", a.Path, err))
+			continue
+		}
+	}
+	sum.Deleted = countKind(actions, ActionDelete)
+
+	sum.Created = len(mkdirs)
+	if len(mkdirs) > 0 && !opts.DryRun {
+		// Parents sort first, and the server creates paths in order.
+		paths := make([]string, 0, len(mkdirs))
+		for _, a := range mkdirs {
+			paths = append(paths, joinRel(opts.RemotePath, a.Path))
+		}
+		issues, err := c.Mkdir(paths)
+		if err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("mkdir: %v", err))
+		}
+		sum.Errors = append(sum.Errors, issues...)
+	}
+
+	maxBatch := policy.MaxUploadSizeMB*1024*1024 - uploadOverhead
+	batch := make([]UploadFile, 0)
+	var batchBytes int64
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		issues, err := c.Upload(opts.RemotePath, batch)
+		if err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("upload: %v", err))
+		}
+		sum.Errors = append(sum.Errors, issues...)
+		batch = batch[:0]
+		batchBytes = 0
+	}
+	for _, a := range uploads {
+		if a.Kind == ActionCopy {
+			sum.Copied++
+		} else {
+			sum.Updated++
+		}
+		sum.Bytes += a.Size
+		if opts.DryRun {
+			continue
+		}
+		if maxBatch > 0 && a.Size > maxBatch {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("%s exceeds the server upload limit", a.Path))
+			continue
+		}
+		if maxBatch > 0 && batchBytes+a.Size > maxBatch {
+			flush()
+		}
+		localPath := filepath.Join(opts.LocalDir, filepath.FromSlash(a.Path))
+		info, err := os.Stat(localPath)
+		if err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("stat %s: %v", a.Path, err))
+			continue
+		}
+		batch = append(batch, UploadFile{
+			RelPath: a.Path,
+			ModTime: info.ModTime(),
+			SHA256:  localByPath[a.Path].SHA256,
+			Open:    func() (io.ReadCloser, error) { return os.Open(localPath) },
+		})
+		batchBytes += a.Size
+	}
+	flush()
+	return sum, nil
+}
+
+// checkRemoteEntries refuses a manifest with paths that are not plain
+// relative paths inside the synced folder. Pull writes and deletes where the
+// server says, so a malicious server could otherwise reach any file the user
+// can.
+func checkRemoteEntries(entries []Entry) error {
+	for _, e := range entries {
+		p := e.Path
+		if p == "" || p == "." || p == ".." || strings.HasPrefix(p, "../") || path.IsAbs(p) || path.Clean(p) != p ||
+			strings.ContainsAny(p, "\\\x00") || filepath.VolumeName(filepath.FromSlash(p)) != "" {
+			return fmt.Errorf("remote manifest has an unsafe path %q", p)
+		}
+	}
+	return nil
+}
+
+func pull(c *Client, opts Options, remote []Entry, actions []Action) (Summary, error) {
+	var sum Summary
+	byPath := make(map[string]Entry, len(remote))
+	for _, e := range remote {
+		byPath[e.Path] = e
+	}
+	for _, a := range actions {
+		fmt.Fprintln(opts.Out, a.String())
+		switch a.Kind {
+		case ActionDelete:
+			sum.Deleted++
+		case ActionMkdir:
+			sum.Created++
+		case ActionCopy:
+			sum.Copied++
+			sum.Bytes += a.Size
+		case ActionUpdate:
+			sum.Updated++
+			sum.Bytes += a.Size
+		}
+	}
+	if opts.DryRun {
+		return sum, nil
+	}
+
+	for _, a := range actions {
+		if a.Kind != ActionDelete {
+			continue
+		}
+		localPath, err := util.SafeJoin(opts.LocalDir, a.Path)
+		if err == nil {
+			err = os.RemoveAll(localPath)
+		}
+		if err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("delete %s: %v", a.Path, err))
+		}
+	}
+	for _, a := range actions {
+		if a.Kind != ActionMkdir {
+			continue
+		}
+		localPath, err := util.SafeJoin(opts.LocalDir, a.Path)
+		if err == nil {
+			err = os.MkdirAll(localPath, 0o755)
+		}
+		if err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("mkdir %s: %v", a.Path, err))
+		}
+	}
+	for _, a := range actions {
+		if a.Kind == ActionDelete || a.Kind == ActionMkdir {
+			continue
+		}
+		localPath, err := util.SafeJoin(opts.LocalDir, a.Path)
+		if err == nil {
+			err = downloadTo(c, joinRel(opts.RemotePath, a.Path), localPath, byPath[a.Path])
+		}
+		if err != nil {
+			sum.Errors = append(sum.Errors, fmt.Sprintf("download %s: %v", a.Path, err))
+		}
+	}
+	return sum, nil
+}
+
+func downloadTo(c *Client, remotePath, localPath string, meta Entry) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	tmp := localPath + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := c.Download(remotePath, f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, localPath); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if !meta.ModTime.IsZero() {
+		_ = os.Chtimes(localPath, meta.ModTime, meta.ModTime)
+	}
+	return nil
+}
+
+func countKind(actions []Action, kind string) int {
+	n := 0
+	for _, a := range actions {
+		if a.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
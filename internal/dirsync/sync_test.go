@@ -0,0 +1,44 @@
+package dirsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPullRefusesHostileManifest(t *testing.T) {
+	for _, bad := range []string{"../escape.txt", "a/../../escape.txt", "/tmp/escape.txt", "..", `..\escape.txt`} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/manifest":
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"exists":  true,
+					"entries": []Entry{{Path: "ok.txt", Size: 1}, {Path: bad, Size: 1}},
+				})
+			case "/api/download":
+				_, _ = w.Write([]byte("x"))
+			}
+		}))
+		c, err := NewClient(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent := t.TempDir()
+		local := filepath.Join(parent, "copy")
+		_, err = Run(c, Options{LocalDir: local, Direction: DirectionPull, Delete: true})
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), "unsafe path") {
+			t.Fatalf("%q: pull err = %v", bad, err)
+		}
+		if entries, _ := os.ReadDir(local); len(entries) != 0 {
+			t.Fatalf("%q: pull wrote %d entries", bad, len(entries))
+		}
+		if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !os.IsNotExist(err) {
+			t.Fatalf("%q: pull wrote outside the folder", bad)
+		}
+	}
+}
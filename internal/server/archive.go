@@ -0,0 +1,227 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveFormat describes one of the download formats offered for folders
+// and selections.
+type archiveFormat struct {
+	Name        string
+	Ext         string
+	ContentType string
+}
+
+var archiveFormats = []archiveFormat{
+	{Name: "zip", Ext: ".zip", ContentType: "application/zip"},
+	{Name: "zip-store", Ext: ".zip", ContentType: "application/zip"},
+	{Name: "tar", Ext: ".tar", ContentType: "application/x-tar"},
+	{Name: "tar.gz", Ext: ".tar.gz", ContentType: "application/gzip"},
+	{Name: "tar.zst", Ext: ".tar.zst", ContentType: "application/zstd"},
+}
+
+func parseArchiveFormat(value string) (archiveFormat, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "":
+		return archiveFormats[0], nil
+	case "tgz":
+		value = "tar.gz"
+	case "tzst":
+		value = "tar.zst"
+	}
+	for _, f := range archiveFormats {
+		if f.Name == value {
+			return f, nil
+		}
+	}
+	return archiveFormat{}, fmt.Errorf("unsupported archive format %q", value)
+}
+
+// compressedExts are formats that gain nothing from another deflate pass, so
+// ZIP archives store them as-is.
+var compressedExts = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true,
+	".zst": true, ".7z": true, ".rar": true, ".lz4": true, ".br": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".heic": true, ".avif": true, ".mp3": true, ".m4a": true, ".aac": true,
+	".ogg": true, ".opus": true, ".flac": true, ".mp4": true, ".m4v": true,
+	".mov": true, ".mkv": true, ".webm": true, ".avi": true, ".docx": true,
+	".xlsx": true, ".pptx": true, ".odt": true, ".jar": true, ".apk": true,
+	".woff": true, ".woff2": true,
+}
+
+// archiveWriter is the common surface of the ZIP and tar writers.
+type archiveWriter interface {
+	addFile(name string, info os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format archiveFormat) (archiveWriter, error) {
+	switch format.Name {
+	case "zip":
+		return &zipArchive{zw: zip.NewWriter(w)}, nil
+	case "zip-store":
+		return &zipArchive{zw: zip.NewWriter(w), storeAll: true}, nil
+	case "tar":
+		return &tarArchive{tw: tar.NewWriter(w)}, nil
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		return &tarArchive{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case "tar.zst":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchive{tw: tar.NewWriter(zw), compressor: zw}, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %q", format.Name)
+}
+
+type zipArchive struct {
+	zw       *zip.Writer
+	storeAll bool
+}
+
+// addFile relies on archive/zip switching to ZIP64 records by itself once a
+// file passes 4 GiB or the archive passes 65535 entries.
+func (z *zipArchive) addFile(name string, info os.FileInfo, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+	if z.storeAll || compressedExts[strings.ToLower(path.Ext(name))] {
+		hdr.Method = zip.Store
+	}
+	fw, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (z *zipArchive) Close() error {
+	return z.zw.Close()
+}
+
+type tarArchive struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (t *tarArchive) addFile(name string, info os.FileInfo, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	// Owner names are host-specific and only leak local account details.
+	hdr.Uname, hdr.Gname = "", ""
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarArchive) Close() error {
+	err := t.tw.Close()
+	if t.compressor != nil {
+		if cerr := t.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type zipSource struct {
+	abs  string
+	rel  string
+	name string
+	info os.FileInfo
+}
+
+// archiveFilename appends the format's extension to base.
+func archiveFilename(base string, format archiveFormat) string {
+	return strings.TrimSuffix(base, ".zip") + format.Ext
+}
+
+// streamArchive writes sources in the requested format. Directories are
+// walked and stored under their name; symlinks and folders outside scope are
+// skipped.
+func (a *App) streamArchive(w http.ResponseWriter, filename string, format archiveFormat, sources []zipSource, scope pathScope) {
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "archive failed")
+		return
+	}
+	defer aw.Close()
+
+	addFile := func(absPath, name string, info os.FileInfo) error {
+		f, err := os.Open(absPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return aw.addFile(filepath.ToSlash(name), info, f)
+	}
+
+	for _, src := range sources {
+		if !src.info.IsDir() {
+			if err := addFile(src.abs, src.name, src.info); err != nil {
+				a.logger.Warn("archive failed", "path", src.abs, "error", err)
+				return
+			}
+			continue
+		}
+		if err := filepath.WalkDir(src.abs, func(curr string, d os.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if curr == src.abs {
+				return nil
+			}
+			if d.Type()&os.ModeSymlink != 0 {
+				return nil
+			}
+			relPath, err := filepath.Rel(src.abs, curr)
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if !scope.allows(path.Join(src.rel, filepath.ToSlash(relPath))) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			return addFile(curr, path.Join(src.name, filepath.ToSlash(relPath)), fi)
+		}); err != nil {
+			a.logger.Warn("archive failed", "path", src.abs, "error", err)
+			return
+		}
+	}
+}
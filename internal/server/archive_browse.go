@@ -0,0 +1,275 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// archivePathSep separates an archive file from a path inside it, as in
+// "backups/site.zip!/public/index.html".
+const archivePathSep = "!/"
+
+// archiveLimits bounds the work done for one archive.
+type archiveLimits struct {
+	// members bounds how many entries are read, both when listing and when
+	// extracting.
+	members int
+	// extractBytes bounds the total uncompressed size written by one
+	// extraction, counted from the bytes actually produced.
+	extractBytes int64
+}
+
+// archiveLimits returns the configured limits, falling back to the config
+// defaults for unset ones.
+func (a *App) archiveLimits() archiveLimits {
+	l := archiveLimits{members: a.opts.ArchiveEntries, extractBytes: a.opts.ExtractSizeMB << 20}
+	if l.members <= 0 {
+		l.members = config.DefaultMaxArchiveEntries
+	}
+	if l.extractBytes <= 0 {
+		l.extractBytes = config.DefaultMaxExtractSizeMB << 20
+	}
+	return l
+}
+
+var (
+	errNotArchive        = errors.New("not a supported archive")
+	errArchiveTooLarge   = errors.New("archive has too many entries")
+	errArchiveMemberPath = errors.New("archive member not found")
+)
+
+type archiveMember struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// archiveKind reports the archive type of a file name, or "" when sharehere
+// cannot read it.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tzst"):
+		return "tar.zst"
+	}
+	return ""
+}
+
+// splitArchivePath splits "foo.zip!/inner/dir" (or "foo.zip!") into the
+// archive's path and the member path inside it.
+func splitArchivePath(rel string) (archiveRel, inner string, ok bool) {
+	if i := strings.Index(rel, archivePathSep); i >= 0 {
+		archiveRel, inner = rel[:i], rel[i+len(archivePathSep):]
+	} else if strings.HasSuffix(rel, "!") {
+		archiveRel = strings.TrimSuffix(rel, "!")
+	} else {
+		return "", "", false
+	}
+	if archiveKind(archiveRel) == "" {
+		return "", "", false
+	}
+	return util.NormalizeRelPath(archiveRel), cleanMemberName(inner), true
+}
+
+// cleanMemberName normalizes an archive member name. It returns "" for names
+// that are empty or try to climb out of the archive.
+func cleanMemberName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return ""
+		}
+	}
+	return util.NormalizeRelPath(name)
+}
+
+// walkArchive calls fn for every member of the archive at abs. fn may read the
+// member's content from r before returning; returning io.EOF stops early.
+// Archives with more than maxMembers entries fail with errArchiveTooLarge.
+func walkArchive(abs, kind string, maxMembers int, fn func(m archiveMember, r io.Reader) error) error {
+	f, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if kind == "zip" {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return fmt.Errorf("read zip: %w", err)
+		}
+		if len(zr.File) > maxMembers {
+			return errArchiveTooLarge
+		}
+		for _, zf := range zr.File {
+			m := archiveMember{Name: zf.Name, Size: int64(zf.UncompressedSize64), ModTime: zf.Modified, IsDir: zf.FileInfo().IsDir()}
+			if !m.IsDir && !zf.Mode().IsRegular() {
+				continue
+			}
+			var body io.ReadCloser
+			if !m.IsDir {
+				if body, err = zf.Open(); err != nil {
+					return err
+				}
+			}
+			err := fn(m, body)
+			if body != nil {
+				body.Close()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = f
+	switch kind {
+	case "tar.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("read gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.zst":
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("read zstd: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	case "tar":
+	default:
+		return errNotArchive
+	}
+	tr := tar.NewReader(r)
+	for count := 0; ; count++ {
+		if count >= maxMembers {
+			return errArchiveTooLarge
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		// Links and device nodes are skipped: they could point outside the
+		// destination when extracted.
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := fn(archiveMember{Name: hdr.Name, ModTime: hdr.ModTime, IsDir: true}, nil); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := fn(archiveMember{Name: hdr.Name, Size: hdr.Size, ModTime: hdr.ModTime}, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// listArchiveDir returns the immediate children of inner inside an archive,
+// synthesizing directories that only exist implicitly in member names.
+func listArchiveDir(abs, kind, archiveRel, inner string, maxMembers int) ([]fileEntry, error) {
+	byName := map[string]fileEntry{}
+	prefix := ""
+	if inner != "" {
+		prefix = inner + "/"
+	}
+	found := inner == ""
+	err := walkArchive(abs, kind, maxMembers, func(m archiveMember, _ io.Reader) error {
+		name := cleanMemberName(m.Name)
+		if name == "" {
+			return nil
+		}
+		if name == inner {
+			if m.IsDir {
+				found = true
+			}
+			return nil
+		}
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		found = true
+		rest := strings.TrimPrefix(name, prefix)
+		child, _, nested := strings.Cut(rest, "/")
+		isDir := m.IsDir || nested
+		entry, seen := byName[child]
+		if seen && entry.IsDir && !isDir {
+			return nil
+		}
+		entry = fileEntry{
+			Name:    child,
+			RelPath: archiveRel + archivePathSep + path.Join(inner, child),
+			IsDir:   isDir,
+			ModTime: m.ModTime,
+			Ext:     strings.ToLower(path.Ext(child)),
+		}
+		if !isDir {
+			entry.Size = m.Size
+		}
+		byName[child] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errArchiveMemberPath
+	}
+	items := make([]fileEntry, 0, len(byName))
+	for _, e := range byName {
+		items = append(items, e)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// copyArchiveMember streams one member of an archive to w.
+func copyArchiveMember(abs, kind, member string, maxMembers int, w io.Writer) error {
+	found := false
+	err := walkArchive(abs, kind, maxMembers, func(m archiveMember, r io.Reader) error {
+		if m.IsDir || cleanMemberName(m.Name) != member {
+			return nil
+		}
+		found = true
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		return io.EOF
+	})
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err == nil && !found {
+		return errArchiveMemberPath
+	}
+	return err
+}
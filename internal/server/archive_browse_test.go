@@ -0,0 +1,201 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func writeTestZip(t *testing.T, dest string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dest, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	archiveRel, inner, ok := splitArchivePath("backups/site.tar.gz!/public/index.html")
+	if !ok || archiveRel != "backups/site.tar.gz" || inner != "public/index.html" {
+		t.Fatalf("splitArchivePath() = %q, %q, %v", archiveRel, inner, ok)
+	}
+	if _, inner, ok := splitArchivePath("a.zip!"); !ok || inner != "" {
+		t.Fatalf("expected archive root, got %q, %v", inner, ok)
+	}
+	if _, _, ok := splitArchivePath("notes.txt!/x"); ok {
+		t.Fatalf("expected non-archive to be rejected")
+	}
+	if _, inner, _ := splitArchivePath("a.zip!/../../etc"); inner != "" {
+		t.Fatalf("expected traversal member to be dropped, got %q", inner)
+	}
+}
+
+func TestListAndExtractArchive(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "a.zip")
+	writeTestZip(t, archive, map[string]string{
+		"docs/readme.txt":  "hi",
+		"docs/img/x.png":   "png",
+		"top.txt":          "top",
+		"../../escape.txt": "nope",
+	})
+
+	items, err := listArchiveDir(archive, "zip", "a.zip", "docs", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !items[0].IsDir || items[0].RelPath != "a.zip!/docs/img" || items[1].Name != "readme.txt" {
+		t.Fatalf("unexpected listing: %+v", items)
+	}
+
+	if _, err := listArchiveDir(archive, "zip", "a.zip", "docs", 3); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("listing past the entry limit: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := copyArchiveMember(archive, "zip", "docs/readme.txt", 10, &buf); err != nil || buf.String() != "hi" {
+		t.Fatalf("copyArchiveMember() = %q, %v", buf.String(), err)
+	}
+
+	dest := filepath.Join(root, "out")
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	res := extractArchive(archive, "zip", extractJob{destAbs: dest, destRel: "out", policy: config.CollisionRename, limits: archiveLimits{members: 10, extractBytes: 1 << 20}})
+	if res.err != nil || res.files != 3 || len(res.issues) != 1 {
+		t.Fatalf("extractArchive() = %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); !os.IsNotExist(err) {
+		t.Fatalf("zip-slip member escaped the destination")
+	}
+	// A second run keeps existing files and writes renamed copies.
+	res = extractArchive(archive, "zip", extractJob{destAbs: dest, destRel: "out", policy: config.CollisionRename, limits: archiveLimits{members: 10, extractBytes: 1 << 20}})
+	if res.files != 3 {
+		t.Fatalf("second extract = %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "top_1.txt")); err != nil {
+		t.Fatalf("expected collision rename: %v", err)
+	}
+}
+
+func TestExtractOversizedMemberKeepsOriginal(t *testing.T) {
+	root := t.TempDir()
+	dest := filepath.Join(root, "out")
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	original := filepath.Join(dest, "big.txt")
+	if err := os.WriteFile(original, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	honest := filepath.Join(root, "honest.zip")
+	writeTestZip(t, honest, map[string]string{"big.txt": strings.Repeat("x", 64)})
+	// Two members that only go over the limit together.
+	split := filepath.Join(root, "split.zip")
+	var two bytes.Buffer
+	zw := zip.NewWriter(&two)
+	for _, name := range []string{"a.txt", "big.txt"} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = fw.Write([]byte(strings.Repeat("x", 10)))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(split, two.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// This one claims 4 bytes but holds 64; the zip reader refuses it.
+	lying := filepath.Join(root, "lying.zip")
+	var buf bytes.Buffer
+	zw = zip.NewWriter(&buf)
+	fw, err := zw.CreateRaw(&zip.FileHeader{Name: "big.txt", Method: zip.Store, CompressedSize64: 64, UncompressedSize64: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte(strings.Repeat("x", 64))); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lying, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hooked := false
+	keep := replaceHook(func(abs string) func(bool) {
+		hooked = hooked || abs == original
+		return func(bool) {}
+	})
+	for _, archive := range []string{honest, split, lying} {
+		res := extractArchive(archive, "zip", extractJob{destAbs: dest, destRel: "out", policy: config.CollisionOverwrite, limits: archiveLimits{members: 10, extractBytes: 16}, keep: keep})
+		if archive != lying && !errors.Is(res.err, errExtractTooLarge) {
+			t.Fatalf("%s: extractArchive() = %+v", filepath.Base(archive), res)
+		}
+		if data, err := os.ReadFile(original); err != nil || string(data) != "original" {
+			t.Fatalf("%s: original = %q, %v", filepath.Base(archive), data, err)
+		}
+		entries, _ := os.ReadDir(dest)
+		for _, e := range entries {
+			if isPartName(e.Name()) {
+				t.Fatalf("%s: left %s behind", filepath.Base(archive), e.Name())
+			}
+		}
+	}
+	if hooked {
+		t.Fatal("original was versioned for a member that was never written")
+	}
+}
+
+func TestExtractAppliesUploadRules(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "a.zip")
+	writeTestZip(t, archive, map[string]string{
+		"ok.txt":         "fine",
+		"tool.exe":       "MZ",
+		"bin/other.exe":  "MZ",
+		"a/b/c/deep.txt": "deep",
+		"photo.jpg":      "MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff",
+	})
+	rules, err := newUploadPolicy(db.AppSettings{UploadDenyRegex: `\.exe$`, UploadMaxDepth: 3, UploadDenyTypes: "application/vnd.microsoft.portable-executable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(root, "out")
+	res := extractArchive(archive, "zip", extractJob{destAbs: dest, destRel: "out", policy: config.CollisionRename, limits: archiveLimits{members: 10, extractBytes: 1 << 20}, rules: rules})
+	if res.err != nil || res.files != 1 || len(res.issues) != 4 {
+		t.Fatalf("extractArchive() = %+v", res)
+	}
+	for _, name := range []string{"tool.exe", "bin/other.exe", "a/b/c/deep.txt", "photo.jpg"} {
+		if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s was extracted despite the upload rules", name)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "ok.txt")); string(data) != "fine" {
+		t.Fatalf("ok.txt = %q", data)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type fakeFileInfo struct {
+	name string
+	size int64
+}
+
+func (f fakeFileInfo) Name() string       { return f.name }
+func (f fakeFileInfo) Size() int64        { return f.size }
+func (f fakeFileInfo) Mode() os.FileMode  { return 0o644 }
+func (f fakeFileInfo) ModTime() time.Time { return time.Unix(1700000000, 0) }
+func (f fakeFileInfo) IsDir() bool        { return false }
+func (f fakeFileInfo) Sys() any           { return nil }
+
+func TestArchiveFormatsRoundTrip(t *testing.T) {
+	files := map[string]string{"dir/a.txt": "hello", "dir/b.jpg": "not really a jpeg"}
+	for _, format := range archiveFormats {
+		var buf bytes.Buffer
+		aw, err := newArchiveWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format.Name, err)
+		}
+		for _, name := range []string{"dir/a.txt", "dir/b.jpg"} {
+			body := files[name]
+			if err := aw.addFile(name, fakeFileInfo{name: name, size: int64(len(body))}, strings.NewReader(body)); err != nil {
+				t.Fatalf("%s: add %s: %v", format.Name, name, err)
+			}
+		}
+		if err := aw.Close(); err != nil {
+			t.Fatalf("%s: close: %v", format.Name, err)
+		}
+		got := readArchive(t, format, buf.Bytes())
+		for name, want := range files {
+			if got[name] != want {
+				t.Fatalf("%s: %s = %q, want %q", format.Name, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestZipStoresCompressedExtensions(t *testing.T) {
+	var buf bytes.Buffer
+	aw, _ := newArchiveWriter(&buf, archiveFormats[0])
+	_ = aw.addFile("a.txt", fakeFileInfo{name: "a.txt", size: 1}, strings.NewReader("a"))
+	_ = aw.addFile("b.mp4", fakeFileInfo{name: "b.mp4", size: 1}, strings.NewReader("b"))
+	_ = aw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.File[0].Method != zip.Deflate || zr.File[1].Method != zip.Store {
+		t.Fatalf("methods = %d, %d", zr.File[0].Method, zr.File[1].Method)
+	}
+}
+
+func TestZipManyEntriesUsesZip64(t *testing.T) {
+	const count = 70000 // more than the 16-bit entry count of a classic ZIP
+	var buf bytes.Buffer
+	aw, _ := newArchiveWriter(&buf, archiveFormats[1])
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("f%d", i)
+		if err := aw.addFile(name, fakeFileInfo{name: name}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != count {
+		t.Fatalf("entries = %d, want %d", len(zr.File), count)
+	}
+}
+
+func readArchive(t *testing.T, format archiveFormat, data []byte) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	if strings.HasPrefix(format.Name, "zip") {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", format.Name, err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			out[f.Name] = string(b)
+		}
+		return out
+	}
+	var r io.Reader = bytes.NewReader(data)
+	switch format.Name {
+	case "tar.gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case "tar.zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", format.Name, err)
+		}
+		b, _ := io.ReadAll(tr)
+		out[hdr.Name] = string(b)
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/webui"
+)
+
+// directoryStub vouches for its users, whose password is "pw", as LDAP
+// users with the role it maps them to.
+type directoryStub map[string]string
+
+func (d directoryStub) Authenticate(_ context.Context, username, password string) (auth.Identity, error) {
+	role, ok := d[username]
+	if !ok {
+		return auth.Identity{}, auth.ErrUnknownUser
+	}
+	if password != "pw" {
+		return auth.Identity{}, auth.ErrInvalidCredentials
+	}
+	return auth.Identity{Username: username, Role: role, Source: db.UserSourceLDAP}, nil
+}
+
+// postLogin signs in through the login form from a fresh anonymous session.
+func postLogin(t *testing.T, a *App, username, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	token := "anon-" + username + "-" + time.Now().Format(time.RFC3339Nano)
+	if err := a.store.CreateSession(db.Session{Token: token, CSRFToken: "c", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"username": {username}, "password": {password}, "_csrf": {"c"}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	rec := httptest.NewRecorder()
+	a.sessionMiddleware(http.HandlerFunc(a.handleLogin)).ServeHTTP(rec, r)
+	return rec
+}
+
+func TestDirectorySignIn(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tmpl, err := template.ParseFS(webui.FS, "templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser("root", "x", auth.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	dir := directoryStub{"alice": auth.RoleUser, "root": auth.RoleAdmin}
+	a := &App{store: store, templates: tmpl, opts: Options{BasePath: "/"}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	a.authn = auth.Chain{dir, a.localAuthenticator()}
+	if rec := postLogin(t, a, "alice", "pw"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("directory sign-in: %d %s", rec.Code, rec.Body)
+	}
+	alice, err := store.GetUserByUsername("alice")
+	if err != nil || alice.Source != db.UserSourceLDAP || alice.Role != auth.RoleUser {
+		t.Fatalf("alice = %+v, %v", alice, err)
+	}
+	dir["alice"] = auth.RoleAdmin
+	if rec := postLogin(t, a, "alice", "pw"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("second directory sign-in: %d", rec.Code)
+	}
+	if alice, _ := store.GetUserByUsername("alice"); alice.Role != auth.RoleAdmin {
+		t.Fatalf("role not synced from the directory: %q", alice.Role)
+	}
+
+	// The directory can't sign in as a local account of the same name.
+	if rec := postLogin(t, a, "root", "pw"); rec.Code == http.StatusSeeOther {
+		t.Fatal("directory user took over a local account")
+	}
+	if root, _ := store.GetUserByUsername("root"); root.Source != db.UserSourceLocal {
+		t.Fatalf("local account changed to %q", root.Source)
+	}
+}
+
+func TestSignInRehashesOutdatedPasswords(t *testing.T) {
+	defer auth.ConfigureHashing(auth.HashConfig{})
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/"}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	if err := auth.ConfigureHashing(auth.HashConfig{Time: 1, MemoryKiB: 1024, Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := auth.HashPassword("correct horse battery")
+	if _, err := store.CreateUser("alice", old, auth.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ConfigureHashing(auth.HashConfig{Time: 2, MemoryKiB: 2048, Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postLogin(t, a, "alice", "correct horse battery"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("sign-in: %d %s", rec.Code, rec.Body)
+	}
+	alice, _ := store.GetUserByUsername("alice")
+	if alice.PasswordHash == old || auth.NeedsRehash(alice.PasswordHash) {
+		t.Fatalf("hash not upgraded: %s", alice.PasswordHash)
+	}
+	if ok, _ := auth.VerifyPassword(alice.PasswordHash, "correct horse battery"); !ok {
+		t.Fatal("upgraded hash doesn't verify")
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// maxCollisionAttempts bounds how many alternative names are tried before a
+// write gives up; with the suffix computed from the directory listing the
+// first candidate is almost always free.
+const maxCollisionAttempts = 64
+
+// errCollision is returned when a name is taken and the policy refuses to
+// pick another one.
+var errCollision = errors.New("already exists")
+
+// uploadConflict describes a file that was not written because its name was
+// taken under the reject or ask policy.
+type uploadConflict struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir,omitempty"`
+	Policy  string    `json:"policy"`
+}
+
+// collisionOverride validates a per-request policy override. Replacing files
+// is only allowed when the configured policy already replaces or the caller
+// may delete; "ask" is a UI decision and can't be requested.
+func collisionOverride(requested, policy string, canReplace bool) (string, error) {
+	requested = strings.ToLower(strings.TrimSpace(requested))
+	if requested == "" {
+		return policy, nil
+	}
+	if !config.ValidCollisionPolicy(requested) || requested == config.CollisionAsk {
+		return "", fmt.Errorf("invalid collision policy %q", requested)
+	}
+	if config.CollisionReplaces(requested) && !config.CollisionReplaces(policy) && !canReplace {
+		return "", fmt.Errorf("not allowed to overwrite")
+	}
+	return requested, nil
+}
+
+// splitCollisionName splits "name.ext" into "name" and ".ext". Dotfiles keep
+// their leading dot in the base.
+func splitCollisionName(name string) (string, string) {
+	ext := filepath.Ext(name)
+	if ext == name {
+		return name, ""
+	}
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// collisionCandidate returns the n-th alternative name for dest: "name_n.ext"
+// for rename, "name_20060102-150405.ext" and then "name_20060102-150405_n.ext"
+// for timestamp.
+func collisionCandidate(dest, policy string, n int, now time.Time) string {
+	base, ext := splitCollisionName(filepath.Base(dest))
+	dir := filepath.Dir(dest)
+	if policy == config.CollisionTimestamp {
+		stamp := now.Format("20060102-150405")
+		if n <= 1 {
+			return filepath.Join(dir, fmt.Sprintf("%s_%s%s", base, stamp, ext))
+		}
+		return filepath.Join(dir, fmt.Sprintf("%s_%s_%d%s", base, stamp, n, ext))
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, n, ext))
+}
+
+// nextCollisionIndex reads dest's directory once and returns one more than
+// the highest "name_N.ext" suffix in use, so finding a free name doesn't take
+// a stat per existing copy.
+func nextCollisionIndex(dest string) int {
+	base, ext := splitCollisionName(filepath.Base(dest))
+	entries, err := os.ReadDir(filepath.Dir(dest))
+	if err != nil {
+		return 1
+	}
+	highest := 0
+	prefix := base + "_"
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+			continue
+		}
+		n, err := strconv.Atoi(name[len(prefix) : len(name)-len(ext)])
+		if err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest + 1
+}
+
+// checkCollisionPath tells up front whether a write to dest can go ahead
+// under policy, so a refused upload isn't read in full first. A taken name
+// yields errCollision under reject and ask, and under the replacing policies
+// when it isn't a regular file. Nothing is claimed.
+func checkCollisionPath(dest, policy string) error {
+	info, err := os.Lstat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case config.CollisionReplaces(policy):
+		if !info.Mode().IsRegular() {
+			return errCollision
+		}
+	case policy == config.CollisionReject || policy == config.CollisionAsk:
+		return errCollision
+	}
+	return nil
+}
+
+// placeCollisionPath moves tmp, a finished file or folder, to dest without
+// replacing anything and returns where it landed. Each name is claimed with a
+// rename that fails if it is taken, so concurrent writers never end up with
+// the same name and nothing shows up under it half-written. A taken dest
+// yields errCollision under reject, ask and the replacing policies, whose
+// callers replace deliberately, and the next free alternative otherwise.
+func placeCollisionPath(tmp, dest, policy string) (string, error) {
+	err := util.RenameNoReplace(tmp, dest)
+	if err == nil {
+		return dest, nil
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return "", err
+	}
+	if policy == config.CollisionReject || policy == config.CollisionAsk || config.CollisionReplaces(policy) {
+		return "", errCollision
+	}
+	now := time.Now()
+	start := 1
+	if policy != config.CollisionTimestamp {
+		start = nextCollisionIndex(dest)
+	}
+	for i := 0; i < maxCollisionAttempts; i++ {
+		candidate := collisionCandidate(dest, policy, start+i, now)
+		err := util.RenameNoReplace(tmp, candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", filepath.Base(dest))
+}
+
+// partPath returns a fresh temporary name next to dest. It is a dotfile
+// ending in .part, which listings leave out and maintenance sweeps up if a
+// crash leaves it behind.
+func partPath(dest string) (string, error) {
+	suffix, err := util.RandomToken(6)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+"."+suffix+".part"), nil
+}
+
+// isPartName reports whether name is a temporary name from partPath.
+func isPartName(name string) bool {
+	return strings.HasPrefix(name, ".") && partFileRe.MatchString(name)
+}
+
+// conflictFor describes the existing entry at abs for an upload response.
+func (a *App) conflictFor(abs, policy string) uploadConflict {
+	c := uploadConflict{Name: filepath.Base(abs), Policy: policy}
+	if rel, err := util.RelPathFromRoot(a.rootAbs, abs); err == nil {
+		c.Path = rel
+	}
+	if info, err := os.Lstat(abs); err == nil {
+		c.Size = info.Size()
+		c.ModTime = info.ModTime()
+		c.IsDir = info.IsDir()
+	}
+	return c
+}
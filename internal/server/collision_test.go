@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+)
+
+func TestPlaceCollisionPath(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "a.txt")
+	for _, name := range []string{"a.txt", "a_1.txt", "a_7.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part := func() string {
+		t.Helper()
+		tmp, err := partPath(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isPartName(filepath.Base(tmp)) {
+			t.Fatalf("%s is not recognised as a part file", tmp)
+		}
+		if err := os.WriteFile(tmp, []byte("new"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return tmp
+	}
+
+	if got, err := placeCollisionPath(part(), dest, config.CollisionRename); err != nil || filepath.Base(got) != "a_8.txt" {
+		t.Fatalf("rename = %q, %v", got, err)
+	}
+	for _, policy := range []string{config.CollisionReject, config.CollisionOverwrite} {
+		tmp := part()
+		if _, err := placeCollisionPath(tmp, dest, policy); !errors.Is(err, errCollision) {
+			t.Fatalf("%s err = %v", policy, err)
+		}
+		os.Remove(tmp)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "x" {
+		t.Fatalf("existing file changed to %q", data)
+	}
+	got, err := placeCollisionPath(part(), dest, config.CollisionTimestamp)
+	if err != nil || !strings.HasPrefix(filepath.Base(got), "a_20") || filepath.Ext(got) != ".txt" {
+		t.Fatalf("timestamp = %q, %v", got, err)
+	}
+	if got, err := placeCollisionPath(part(), filepath.Join(dir, "b.txt"), config.CollisionReject); err != nil || filepath.Base(got) != "b.txt" {
+		t.Fatalf("free name = %q, %v", got, err)
+	}
+
+	if err := checkCollisionPath(dest, config.CollisionReject); !errors.Is(err, errCollision) {
+		t.Fatalf("check reject = %v", err)
+	}
+	if err := checkCollisionPath(dest, config.CollisionOverwrite); err != nil {
+		t.Fatalf("check overwrite = %v", err)
+	}
+	if err := checkCollisionPath(dir, config.CollisionOverwrite); !errors.Is(err, errCollision) {
+		t.Fatalf("check overwrite of a folder = %v", err)
+	}
+}
+
+func TestPlaceCollisionPathConcurrent(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "same.bin")
+	const writers = 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tmp, err := writePartFile(dest, strings.NewReader("data"), nil, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got, err := placeCollisionPath(tmp, dest, config.CollisionRename)
+			if err != nil {
+				t.Errorf("place = %q, %v", got, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[got] {
+				t.Errorf("%s placed twice", got)
+			}
+			seen[got] = true
+		}()
+	}
+	wg.Wait()
+	if len(seen) != writers {
+		t.Fatalf("placed %d files, want %d", len(seen), writers)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dest))
+	for _, e := range entries {
+		if data, _ := os.ReadFile(filepath.Join(filepath.Dir(dest), e.Name())); string(data) != "data" {
+			t.Errorf("%s = %q", e.Name(), data)
+		}
+	}
+}
+
+func TestCollisionOverride(t *testing.T) {
+	if got, err := collisionOverride("", config.CollisionRename, false); err != nil || got != config.CollisionRename {
+		t.Fatalf("empty override = %q, %v", got, err)
+	}
+	if _, err := collisionOverride("overwrite", config.CollisionRename, false); err == nil {
+		t.Fatal("overwrite allowed without permission")
+	}
+	if got, err := collisionOverride("overwrite", config.CollisionRename, true); err != nil || got != config.CollisionOverwrite {
+		t.Fatalf("permitted overwrite = %q, %v", got, err)
+	}
+	if _, err := collisionOverride("ask", config.CollisionRename, true); err == nil {
+		t.Fatal("ask accepted as an override")
+	}
+	if got, err := collisionOverride("reject", config.CollisionOverwrite, false); err != nil || got != config.CollisionReject {
+		t.Fatalf("reject override = %q, %v", got, err)
+	}
+}
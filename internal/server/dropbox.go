@@ -0,0 +1,267 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// dropUploader identifies whoever sends files to a drop box. Signed-in users
+// are named by their account; everyone else by the name and email fields of
+// the upload form.
+type dropUploader struct {
+	Name  string
+	Email string
+}
+
+func (u dropUploader) String() string {
+	switch {
+	case u.Name != "" && u.Email != "":
+		return fmt.Sprintf("%s <%s>", u.Name, u.Email)
+	case u.Name != "":
+		return u.Name
+	case u.Email != "":
+		return u.Email
+	}
+	return "anonymous"
+}
+
+// folderName is the per-uploader subfolder name, safe as a single path
+// segment on any platform.
+func (u dropUploader) folderName() string {
+	name := strings.TrimSpace(u.Name)
+	if u.Email != "" {
+		if name != "" {
+			name += " - "
+		}
+		name += strings.TrimSpace(u.Email)
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "anonymous"
+	}
+	return name
+}
+
+// dropBoxDir is the root-relative folder that files from uploader go to.
+func dropBoxDir(box db.DropBox, uploader dropUploader) string {
+	if !box.PerUploader {
+		return box.Path
+	}
+	return util.NormalizeRelPath(path.Join(box.Path, uploader.folderName()))
+}
+
+// uploadReceipt is what a drop box uploader gets back for each stored file,
+// in place of a view of the folder.
+type uploadReceipt struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// foreignDropBoxes returns the folder drop boxes whose contents the request
+// may not see: all of them for guests, and those owned by someone else for
+// signed-in users.
+func (a *App) foreignDropBoxes(r *http.Request) []db.DropBox {
+	boxes, err := a.store.ListDropBoxFolders()
+	if err != nil {
+		a.logger.Warn("list drop boxes failed", "error", err)
+		return nil
+	}
+	u := a.currentUser(r)
+	out := boxes[:0]
+	for _, d := range boxes {
+		if u != nil && d.CreatedBy != nil && *d.CreatedBy == u.ID {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// notifyDropBox tells the owner of box that files arrived.
+func (a *App) notifyDropBox(box db.DropBox, uploader dropUploader, files []string) {
+	if box.CreatedBy == nil || len(files) == 0 {
+		return
+	}
+	msg := fmt.Sprintf("%d file(s) from %s arrived in drop box /%s", len(files), uploader, box.Path)
+	if err := a.store.CreateNotification(db.Notification{UserID: *box.CreatedBy, Kind: "dropbox.upload", Message: msg, Path: dropBoxDir(box, uploader)}); err != nil {
+		a.logger.Warn("drop box notification failed", "path", box.Path, "error", err)
+	}
+}
+
+// validDropBoxFolder checks that rel can become a drop box: an existing
+// folder other than the share root.
+func (a *App) validDropBoxFolder(rel string) error {
+	if rel == "" {
+		return errors.New("the share root cannot be a drop box")
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		return errors.New("invalid path")
+	}
+	info, err := os.Stat(abs)
+	if err != nil || !info.IsDir() {
+		return errors.New("drop box must be an existing folder")
+	}
+	return nil
+}
+
+// dropBoxInfo describes the folder drop box at rel for /api/list, or nil if
+// rel is not one.
+func (a *App) dropBoxInfo(r *http.Request, rel string) map[string]any {
+	box, err := a.store.GetDropBoxFolder(rel)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			a.logger.Warn("get drop box failed", "path", rel, "error", err)
+		}
+		return nil
+	}
+	u := a.currentUser(r)
+	return map[string]any{
+		"perUploader": box.PerUploader,
+		"owned":       u != nil && box.CreatedBy != nil && *box.CreatedBy == u.ID,
+	}
+}
+
+func (a *App) handleAdminDropBoxes(w http.ResponseWriter, r *http.Request) {
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		boxes, err := a.store.ListDropBoxFolders()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to list drop boxes")
+			return
+		}
+		a.writeJSON(w, http.StatusOK, map[string]any{"dropBoxes": boxes})
+	case http.MethodPost:
+		if !a.verifyCSRF(w, r) {
+			return
+		}
+		var req struct {
+			Path        string `json:"path"`
+			PerUploader bool   `json:"perUploader"`
+		}
+		if err := decodeJSONBody(r, &req); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid payload")
+			return
+		}
+		rel := util.NormalizeRelPath(req.Path)
+		if err := a.validDropBoxFolder(rel); err != nil {
+			a.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// The admin who sets up a drop box owns it and is notified of uploads.
+		var createdBy *int64
+		if u := a.currentUser(r); u != nil {
+			createdBy = &u.ID
+		}
+		if err := a.store.SetDropBoxFolder(db.DropBox{Path: rel, PerUploader: req.PerUploader, CreatedBy: createdBy}); err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to save drop box")
+			return
+		}
+		_ = a.store.RecordAudit(createdBy, "admin.dropbox.set", rel, fmt.Sprintf("per_uploader=%t", req.PerUploader))
+		a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminDeleteDropBox(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	rel := util.NormalizeRelPath(req.Path)
+	if err := a.store.DeleteDropBoxFolder(rel); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to remove drop box")
+		return
+	}
+	if u := a.currentUser(r); u != nil {
+		_ = a.store.RecordAudit(&u.ID, "admin.dropbox.delete", rel, "")
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	items, err := a.store.ListNotifications(u.ID, 50)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list notifications")
+		return
+	}
+	unread, err := a.store.CountUnreadNotifications(u.ID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list notifications")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"notifications": items, "unread": unread})
+}
+
+func (a *App) handleNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if err := a.store.MarkNotificationsRead(u.ID, req.IDs); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to update notifications")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
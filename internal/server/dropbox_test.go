@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestDropBoxDir(t *testing.T) {
+	shared := db.DropBox{Path: "inbox"}
+	per := db.DropBox{Path: "inbox", PerUploader: true}
+	cases := []struct {
+		box      db.DropBox
+		uploader dropUploader
+		want     string
+	}{
+		{shared, dropUploader{Name: "Jane"}, "inbox"},
+		{per, dropUploader{Name: "Jane Doe", Email: "jane@example.com"}, "inbox/Jane Doe - jane@example.com"},
+		{per, dropUploader{Email: "a/b@example.com"}, "inbox/a_b@example.com"},
+		{per, dropUploader{Name: ".."}, "inbox/anonymous"},
+		{per, dropUploader{}, "inbox/anonymous"},
+	}
+	for _, c := range cases {
+		if got := dropBoxDir(c.box, c.uploader); got != c.want {
+			t.Errorf("dropBoxDir(%+v, %+v) = %q, want %q", c.box, c.uploader, got, c.want)
+		}
+	}
+}
+
+func TestPathScopeHidesDropBoxContents(t *testing.T) {
+	s := pathScope{drops: []db.DropBox{{Path: "inbox"}}}
+	if !s.allows("inbox") || s.allows("inbox/report.pdf") || !s.allows("inboxes/x") {
+		t.Fatal("allows mismatch")
+	}
+	if err := s.check("", true); err == nil {
+		t.Fatal("tree operation above a drop box allowed")
+	}
+	if err := s.check("docs", true); err != nil {
+		t.Fatalf("check(docs) = %v", err)
+	}
+}
+
+func TestUploadIntoDropBox(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "inbox", "Jane"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "inbox", "Jane", "cv.pdf"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	owner, err := store.CreateUser("owner", "x", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetDropBoxFolder(db.DropBox{Path: "inbox", PerUploader: true, CreatedBy: &owner}); err != nil {
+		t.Fatal(err)
+	}
+	box, err := store.GetDropBoxFolder("inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{rootAbs: root, store: store, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("path", "inbox")
+	_ = mw.WriteField("name", "Jane")
+	// Asking to overwrite must not reach files the uploader cannot see.
+	_ = mw.WriteField("collision", config.CollisionOverwrite)
+	fw, _ := mw.CreateFormFile("files", "cv.pdf")
+	_, _ = fw.Write([]byte("new"))
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/api/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	settings := db.AppSettings{MaxUploadSizeMB: 1, CollisionPolicy: config.CollisionRename}
+	res, err := a.consumeMultipartUpload(httptest.NewRecorder(), r, settings, "", true, pathScope{drops: []db.DropBox{box}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) != 0 || len(res.Receipt) != 1 || res.Receipt[0].Name != "cv_1.pdf" || res.Receipt[0].Size != 3 {
+		t.Fatalf("result = %+v", res)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "inbox", "Jane", "cv.pdf")); string(data) != "old" {
+		t.Fatalf("existing file changed to %q", data)
+	}
+	notes, err := store.ListNotifications(owner, 10)
+	if err != nil || len(notes) != 1 || notes[0].Path != "inbox/Jane" {
+		t.Fatalf("notifications = %+v, %v", notes, err)
+	}
+}
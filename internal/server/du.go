@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+const (
+	// duScanInterval is how often the whole tree is re-checked. Directories
+	// whose modification time is unchanged are not re-read.
+	duScanInterval = 5 * time.Minute
+	// duDeepScanEvery forces every directory to be re-read on every nth scan,
+	// which catches files that grew in place without touching their folder.
+	duDeepScanEvery = 12
+)
+
+// dirTotals is the recursive usage of one directory.
+type dirTotals struct {
+	Size  int64 `json:"size"`
+	Files int64 `json:"files"`
+	Dirs  int64 `json:"dirs"`
+}
+
+// duScanner computes recursive directory sizes in the background. Per
+// directory it caches only what the directory holds directly, keyed by its
+// modification time, so a rescan of an unchanged tree costs one stat per
+// directory. Totals are rebuilt from those records after every scan.
+type duScanner struct {
+	root    string
+	store   *db.Store
+	logger  *slog.Logger
+	trigger chan struct{}
+
+	scans int
+
+	mu sync.RWMutex
+	// records is only replaced by the scan goroutine, which may read it
+	// without holding mu.
+	records  map[string]db.DirUsage
+	totals   map[string]dirTotals
+	scanning bool
+	lastScan time.Time
+}
+
+func newDUScanner(root string, store *db.Store, logger *slog.Logger) *duScanner {
+	return &duScanner{
+		root:    root,
+		store:   store,
+		logger:  logger,
+		trigger: make(chan struct{}, 1),
+		records: map[string]db.DirUsage{},
+		totals:  map[string]dirTotals{},
+	}
+}
+
+// run scans once at start-up, then on every interval or refresh request.
+func (s *duScanner) run(ctx context.Context) {
+	cached, err := s.store.ListDirUsage()
+	if err != nil {
+		s.logger.Warn("load disk usage cache failed", "error", err)
+	}
+	records := make(map[string]db.DirUsage, len(cached))
+	for _, rec := range cached {
+		records[rec.Path] = rec
+	}
+	s.mu.Lock()
+	s.records = records
+	s.mu.Unlock()
+	ticker := time.NewTicker(duScanInterval)
+	defer ticker.Stop()
+	for {
+		s.scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.trigger:
+		}
+	}
+}
+
+// refresh asks for a rescan soon; requests arriving during a scan coalesce.
+func (s *duScanner) refresh() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (s *duScanner) get(rel string) (dirTotals, bool) {
+	if s == nil {
+		return dirTotals{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.totals[rel]
+	return t, ok
+}
+
+func (s *duScanner) status() (bool, time.Time) {
+	if s == nil {
+		return false, time.Time{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scanning, s.lastScan
+}
+
+// subdirs returns the cached subdirectory names of rel.
+func (s *duScanner) subdirs(rel string) ([]string, int64, bool) {
+	if s == nil {
+		return nil, 0, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.records[rel]
+	if !ok {
+		return nil, 0, false
+	}
+	return rec.Subdirs, rec.OwnSize, true
+}
+
+func (s *duScanner) scan(ctx context.Context) {
+	s.mu.Lock()
+	s.scanning = true
+	s.mu.Unlock()
+
+	s.scans++
+	deep := s.scans%duDeepScanEvery == 0
+	seen := map[string]bool{}
+	var changed []db.DirUsage
+	totals := map[string]dirTotals{}
+	records := make(map[string]db.DirUsage, len(s.records))
+	for k, v := range s.records {
+		records[k] = v
+	}
+
+	var visit func(rel string) (dirTotals, bool)
+	visit = func(rel string) (dirTotals, bool) {
+		if ctx.Err() != nil {
+			return dirTotals{}, false
+		}
+		abs := filepath.Join(s.root, filepath.FromSlash(rel))
+		info, err := os.Lstat(abs)
+		if err != nil || !info.IsDir() {
+			return dirTotals{}, false
+		}
+		rec, ok := records[rel]
+		if deep || !ok || !rec.ModTime.Equal(info.ModTime()) {
+			fresh, err := readDirUsage(rel, abs, info.ModTime())
+			if err != nil {
+				return dirTotals{}, false
+			}
+			rec = fresh
+			records[rel] = rec
+			changed = append(changed, rec)
+		}
+		seen[rel] = true
+		t := dirTotals{Size: rec.OwnSize, Files: rec.OwnFiles}
+		for _, name := range rec.Subdirs {
+			child, ok := visit(path.Join(rel, name))
+			if !ok {
+				continue
+			}
+			t.Size += child.Size
+			t.Files += child.Files
+			t.Dirs += child.Dirs + 1
+		}
+		totals[rel] = t
+		return t, true
+	}
+	_, ok := visit("")
+
+	if ctx.Err() == nil && ok {
+		var stale []string
+		for rel := range records {
+			if !seen[rel] {
+				stale = append(stale, rel)
+				delete(records, rel)
+			}
+		}
+		if err := s.store.PutDirUsage(changed); err != nil {
+			s.logger.Warn("store disk usage failed", "error", err)
+		}
+		if err := s.store.DeleteDirUsage(stale); err != nil {
+			s.logger.Warn("prune disk usage failed", "error", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.records = records
+	if ok {
+		s.totals = totals
+		s.lastScan = time.Now()
+	}
+	s.scanning = false
+	s.mu.Unlock()
+}
+
+// readDirUsage reads one directory. Symlinks are neither followed nor
+// counted, so links pointing outside the root never inflate totals.
+func readDirUsage(rel, abs string, modTime time.Time) (db.DirUsage, error) {
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return db.DirUsage{}, err
+	}
+	rec := db.DirUsage{Path: rel, ModTime: modTime}
+	for _, e := range entries {
+		switch {
+		case e.Type()&os.ModeSymlink != 0:
+		case e.IsDir():
+			rec.Subdirs = append(rec.Subdirs, e.Name())
+		case e.Type().IsRegular():
+			fi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			rec.OwnSize += fi.Size()
+			rec.OwnFiles++
+		}
+	}
+	sort.Strings(rec.Subdirs)
+	return rec, nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestDUScannerTotals(t *testing.T) {
+	root := t.TempDir()
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	write := func(rel string, size int) {
+		t.Helper()
+		abs := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a/one.bin", 100)
+	write("a/b/two.bin", 250)
+	write("top.txt", 7)
+
+	s := newDUScanner(root, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.scan(context.Background())
+	if got, ok := s.get(""); !ok || got.Size != 357 || got.Files != 3 || got.Dirs != 2 {
+		t.Fatalf("root totals = %+v, %v", got, ok)
+	}
+	if got, _ := s.get("a"); got.Size != 350 || got.Files != 2 {
+		t.Fatalf("a totals = %+v", got)
+	}
+
+	write("a/b/three.bin", 50)
+	if err := os.RemoveAll(filepath.Join(root, "top.txt")); err != nil {
+		t.Fatal(err)
+	}
+	s.scan(context.Background())
+	if got, _ := s.get(""); got.Size != 400 || got.Files != 3 {
+		t.Fatalf("root totals after change = %+v", got)
+	}
+
+	cached, err := store.ListDirUsage()
+	if err != nil || len(cached) != 3 {
+		t.Fatalf("cached records = %d, %v", len(cached), err)
+	}
+}
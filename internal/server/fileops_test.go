@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+)
+
+func TestCopyAndMovePaths(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{rootAbs: root}
+	mustWrite := func(rel, content string) {
+		t.Helper()
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("src/a.txt", "a")
+	mustWrite("src/nested/b.txt", "b")
+	if err := a.mkdirPath("dst"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := a.mkdirPath("dst"); err == nil {
+		t.Fatalf("expected mkdir of existing folder to fail")
+	}
+
+	got, err := a.copyPath("src", "dst", config.CollisionRename, nil)
+	if err != nil || got != "dst/src" {
+		t.Fatalf("copyPath() = %q, %v", got, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "dst/src/nested/b.txt")); err != nil || string(b) != "b" {
+		t.Fatalf("nested file not copied: %q, %v", b, err)
+	}
+	if got, err := a.copyPath("src/a.txt", "src", config.CollisionRename, nil); err != nil || got != "src/a_1.txt" {
+		t.Fatalf("duplicate copy = %q, %v", got, err)
+	}
+	if _, err := a.copyPath("src", "src/nested", config.CollisionRename, nil); err == nil {
+		t.Fatalf("expected copy into itself to fail")
+	}
+
+	got, err = a.movePath("src/a.txt", "dst", config.CollisionRename, nil)
+	if err != nil || got != "dst/a.txt" {
+		t.Fatalf("movePath() = %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "src/a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected source to be gone after move")
+	}
+	if _, err := a.movePath("dst", "dst/src", config.CollisionRename, nil); err == nil {
+		t.Fatalf("expected move into itself to fail")
+	}
+}
+
+func TestConcurrentTransfersClaimDistinctNames(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{rootAbs: root}
+	const workers = 8
+	writeTree(t, root, map[string]string{"a.txt": "a", "dir/x.txt": "x"}, "dst")
+	for i := 0; i < workers; i++ {
+		writeTree(t, root, map[string]string{fmt.Sprintf("src%d/same.txt", i): fmt.Sprint(i)})
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	copies := map[string]bool{}
+	moved := 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := "a.txt"
+			if i%2 == 1 {
+				src = "dir"
+			}
+			got, err := a.copyPath(src, "dst", config.CollisionRename, nil)
+			if err != nil {
+				t.Errorf("copy %s: %v", src, err)
+				return
+			}
+			_, moveErr := a.movePath(fmt.Sprintf("src%d/same.txt", i), "dst", config.CollisionReject, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if copies[got] {
+				t.Errorf("%s claimed twice", got)
+			}
+			copies[got] = true
+			if moveErr == nil {
+				moved++
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(copies) != workers {
+		t.Fatalf("made %d copies, want %d", len(copies), workers)
+	}
+	// Under reject only one of the same-named moves may land.
+	if moved != 1 {
+		t.Fatalf("%d moves landed on dst/same.txt", moved)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "dst"))
+	if len(entries) != workers+1 {
+		t.Fatalf("dst holds %d entries, want %d", len(entries), workers+1)
+	}
+	for _, e := range entries {
+		if isPartName(e.Name()) {
+			t.Fatalf("left %s behind", e.Name())
+		}
+	}
+}
+
+func TestDedupeNestedPaths(t *testing.T) {
+	got := dedupeNestedPaths([]string{"docs/a.txt", "docs", "docsx", "img/b.png"})
+	want := []string{"docs", "docsx", "img/b.png"}
+	if len(got) != len(want) {
+		t.Fatalf("dedupeNestedPaths() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("dedupeNestedPaths() = %v, want %v", got, want)
+		}
+	}
+	used := map[string]bool{}
+	if a, b := uniqueArchiveName("a.txt", used), uniqueArchiveName("a.txt", used); a != "a.txt" || b != "a_1.txt" {
+		t.Fatalf("uniqueArchiveName() = %q, %q", a, b)
+	}
+}
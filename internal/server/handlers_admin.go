@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -13,6 +14,8 @@ import (
 	"github.com/matthewsawatzky/sharehere/internal/config"
 	"github.com/matthewsawatzky/sharehere/internal/db"
 	"github.com/matthewsawatzky/sharehere/internal/theme"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+	"github.com/matthewsawatzky/sharehere/internal/virusscan"
 )
 
 func (a *App) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
@@ -49,12 +52,42 @@ func (a *App) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusBadRequest, "invalid guest mode")
 		return
 	}
-	switch next.CollisionPolicy {
-	case config.CollisionRename, config.CollisionOverwrite:
-	default:
+	if !config.ValidCollisionPolicy(next.CollisionPolicy) {
 		a.writeError(w, http.StatusBadRequest, "invalid collision policy")
 		return
 	}
+	if next.PasswordMinLength < auth.MinPasswordLength {
+		next.PasswordMinLength = auth.MinPasswordLength
+	}
+	if next.PasswordMinClasses < 0 || next.PasswordMinClasses > 4 {
+		a.writeError(w, http.StatusBadRequest, "password_min_classes must be between 0 and 4")
+		return
+	}
+	if next.PasswordBreachedList = strings.TrimSpace(next.PasswordBreachedList); next.PasswordBreachedList != "" {
+		if _, err := os.Stat(next.PasswordBreachedList); err != nil {
+			a.writeError(w, http.StatusBadRequest, "password_breached_list not found")
+			return
+		}
+	}
+	if key := validThrottleSettings(next); key != "" {
+		a.writeError(w, http.StatusBadRequest, key+" must not be negative")
+		return
+	}
+	if key, err := validNetworkLists(next); err != nil {
+		a.writeError(w, http.StatusBadRequest, key+": "+err.Error())
+		return
+	}
+	if !a.networkAllows(r, next, netAdmin) {
+		a.writeError(w, http.StatusBadRequest, "the admin network lists would lock you out; your address is "+remoteIP(r))
+		return
+	}
+	if next.PasskeyPolicy == "" {
+		next.PasskeyPolicy = config.PasskeyOptional
+	}
+	if !config.ValidPasskeyPolicy(next.PasskeyPolicy) {
+		a.writeError(w, http.StatusBadRequest, "invalid passkey policy")
+		return
+	}
 	if strings.TrimSpace(next.DefaultShareExpiry) == "" {
 		next.DefaultShareExpiry = "24h"
 	}
@@ -62,6 +95,45 @@ func (a *App) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusBadRequest, "invalid default share expiry")
 		return
 	}
+	next.HomeDirsPath = util.NormalizeRelPath(next.HomeDirsPath)
+	if next.HomeDirsPath == "" {
+		next.HomeDirsPath = defaultHomeDirsPath
+	}
+	if _, err := a.resolvePath(next.HomeDirsPath); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid home_dirs_path")
+		return
+	}
+	if next.UsersSeeOnlyHome && !next.HomeDirsEnabled {
+		a.writeError(w, http.StatusBadRequest, "users_see_only_home requires home_dirs_enabled")
+		return
+	}
+	if next.VersionKeep < 0 {
+		a.writeError(w, http.StatusBadRequest, "version_keep must not be negative")
+		return
+	}
+	next.VersionMaxAge = strings.TrimSpace(next.VersionMaxAge)
+	if next.VersionMaxAge != "" {
+		if d, err := time.ParseDuration(next.VersionMaxAge); err != nil || d <= 0 {
+			a.writeError(w, http.StatusBadRequest, "invalid version_max_age")
+			return
+		}
+	}
+	for _, f := range []struct {
+		key string
+		v   *string
+	}{
+		{"maintenance_interval", &next.MaintenanceInterval},
+		{"vacuum_interval", &next.VacuumInterval},
+		{"audit_retention", &next.AuditRetention},
+	} {
+		// Empty switches the job off.
+		if *f.v = strings.TrimSpace(*f.v); *f.v != "" {
+			if d, err := time.ParseDuration(*f.v); err != nil || d <= 0 {
+				a.writeError(w, http.StatusBadRequest, "invalid "+f.key)
+				return
+			}
+		}
+	}
 	if strings.TrimSpace(next.UploadAllowRegex) != "" {
 		if _, err := regexp.Compile(next.UploadAllowRegex); err != nil {
 			a.writeError(w, http.StatusBadRequest, "invalid upload_allow_regex")
@@ -74,6 +146,22 @@ func (a *App) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	for _, p := range util.ParseMIMEPatterns(next.UploadAllowTypes + "," + next.UploadDenyTypes) {
+		if !util.ValidMIMEPattern(p) {
+			a.writeError(w, http.StatusBadRequest, "invalid upload type pattern "+strconv.Quote(p))
+			return
+		}
+	}
+	if next.UploadMaxFiles < 0 || next.UploadMaxDepth < 0 {
+		a.writeError(w, http.StatusBadRequest, "upload_max_files and upload_max_depth must not be negative")
+		return
+	}
+	if strings.TrimSpace(next.VirusScanClamd) != "" {
+		if _, err := virusscan.ParseClamdAddress(next.VirusScanClamd); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid virus_scan_clamd")
+			return
+		}
+	}
 	if strings.TrimSpace(next.Theme) == "" {
 		next.Theme = "light"
 	}
@@ -93,6 +181,7 @@ func (a *App) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusInternalServerError, "failed to save settings")
 		return
 	}
+	a.refreshSettings()
 	if u := a.currentUser(r); u != nil {
 		_ = a.store.RecordAudit(&u.ID, "admin.settings.update", "settings", "")
 	}
@@ -150,6 +239,10 @@ func (a *App) handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
 	default:
 		req.Role = auth.RoleUser
 	}
+	if err := settings.PasswordPolicy().Check(req.Username, req.Password); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	hash, err := auth.HashPassword(req.Password)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, err.Error())
@@ -160,6 +253,7 @@ func (a *App) handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusBadRequest, "failed to create user")
 		return
 	}
+	a.ensureHomeDir(settings, req.Username)
 	if u := a.currentUser(r); u != nil {
 		_ = a.store.RecordAudit(&u.ID, "admin.user.create", req.Username, req.Role)
 	}
@@ -186,12 +280,21 @@ func (a *App) handleAdminSetPassword(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusBadRequest, "invalid payload")
 		return
 	}
+	if target, err := a.store.GetUserByUsername(req.Username); err == nil && target.Source != db.UserSourceLocal {
+		a.writeError(w, http.StatusBadRequest, "directory users change their password in the directory")
+		return
+	}
+	if err := settings.PasswordPolicy().Check(req.Username, req.Password); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	hash, err := auth.HashPassword(req.Password)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if err := a.store.SetUserPassword(req.Username, hash); err != nil {
+	// The user has to choose their own password when they next sign in.
+	if err := a.store.SetTemporaryPassword(req.Username, hash); err != nil {
 		if err == sql.ErrNoRows {
 			a.writeError(w, http.StatusNotFound, "user not found")
 			return
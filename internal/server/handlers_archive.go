@@ -0,0 +1,404 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// archivePath reports whether rel addresses something inside an archive. A
+// real file whose name happens to contain "!" takes precedence.
+func (a *App) archivePath(rel string) (string, string, bool) {
+	archiveRel, inner, ok := splitArchivePath(rel)
+	if !ok {
+		return "", "", false
+	}
+	if abs, err := a.resolvePath(rel); err == nil {
+		if _, err := os.Lstat(abs); err == nil {
+			return "", "", false
+		}
+	}
+	return archiveRel, inner, true
+}
+
+// resolveArchive resolves the archive half of a "foo.zip!/inner" path.
+func (a *App) resolveArchive(archiveRel string) (string, string, error) {
+	abs, err := a.resolvePath(archiveRel)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir() {
+		return "", "", errNotArchive
+	}
+	return abs, archiveKind(archiveRel), nil
+}
+
+// serveArchiveList answers /api/list for a path inside an archive.
+func (a *App) serveArchiveList(w http.ResponseWriter, rel, archiveRel, inner string, q listQuery, scope pathScope) {
+	abs, kind, err := a.resolveArchive(archiveRel)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "archive not found")
+		return
+	}
+	items, err := listArchiveDir(abs, kind, archiveRel, inner, a.archiveLimits().members)
+	if errors.Is(err, errArchiveMemberPath) {
+		a.writeError(w, http.StatusNotFound, "not found in archive")
+		return
+	}
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := paginateEntries(items, q)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload := listPayload(rel, page, scope)
+	payload["archive"] = archiveRel
+	a.writeJSON(w, http.StatusOK, payload)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// serveArchiveMember streams a single archive member as a download without
+// extracting anything to disk.
+func (a *App) serveArchiveMember(w http.ResponseWriter, archiveRel, inner string) {
+	abs, kind, err := a.resolveArchive(archiveRel)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "archive not found")
+		return
+	}
+	if inner == "" {
+		a.writeError(w, http.StatusBadRequest, "archive member required")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(inner)))
+	cw := &countingWriter{w: w}
+	if err := copyArchiveMember(abs, kind, inner, a.archiveLimits().members, cw); err != nil {
+		if cw.n > 0 {
+			a.logger.Warn("archive member download failed", "archive", archiveRel, "member", inner, "error", err)
+			return
+		}
+		w.Header().Del("Content-Disposition")
+		if errors.Is(err, errArchiveMemberPath) {
+			a.writeError(w, http.StatusNotFound, "not found in archive")
+			return
+		}
+		a.writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+type extractRequest struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"`
+	// Collision optionally overrides the collision policy for this request.
+	Collision string `json:"collision,omitempty"`
+}
+
+// handleExtract unpacks an archive into a directory under the root. Every
+// member is joined with SafeJoin against the destination, links are never
+// created, and the entry count and bytes written are capped.
+func (a *App) handleExtract(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireWrite(w, perms, "upload") {
+		return
+	}
+	var req extractRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	policy, err := collisionOverride(req.Collision, settings.CollisionPolicy, perms.CanDelete)
+	if err != nil {
+		a.writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	rel := util.NormalizeRelPath(req.Path)
+	kind := archiveKind(rel)
+	if kind == "" {
+		a.writeError(w, http.StatusBadRequest, "not a supported archive")
+		return
+	}
+	abs, _, err := a.resolveArchive(rel)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "archive not found")
+		return
+	}
+	destRel := util.NormalizeRelPath(req.Destination)
+	if strings.TrimSpace(req.Destination) == "" {
+		destRel = defaultExtractDir(rel)
+	}
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel, destRel) {
+		return
+	}
+	// Members could otherwise land in other users' homes.
+	if err := scope.check(destRel, true); err != nil {
+		a.writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	destAbs, err := a.resolvePath(destRel)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid destination")
+		return
+	}
+	if info, err := os.Stat(destAbs); err == nil && !info.IsDir() {
+		a.writeError(w, http.StatusBadRequest, "destination is not a directory")
+		return
+	}
+	if err := os.MkdirAll(destAbs, 0o755); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "mkdir failed")
+		return
+	}
+
+	var createdBy *int64
+	if u := a.currentUser(r); u != nil {
+		createdBy = &u.ID
+	}
+	if policy == config.CollisionVersion {
+		settings.VersioningEnabled = true
+	}
+	rules, err := newUploadPolicy(settings)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	scanner, err := virusScanner(settings)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	job := extractJob{
+		destAbs: destAbs,
+		destRel: destRel,
+		policy:  policy,
+		limits:  a.archiveLimits(),
+		keep:    a.versionHook(settings, createdBy),
+		rules:   rules,
+	}
+	if scanner != nil && settings.VirusScanSync {
+		job.scan = func(tmp, rel string) error { return a.scanFile(scanner, tmp, rel, createdBy) }
+	} else if scanner != nil {
+		job.placed = func(abs string) { a.scanPlaced(scanner, abs, createdBy) }
+	}
+	result := extractArchive(abs, kind, job)
+	if u := a.currentUser(r); u != nil {
+		meta, _ := json.Marshal(map[string]any{"destination": destRel, "files": result.files, "errors": result.issues})
+		_ = a.store.RecordAudit(&u.ID, "file.extract", fmt.Sprintf("%s -> %s", rel, destRel), string(meta))
+	}
+	if result.err != nil && result.files == 0 {
+		a.writeError(w, http.StatusBadRequest, result.err.Error())
+		return
+	}
+	issues := result.issues
+	if result.err != nil {
+		issues = append(issues, result.err.Error())
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{
+		"ok":          len(issues) == 0,
+		"destination": destRel,
+		"files":       result.files,
+		"bytes":       result.bytes,
+		"errors":      issues,
+	})
+}
+
+// defaultExtractDir names a sibling folder after the archive, e.g.
+// "uploads/site.tar.gz" extracts to "uploads/site".
+func defaultExtractDir(rel string) string {
+	base := path.Base(rel)
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tgz", ".tzst", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	if base == "" {
+		base = "extracted"
+	}
+	return util.NormalizeRelPath(path.Join(path.Dir(rel), base))
+}
+
+type extractResult struct {
+	files  int
+	bytes  int64
+	issues []string
+	err    error
+}
+
+// errExtractTooLarge is returned once an archive expands beyond the limit
+// given to extractArchive.
+var errExtractTooLarge = errors.New("archive expands beyond the extraction limit")
+
+// extractJob says where and how extractArchive writes. destRel is destAbs
+// relative to the root, for the depth rule.
+type extractJob struct {
+	destAbs string
+	destRel string
+	policy  string
+	limits  archiveLimits
+	keep    replaceHook
+	rules   uploadPolicy
+	// scan, if set, vets each file under its temporary name before it is
+	// placed, as the synchronous upload scan does; placed, if set, is told
+	// about each file once it is in place.
+	scan   func(tmp, rel string) error
+	placed func(abs string)
+}
+
+// extractArchive writes the members of the archive at abs into job.destAbs,
+// stopping with errExtractTooLarge once more than job.limits.extractBytes
+// come out. Files are held to the same rules and virus scan as uploads, and
+// refused ones are reported as issues. Each file is written to a hidden
+// temporary first and only takes its name, or replaces an existing file,
+// once it is complete, within the limit and scanned.
+func extractArchive(abs, kind string, job extractJob) extractResult {
+	res := extractResult{issues: make([]string, 0)}
+	remaining := job.limits.extractBytes
+	res.err = walkArchive(abs, kind, job.limits.members, func(m archiveMember, r io.Reader) error {
+		name := cleanMemberName(m.Name)
+		if name == "" {
+			if strings.Trim(m.Name, "/.") != "" {
+				res.issues = append(res.issues, fmt.Sprintf("skipped unsafe path %q", m.Name))
+			}
+			return nil
+		}
+		if !m.IsDir {
+			base, rej := job.rules.name(path.Base(name))
+			if rej == nil {
+				name = path.Join(path.Dir(name), base)
+				rej = job.rules.depth(base, util.NormalizeRelPath(path.Join(job.destRel, name)))
+			}
+			if rej != nil {
+				res.issues = append(res.issues, rej.Message)
+				return nil
+			}
+		}
+		target, err := util.SafeJoin(job.destAbs, name)
+		if err != nil {
+			res.issues = append(res.issues, fmt.Sprintf("skipped unsafe path %q", m.Name))
+			return nil
+		}
+		if m.IsDir {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				res.issues = append(res.issues, fmt.Sprintf("mkdir failed for %s", name))
+			}
+			return nil
+		}
+		if m.Size > remaining {
+			return errExtractTooLarge
+		}
+		// The type rules go by content, so a renamed file in an archive is
+		// caught just as it would be when uploaded.
+		r, rej, err := job.rules.sniff(path.Base(name), r)
+		if rej != nil {
+			res.issues = append(res.issues, rej.Message)
+			return nil
+		}
+		if err != nil {
+			res.issues = append(res.issues, fmt.Sprintf("write failed for %s", name))
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			res.issues = append(res.issues, fmt.Sprintf("mkdir failed for %s", name))
+			return nil
+		}
+		filePolicy := job.policy
+		err = checkCollisionPath(target, filePolicy)
+		if errors.Is(err, errCollision) && config.CollisionReplaces(filePolicy) {
+			// A folder is in the way of the file; keep both.
+			filePolicy = config.CollisionRename
+			err = nil
+		}
+		if errors.Is(err, errCollision) {
+			res.issues = append(res.issues, fmt.Sprintf("already exists: %s", name))
+			return nil
+		}
+		if err != nil {
+			res.issues = append(res.issues, fmt.Sprintf("write failed for %s", name))
+			return nil
+		}
+		// The declared size can lie, so also cap what is actually read.
+		limited := &io.LimitedReader{R: r, N: remaining + 1}
+		tmp, err := writePartFile(target, limited, nil, nil)
+		if err != nil {
+			res.issues = append(res.issues, fmt.Sprintf("write failed for %s", name))
+			return nil
+		}
+		written := remaining + 1 - limited.N
+		if written > remaining {
+			_ = os.Remove(tmp)
+			return errExtractTooLarge
+		}
+		if job.scan != nil {
+			if err := job.scan(tmp, util.NormalizeRelPath(path.Join(job.destRel, name))); err != nil {
+				_ = os.Remove(tmp)
+				if errors.Is(err, errInfected) {
+					res.issues = append(res.issues, fmt.Sprintf("infected file quarantined: %s", name))
+				} else {
+					res.issues = append(res.issues, fmt.Sprintf("virus scan failed: %s", name))
+				}
+				return nil
+			}
+		}
+		if !m.ModTime.IsZero() {
+			_ = os.Chtimes(tmp, m.ModTime, m.ModTime)
+		}
+		if config.CollisionReplaces(filePolicy) {
+			finish := job.keep.before(target)
+			err = os.Rename(tmp, target)
+			finish(err == nil)
+		} else {
+			target, err = placeCollisionPath(tmp, target, filePolicy)
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			if errors.Is(err, errCollision) {
+				res.issues = append(res.issues, fmt.Sprintf("already exists: %s", name))
+			} else {
+				res.issues = append(res.issues, fmt.Sprintf("write failed for %s", name))
+			}
+			return nil
+		}
+		remaining -= written
+		res.bytes += written
+		res.files++
+		if job.placed != nil {
+			job.placed(target)
+		}
+		return nil
+	})
+	return res
+}
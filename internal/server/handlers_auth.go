@@ -1,6 +1,8 @@
 package server
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -59,7 +61,13 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, a.route("/"), http.StatusSeeOther)
 			return
 		}
-		_ = a.templates.ExecuteTemplate(w, "login.html", a.loginTemplateData(session.CSRFToken, ""))
+		data := a.loginTemplateData(session.CSRFToken, "")
+		// Guests may not be able to browse, so viewing as one can land here.
+		data["Impersonating"] = a.impersonator(r) != nil
+		if r.URL.Query().Get("step") == "passkey" {
+			_, data["PasskeyStep"] = a.ceremonies.get(ceremonySecondFactor, session.Token)
+		}
+		_ = a.templates.ExecuteTemplate(w, "login.html", data)
 		return
 	}
 	if r.Method != http.MethodPost {
@@ -88,27 +96,113 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := a.store.GetUserByUsername(username)
-	if err != nil || user.Disabled {
+	ident, err := a.authenticator().Authenticate(r.Context(), username, password)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidCredentials) && !errors.Is(err, auth.ErrUnknownUser) {
+			a.logger.Warn("sign-in check failed", "user", username, "err", err)
+		}
 		a.failLogin(w, session.CSRFToken, key, username)
 		return
 	}
-	ok, err := auth.VerifyPassword(user.PasswordHash, password)
-	if err != nil || !ok {
+	var user db.User
+	if ident.Source == db.UserSourceLDAP {
+		user, err = a.store.SyncDirectoryUser(ident.Username, ident.Role)
+	} else {
+		user, err = a.store.GetUserByUsername(ident.Username)
+	}
+	if err != nil || user.Disabled {
+		if err != nil {
+			a.logger.Warn("sign-in account lookup failed", "user", username, "err", err)
+		}
 		a.failLogin(w, session.CSRFToken, key, username)
 		return
 	}
 	_ = a.store.ResetLoginAttempts(key)
+	if ident.Source == db.UserSourceLocal && auth.NeedsRehash(user.PasswordHash) {
+		// The hash predates the configured cost; the password is at hand
+		// to bring it up to date.
+		if hash, err := auth.HashPassword(password); err == nil {
+			if err := a.store.UpdatePasswordHash(user.ID, hash); err != nil {
+				a.logger.Warn("rehash password failed", "user", username, "err", err)
+			}
+		}
+	}
 
-	token, err := util.RandomToken(32)
-	if err != nil {
+	settings := a.effectiveSettings()
+	restriction := ""
+	if settings.PasskeyPolicy == config.PasskeySecondFactor || settings.PasskeyPolicy == config.PasskeyRequired {
+		n, err := a.store.CountPasskeys(user.ID)
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "session failure")
+			return
+		}
+		if n > 0 {
+			// The password checked out; the session is only signed in
+			// once a passkey confirms it.
+			a.ceremonies.put(ceremonySecondFactor, session.Token, ceremony{userID: user.ID, remember: remember})
+			http.Redirect(w, r, a.route("/login")+"?step=passkey", http.StatusSeeOther)
+			return
+		}
+		if settings.PasskeyPolicy == config.PasskeyRequired {
+			restriction = restrictPasskeyEnroll
+		}
+	}
+	method := "password"
+	if user.Source == db.UserSourceLDAP {
+		method = "ldap"
+	}
+	if user.MustChangePassword {
+		// Changing the password comes first; enrolling a passkey after.
+		restriction = restrictPasswordChange
+	}
+	if err := a.signIn(w, r, user, remember, restriction, method); err != nil {
 		a.writeError(w, http.StatusInternalServerError, "session failure")
 		return
 	}
+	if restriction != "" {
+		http.Redirect(w, r, a.restrictionPage(restriction), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, a.route("/"), http.StatusSeeOther)
+}
+
+// authenticator returns what checks sign-in passwords: the configured chain,
+// or local accounts alone.
+func (a *App) authenticator() auth.Authenticator {
+	if a.authn != nil {
+		return a.authn
+	}
+	return a.localAuthenticator()
+}
+
+// localAuthenticator checks local accounts' passwords. While LDAP is on only
+// admins may use them, unless the config lets every local user.
+func (a *App) localAuthenticator() auth.Local {
+	return auth.Local{
+		AdminsOnly: a.opts.LDAP.Enabled() && a.opts.LDAP.LocalFallback != auth.LocalFallbackAll,
+		Lookup: func(username string) (auth.LocalUser, error) {
+			u, err := a.store.GetUserByUsername(username)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Source != db.UserSourceLocal) {
+				return auth.LocalUser{}, auth.ErrUnknownUser
+			}
+			if err != nil {
+				return auth.LocalUser{}, err
+			}
+			return auth.LocalUser{PasswordHash: u.PasswordHash, Role: u.Role, Disabled: u.Disabled}, nil
+		},
+	}
+}
+
+// signIn replaces the request's session with a new one signed in as user,
+// possibly restricted, and records how the user signed in.
+func (a *App) signIn(w http.ResponseWriter, r *http.Request, user db.User, remember bool, restriction, method string) error {
+	token, err := util.RandomToken(32)
+	if err != nil {
+		return err
+	}
 	csrf, err := util.RandomToken(24)
 	if err != nil {
-		a.writeError(w, http.StatusInternalServerError, "session failure")
-		return
+		return err
 	}
 	expires := time.Now().Add(authTTL)
 	if remember {
@@ -116,21 +210,22 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	uid := user.ID
 	newSess := db.Session{
-		Token:     token,
-		UserID:    &uid,
-		CSRFToken: csrf,
-		Remember:  remember,
-		IP:        remoteIP(r),
-		UserAgent: r.UserAgent(),
-		ExpiresAt: expires,
-	}
-	if err := a.store.RotateSession(session.Token, newSess); err != nil {
-		a.writeError(w, http.StatusInternalServerError, "session failure")
-		return
+		Token:       token,
+		UserID:      &uid,
+		CSRFToken:   csrf,
+		Remember:    remember,
+		IP:          remoteIP(r),
+		UserAgent:   r.UserAgent(),
+		ExpiresAt:   expires,
+		Restriction: restriction,
+	}
+	if err := a.store.RotateSession(a.currentSession(r).Token, newSess); err != nil {
+		return err
 	}
 	a.setSessionCookie(w, newSess)
-	_ = a.store.RecordAudit(&uid, "login.success", username, "")
-	http.Redirect(w, r, a.route("/"), http.StatusSeeOther)
+	a.ensureHomeDir(a.effectiveSettings(), user.Username)
+	_ = a.store.RecordAudit(&uid, "login.success", user.Username, method)
+	return nil
 }
 
 func (a *App) failLogin(w http.ResponseWriter, csrfToken, key, username string) {
@@ -157,6 +252,7 @@ func (a *App) loginTemplateData(csrfToken, message string) map[string]any {
 	if a.opts.AuthMode == config.AuthOff {
 		return data
 	}
+	data["OpenRegistration"] = a.effectiveSettings().OpenRegistration && a.registrationClosed() == ""
 	admins, err := a.store.AdminCount()
 	if err != nil || admins > 0 {
 		return data
@@ -172,6 +268,10 @@ func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	if !a.verifyCSRF(w, r) {
 		return
 	}
+	if a.impersonator(r) != nil {
+		a.stopImpersonation(w, r)
+		return
+	}
 	session := a.currentSession(r)
 	user := a.currentUser(r)
 	if user != nil {
@@ -194,6 +294,11 @@ func (a *App) handleMe(w http.ResponseWriter, r *http.Request) {
 	session := a.currentSession(r)
 	principal := a.currentPrincipal(r)
 	th := a.themeFromSettings(settings)
+	// Sessions from before home directories were turned on never logged in
+	// with them on, so the home may not exist yet.
+	if perms.Home != "" {
+		a.ensureHomeDir(settings, principal.Username)
+	}
 
 	role := "guest"
 	if !principal.Anonymous {
@@ -209,6 +314,9 @@ func (a *App) handleMe(w http.ResponseWriter, r *http.Request) {
 		"theme":         map[string]any{"name": th.Name, "label": th.Label, "css_variables": th.CSSVariables},
 		"rootPath":      a.rootAbs,
 	}
+	if admin := a.impersonator(r); admin != nil {
+		payload["impersonation"] = map[string]any{"admin": admin.Username, "allowChanges": session.AllowChanges}
+	}
 	a.writeJSON(w, http.StatusOK, payload)
 }
 
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+func (a *App) handleChecksum(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireBrowse(w, r, perms) {
+		return
+	}
+	rel := a.parseRelative(r, "path")
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel) {
+		return
+	}
+	algo, ok := a.checksumAlgo(w, r)
+	if !ok {
+		return
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid path")
+		return
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if info.IsDir() {
+		a.writeChecksumList(w, rel, abs, algo, scope)
+		return
+	}
+	digest, cached, err := a.fileChecksum(rel, abs, info, algo)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "checksum failed")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{
+		"path":     rel,
+		"algo":     algo,
+		"checksum": digest,
+		"size":     info.Size(),
+		"cached":   cached,
+	})
+}
+
+func (a *App) checksumAlgo(w http.ResponseWriter, r *http.Request) (string, bool) {
+	algo := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("algo")))
+	if algo == "" {
+		algo = "sha256"
+	}
+	if _, err := util.NewChecksum(algo); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return algo, true
+}
+
+// fileChecksum returns the digest of a regular file, reusing the cached value
+// when the file's size and modification time are unchanged.
+func (a *App) fileChecksum(rel, abs string, info os.FileInfo, algo string) (string, bool, error) {
+	if digest, err := a.store.GetFileChecksum(rel, algo, info.Size(), info.ModTime()); err == nil {
+		return digest, true, nil
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	digest, err := util.ChecksumReader(algo, f)
+	if err != nil {
+		return "", false, err
+	}
+	if err := a.store.PutFileChecksum(rel, algo, info.Size(), info.ModTime(), digest); err != nil {
+		a.logger.Warn("cache checksum failed", "path", rel, "error", err)
+	}
+	return digest, false, nil
+}
+
+// writeChecksumList streams a sha256sum-compatible listing for every regular
+// file below a directory, with paths relative to that directory. Folders
+// outside scope are skipped.
+func (a *App) writeChecksumList(w http.ResponseWriter, rel, abs, algo string, scope pathScope) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "checksums."+algo+".txt"))
+	_ = filepath.WalkDir(abs, func(curr string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(abs, curr)
+		if err != nil {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		if d.IsDir() {
+			if !scope.allows(util.NormalizeRelPath(path.Join(rel, relPath))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		digest, _, err := a.fileChecksum(util.NormalizeRelPath(path.Join(rel, relPath)), curr, info, algo)
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(w, "%s  %s\n", digest, relPath)
+		return nil
+	})
+}
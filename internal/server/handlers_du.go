@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+const (
+	// maxDUDepth bounds how many levels of subdirectories /api/du nests.
+	maxDUDepth = 4
+	// maxDUFiles bounds how many individual files are listed at the top
+	// level of /api/du; the rest are folded into one "other files" node.
+	maxDUFiles = 50
+)
+
+// applyDirUsage replaces a directory's inode size with its recursive size.
+func (a *App) applyDirUsage(e *fileEntry) {
+	t, ok := a.du.get(e.RelPath)
+	if !ok {
+		e.Size = 0
+		e.SizePending = true
+		return
+	}
+	e.Size = t.Size
+	e.Files = t.Files
+}
+
+// duNode is one rectangle of the usage treemap.
+type duNode struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	IsDir    bool      `json:"isDir"`
+	Size     int64     `json:"size"`
+	Files    int64     `json:"files"`
+	Dirs     int64     `json:"dirs,omitempty"`
+	Other    bool      `json:"other,omitempty"`
+	Children []*duNode `json:"children,omitempty"`
+}
+
+// handleDiskUsage answers /api/du with the recursive usage of a directory as
+// a tree of nodes sorted by size, together with the free and total space of
+// the filesystem holding the root. Sizes come from the background scanner;
+// "pending" is set until the first scan has finished.
+func (a *App) handleDiskUsage(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireBrowse(w, r, perms) {
+		return
+	}
+	rel := a.parseRelative(r, "path")
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel) {
+		return
+	}
+	depth := 1
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			a.writeError(w, http.StatusBadRequest, "invalid depth")
+			return
+		}
+		depth = min(n, maxDUDepth)
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid path")
+		return
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !info.IsDir() {
+		a.writeError(w, http.StatusBadRequest, "not a directory")
+		return
+	}
+
+	scanning, lastScan := a.du.status()
+	payload := map[string]any{
+		"path":     rel,
+		"scanning": scanning,
+	}
+	if !lastScan.IsZero() {
+		payload["scannedAt"] = lastScan.UTC().Format(time.RFC3339)
+	}
+	if space, err := util.GetDiskSpace(a.rootAbs); err == nil {
+		payload["disk"] = space
+	}
+	root, ok := a.usageTree(rel, depth, scope)
+	payload["pending"] = !ok
+	if ok {
+		a.addLargestFiles(root, abs)
+		payload["usage"] = root
+	}
+	a.writeJSON(w, http.StatusOK, payload)
+}
+
+func (a *App) usageTree(rel string, depth int, scope pathScope) (*duNode, bool) {
+	t, ok := a.du.get(rel)
+	if !ok {
+		return nil, false
+	}
+	name := path.Base(rel)
+	if rel == "" {
+		name = "/"
+	}
+	node := &duNode{Name: name, Path: rel, IsDir: true, Size: t.Size, Files: t.Files, Dirs: t.Dirs}
+	if depth == 0 {
+		return node, true
+	}
+	subdirs, _, _ := a.du.subdirs(rel)
+	for _, sub := range subdirs {
+		subRel := util.NormalizeRelPath(path.Join(rel, sub))
+		if !scope.allows(subRel) {
+			continue
+		}
+		if child, ok := a.usageTree(subRel, depth-1, scope); ok {
+			node.Children = append(node.Children, child)
+		}
+	}
+	sortUsage(node.Children)
+	return node, true
+}
+
+// addLargestFiles lists the files directly inside the requested directory,
+// keeping the largest maxDUFiles and summing the rest into one node.
+func (a *App) addLargestFiles(node *duNode, abs string) {
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return
+	}
+	var files []*duNode
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &duNode{
+			Name:  e.Name(),
+			Path:  util.NormalizeRelPath(path.Join(node.Path, e.Name())),
+			Size:  fi.Size(),
+			Files: 1,
+		})
+	}
+	sortUsage(files)
+	if len(files) > maxDUFiles {
+		other := &duNode{Name: "other files", Path: node.Path, Other: true}
+		for _, f := range files[maxDUFiles:] {
+			other.Size += f.Size
+			other.Files++
+		}
+		files = append(files[:maxDUFiles], other)
+	}
+	node.Children = append(node.Children, files...)
+	sortUsage(node.Children)
+}
+
+func sortUsage(nodes []*duNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Size != nodes[j].Size {
+			return nodes[i].Size > nodes[j].Size
+		}
+		return naturalLess(nodes[i].Name, nodes[j].Name)
+	})
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+type mkdirRequest struct {
+	Path  string   `json:"path"`
+	Paths []string `json:"paths"`
+}
+
+type transferRequest struct {
+	Path        string   `json:"path"`
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"`
+	// Collision optionally overrides the collision policy for this request.
+	Collision string `json:"collision,omitempty"`
+}
+
+// fileOpError carries a client-facing message for a single item of a file
+// operation. Anything else is reported as a generic failure.
+type fileOpError struct {
+	msg string
+}
+
+func (e *fileOpError) Error() string { return e.msg }
+
+func opErrorf(format string, args ...any) error {
+	return &fileOpError{msg: fmt.Sprintf(format, args...)}
+}
+
+func opErrorMessage(rel, verb string, err error) string {
+	var opErr *fileOpError
+	if errors.As(err, &opErr) {
+		return fmt.Sprintf("%s: %s", rel, opErr.msg)
+	}
+	return fmt.Sprintf("%s: %s failed", rel, verb)
+}
+
+func requestPaths(single string, many []string) []string {
+	out := make([]string, 0, len(many)+1)
+	seen := map[string]bool{}
+	for _, raw := range append([]string{single}, many...) {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		rel := util.NormalizeRelPath(raw)
+		if seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		out = append(out, rel)
+	}
+	return out
+}
+
+// writeBatchResult reports a file operation. A request for a single item that
+// failed is answered with an error status so simple clients can treat it like
+// rename or delete; batches always get per-item results.
+func (a *App) writeBatchResult(w http.ResponseWriter, requested int, done, issues []string) {
+	if requested == 1 && len(done) == 0 && len(issues) == 1 {
+		a.writeError(w, http.StatusBadRequest, issues[0])
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{
+		"ok":     len(issues) == 0,
+		"paths":  done,
+		"errors": issues,
+	})
+}
+
+func (a *App) handleMkdir(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireWrite(w, perms, "upload") {
+		return
+	}
+	var req mkdirRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	rels := requestPaths(req.Path, req.Paths)
+	if len(rels) == 0 {
+		a.writeError(w, http.StatusBadRequest, "path required")
+		return
+	}
+	created := make([]string, 0, len(rels))
+	issues := make([]string, 0)
+	u := a.currentUser(r)
+	scope := a.scopeFor(r, settings, perms)
+	for _, rel := range rels {
+		err := scope.check(rel, false)
+		if err == nil {
+			err = a.mkdirPath(rel)
+		}
+		if err != nil {
+			issues = append(issues, opErrorMessage(rel, "mkdir", err))
+			continue
+		}
+		created = append(created, rel)
+		if u != nil {
+			_ = a.store.RecordAudit(&u.ID, "file.mkdir", rel, "")
+		}
+	}
+	a.writeBatchResult(w, len(rels), created, issues)
+}
+
+func (a *App) mkdirPath(rel string) error {
+	if rel == "" {
+		return opErrorf("invalid folder name")
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		return opErrorf("invalid path")
+	}
+	if _, err := os.Lstat(abs); err == nil {
+		return opErrorf("already exists")
+	}
+	return os.MkdirAll(abs, 0o755)
+}
+
+func (a *App) handleMove(w http.ResponseWriter, r *http.Request) {
+	a.handleTransfer(w, r, "move")
+}
+
+func (a *App) handleCopy(w http.ResponseWriter, r *http.Request) {
+	a.handleTransfer(w, r, "copy")
+}
+
+// handleTransfer implements /api/move and /api/copy. Both take one "path" or
+// several "paths" and a destination directory; each source keeps its name and
+// collisions are resolved with the configured upload collision policy.
+func (a *App) handleTransfer(w http.ResponseWriter, r *http.Request, op string) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	// Moving removes the source, so it is gated like rename; copying only
+	// adds files, so it is gated like upload.
+	action := "upload"
+	if op == "move" {
+		action = "rename"
+	}
+	if !a.requireWrite(w, perms, action) {
+		return
+	}
+	var req transferRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	rels := requestPaths(req.Path, req.Paths)
+	if len(rels) == 0 {
+		a.writeError(w, http.StatusBadRequest, "path required")
+		return
+	}
+	destRel := util.NormalizeRelPath(req.Destination)
+	destAbs, err := a.resolvePath(destRel)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid destination")
+		return
+	}
+	if info, err := os.Stat(destAbs); err != nil || !info.IsDir() {
+		a.writeError(w, http.StatusBadRequest, "destination is not a directory")
+		return
+	}
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, destRel) {
+		return
+	}
+
+	policy, err := collisionOverride(req.Collision, settings.CollisionPolicy, perms.CanDelete)
+	if err != nil {
+		a.writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if policy == config.CollisionVersion {
+		settings.VersioningEnabled = true
+	}
+	done := make([]string, 0, len(rels))
+	issues := make([]string, 0)
+	u := a.currentUser(r)
+	var keep replaceHook
+	if u != nil {
+		keep = a.versionHook(settings, &u.ID)
+	} else {
+		keep = a.versionHook(settings, nil)
+	}
+	for _, rel := range rels {
+		err := scope.check(rel, true)
+		if err == nil && !scope.allows(util.NormalizeRelPath(path.Join(destRel, path.Base(rel)))) {
+			err = opErrorf("invalid destination")
+		}
+		if err != nil {
+			issues = append(issues, opErrorMessage(rel, op, err))
+			continue
+		}
+		var target string
+		if op == "move" {
+			target, err = a.movePath(rel, destRel, policy, keep)
+		} else {
+			target, err = a.copyPath(rel, destRel, policy, keep)
+		}
+		if err != nil {
+			issues = append(issues, opErrorMessage(rel, op, err))
+			continue
+		}
+		done = append(done, target)
+		if u != nil {
+			_ = a.store.RecordAudit(&u.ID, "file."+op, fmt.Sprintf("%s -> %s", rel, target), "")
+		}
+	}
+	if u != nil && len(rels) > 1 {
+		meta, _ := json.Marshal(map[string]any{"destination": destRel, "paths": done, "errors": issues})
+		_ = a.store.RecordAudit(&u.ID, "file."+op+".batch", destRel, string(meta))
+	}
+	a.writeBatchResult(w, len(rels), done, issues)
+}
+
+// transferTarget validates a move or copy of srcRel into destRel and returns
+// the resolved source, the wanted target and the policy to place it under.
+// A target that is taken now is refused up front under reject and ask, or
+// when replacing it would change its type; other collisions are settled when
+// the item is placed. Copying an item onto itself always makes a renamed
+// duplicate.
+func (a *App) transferTarget(srcRel, destRel, policy string, duplicate bool) (string, string, string, error) {
+	if srcRel == "" {
+		return "", "", "", opErrorf("cannot use the share root as a source")
+	}
+	if destRel == srcRel || strings.HasPrefix(destRel, srcRel+"/") {
+		return "", "", "", opErrorf("cannot place a folder inside itself")
+	}
+	srcAbs, err := a.resolvePath(srcRel)
+	if err != nil {
+		return "", "", "", opErrorf("invalid path")
+	}
+	srcInfo, err := os.Lstat(srcAbs)
+	if err != nil {
+		return "", "", "", opErrorf("not found")
+	}
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		return "", "", "", opErrorf("symlinks cannot be moved or copied")
+	}
+	targetRel := util.NormalizeRelPath(path.Join(destRel, path.Base(srcRel)))
+	targetAbs, err := a.resolvePath(targetRel)
+	if err != nil {
+		return "", "", "", opErrorf("invalid destination")
+	}
+	if targetAbs == srcAbs {
+		if !duplicate {
+			return "", "", "", opErrorf("source and destination are the same")
+		}
+		return srcAbs, targetAbs, config.CollisionRename, nil
+	}
+	if existing, err := os.Lstat(targetAbs); err == nil {
+		switch {
+		case config.CollisionReplaces(policy):
+			if existing.IsDir() != srcInfo.IsDir() {
+				return "", "", "", opErrorf("destination exists with a different type")
+			}
+		case policy == config.CollisionReject || policy == config.CollisionAsk:
+			return "", "", "", opErrorf("%s already exists", path.Base(srcRel))
+		}
+	}
+	return srcAbs, targetAbs, policy, nil
+}
+
+// placeTransfer claims a name for tmp at targetAbs under policy and returns
+// it relative to the root.
+func (a *App) placeTransfer(tmp, targetAbs, policy string) (string, error) {
+	placed, err := placeCollisionPath(tmp, targetAbs, policy)
+	if errors.Is(err, errCollision) {
+		return "", opErrorf("%s already exists", filepath.Base(targetAbs))
+	}
+	if err != nil {
+		return "", err
+	}
+	return util.RelPathFromRoot(a.rootAbs, placed)
+}
+
+// replacing reports whether a transfer to targetAbs replaces what is there.
+func replacing(targetAbs, policy string) bool {
+	if !config.CollisionReplaces(policy) {
+		return false
+	}
+	_, err := os.Lstat(targetAbs)
+	return err == nil
+}
+
+func (a *App) movePath(srcRel, destRel, policy string, keep replaceHook) (string, error) {
+	srcAbs, targetAbs, policy, err := a.transferTarget(srcRel, destRel, policy, false)
+	if err != nil {
+		return "", err
+	}
+	if !replacing(targetAbs, policy) {
+		targetRel, err := a.placeTransfer(srcAbs, targetAbs, policy)
+		if err == nil || !errors.Is(err, syscall.EXDEV) {
+			return targetRel, err
+		}
+		// The root may span mounts; copy next to the target, place the
+		// copy and delete the original.
+		tmp, err := partPath(targetAbs)
+		if err != nil {
+			return "", err
+		}
+		if err := copyTree(srcAbs, tmp, false, keep); err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", err
+		}
+		if targetRel, err = a.placeTransfer(tmp, targetAbs, policy); err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", err
+		}
+		return targetRel, os.RemoveAll(srcAbs)
+	}
+	targetRel, err := util.RelPathFromRoot(a.rootAbs, targetAbs)
+	if err != nil {
+		return "", opErrorf("invalid destination")
+	}
+	if info, err := os.Stat(targetAbs); err == nil && info.IsDir() {
+		// os.Rename cannot replace a directory; merge into it instead.
+		if err := copyTree(srcAbs, targetAbs, true, keep); err != nil {
+			return "", err
+		}
+		return targetRel, os.RemoveAll(srcAbs)
+	}
+	finish := keep.before(targetAbs)
+	err = os.Rename(srcAbs, targetAbs)
+	finish(err == nil)
+	if err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return "", err
+		}
+		if err := copyTree(srcAbs, targetAbs, true, keep); err != nil {
+			return "", err
+		}
+		return targetRel, os.RemoveAll(srcAbs)
+	}
+	return targetRel, nil
+}
+
+func (a *App) copyPath(srcRel, destRel, policy string, keep replaceHook) (string, error) {
+	srcAbs, targetAbs, policy, err := a.transferTarget(srcRel, destRel, policy, true)
+	if err != nil {
+		return "", err
+	}
+	if replacing(targetAbs, policy) {
+		if err := copyTree(srcAbs, targetAbs, true, keep); err != nil {
+			return "", err
+		}
+		return util.RelPathFromRoot(a.rootAbs, targetAbs)
+	}
+	// Build the copy under a hidden name and only then give it its own, so
+	// nobody sees it half-done and concurrent copies can't share a name.
+	tmp, err := partPath(targetAbs)
+	if err != nil {
+		return "", err
+	}
+	if err := copyTree(srcAbs, tmp, false, keep); err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", err
+	}
+	targetRel, err := a.placeTransfer(tmp, targetAbs, policy)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+	}
+	return targetRel, err
+}
+
+// copyTree recursively copies src to dst, streaming file contents and keeping
+// modes and modification times. Symlinks are skipped so a copy can never pull
+// in content from outside the share root. Existing files are only replaced
+// when overwrite is set, and keep is told about each one first.
+func copyTree(src, dst string, overwrite bool, keep replaceHook) error {
+	return filepath.WalkDir(src, func(curr string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, curr)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if err := os.MkdirAll(target, info.Mode().Perm()|0o700); err != nil {
+				return err
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		finish := func(bool) {}
+		if _, err := os.Lstat(target); err == nil {
+			if !overwrite {
+				return opErrorf("%s already exists", filepath.Base(target))
+			}
+			finish = keep.before(target)
+		}
+		err = copyFile(curr, target, info)
+		finish(err == nil)
+		return err
+	})
+}
+
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := writeUploadedFile(dst, in, nil); err != nil {
+		return err
+	}
+	_ = os.Chmod(dst, info.Mode().Perm())
+	_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+	return nil
+}
@@ -1,25 +1,24 @@
 package server
 
 import (
-	"archive/zip"
 	"bytes"
-	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
-	"regexp"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/matthewsawatzky/sharehere/internal/config"
 	"github.com/matthewsawatzky/sharehere/internal/db"
 	"github.com/matthewsawatzky/sharehere/internal/util"
 )
@@ -34,16 +33,49 @@ func (a *App) handleList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	rel := a.parseRelative(r, "path")
-	items, err := a.listDir(rel)
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel) {
+		return
+	}
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if archiveRel, inner, ok := a.archivePath(rel); ok {
+		a.serveArchiveList(w, rel, archiveRel, inner, q, scope)
+		return
+	}
+	page, err := a.listDirPage(rel, q, scope)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	a.writeJSON(w, http.StatusOK, map[string]any{
+	payload := listPayload(rel, page, scope)
+	if box := a.dropBoxInfo(r, rel); box != nil {
+		payload["dropBox"] = box
+	}
+	a.writeJSON(w, http.StatusOK, payload)
+}
+
+// listPayload is the /api/list response body. total counts the entries that
+// match the filter; nextCursor is empty on the last page. Breadcrumbs above
+// the caller's browse root are left out.
+func listPayload(rel string, page listPage, scope pathScope) map[string]any {
+	crumbs := make([]breadcrumb, 0)
+	for _, c := range buildBreadcrumbs(rel) {
+		if scope.allows(c.Path) {
+			crumbs = append(crumbs, c)
+		}
+	}
+	return map[string]any{
 		"path":        rel,
-		"entries":     items,
-		"breadcrumbs": buildBreadcrumbs(rel),
-	})
+		"entries":     page.Entries,
+		"breadcrumbs": crumbs,
+		"total":       page.Total,
+		"hiddenCount": page.HiddenCount,
+		"nextCursor":  page.NextCursor,
+	}
 }
 
 func (a *App) handleDownload(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +88,13 @@ func (a *App) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	rel := a.parseRelative(r, "path")
+	if !a.requireVisible(w, a.scopeFor(r, settings, perms), rel) {
+		return
+	}
+	if archiveRel, inner, ok := a.archivePath(rel); ok {
+		a.serveArchiveMember(w, archiveRel, inner)
+		return
+	}
 	abs, err := a.resolvePath(rel)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid path")
@@ -70,10 +109,36 @@ func (a *App) handleDownload(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, fmt.Sprintf("%s?path=%s", a.route("/api/zip"), rel), http.StatusSeeOther)
 		return
 	}
+	if r.URL.Query().Get("inline") != "" && a.serveInline(w, r, abs, info) {
+		return
+	}
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
 	http.ServeFile(w, r, abs)
 }
 
+// serveInline serves media and PDFs for the preview pane with an inline
+// disposition and framing allowed from our own pages. Other types are left
+// to the regular attachment download.
+func (a *App) serveInline(w http.ResponseWriter, r *http.Request, abs string, info os.FileInfo) bool {
+	f, err := os.Open(abs)
+	if err != nil {
+		return false
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	f.Close()
+	ct := fileContentType(abs, head[:n])
+	if !inlineSafeType(ct) {
+		return false
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", info.Name()))
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'self'")
+	http.ServeFile(w, r, abs)
+	return true
+}
+
 func (a *App) handlePreview(w http.ResponseWriter, r *http.Request) {
 	if !a.enforceMethod(w, r, http.MethodGet) {
 		return
@@ -84,6 +149,9 @@ func (a *App) handlePreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	rel := a.parseRelative(r, "path")
+	if !a.requireVisible(w, a.scopeFor(r, settings, perms), rel) {
+		return
+	}
 	abs, err := a.resolvePath(rel)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid path")
@@ -105,34 +173,13 @@ func (a *App) handlePreview(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	head := make([]byte, 512)
-	n, _ := io.ReadFull(file, head)
-	ct := http.DetectContentType(head[:n])
-	ext := strings.ToLower(filepath.Ext(abs))
-	if strings.HasPrefix(ct, "image/") {
-		a.writeJSON(w, http.StatusOK, map[string]any{"type": "image"})
-		return
-	}
-	if isTextPreviewType(ct, ext) {
-		const limit = 128 * 1024
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			a.writeError(w, http.StatusInternalServerError, "preview failed")
-			return
-		}
-		buf := make([]byte, limit+1)
-		n, _ := io.ReadFull(file, buf)
-		truncated := n > limit
-		if truncated {
-			n = limit
-		}
-		a.writeJSON(w, http.StatusOK, map[string]any{
-			"type":      "text",
-			"content":   string(buf[:n]),
-			"truncated": truncated,
-		})
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	desc, err := a.buildPreview(rel, abs, info, file, page)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "preview failed")
 		return
 	}
-	a.writeJSON(w, http.StatusOK, map[string]any{"type": "binary"})
+	a.writeJSON(w, http.StatusOK, desc)
 }
 
 func isTextPreviewType(contentType, ext string) bool {
@@ -150,6 +197,10 @@ func isTextPreviewType(contentType, ext string) bool {
 }
 
 func (a *App) handleZip(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		a.handleZipSelection(w, r)
+		return
+	}
 	if !a.enforceMethod(w, r, http.MethodGet) {
 		return
 	}
@@ -159,6 +210,10 @@ func (a *App) handleZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	rel := a.parseRelative(r, "path")
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel) {
+		return
+	}
 	abs, err := a.resolvePath(rel)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid path")
@@ -170,73 +225,136 @@ func (a *App) handleZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	zipName := info.Name() + ".zip"
+	format, err := parseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	baseName := info.Name()
+	rootName := info.Name()
 	if rel == "" {
-		zipName = "sharehere-root.zip"
+		baseName = "sharehere-root"
+		rootName = "root"
 	}
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", zipName))
+	a.streamArchive(w, archiveFilename(baseName, format), format, []zipSource{{abs: abs, rel: rel, name: rootName, info: info}}, scope)
+}
 
-	zw := zip.NewWriter(w)
-	defer zw.Close()
+// maxZipSelection bounds how many paths one POST /api/zip request may name.
+const maxZipSelection = 5000
 
-	addFile := func(absPath, name string, info os.FileInfo) error {
-		hdr, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-		hdr.Name = filepath.ToSlash(name)
-		hdr.Method = zip.Deflate
-		writer, err := zw.CreateHeader(hdr)
-		if err != nil {
-			return err
+type zipSelectionRequest struct {
+	Paths  []string `json:"paths"`
+	Name   string   `json:"name"`
+	Format string   `json:"format"`
+}
+
+// handleZipSelection streams a single archive of several paths. It accepts a
+// JSON body or a form post (so the browser can save the response directly);
+// every path is validated before the first byte is written.
+func (a *App) handleZipSelection(w http.ResponseWriter, r *http.Request) {
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireBrowse(w, r, perms) {
+		return
+	}
+	var req zipSelectionRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := decodeJSONBody(r, &req); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid payload")
+			return
 		}
-		f, err := os.Open(absPath)
-		if err != nil {
-			return err
+	} else {
+		if err := r.ParseForm(); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid payload")
+			return
 		}
-		defer f.Close()
-		_, err = io.Copy(writer, f)
-		return err
+		req.Paths = r.PostForm["paths"]
+		req.Name = r.PostForm.Get("name")
+		req.Format = r.PostForm.Get("format")
 	}
-
-	if !info.IsDir() {
-		if err := addFile(abs, info.Name(), info); err != nil {
-			a.writeError(w, http.StatusInternalServerError, "zip failed")
-		}
+	format, err := parseArchiveFormat(req.Format)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	rootName := info.Name()
-	if rel == "" {
-		rootName = "root"
+	rels := dedupeNestedPaths(requestPaths("", req.Paths))
+	if len(rels) == 0 {
+		a.writeError(w, http.StatusBadRequest, "paths required")
+		return
 	}
-	if err := filepath.WalkDir(abs, func(curr string, d os.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if curr == abs {
-			return nil
+	if len(rels) > maxZipSelection {
+		a.writeError(w, http.StatusBadRequest, fmt.Sprintf("too many paths (max %d)", maxZipSelection))
+		return
+	}
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rels...) {
+		return
+	}
+	sources := make([]zipSource, 0, len(rels))
+	used := map[string]bool{}
+	for _, rel := range rels {
+		abs, err := a.resolvePath(rel)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid path: "+rel)
+			return
 		}
-		if d.Type()&os.ModeSymlink != 0 {
-			return nil
+		info, err := os.Stat(abs)
+		if err != nil {
+			a.writeError(w, http.StatusNotFound, "not found: "+rel)
+			return
 		}
-		if d.IsDir() {
-			return nil
+		name := info.Name()
+		if rel == "" {
+			name = "root"
 		}
-		fi, err := d.Info()
-		if err != nil {
-			return nil
+		sources = append(sources, zipSource{abs: abs, rel: rel, name: uniqueArchiveName(name, used), info: info})
+	}
+
+	baseName := "sharehere-selection"
+	if name := filepath.Base(strings.TrimSpace(req.Name)); name != "." && name != "/" && name != "" {
+		baseName = strings.TrimSuffix(name, format.Ext)
+	}
+	if u := a.currentUser(r); u != nil {
+		meta, _ := json.Marshal(map[string]any{"paths": rels, "format": format.Name})
+		_ = a.store.RecordAudit(&u.ID, "file.zip", strings.Join(rels, ","), string(meta))
+	}
+	a.streamArchive(w, archiveFilename(baseName, format), format, sources, scope)
+}
+
+// dedupeNestedPaths drops paths already covered by a selected ancestor so the
+// archive never contains the same file twice.
+func dedupeNestedPaths(rels []string) []string {
+	sorted := append([]string(nil), rels...)
+	sort.Strings(sorted)
+	out := make([]string, 0, len(sorted))
+	for _, rel := range sorted {
+		covered := false
+		for _, kept := range out {
+			if kept == "" || rel == kept || strings.HasPrefix(rel, kept+"/") {
+				covered = true
+				break
+			}
 		}
-		relPath, err := filepath.Rel(abs, curr)
-		if err != nil {
-			return nil
+		if !covered {
+			out = append(out, rel)
 		}
-		zipPath := path.Join(rootName, filepath.ToSlash(relPath))
-		return addFile(curr, zipPath, fi)
-	}); err != nil {
-		a.writeError(w, http.StatusInternalServerError, "zip failed")
 	}
+	return out
+}
+
+func uniqueArchiveName(name string, used map[string]bool) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+	used[candidate] = true
+	return candidate
 }
 
 type renameRequest struct {
@@ -244,8 +362,9 @@ type renameRequest struct {
 	NewName string `json:"newName"`
 }
 
-type singlePathRequest struct {
-	Path string `json:"path"`
+type deleteRequest struct {
+	Path  string   `json:"path"`
+	Paths []string `json:"paths"`
 }
 
 func decodeJSONBody(r *http.Request, out any) error {
@@ -268,42 +387,81 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := a.currentUser(r)
-	uploaded, issues, err := a.consumeMultipartUpload(w, r, settings, util.NormalizeRelPath(""))
+	// Users who may delete may also ask for a file to be overwritten.
+	res, err := a.consumeMultipartUpload(w, r, settings, util.NormalizeRelPath(""), perms.CanDelete, a.scopeFor(r, settings, perms))
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	meta, _ := json.Marshal(map[string]any{"files": res.Uploaded, "errors": res.Errors})
 	if user != nil {
-		meta, _ := json.Marshal(map[string]any{"files": uploaded, "errors": issues})
-		_ = a.store.RecordAudit(&user.ID, "upload", strings.Join(uploaded, ","), string(meta))
+		_ = a.store.RecordAudit(&user.ID, "upload", strings.Join(res.Uploaded, ","), string(meta))
 	} else {
-		meta, _ := json.Marshal(map[string]any{"files": uploaded, "errors": issues})
-		_ = a.store.RecordAudit(nil, "upload.guest", strings.Join(uploaded, ","), string(meta))
+		_ = a.store.RecordAudit(nil, "upload.guest", strings.Join(res.Uploaded, ","), string(meta))
 	}
-	a.writeJSON(w, http.StatusOK, map[string]any{"uploaded": uploaded, "errors": issues})
+	a.writeJSON(w, http.StatusOK, res)
 }
 
-func (a *App) consumeMultipartUpload(w http.ResponseWriter, r *http.Request, settings db.AppSettings, forcedBaseRel string) ([]string, []string, error) {
+// uploadResult is the response body of an upload. Conflicts lists the files
+// refused under the reject or ask policy and Rejected those refused by the
+// upload policy; each also has an entry in Errors for clients that only read
+// those. Receipt lists the files stored in drop boxes the uploader cannot see
+// into.
+type uploadResult struct {
+	Uploaded  []string          `json:"uploaded"`
+	Errors    []string          `json:"errors"`
+	Conflicts []uploadConflict  `json:"conflicts,omitempty"`
+	Rejected  []uploadRejection `json:"rejected,omitempty"`
+	Receipt   []uploadReceipt   `json:"receipt,omitempty"`
+}
+
+func (res *uploadResult) reject(rej *uploadRejection) {
+	res.Rejected = append(res.Rejected, *rej)
+	res.Errors = append(res.Errors, rej.Message)
+}
+
+// consumeMultipartUpload stores the file parts of an upload. The collision
+// policy can be overridden for the whole request with ?collision= or for the
+// next file with a "collision" field; canReplace allows overriding to
+// overwrite or version when the configured policy doesn't replace. Files are
+// only written to folders inside scope, except that files sent to one of its
+// hidden drop boxes are stored there under the rename policy. Optional "name"
+// and "email" fields identify the uploader to the drop box owner.
+func (a *App) consumeMultipartUpload(w http.ResponseWriter, r *http.Request, settings db.AppSettings, forcedBaseRel string, canReplace bool, scope pathScope) (uploadResult, error) {
+	res := uploadResult{Uploaded: make([]string, 0), Errors: make([]string, 0)}
 	maxBytes := settings.MaxUploadSizeMB * 1024 * 1024
 	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	requestPolicy, err := collisionOverride(r.URL.Query().Get("collision"), settings.CollisionPolicy, canReplace)
+	if err != nil {
+		return res, err
+	}
 	mr, err := r.MultipartReader()
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid multipart payload")
+		return res, fmt.Errorf("invalid multipart payload")
 	}
-	var allowRe, denyRe *regexp.Regexp
-	if strings.TrimSpace(settings.UploadAllowRegex) != "" {
-		allowRe, err = regexp.Compile(settings.UploadAllowRegex)
-		if err != nil {
-			return nil, nil, fmt.Errorf("invalid allow regex")
-		}
+	rules, err := newUploadPolicy(settings)
+	if err != nil {
+		return res, err
 	}
-	if strings.TrimSpace(settings.UploadDenyRegex) != "" {
-		denyRe, err = regexp.Compile(settings.UploadDenyRegex)
-		if err != nil {
-			return nil, nil, fmt.Errorf("invalid deny regex")
-		}
+	files := 0
+	scanner, err := virusScanner(settings)
+	if err != nil {
+		return res, err
 	}
 
+	var createdBy *int64
+	var uploader dropUploader
+	u := a.currentUser(r)
+	if u != nil {
+		createdBy = &u.ID
+		uploader.Name = u.Username
+	}
+	keep := a.versionHook(settings, createdBy)
+	// The version policy keeps old content even with versioning switched off.
+	forced := settings
+	forced.VersioningEnabled = true
+	keepForced := a.versionHook(forced, createdBy)
+
 	baseRel := forcedBaseRel
 	if baseRel == "" {
 		baseRel = util.NormalizeRelPath(r.URL.Query().Get("path"))
@@ -313,8 +471,19 @@ func (a *App) consumeMultipartUpload(w http.ResponseWriter, r *http.Request, set
 	}
 	baseRel = util.NormalizeRelPath(baseRel)
 
-	uploaded := make([]string, 0)
-	issues := make([]string, 0)
+	// An optional "mtime" field (unix seconds) applies to the next file part so
+	// sync clients can preserve modification times.
+	var pendingMtime *time.Time
+	// Likewise, "checksum" ("algo:hex") is verified against the next file
+	// before it is moved into place, and "collision" overrides the policy for
+	// the next file only.
+	var pendingChecksum *uploadChecksum
+	pendingChecksumInvalid := false
+	pendingPolicy := ""
+
+	// Files per drop box, for notifying the owners once the upload is done.
+	var drops []db.DropBox
+	dropFiles := make(map[int64][]string)
 
 	for {
 		part, err := mr.NextPart()
@@ -322,7 +491,7 @@ func (a *App) consumeMultipartUpload(w http.ResponseWriter, r *http.Request, set
 			break
 		}
 		if err != nil {
-			return uploaded, issues, err
+			return res, err
 		}
 		if part.FormName() == "path" && forcedBaseRel == "" {
 			buf := &bytes.Buffer{}
@@ -335,114 +504,291 @@ func (a *App) consumeMultipartUpload(w http.ResponseWriter, r *http.Request, set
 			part.Close()
 			continue
 		}
+		if part.FormName() == "mtime" {
+			buf := &bytes.Buffer{}
+			_, _ = io.CopyN(buf, part, 64)
+			part.Close()
+			if secs, err := strconv.ParseInt(strings.TrimSpace(buf.String()), 10, 64); err == nil && secs > 0 {
+				t := time.Unix(secs, 0)
+				pendingMtime = &t
+			}
+			continue
+		}
+		if part.FormName() == "checksum" {
+			buf := &bytes.Buffer{}
+			_, _ = io.CopyN(buf, part, 256)
+			part.Close()
+			pendingChecksum, pendingChecksumInvalid = nil, false
+			if strings.TrimSpace(buf.String()) == "" {
+				continue
+			}
+			algo, digest, err := util.ParseChecksum(buf.String())
+			if err != nil {
+				pendingChecksumInvalid = true
+				continue
+			}
+			pendingChecksum = &uploadChecksum{algo: algo, digest: digest}
+			continue
+		}
+		if part.FormName() == "collision" {
+			buf := &bytes.Buffer{}
+			_, _ = io.CopyN(buf, part, 64)
+			part.Close()
+			pendingPolicy = buf.String()
+			continue
+		}
+		if (part.FormName() == "name" || part.FormName() == "email") && u == nil {
+			buf := &bytes.Buffer{}
+			_, _ = io.CopyN(buf, part, 256)
+			part.Close()
+			if part.FormName() == "name" {
+				uploader.Name = strings.TrimSpace(buf.String())
+			} else {
+				uploader.Email = strings.TrimSpace(buf.String())
+			}
+			continue
+		}
 		if part.FileName() == "" {
 			part.Close()
 			continue
 		}
+		mtime := pendingMtime
+		pendingMtime = nil
+		expected := pendingChecksum
+		checksumInvalid := pendingChecksumInvalid
+		pendingChecksum, pendingChecksumInvalid = nil, false
+		requested := pendingPolicy
+		pendingPolicy = ""
+		files++
 		filename := filepath.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
-		if filename == "." || filename == "" {
-			issues = append(issues, "invalid filename")
+		if rej := rules.count(filename, files); rej != nil {
+			res.reject(rej)
 			part.Close()
 			continue
 		}
-		if allowRe != nil && !allowRe.MatchString(filename) {
-			issues = append(issues, fmt.Sprintf("rejected by allow policy: %s", filename))
+		filename, rej := rules.name(filename)
+		if rej != nil {
+			res.reject(rej)
 			part.Close()
 			continue
 		}
-		if denyRe != nil && denyRe.MatchString(filename) {
-			issues = append(issues, fmt.Sprintf("rejected by deny policy: %s", filename))
+		if checksumInvalid {
+			res.Errors = append(res.Errors, fmt.Sprintf("invalid checksum for %s", filename))
 			part.Close()
 			continue
 		}
-
-		dirAbs, err := a.resolvePath(baseRel)
+		policy, err := collisionOverride(requested, requestPolicy, canReplace)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %s", err.Error(), filename))
+			part.Close()
+			continue
+		}
+		dirRel := baseRel
+		box, inBox := scope.dropBoxFor(baseRel)
+		if inBox {
+			// Drop box uploaders never replace files they cannot see.
+			dirRel = dropBoxDir(box, uploader)
+			policy = config.CollisionRename
+		}
+		dirAbs, err := a.resolvePath(dirRel)
+		if err == nil && !inBox {
+			err = scope.check(util.NormalizeRelPath(path.Join(baseRel, filename)), false)
+		}
 		if err != nil {
-			issues = append(issues, fmt.Sprintf("invalid destination for %s", filename))
+			res.Errors = append(res.Errors, fmt.Sprintf("invalid destination for %s", filename))
+			part.Close()
+			continue
+		}
+		if rej := rules.depth(filename, util.NormalizeRelPath(path.Join(dirRel, filename))); rej != nil {
+			res.reject(rej)
+			part.Close()
+			continue
+		}
+		// Check the real content type before anything is created on disk.
+		src, rej, err := rules.sniff(filename, part)
+		if err != nil || rej != nil {
+			if rej != nil {
+				res.reject(rej)
+			} else {
+				res.Errors = append(res.Errors, fmt.Sprintf("write failed for %s", filename))
+			}
 			part.Close()
 			continue
 		}
 		if err := os.MkdirAll(dirAbs, 0o755); err != nil {
-			issues = append(issues, fmt.Sprintf("mkdir failed for %s", filename))
+			res.Errors = append(res.Errors, fmt.Sprintf("mkdir failed for %s", filename))
 			part.Close()
 			continue
 		}
 
-		dest := filepath.Join(dirAbs, filename)
-		if settings.CollisionPolicy != "overwrite" {
-			dest = chooseCollisionPath(dest)
+		want := filepath.Join(dirAbs, filename)
+		if err := checkCollisionPath(want, policy); err != nil {
+			if errors.Is(err, errCollision) {
+				res.Conflicts = append(res.Conflicts, a.conflictFor(want, policy))
+				res.Errors = append(res.Errors, fmt.Sprintf("already exists: %s", filename))
+			} else {
+				res.Errors = append(res.Errors, fmt.Sprintf("write failed for %s", filename))
+			}
+			part.Close()
+			continue
 		}
 
-		if err := writeUploadedFile(dest, part); err != nil {
-			issues = append(issues, fmt.Sprintf("write failed for %s", filename))
+		var vet func(string) error
+		if scanner != nil && settings.VirusScanSync {
+			// Scan the .part file so nothing infected ever shows up under
+			// its final name.
+			relDest, _ := util.RelPathFromRoot(a.rootAbs, want)
+			vet = func(tmp string) error { return a.scanFile(scanner, tmp, relDest, createdBy) }
+		}
+		// The file only takes its name once it is complete and vetted.
+		dest := want
+		tmp, err := writePartFile(want, src, expected, vet)
+		if err == nil {
+			if config.CollisionReplaces(policy) {
+				hook := keep
+				if policy == config.CollisionVersion {
+					hook = keepForced
+				}
+				finish := hook.before(want)
+				err = os.Rename(tmp, want)
+				finish(err == nil)
+			} else {
+				dest, err = placeCollisionPath(tmp, want, policy)
+			}
+			if err != nil {
+				_ = os.Remove(tmp)
+			}
+		}
+		if err != nil {
+			var scanErr *scanError
+			switch {
+			case errors.Is(err, errCollision):
+				// Someone else took the name while this file was written.
+				res.Conflicts = append(res.Conflicts, a.conflictFor(want, policy))
+				res.Errors = append(res.Errors, fmt.Sprintf("already exists: %s", filename))
+			case errors.Is(err, errChecksumMismatch):
+				res.Errors = append(res.Errors, fmt.Sprintf("checksum mismatch for %s", filename))
+			case errors.Is(err, errInfected):
+				res.reject(rejection(filename, rejectInfected, "", "infected file quarantined: %s", filename))
+			case errors.As(err, &scanErr):
+				res.reject(rejection(filename, rejectScanFailed, "", "virus scan failed: %s", filename))
+			default:
+				res.Errors = append(res.Errors, fmt.Sprintf("write failed for %s", filename))
+			}
 			part.Close()
 			continue
 		}
 		part.Close()
+		if mtime != nil {
+			_ = os.Chtimes(dest, *mtime, *mtime)
+		}
 
 		relSaved, err := util.RelPathFromRoot(a.rootAbs, dest)
 		if err != nil {
 			relSaved = filename
 		}
-		uploaded = append(uploaded, relSaved)
-		a.runVirusScanHook(settings.VirusScanCommand, dest)
+		if expected != nil {
+			if info, err := os.Stat(dest); err == nil {
+				_ = a.store.PutFileChecksum(relSaved, expected.algo, info.Size(), info.ModTime(), expected.digest)
+			}
+		}
+		res.Uploaded = append(res.Uploaded, relSaved)
+		if inBox {
+			receipt := uploadReceipt{Name: filepath.Base(dest)}
+			if info, err := os.Stat(dest); err == nil {
+				receipt.Size = info.Size()
+			}
+			res.Receipt = append(res.Receipt, receipt)
+			if _, seen := dropFiles[box.ID]; !seen {
+				drops = append(drops, box)
+			}
+			dropFiles[box.ID] = append(dropFiles[box.ID], relSaved)
+		}
+		if scanner != nil && !settings.VirusScanSync {
+			a.scanPlaced(scanner, dest, createdBy)
+		}
 	}
-	return uploaded, issues, nil
+	for _, box := range drops {
+		a.notifyDropBox(box, uploader, dropFiles[box.ID])
+	}
+	return res, nil
+}
+
+type uploadChecksum struct {
+	algo   string
+	digest string
 }
 
-func chooseCollisionPath(dest string) string {
-	if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-		return dest
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// writeUploadedFile writes src to a temporary file next to path and renames
+// it into place. Each call uses its own temporary name, so concurrent writes
+// to the same path can't interleave.
+func writeUploadedFile(path string, src io.Reader, expected *uploadChecksum) error {
+	tmp, err := writePartFile(path, src, expected, nil)
+	if err != nil {
+		return err
 	}
-	ext := filepath.Ext(dest)
-	base := strings.TrimSuffix(filepath.Base(dest), ext)
-	dir := filepath.Dir(dest)
-	for i := 1; i < 100000; i++ {
-		candidate := filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
-		if _, err := os.Stat(candidate); errors.Is(err, os.ErrNotExist) {
-			return candidate
-		}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
 	}
-	return filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, time.Now().UnixNano(), ext))
+	return nil
 }
 
-func writeUploadedFile(path string, src io.Reader) error {
-	tmp := path + ".part"
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+// scanError wraps a failure of the vet step of writePartFile.
+type scanError struct{ err error }
+
+func (e *scanError) Error() string { return "scan: " + e.err.Error() }
+func (e *scanError) Unwrap() error { return e.err }
+
+// writePartFile writes src to a new temporary file next to path and returns
+// its name, leaving it to the caller to move it into place. The file is
+// checked against expected and then shown to vet, if given; a vet error
+// stops the write, with errInfected passed through as is and anything else
+// coming back as a *scanError. Nothing is left behind on failure.
+func writePartFile(path string, src io.Reader, expected *uploadChecksum, vet func(tmp string) error) (string, error) {
+	tmp, err := partPath(path)
 	if err != nil {
-		return err
+		return "", err
+	}
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	var dst io.Writer = f
+	var h hash.Hash
+	if expected != nil {
+		if h, err = util.NewChecksum(expected.algo); err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+			return "", err
+		}
+		dst = io.MultiWriter(f, h)
 	}
-	if _, err := io.Copy(f, src); err != nil {
+	if _, err := io.Copy(dst, src); err != nil {
 		_ = f.Close()
 		_ = os.Remove(tmp)
-		return err
+		return "", err
 	}
 	if err := f.Close(); err != nil {
 		_ = os.Remove(tmp)
-		return err
+		return "", err
 	}
-	return os.Rename(tmp, path)
-}
-
-func (a *App) runVirusScanHook(cmdline, filePath string) {
-	cmdline = strings.TrimSpace(cmdline)
-	if cmdline == "" {
-		return
+	if h != nil && hex.EncodeToString(h.Sum(nil)) != expected.digest {
+		_ = os.Remove(tmp)
+		return "", errChecksumMismatch
 	}
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-		defer cancel()
-		var cmd *exec.Cmd
-		if runtime.GOOS == "windows" {
-			cmd = exec.CommandContext(ctx, "cmd", "/C", cmdline)
-		} else {
-			cmd = exec.CommandContext(ctx, "sh", "-c", cmdline)
-		}
-		cmd.Env = append(os.Environ(), "SHAREHERE_FILE="+filePath)
-		if err := cmd.Run(); err != nil {
-			a.logger.Warn("virus scan hook failed", "file", filePath, "error", err)
+	if vet != nil {
+		if err := vet(tmp); err != nil {
+			_ = os.Remove(tmp)
+			if errors.Is(err, errInfected) {
+				return "", err
+			}
+			return "", &scanError{err}
 		}
-	}()
+	}
+	return tmp, nil
 }
 
 func (a *App) handleDelete(w http.ResponseWriter, r *http.Request) {
@@ -457,29 +803,49 @@ func (a *App) handleDelete(w http.ResponseWriter, r *http.Request) {
 	if !a.requireWrite(w, perms, "delete") {
 		return
 	}
-	var req singlePathRequest
+	var req deleteRequest
 	if err := decodeJSONBody(r, &req); err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid payload")
 		return
 	}
-	rel := util.NormalizeRelPath(req.Path)
-	if rel == "" {
+	if strings.TrimSpace(req.Path) == "" && len(req.Paths) == 0 {
 		a.writeError(w, http.StatusBadRequest, "refusing to delete root")
 		return
 	}
-	abs, err := a.resolvePath(rel)
-	if err != nil {
-		a.writeError(w, http.StatusBadRequest, "invalid path")
-		return
-	}
-	if err := os.RemoveAll(abs); err != nil {
-		a.writeError(w, http.StatusInternalServerError, "delete failed")
-		return
-	}
-	if u := a.currentUser(r); u != nil {
-		_ = a.store.RecordAudit(&u.ID, "file.delete", rel, "")
+	requested := requestPaths(req.Path, req.Paths)
+	issues := make([]string, 0)
+	rels := make([]string, 0, len(requested))
+	for _, rel := range requested {
+		if rel == "" {
+			issues = append(issues, "refusing to delete root")
+			continue
+		}
+		rels = append(rels, rel)
+	}
+	rels = dedupeNestedPaths(rels)
+	deleted := make([]string, 0, len(rels))
+	u := a.currentUser(r)
+	scope := a.scopeFor(r, settings, perms)
+	for _, rel := range rels {
+		if err := scope.check(rel, true); err != nil {
+			issues = append(issues, opErrorMessage(rel, "delete", err))
+			continue
+		}
+		abs, err := a.resolvePath(rel)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("%s: invalid path", rel))
+			continue
+		}
+		if err := os.RemoveAll(abs); err != nil {
+			issues = append(issues, fmt.Sprintf("%s: delete failed", rel))
+			continue
+		}
+		deleted = append(deleted, rel)
+		if u != nil {
+			_ = a.store.RecordAudit(&u.ID, "file.delete", rel, "")
+		}
 	}
-	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	a.writeBatchResult(w, len(requested), deleted, issues)
 }
 
 func (a *App) handleRename(w http.ResponseWriter, r *http.Request) {
@@ -505,12 +871,20 @@ func (a *App) handleRename(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusBadRequest, "invalid rename request")
 		return
 	}
+	newRel := path.Join(path.Dir(rel), newName)
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel, newRel) {
+		return
+	}
+	if err := scope.check(rel, true); err != nil {
+		a.writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	oldAbs, err := a.resolvePath(rel)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid source path")
 		return
 	}
-	newRel := path.Join(path.Dir(rel), newName)
 	newAbs, err := a.resolvePath(newRel)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid target path")
@@ -526,10 +900,14 @@ func (a *App) handleRename(w http.ResponseWriter, r *http.Request) {
 	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": newRel})
 }
 
+// shareCreateRequest creates share links. PerUploader only applies to the
+// dropbox mode.
 type shareCreateRequest struct {
-	Path   string `json:"path"`
-	Expiry string `json:"expiry"`
-	Mode   string `json:"mode"`
+	Path        string   `json:"path"`
+	Paths       []string `json:"paths"`
+	Expiry      string   `json:"expiry"`
+	Mode        string   `json:"mode"`
+	PerUploader bool     `json:"perUploader"`
 }
 
 func (a *App) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
@@ -550,11 +928,6 @@ func (a *App) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
 		a.writeError(w, http.StatusBadRequest, "invalid payload")
 		return
 	}
-	rel := util.NormalizeRelPath(req.Path)
-	if _, err := a.resolvePath(rel); err != nil {
-		a.writeError(w, http.StatusBadRequest, "invalid path")
-		return
-	}
 	expiry := strings.TrimSpace(req.Expiry)
 	if expiry == "" {
 		expiry = settings.DefaultShareExpiry
@@ -569,39 +942,95 @@ func (a *App) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
 		mode = "browse"
 	}
 	switch mode {
-	case "browse", "download", "upload":
+	case "browse", "download", "upload", "dropbox":
 	default:
 		a.writeError(w, http.StatusBadRequest, "invalid mode")
 		return
 	}
 
-	token, err := util.RandomToken(18)
-	if err != nil {
-		a.writeError(w, http.StatusInternalServerError, "token generation failed")
-		return
-	}
-	principal := a.currentPrincipal(r)
+	// Use the session user rather than the principal: with auth off the
+	// principal is a synthetic admin with no users row to reference.
 	var createdBy *int64
-	if !principal.Anonymous {
-		uid := principal.UserID
+	if u := a.currentUser(r); u != nil {
+		uid := u.ID
 		createdBy = &uid
 	}
+	// Links are visited without the creator's scope, so they may only point
+	// at trees the creator sees in full.
+	scope := a.scopeFor(r, settings, perms)
+	if len(req.Paths) == 0 {
+		rel := util.NormalizeRelPath(req.Path)
+		if err := scope.check(rel, true); err != nil {
+			a.writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		link, status, err := a.createShareLink(rel, mode, d, createdBy, req.PerUploader)
+		if err != nil {
+			a.writeError(w, status, err.Error())
+			return
+		}
+		url := a.absoluteURL(r, a.route("/s/"+link.Token))
+		a.writeJSON(w, http.StatusOK, map[string]any{"token": link.Token, "url": url, "expiresAt": link.ExpiresAt})
+		return
+	}
+
+	// Batch form: one link per selected path.
+	links := make([]map[string]any, 0, len(req.Paths))
+	issues := make([]string, 0)
+	for _, rel := range requestPaths(req.Path, req.Paths) {
+		if err := scope.check(rel, true); err != nil {
+			issues = append(issues, opErrorMessage(rel, "share", err))
+			continue
+		}
+		link, _, err := a.createShareLink(rel, mode, d, createdBy, req.PerUploader)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("%s: %s", rel, err.Error()))
+			continue
+		}
+		links = append(links, map[string]any{
+			"path":      rel,
+			"token":     link.Token,
+			"url":       a.absoluteURL(r, a.route("/s/"+link.Token)),
+			"expiresAt": link.ExpiresAt,
+		})
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"links": links, "errors": issues})
+}
+
+func (a *App) createShareLink(rel, mode string, ttl time.Duration, createdBy *int64, perUploader bool) (db.ShareLink, int, error) {
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		return db.ShareLink{}, http.StatusBadRequest, errors.New("invalid path")
+	}
+	if mode == "dropbox" {
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return db.ShareLink{}, http.StatusBadRequest, errors.New("drop box must be an existing folder")
+		}
+	}
+	token, err := util.RandomToken(18)
+	if err != nil {
+		return db.ShareLink{}, http.StatusInternalServerError, errors.New("token generation failed")
+	}
 	link := db.ShareLink{
 		Token:     token,
 		Path:      rel,
 		Mode:      mode,
 		CreatedBy: createdBy,
-		ExpiresAt: time.Now().Add(d),
+		ExpiresAt: time.Now().Add(ttl),
 	}
 	if err := a.store.CreateShareLink(link); err != nil {
-		a.writeError(w, http.StatusInternalServerError, "failed to create link")
-		return
+		return db.ShareLink{}, http.StatusInternalServerError, errors.New("failed to create link")
+	}
+	if mode == "dropbox" {
+		if err := a.store.CreateDropBoxLink(db.DropBox{Path: rel, LinkToken: &token, PerUploader: perUploader, CreatedBy: createdBy}); err != nil {
+			_ = a.store.RevokeShareLink(token)
+			return db.ShareLink{}, http.StatusInternalServerError, errors.New("failed to create link")
+		}
 	}
 	if createdBy != nil {
 		_ = a.store.RecordAudit(createdBy, "share.create", rel, mode)
 	}
-	url := a.absoluteURL(r, a.route("/s/"+token))
-	a.writeJSON(w, http.StatusOK, map[string]any{"token": token, "url": url, "expiresAt": link.ExpiresAt})
+	return link, http.StatusOK, nil
 }
 
 func (a *App) handleRevokeShareLink(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+const maxManifestEntries = 200000
+
+var errManifestTooLarge = errors.New("directory has too many entries for a manifest")
+
+// handleManifest returns a recursive listing of a directory for sync clients.
+// SHA-256 digests are only computed when hash=1 because they require reading
+// every file.
+func (a *App) handleManifest(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireBrowse(w, r, perms) {
+		return
+	}
+	rel := a.parseRelative(r, "path")
+	withHash, _ := strconv.ParseBool(r.URL.Query().Get("hash"))
+	scope := a.scopeFor(r, settings, perms)
+	if !a.requireVisible(w, scope, rel) {
+		return
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid path")
+		return
+	}
+	entries := make([]manifestEntry, 0)
+	// A missing directory is reported as empty so clients can push into it.
+	info, err := os.Stat(abs)
+	exists := err == nil
+	if exists {
+		if !info.IsDir() {
+			a.writeError(w, http.StatusBadRequest, "path is not a directory")
+			return
+		}
+		entries, err = buildManifest(abs, withHash, func(sub string) bool {
+			return !scope.allows(util.NormalizeRelPath(path.Join(rel, sub)))
+		})
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{
+		"path":        rel,
+		"exists":      exists,
+		"entries":     entries,
+		"hashed":      withHash,
+		"permissions": perms,
+		"uploadPolicy": map[string]any{
+			"collisionPolicy": settings.CollisionPolicy,
+			"maxUploadSizeMB": settings.MaxUploadSizeMB,
+			"uploadSubdir":    settings.UploadSubdir,
+		},
+	})
+}
+
+// buildManifest walks rootAbs. Directories for which skip reports true (given
+// their path relative to rootAbs) are left out with everything below them.
+func buildManifest(rootAbs string, withHash bool, skip func(string) bool) ([]manifestEntry, error) {
+	entries := make([]manifestEntry, 0)
+	err := filepath.WalkDir(rootAbs, func(curr string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if curr == rootAbs {
+				return walkErr
+			}
+			return nil
+		}
+		if curr == rootAbs || d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		if len(entries) >= maxManifestEntries {
+			return errManifestTooLarge
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(rootAbs, curr)
+		if err != nil {
+			return nil
+		}
+		relPath = util.NormalizeRelPath(filepath.ToSlash(relPath))
+		if d.IsDir() && skip != nil && skip(relPath) {
+			return filepath.SkipDir
+		}
+		entry := manifestEntry{
+			Path:    relPath,
+			IsDir:   d.IsDir(),
+			ModTime: fi.ModTime().UTC(),
+		}
+		if !d.IsDir() {
+			entry.Size = fi.Size()
+			if withHash {
+				sum, err := sha256File(curr)
+				if err != nil {
+					// A manifest without a hash for a file that exists would
+					// read as "not hashed"; fail instead. The path error names
+					// the absolute path, so report the relative one.
+					var pathErr *fs.PathError
+					if errors.As(err, &pathErr) {
+						err = pathErr.Err
+					}
+					return fmt.Errorf("cannot hash %s: %w", relPath, err)
+				}
+				entry.SHA256 = sum
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
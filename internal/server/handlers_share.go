@@ -1,15 +1,12 @@
 package server
 
 import (
-	"archive/zip"
 	"fmt"
 	"html"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -44,7 +41,7 @@ func (a *App) handleShare(w http.ResponseWriter, r *http.Request) {
 	_ = a.store.MarkShareLinkAccessed(token)
 
 	if suffix == "upload" {
-		if link.Mode != "upload" {
+		if link.Mode != "upload" && link.Mode != "dropbox" {
 			a.writeError(w, http.StatusForbidden, "upload not allowed for this link")
 			return
 		}
@@ -53,7 +50,7 @@ func (a *App) handleShare(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch link.Mode {
-	case "upload":
+	case "upload", "dropbox":
 		if r.Method != http.MethodGet {
 			a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
@@ -62,6 +59,7 @@ func (a *App) handleShare(w http.ResponseWriter, r *http.Request) {
 			"BasePath": a.templateBasePath(),
 			"Token":    link.Token,
 			"Path":     link.Path,
+			"DropBox":  link.Mode == "dropbox",
 		}); err != nil {
 			a.writeError(w, http.StatusInternalServerError, "render failed")
 		}
@@ -94,14 +92,28 @@ func (a *App) handleShareUpload(w http.ResponseWriter, r *http.Request, link db.
 			base = path.Dir(base)
 		}
 	}
-	uploaded, issues, err := a.consumeMultipartUpload(w, r, settings, util.NormalizeRelPath(base))
+	// A drop box link uploads into a folder the visitor cannot see into.
+	scope := pathScope{}
+	action := "share.upload"
+	if link.Mode == "dropbox" {
+		box, err := a.store.GetDropBoxLink(link.Token)
+		if err != nil {
+			a.writeError(w, http.StatusNotFound, "link not found")
+			return
+		}
+		base = box.Path
+		scope.drops = []db.DropBox{box}
+		action = "share.dropbox"
+	}
+	// Link visitors may not override the policy to replace existing files.
+	res, err := a.consumeMultipartUpload(w, r, settings, util.NormalizeRelPath(base), false, scope)
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	meta := fmt.Sprintf("token=%s", link.Token)
-	_ = a.store.RecordAudit(link.CreatedBy, "share.upload", strings.Join(uploaded, ","), meta)
-	a.writeJSON(w, http.StatusOK, map[string]any{"uploaded": uploaded, "errors": issues})
+	_ = a.store.RecordAudit(link.CreatedBy, action, strings.Join(res.Uploaded, ","), meta)
+	a.writeJSON(w, http.StatusOK, res)
 }
 
 func (a *App) handleShareBrowse(w http.ResponseWriter, r *http.Request, link db.ShareLink) {
@@ -109,12 +121,15 @@ func (a *App) handleShareBrowse(w http.ResponseWriter, r *http.Request, link db.
 		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	sub := util.NormalizeRelPath(r.URL.Query().Get("p"))
-	scopedRel, err := resolveScopedSharePath(link.Path, sub)
+	scopedRel, err := resolveScopedSharePath(link.Path, r.URL.Query().Get("p"))
 	if err != nil {
 		a.writeError(w, http.StatusBadRequest, "invalid path")
 		return
 	}
+	if r.URL.Query().Get("checksum") != "" {
+		a.serveShareChecksum(w, r, scopedRel)
+		return
+	}
 	if r.URL.Query().Get("download") != "" {
 		a.serveRelAsDownload(w, r, scopedRel)
 		return
@@ -142,11 +157,18 @@ func (a *App) handleShareBrowse(w http.ResponseWriter, r *http.Request, link db.
 	fmt.Fprintf(w, "<!doctype html><html><head><meta charset=\"utf-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><title>sharehere link</title><link rel=\"stylesheet\" href=\"%s\"></head><body><main class=\"panel\" style=\"margin:1rem;max-width:960px\"><h1>Shared folder</h1>", html.EscapeString(a.route("/static/tailwind.css")))
 	fmt.Fprintf(w, "<p><strong>Path:</strong> <code>%s</code></p>", html.EscapeString(scopedRel))
 	shareBase := a.route("/s/" + link.Token)
-	zipURL := fmt.Sprintf("%s?p=%s&download=1", shareBase, url.QueryEscape(scopedRel))
-	fmt.Fprintf(w, "<p><a class=\"button\" href=\"%s\">Download current path</a></p>", html.EscapeString(zipURL))
+	// Query paths are relative to the link's own path.
+	current := shareRelative(link.Path, scopedRel)
+	sumsURL := fmt.Sprintf("%s?p=%s&checksum=sha256", shareBase, url.QueryEscape(current))
+	fmt.Fprintf(w, "<form class=\"row\" method=\"get\" action=\"%s\"><input type=\"hidden\" name=\"p\" value=\"%s\"><input type=\"hidden\" name=\"download\" value=\"1\">", html.EscapeString(shareBase), html.EscapeString(current))
+	fmt.Fprint(w, "<select name=\"format\" aria-label=\"Archive format\">")
+	for _, f := range archiveFormats {
+		fmt.Fprintf(w, "<option value=\"%s\">%s</option>", html.EscapeString(f.Name), html.EscapeString(f.Name))
+	}
+	fmt.Fprintf(w, "</select><button class=\"button\" type=\"submit\">Download current path</button> <a class=\"button ghost\" href=\"%s\">checksums.txt (SHA-256)</a></form>", html.EscapeString(sumsURL))
 	fmt.Fprint(w, "<ul>")
-	if scopedRel != link.Path {
-		parent := path.Dir(scopedRel)
+	if current != "" {
+		parent := path.Dir(current)
 		if parent == "." {
 			parent = ""
 		}
@@ -155,8 +177,7 @@ func (a *App) handleShareBrowse(w http.ResponseWriter, r *http.Request, link db.
 	}
 	for _, e := range entries {
 		name := e.Name()
-		next := path.Join(scopedRel, name)
-		next = util.NormalizeRelPath(next)
+		next := util.NormalizeRelPath(path.Join(current, name))
 		href := fmt.Sprintf("%s?p=%s", shareBase, url.QueryEscape(next))
 		if !e.IsDir() {
 			href = fmt.Sprintf("%s?p=%s&download=1", shareBase, url.QueryEscape(next))
@@ -165,12 +186,22 @@ func (a *App) handleShareBrowse(w http.ResponseWriter, r *http.Request, link db.
 		if e.IsDir() {
 			suffix = "/"
 		}
-		fmt.Fprintf(w, "<li><a href=\"%s\">%s%s</a></li>", html.EscapeString(href), html.EscapeString(name), suffix)
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s%s</a>", html.EscapeString(href), html.EscapeString(name), suffix)
+		if !e.IsDir() {
+			sumURL := fmt.Sprintf("%s?p=%s&checksum=sha256", shareBase, url.QueryEscape(next))
+			fmt.Fprintf(w, " <a class=\"muted small\" href=\"%s\">sha256</a>", html.EscapeString(sumURL))
+		}
+		fmt.Fprint(w, "</li>")
 	}
 	fmt.Fprint(w, "</ul></main></body></html>")
 }
 
 func resolveScopedSharePath(base, sub string) (string, error) {
+	for _, seg := range strings.Split(strings.ReplaceAll(sub, "\\", "/"), "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("path escapes scope")
+		}
+	}
 	base = util.NormalizeRelPath(base)
 	sub = util.NormalizeRelPath(sub)
 	if sub == "" {
@@ -186,6 +217,49 @@ func resolveScopedSharePath(base, sub string) (string, error) {
 	return "", fmt.Errorf("path escapes scope")
 }
 
+// shareRelative converts a root-relative path inside a share link back to a
+// path relative to the link itself.
+func shareRelative(base, scoped string) string {
+	base = util.NormalizeRelPath(base)
+	if base == "" {
+		return scoped
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(scoped, base), "/")
+}
+
+func (a *App) serveShareChecksum(w http.ResponseWriter, r *http.Request, rel string) {
+	algo, ok := a.checksumAlgo(w, r)
+	if !ok {
+		return
+	}
+	if v := strings.ToLower(r.URL.Query().Get("checksum")); v != "1" && v != "true" {
+		if _, err := util.NewChecksum(v); err == nil {
+			algo = v
+		}
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid path")
+		return
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if info.IsDir() {
+		a.writeChecksumList(w, rel, abs, algo, pathScope{})
+		return
+	}
+	digest, _, err := a.fileChecksum(rel, abs, info, algo)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "checksum failed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s  %s\n", digest, info.Name())
+}
+
 func (a *App) serveRelAsDownload(w http.ResponseWriter, r *http.Request, rel string) {
 	abs, err := a.resolvePath(rel)
 	if err != nil {
@@ -202,38 +276,10 @@ func (a *App) serveRelAsDownload(w http.ResponseWriter, r *http.Request, rel str
 		http.ServeFile(w, r, abs)
 		return
 	}
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()+".zip"))
-	zw := zip.NewWriter(w)
-	defer zw.Close()
-	_ = filepath.WalkDir(abs, func(curr string, d os.DirEntry, err error) error {
-		if err != nil || d.IsDir() || d.Type()&os.ModeSymlink != 0 {
-			return nil
-		}
-		fi, err := d.Info()
-		if err != nil {
-			return nil
-		}
-		relPath, err := filepath.Rel(abs, curr)
-		if err != nil {
-			return nil
-		}
-		hdr, err := zip.FileInfoHeader(fi)
-		if err != nil {
-			return nil
-		}
-		hdr.Name = filepath.ToSlash(path.Join(info.Name(), relPath))
-		hdr.Method = zip.Deflate
-		fw, err := zw.CreateHeader(hdr)
-		if err != nil {
-			return nil
-		}
-		f, err := os.Open(curr)
-		if err != nil {
-			return nil
-		}
-		defer f.Close()
-		_, _ = io.Copy(fw, f)
-		return nil
-	})
+	format, err := parseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.streamArchive(w, archiveFilename(info.Name(), format), format, []zipSource{{abs: abs, rel: rel, name: info.Name(), info: info}}, pathScope{})
 }
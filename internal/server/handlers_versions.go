@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// handleVersions lists the kept versions of a file, newest first.
+func (a *App) handleVersions(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireBrowse(w, r, perms) {
+		return
+	}
+	rel := a.parseRelative(r, "path")
+	if rel == "" {
+		a.writeError(w, http.StatusBadRequest, "path required")
+		return
+	}
+	if !a.requireVisible(w, a.scopeFor(r, settings, perms), rel) {
+		return
+	}
+	versions, err := a.store.ListFileVersions(rel)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list versions")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{
+		"path":       rel,
+		"versions":   versions,
+		"versioning": settings.VersioningEnabled,
+	})
+}
+
+// versionFromRequest loads the version named by the "id" query parameter.
+func (a *App) versionFromRequest(w http.ResponseWriter, id string) (db.FileVersion, bool) {
+	n, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+	if err != nil || n <= 0 {
+		a.writeError(w, http.StatusBadRequest, "invalid version id")
+		return db.FileVersion{}, false
+	}
+	v, err := a.store.GetFileVersion(n)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "version not found")
+		return db.FileVersion{}, false
+	}
+	return v, true
+}
+
+// versionFilename names a downloaded version after the file and the time it
+// was replaced, e.g. "report (2024-05-01 1030).pdf".
+func versionFilename(v db.FileVersion) string {
+	name := path.Base(v.Path)
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(name, ext), v.CreatedAt.Local().Format("2006-01-02 1504"), ext)
+}
+
+func (a *App) handleVersionDownload(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireBrowse(w, r, perms) {
+		return
+	}
+	v, ok := a.versionFromRequest(w, r.URL.Query().Get("id"))
+	if !ok || !a.requireVisible(w, a.scopeFor(r, settings, perms), v.Path) {
+		return
+	}
+	blobPath := filepath.Join(a.versionsDir(), v.Blob)
+	if _, err := os.Stat(blobPath); err != nil {
+		a.writeError(w, http.StatusNotFound, "version content missing")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", versionFilename(v)))
+	http.ServeFile(w, r, blobPath)
+}
+
+type restoreVersionRequest struct {
+	ID int64 `json:"id"`
+}
+
+// handleVersionRestore copies a version back over its file. The current
+// content is itself kept as a version first, so a restore can be undone.
+func (a *App) handleVersionRestore(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireWrite(w, perms, "upload") {
+		return
+	}
+	var req restoreVersionRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	v, ok := a.versionFromRequest(w, strconv.FormatInt(req.ID, 10))
+	if !ok || !a.requireVisible(w, a.scopeFor(r, settings, perms), v.Path) {
+		return
+	}
+	targetAbs, err := a.resolvePath(v.Path)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid path")
+		return
+	}
+	if info, err := os.Lstat(targetAbs); err == nil && !info.Mode().IsRegular() {
+		a.writeError(w, http.StatusConflict, "path is no longer a regular file")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(targetAbs), 0o755); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "mkdir failed")
+		return
+	}
+	in, err := os.Open(filepath.Join(a.versionsDir(), v.Blob))
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "version content missing")
+		return
+	}
+	defer in.Close()
+
+	u := a.currentUser(r)
+	var createdBy *int64
+	if u != nil {
+		createdBy = &u.ID
+	}
+	// The restored version stays in the store; retention removes it later.
+	finish := a.versionHook(settings, createdBy).before(targetAbs)
+	err = writeUploadedFile(targetAbs, in, nil)
+	finish(err == nil)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "restore failed")
+		return
+	}
+	_ = os.Chtimes(targetAbs, v.ModTime, v.ModTime)
+	if u != nil {
+		meta, _ := json.Marshal(map[string]any{"version": v.ID})
+		_ = a.store.RecordAudit(&u.ID, "file.restore", v.Path, string(meta))
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": util.NormalizeRelPath(v.Path)})
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// defaultHomeDirsPath is where home directories live when the setting is
+// empty.
+const defaultHomeDirsPath = "home"
+
+func homeDirsBase(settings db.AppSettings) string {
+	if base := util.NormalizeRelPath(settings.HomeDirsPath); base != "" {
+		return base
+	}
+	return defaultHomeDirsPath
+}
+
+// pathScope decides which root-relative paths a request may see. With home
+// directories on, everything under the home base other than the caller's own
+// home is hidden from non-admins, and with users_see_only_home a non-admin
+// user sees nothing outside their home at all. The contents of drop boxes
+// the caller doesn't own are hidden too. The zero value allows everything.
+type pathScope struct {
+	base    string
+	home    string
+	confine bool
+	drops   []db.DropBox
+}
+
+func (a *App) scopeFor(r *http.Request, settings db.AppSettings, perms Permissions) pathScope {
+	if perms.CanAdmin {
+		return pathScope{}
+	}
+	var s pathScope
+	if settings.HomeDirsEnabled {
+		s = pathScope{base: homeDirsBase(settings), home: perms.Home, confine: perms.HomeOnly}
+	}
+	s.drops = a.foreignDropBoxes(r)
+	return s
+}
+
+func pathWithin(rel, dir string) bool {
+	return dir == "" || rel == dir || strings.HasPrefix(rel, dir+"/")
+}
+
+// allows reports whether rel itself may be seen.
+func (s pathScope) allows(rel string) bool {
+	for _, d := range s.drops {
+		if rel != d.Path && pathWithin(rel, d.Path) {
+			return false
+		}
+	}
+	if s.base == "" {
+		return true
+	}
+	if s.confine {
+		return pathWithin(rel, s.home)
+	}
+	if rel == s.base || !pathWithin(rel, s.base) {
+		return true
+	}
+	return s.home != "" && pathWithin(rel, s.home)
+}
+
+// hidesBelow reports whether something inside rel is hidden.
+func (s pathScope) hidesBelow(rel string) bool {
+	if s.dropAtOrBelow(rel) {
+		return true
+	}
+	if s.base == "" || s.confine {
+		return false
+	}
+	return pathWithin(s.base, rel)
+}
+
+func (s pathScope) dropAtOrBelow(rel string) bool {
+	for _, d := range s.drops {
+		if pathWithin(d.Path, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropBoxFor returns the hidden drop box that rel is, or lies inside of.
+func (s pathScope) dropBoxFor(rel string) (db.DropBox, bool) {
+	for _, d := range s.drops {
+		if pathWithin(rel, d.Path) {
+			return d, true
+		}
+	}
+	return db.DropBox{}, false
+}
+
+// requireVisible answers 404 for paths outside the caller's scope, so hidden
+// homes are indistinguishable from missing ones.
+func (a *App) requireVisible(w http.ResponseWriter, scope pathScope, rels ...string) bool {
+	for _, rel := range rels {
+		if !scope.allows(rel) {
+			a.writeError(w, http.StatusNotFound, "not found")
+			return false
+		}
+	}
+	return true
+}
+
+// check is the per-item form of requireVisible for batch operations. With
+// tree set it also refuses folders that are or contain a foreign drop box or
+// contain other users' homes, for operations that act on a whole tree at
+// once.
+func (s pathScope) check(rel string, tree bool) error {
+	if !s.allows(rel) {
+		return opErrorf("not found")
+	}
+	if tree && s.dropAtOrBelow(rel) {
+		return opErrorf("contains a drop box")
+	}
+	if tree && s.hidesBelow(rel) {
+		return opErrorf("contains other users' home directories")
+	}
+	return nil
+}
+
+// ensureHomeDir creates the home directory of username if home directories
+// are on.
+func (a *App) ensureHomeDir(settings db.AppSettings, username string) {
+	if settings.HomeDirsEnabled {
+		a.createHomeDir(settings, username)
+	}
+}
+
+// createHomeDir creates the home directory of username whether or not home
+// directories are on, so that it's ready once they are.
+func (a *App) createHomeDir(settings db.AppSettings, username string) {
+	rel, err := util.HomeDirRel(homeDirsBase(settings), username)
+	if err != nil {
+		a.logger.Warn("home directory skipped", "user", username, "error", err)
+		return
+	}
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		a.logger.Warn("home directory skipped", "user", username, "error", err)
+		return
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		a.logger.Warn("create home directory failed", "user", username, "error", err)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathScope(t *testing.T) {
+	shared := pathScope{base: "home", home: "home/alice"}
+	confined := pathScope{base: "home", home: "home/alice", confine: true}
+	guest := pathScope{base: "home"}
+	cases := []struct {
+		scope pathScope
+		rel   string
+		want  bool
+	}{
+		{pathScope{}, "home/bob", true},
+		{shared, "", true},
+		{shared, "home", true},
+		{shared, "home/alice/notes.txt", true},
+		{shared, "home/bob", false},
+		{shared, "home/bob/x", false},
+		{shared, "homework", true},
+		{confined, "", false},
+		{confined, "docs", false},
+		{confined, "home/alice", true},
+		{guest, "home/alice", false},
+		{guest, "docs", true},
+	}
+	for _, c := range cases {
+		if got := c.scope.allows(c.rel); got != c.want {
+			t.Errorf("%+v.allows(%q) = %v, want %v", c.scope, c.rel, got, c.want)
+		}
+	}
+	if !shared.hidesBelow("") || !shared.hidesBelow("home") || shared.hidesBelow("home/alice") || shared.hidesBelow("docs") {
+		t.Fatal("hidesBelow mismatch")
+	}
+	if err := shared.check("", true); err == nil {
+		t.Fatal("tree operation on the root allowed")
+	}
+}
+
+func TestListDirPageHidesOtherHomes(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"home/alice", "home/bob", "docs"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &App{rootAbs: root}
+	page, err := a.listDirPage("home", listQuery{Sort: "name", IncludeHidden: true}, pathScope{base: "home", home: "home/alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].RelPath != "home/alice" {
+		t.Fatalf("entries = %+v", page.Entries)
+	}
+}
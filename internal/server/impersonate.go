@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// errNotImpersonating is returned by impersonatorOf for sessions whose admin
+// can no longer view as someone else.
+var errNotImpersonating = errors.New("impersonator is not an admin")
+
+// impersonatorOf returns the admin who opened an impersonation session. An
+// admin who has since been disabled or demoted loses it.
+func (a *App) impersonatorOf(session db.Session) (db.User, error) {
+	admin, err := a.store.GetUserByID(*session.ImpersonatorID)
+	if err != nil {
+		return db.User{}, err
+	}
+	if admin.Disabled || admin.Role != auth.RoleAdmin {
+		_ = a.store.DeleteSession(session.Token)
+		return db.User{}, errNotImpersonating
+	}
+	return admin, nil
+}
+
+// impersonator returns the admin viewing the app as the current principal,
+// or nil outside an impersonation session.
+func (a *App) impersonator(r *http.Request) *db.User {
+	u, ok := r.Context().Value(ctxImpersonatorKey).(db.User)
+	if !ok {
+		return nil
+	}
+	return &u
+}
+
+// blockedWhileImpersonating reports whether path is off limits to an
+// impersonation session even when it may make changes: signing in, account
+// security and administration all belong to the admin's own session.
+func (a *App) blockedWhileImpersonating(p string) bool {
+	switch p {
+	case a.route("/login"), a.route("/api/register"), a.route("/api/password"), a.route("/api/sessions/revoke"):
+		return true
+	}
+	return strings.HasPrefix(p, a.route("/api/passkeys")) || strings.HasPrefix(p, a.route("/api/admin/"))
+}
+
+// enforceImpersonation answers requests an impersonation session may not
+// make and reports whether it did. Looking around is fine outside the admin
+// API, so the view stays what the user sees; changes need the session to
+// allow them and are audited against the admin.
+func (a *App) enforceImpersonation(w http.ResponseWriter, r *http.Request, session db.Session, admin db.User, principal auth.Principal) bool {
+	if strings.HasPrefix(r.URL.Path, a.route("/api/admin/")) {
+		a.writeError(w, http.StatusForbidden, "not available while viewing as another user")
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	switch r.URL.Path {
+	case a.route("/logout"), a.route("/impersonate/stop"):
+		return false
+	}
+	if a.blockedWhileImpersonating(r.URL.Path) {
+		a.writeError(w, http.StatusForbidden, "not available while viewing as another user")
+		return true
+	}
+	if !session.AllowChanges {
+		a.writeError(w, http.StatusForbidden, "changes are off while viewing as another user")
+		return true
+	}
+	_ = a.store.RecordAudit(&admin.ID, "admin.impersonate.action", principal.Username, r.Method+" "+r.URL.Path)
+	return false
+}
+
+// handleAdminImpersonate opens a session as another user, or as a guest,
+// for the admin to see the app the way they do. The admin's own session is
+// kept to return to.
+func (a *App) handleAdminImpersonate(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	perms := a.permissionsFor(r, a.effectiveSettings())
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	admin := a.currentUser(r)
+	if a.opts.AuthMode == config.AuthOff || admin == nil {
+		a.writeError(w, http.StatusBadRequest, "viewing as another user needs authentication")
+		return
+	}
+	var req struct {
+		Username     string `json:"username"`
+		Guest        bool   `json:"guest"`
+		AllowChanges bool   `json:"allow_changes"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	token, err := util.RandomToken(32)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "session failure")
+		return
+	}
+	csrf, err := util.RandomToken(24)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "session failure")
+		return
+	}
+	adminID := admin.ID
+	session := db.Session{
+		Token:          token,
+		CSRFToken:      csrf,
+		IP:             remoteIP(r),
+		UserAgent:      r.UserAgent(),
+		ExpiresAt:      time.Now().Add(authTTL),
+		ImpersonatorID: &adminID,
+		ReturnToken:    a.currentSession(r).Token,
+		AllowChanges:   req.AllowChanges,
+	}
+	target := "guest"
+	if !req.Guest {
+		user, err := a.store.GetUserByUsername(strings.ToLower(strings.TrimSpace(req.Username)))
+		switch {
+		case err != nil:
+			a.writeError(w, http.StatusNotFound, "user not found")
+			return
+		case user.ID == admin.ID:
+			a.writeError(w, http.StatusBadRequest, "that is you")
+			return
+		case user.Role == auth.RoleAdmin:
+			a.writeError(w, http.StatusForbidden, "cannot view as another admin")
+			return
+		case user.Disabled:
+			a.writeError(w, http.StatusBadRequest, "user is disabled")
+			return
+		}
+		session.UserID = &user.ID
+		target = user.Username
+	}
+	if err := a.store.CreateSession(session); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "session failure")
+		return
+	}
+	a.setSessionCookie(w, session)
+	_ = a.store.RecordAudit(&admin.ID, "admin.impersonate.start", target, fmt.Sprintf("allow_changes=%t", req.AllowChanges))
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "redirect": a.route("/")})
+}
+
+// handleStopImpersonation ends an impersonation session and goes back to
+// the admin's own.
+func (a *App) handleStopImpersonation(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	if a.impersonator(r) == nil {
+		a.writeError(w, http.StatusBadRequest, "not viewing as another user")
+		return
+	}
+	a.stopImpersonation(w, r)
+}
+
+// stopImpersonation deletes the current impersonation session and restores
+// the admin's session, or sends them to sign in if it has expired meanwhile.
+func (a *App) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	session := a.currentSession(r)
+	admin := a.impersonator(r)
+	_ = a.store.DeleteSession(session.Token)
+	_ = a.store.RecordAudit(&admin.ID, "admin.impersonate.stop", a.currentPrincipal(r).Username, "")
+	own, err := a.store.GetSession(session.ReturnToken)
+	if err != nil || own.UserID == nil || *own.UserID != admin.ID {
+		a.clearSessionCookie(w)
+		http.Redirect(w, r, a.route("/login"), http.StatusSeeOther)
+		return
+	}
+	a.setSessionCookie(w, own)
+	http.Redirect(w, r, a.route("/admin"), http.StatusSeeOther)
+}
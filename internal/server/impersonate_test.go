@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestImpersonation(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/", AuthMode: config.AuthOn}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	adminID, err := store.CreateUser("root", "x", auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser("alice", "x", auth.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser("bob", "x", auth.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateSession(db.Session{Token: "admin", UserID: &adminID, CSRFToken: "c", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var perms Permissions
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/admin/impersonate", a.handleAdminImpersonate)
+	mux.HandleFunc("/api/admin/users", a.handleAdminUsers)
+	mux.HandleFunc("/impersonate/stop", a.handleStopImpersonation)
+	mux.HandleFunc("/logout", a.handleLogout)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		perms = a.permissionsFor(r, a.effectiveSettings())
+	})
+	handler := a.sessionMiddleware(mux)
+	do := func(token, method, path string, body any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		r := httptest.NewRequest(method, path, bytes.NewReader(raw))
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		if sess, err := store.GetSession(token); err == nil {
+			r.Header.Set("X-CSRF-Token", sess.CSRFToken)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	start := func(body map[string]any) string {
+		t.Helper()
+		rec := do("admin", "POST", "/api/admin/impersonate", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("start %v: %d %s", body, rec.Code, rec.Body)
+		}
+		// The last cookie set wins over the refreshed admin one.
+		cookies := rec.Result().Cookies()
+		return cookies[len(cookies)-1].Value
+	}
+
+	if rec := do("admin", "POST", "/api/admin/impersonate", map[string]any{"username": "root"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("viewing as yourself: %d", rec.Code)
+	}
+	if rec := do("admin", "POST", "/api/admin/impersonate", map[string]any{"username": "bob"}); rec.Code != http.StatusForbidden {
+		t.Fatalf("viewing as another admin: %d", rec.Code)
+	}
+	if rec := do("admin", "GET", "/api/admin/users", nil); rec.Code != http.StatusOK {
+		t.Fatalf("admin users as the admin: %d", rec.Code)
+	}
+
+	// A read-only view sees what alice sees and can't change anything.
+	token := start(map[string]any{"username": "alice"})
+	do(token, "GET", "/api/list", nil)
+	if perms.CanAdmin || !perms.CanBrowse {
+		t.Fatalf("viewing as alice: %+v", perms)
+	}
+	// Admin data stays hidden, and not merely because alice isn't an admin.
+	if rec := do(token, "GET", "/api/admin/users", nil); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "viewing as another user") {
+		t.Fatalf("read-only view GET /api/admin/users: %d %s", rec.Code, rec.Body)
+	}
+	for _, path := range []string{"/api/mkdir", "/api/admin/impersonate", "/api/password"} {
+		if rec := do(token, "POST", path, map[string]any{"username": "alice"}); rec.Code != http.StatusForbidden {
+			t.Errorf("read-only view POST %s: %d", path, rec.Code)
+		}
+	}
+	rec := do(token, "POST", "/impersonate/stop", nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin" {
+		t.Fatalf("stop: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if c := rec.Result().Cookies(); c[len(c)-1].Value != "admin" {
+		t.Fatalf("stop did not restore the admin session: %+v", c)
+	}
+	if _, err := store.GetSession(token); err == nil {
+		t.Fatal("impersonation session outlived stop")
+	}
+
+	// Changes can be allowed, but account security stays off limits.
+	token = start(map[string]any{"guest": true, "allow_changes": true})
+	if rec := do(token, "POST", "/api/mkdir", nil); rec.Code != http.StatusOK {
+		t.Fatalf("allowed change: %d", rec.Code)
+	}
+	if rec := do(token, "GET", "/api/admin/users", nil); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "viewing as another user") {
+		t.Fatalf("GET /api/admin/users while viewing as guest: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(token, "POST", "/api/passkeys/register/begin", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("passkey enrolment while viewing as guest: %d", rec.Code)
+	}
+	if rec := do(token, "POST", "/logout", nil); rec.Header().Get("Location") != "/admin" {
+		t.Fatalf("logout did not end the view: %s", rec.Header().Get("Location"))
+	}
+
+	logs, err := store.ListAudit(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, l := range logs {
+		if l.ActorUserID == nil || *l.ActorUserID != adminID {
+			t.Errorf("%s not attributed to the admin", l.Action)
+		}
+		counts[l.Action+" "+l.Target]++
+	}
+	for _, want := range []string{"admin.impersonate.start alice", "admin.impersonate.stop alice", "admin.impersonate.start guest", "admin.impersonate.action guest", "admin.impersonate.stop guest"} {
+		if counts[want] != 1 {
+			t.Errorf("audit %q: %d entries, want 1", want, counts[want])
+		}
+	}
+
+	// An admin who is disabled meanwhile loses the view.
+	token = start(map[string]any{"username": "alice"})
+	if err := store.SetUserDisabled("root", true); err != nil {
+		t.Fatal(err)
+	}
+	do(token, "GET", "/api/list", nil)
+	if _, err := store.GetSession(token); err == nil {
+		t.Fatal("impersonation session outlived its admin")
+	}
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+const (
+	// maxListLimit caps one page of /api/list.
+	maxListLimit = 5000
+)
+
+var errInvalidListQuery = errors.New("invalid list query")
+
+// listQuery holds the sort, filter and paging options of /api/list. Without a
+// limit the whole (sorted, filtered) directory is returned, which keeps older
+// clients working.
+type listQuery struct {
+	Sort          string
+	Desc          bool
+	Filter        string
+	IncludeHidden bool
+	Limit         int
+	Cursor        string
+}
+
+type listPage struct {
+	Entries     []fileEntry
+	Total       int
+	HiddenCount int
+	NextCursor  string
+}
+
+func parseListQuery(values map[string][]string) (listQuery, error) {
+	get := func(key string) string {
+		if v := values[key]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	q := listQuery{Sort: strings.ToLower(get("sort")), Filter: get("q"), Cursor: get("cursor"), IncludeHidden: true}
+	switch q.Sort {
+	case "":
+		q.Sort = "name"
+	case "date":
+		q.Sort = "mtime"
+	case "name", "size", "mtime", "type":
+	default:
+		return q, errInvalidListQuery
+	}
+	// Names and types read naturally A to Z; sizes and dates are most useful
+	// largest or newest first.
+	q.Desc = q.Sort == "size" || q.Sort == "mtime"
+	switch strings.ToLower(get("order")) {
+	case "":
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errInvalidListQuery
+	}
+	if h := get("hidden"); h != "" {
+		q.IncludeHidden = h == "1" || h == "true"
+	}
+	if l := get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return q, errInvalidListQuery
+		}
+		if n > maxListLimit {
+			n = maxListLimit
+		}
+		q.Limit = n
+	}
+	if q.Filter != "" && strings.ContainsAny(q.Filter, "*?[") {
+		if _, err := path.Match(strings.ToLower(q.Filter), ""); err != nil {
+			return q, errInvalidListQuery
+		}
+	}
+	return q, nil
+}
+
+// matchesFilter applies the name filter: a glob when it contains wildcard
+// characters, a substring otherwise. Both are case-insensitive.
+func (q listQuery) matchesFilter(name string) bool {
+	if q.Filter == "" {
+		return true
+	}
+	lower := strings.ToLower(name)
+	filter := strings.ToLower(q.Filter)
+	if strings.ContainsAny(filter, "*?[") {
+		ok, _ := path.Match(filter, lower)
+		return ok
+	}
+	return strings.Contains(lower, filter)
+}
+
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// listItem defers the stat call of a directory entry until it is needed, so
+// name-sorted pages of huge folders only stat the entries they return.
+type listItem struct {
+	name  string
+	isDir bool
+	entry *fileEntry
+	load  func() (fileEntry, bool)
+}
+
+func (it *listItem) resolve() (fileEntry, bool) {
+	if it.entry != nil {
+		return *it.entry, true
+	}
+	e, ok := it.load()
+	if ok {
+		it.entry = &e
+	}
+	return e, ok
+}
+
+// listDirPage lists rel according to q, leaving out entries outside scope.
+func (a *App) listDirPage(rel string, q listQuery, scope pathScope) (listPage, error) {
+	abs, err := a.resolvePath(rel)
+	if err != nil {
+		return listPage{}, err
+	}
+	dirents, err := os.ReadDir(abs)
+	if err != nil {
+		return listPage{}, err
+	}
+	items := make([]*listItem, 0, len(dirents))
+	for _, d := range dirents {
+		d := d
+		itemRel := util.NormalizeRelPath(path.Join(rel, d.Name()))
+		if !scope.allows(itemRel) {
+			continue
+		}
+		items = append(items, &listItem{
+			name:  d.Name(),
+			isDir: d.IsDir(),
+			load: func() (fileEntry, bool) {
+				info, err := d.Info()
+				if err != nil {
+					return fileEntry{}, false
+				}
+				e := fileEntry{
+					Name:    d.Name(),
+					RelPath: itemRel,
+					IsDir:   d.IsDir(),
+					Size:    info.Size(),
+					ModTime: info.ModTime(),
+					Ext:     strings.ToLower(filepath.Ext(d.Name())),
+				}
+				if e.IsDir {
+					a.applyDirUsage(&e)
+				}
+				return e, true
+			},
+		})
+	}
+	return paginateList(items, q)
+}
+
+// paginateEntries applies q to an already materialized listing, such as the
+// contents of an archive.
+func paginateEntries(entries []fileEntry, q listQuery) (listPage, error) {
+	items := make([]*listItem, 0, len(entries))
+	for i := range entries {
+		e := entries[i]
+		items = append(items, &listItem{name: e.Name, isDir: e.IsDir, entry: &e})
+	}
+	return paginateList(items, q)
+}
+
+func paginateList(items []*listItem, q listQuery) (listPage, error) {
+	page := listPage{Entries: make([]fileEntry, 0)}
+	filtered := items[:0:0]
+	for _, it := range items {
+		if isPartName(it.name) {
+			// An upload in progress, not part of the folder yet.
+			continue
+		}
+		if isHiddenName(it.name) {
+			page.HiddenCount++
+			if !q.IncludeHidden {
+				continue
+			}
+		}
+		if !q.matchesFilter(it.name) {
+			continue
+		}
+		filtered = append(filtered, it)
+	}
+	if q.Sort == "size" || q.Sort == "mtime" {
+		kept := filtered[:0]
+		for _, it := range filtered {
+			if _, ok := it.resolve(); ok {
+				kept = append(kept, it)
+			}
+		}
+		filtered = kept
+	}
+	sortListItems(filtered, q)
+	page.Total = len(filtered)
+
+	start, err := cursorStart(filtered, q.Cursor)
+	if err != nil {
+		return listPage{}, err
+	}
+	end := len(filtered)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+	for _, it := range filtered[start:end] {
+		if e, ok := it.resolve(); ok {
+			page.Entries = append(page.Entries, e)
+		}
+	}
+	if end < len(filtered) {
+		page.NextCursor = encodeListCursor(end, filtered[end-1].name)
+	}
+	return page, nil
+}
+
+func sortListItems(items []*listItem, q listQuery) {
+	less := func(x, y *listItem) bool {
+		switch q.Sort {
+		case "size":
+			xs, ys := x.entry.Size, y.entry.Size
+			if xs != ys {
+				return xs < ys
+			}
+		case "mtime":
+			xt, yt := x.entry.ModTime, y.entry.ModTime
+			if !xt.Equal(yt) {
+				return xt.Before(yt)
+			}
+		case "type":
+			xe, ye := strings.ToLower(path.Ext(x.name)), strings.ToLower(path.Ext(y.name))
+			if xe != ye {
+				return naturalLess(xe, ye)
+			}
+		}
+		return naturalLess(x.name, y.name)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		x, y := items[i], items[j]
+		// Folders always come first, whatever the order.
+		if x.isDir != y.isDir {
+			return x.isDir
+		}
+		if q.Desc {
+			return less(y, x)
+		}
+		return less(x, y)
+	})
+}
+
+// A cursor records the offset of the next page and the name of the last
+// entry returned. If entries were added or removed since, the name is used to
+// find the right place again.
+func encodeListCursor(offset int, lastName string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + "/" + lastName))
+}
+
+func cursorStart(items []*listItem, cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidListQuery
+	}
+	offsetText, lastName, ok := strings.Cut(string(raw), "/")
+	offset, err := strconv.Atoi(offsetText)
+	if !ok || err != nil || offset < 0 {
+		return 0, errInvalidListQuery
+	}
+	if offset > 0 && offset <= len(items) && items[offset-1].name == lastName {
+		return offset, nil
+	}
+	for i, it := range items {
+		if it.name == lastName {
+			return i + 1, nil
+		}
+	}
+	if offset > len(items) {
+		return len(items), nil
+	}
+	return offset, nil
+}
+
+// naturalLess compares names case-insensitively, treating runs of digits as
+// numbers so "frame2" sorts before "frame10".
+func naturalLess(a, b string) bool {
+	ar, br := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		ca, cb := ar[i], br[j]
+		if unicode.IsDigit(ca) && unicode.IsDigit(cb) {
+			si := i
+			for i < len(ar) && unicode.IsDigit(ar[i]) {
+				i++
+			}
+			sj := j
+			for j < len(br) && unicode.IsDigit(br[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ar[si:i]), "0")
+			nb := strings.TrimLeft(string(br[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		la, lb := unicode.ToLower(ca), unicode.ToLower(cb)
+		if la != lb {
+			return la < lb
+		}
+		i++
+		j++
+	}
+	if len(ar)-i != len(br)-j {
+		return len(ar)-i < len(br)-j
+	}
+	return a < b
+}
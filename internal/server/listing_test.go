@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+)
+
+func entryNames(entries []fileEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestNaturalLess(t *testing.T) {
+	if !naturalLess("frame2.png", "frame10.png") {
+		t.Fatal("expected frame2 before frame10")
+	}
+	if !naturalLess("alpha", "Beta") || naturalLess("Beta", "alpha") {
+		t.Fatal("expected case-insensitive ordering")
+	}
+	if !naturalLess("v007", "v8") {
+		t.Fatal("expected leading zeros to be ignored")
+	}
+}
+
+func TestPaginateEntries(t *testing.T) {
+	entries := []fileEntry{
+		{Name: "file10.txt", Size: 5},
+		{Name: "file2.txt", Size: 50},
+		{Name: ".env", Size: 1},
+		{Name: "docs", IsDir: true},
+		{Name: "image.png", Size: 500},
+	}
+	q, err := parseListQuery(url.Values{"limit": {"2"}, "hidden": {"0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := paginateEntries(entries, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryNames(first.Entries); len(got) != 2 || got[0] != "docs" || got[1] != "file2.txt" {
+		t.Fatalf("unexpected first page %v", got)
+	}
+	if first.Total != 4 || first.HiddenCount != 1 || first.NextCursor == "" {
+		t.Fatalf("unexpected page metadata %+v", first)
+	}
+	q.Cursor = first.NextCursor
+	second, err := paginateEntries(entries, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryNames(second.Entries); len(got) != 2 || got[0] != "file10.txt" || got[1] != "image.png" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page %v (cursor %q)", got, second.NextCursor)
+	}
+
+	q, _ = parseListQuery(url.Values{"sort": {"size"}, "q": {"*.txt"}})
+	page, _ := paginateEntries(entries, q)
+	if got := entryNames(page.Entries); len(got) != 2 || got[0] != "file2.txt" || got[1] != "file10.txt" {
+		t.Fatalf("unexpected size-sorted glob result %v", got)
+	}
+	if _, err := parseListQuery(url.Values{"sort": {"colour"}}); err == nil {
+		t.Fatal("expected unknown sort key to be rejected")
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+const (
+	// orphanPartAge is how long a .part file may sit untouched before it
+	// counts as left behind by a crashed upload.
+	orphanPartAge = 24 * time.Hour
+	// loginAttemptMaxAge is how long failed-login counters are kept; the
+	// longest lockout is far shorter.
+	loginAttemptMaxAge = 24 * time.Hour
+	// expiredLinkGrace keeps expired share links and invitations listed for
+	// a while so admins can still see them.
+	expiredLinkGrace = 7 * 24 * time.Hour
+	// maintenanceRecheck is how often a switched-off maintenance loop looks
+	// at the settings again.
+	maintenanceRecheck = time.Hour
+)
+
+// partFileRe matches the temporary names writePartFile writes to.
+var partFileRe = regexp.MustCompile(`\.[A-Za-z0-9_-]{8}\.part$`)
+
+// MaintenanceReport counts what one maintenance pass cleaned up.
+type MaintenanceReport struct {
+	Sessions      int
+	ShareLinks    int
+	Invitations   int
+	LoginAttempts int
+	AuditLogs     int
+	Versions      int
+	PartFiles     int
+	Vacuumed      bool
+}
+
+// durationSetting parses a duration setting; empty, invalid or non-positive
+// values mean off.
+func durationSetting(v string) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// maintenanceLoop runs a maintenance pass at start-up and then every
+// maintenance_interval, vacuuming the database every vacuum_interval.
+// Settings are re-read before each pass.
+func (a *App) maintenanceLoop(ctx context.Context) {
+	lastVacuum := time.Now()
+	for {
+		settings := a.effectiveSettings()
+		vacuumEvery := durationSetting(settings.VacuumInterval)
+		vacuum := vacuumEvery > 0 && time.Since(lastVacuum) >= vacuumEvery
+		a.maintain(ctx, settings, vacuum)
+		if vacuum {
+			lastVacuum = time.Now()
+		}
+
+		wait := durationSetting(settings.MaintenanceInterval)
+		for wait == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(maintenanceRecheck):
+			}
+			wait = durationSetting(a.effectiveSettings().MaintenanceInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// maintain runs one maintenance pass and logs what it did. A failing step is
+// logged and doesn't stop the others.
+func (a *App) maintain(ctx context.Context, settings db.AppSettings, vacuum bool) MaintenanceReport {
+	var rep MaintenanceReport
+	now := time.Now()
+	step := func(name string, n *int, fn func() (int, error)) {
+		if ctx.Err() != nil {
+			return
+		}
+		v, err := fn()
+		if err != nil {
+			a.logger.Warn("maintenance step failed", "step", name, "error", err)
+			return
+		}
+		*n = v
+	}
+	step("sessions", &rep.Sessions, a.store.PurgeExpiredSessions)
+	step("share_links", &rep.ShareLinks, func() (int, error) {
+		return a.store.PurgeExpiredShareLinks(now.Add(-expiredLinkGrace))
+	})
+	step("invitations", &rep.Invitations, func() (int, error) {
+		return a.store.PurgeExpiredInvitations(now.Add(-expiredLinkGrace))
+	})
+	step("login_attempts", &rep.LoginAttempts, func() (int, error) {
+		return a.store.PurgeLoginAttempts(now.Add(-loginAttemptMaxAge))
+	})
+	if keep := durationSetting(settings.AuditRetention); keep > 0 {
+		step("audit_logs", &rep.AuditLogs, func() (int, error) {
+			return a.store.PurgeAuditLogs(now.Add(-keep))
+		})
+	}
+	step("versions", &rep.Versions, func() (int, error) {
+		return a.pruneAllVersions(settings), nil
+	})
+	if a.rootAbs != "" {
+		step("part_files", &rep.PartFiles, func() (int, error) {
+			return a.sweepPartFiles(ctx, now.Add(-orphanPartAge))
+		})
+	}
+	if vacuum && ctx.Err() == nil {
+		if err := a.store.Vacuum(); err != nil {
+			a.logger.Warn("maintenance step failed", "step", "vacuum", "error", err)
+		} else {
+			rep.Vacuumed = true
+		}
+	} else if err := a.store.Checkpoint(); err != nil {
+		a.logger.Warn("maintenance step failed", "step", "checkpoint", "error", err)
+	}
+	a.logger.Info("maintenance done",
+		"sessions", rep.Sessions,
+		"share_links", rep.ShareLinks,
+		"invitations", rep.Invitations,
+		"login_attempts", rep.LoginAttempts,
+		"audit_logs", rep.AuditLogs,
+		"versions", rep.Versions,
+		"part_files", rep.PartFiles,
+		"vacuumed", rep.Vacuumed,
+		"took", time.Since(now).Round(time.Millisecond).String())
+	return rep
+}
+
+// sweepPartFiles removes temporary upload files under the share root that
+// haven't been written to since cutoff.
+func (a *App) sweepPartFiles(ctx context.Context, cutoff time.Time) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(a.rootAbs, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			// Unreadable folders are skipped, not fatal.
+			return nil
+		}
+		if !d.Type().IsRegular() || !partFileRe.MatchString(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.logger.Warn("remove orphaned part file failed", "path", p, "error", err)
+			return nil
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// Maintain runs one maintenance pass outside a running server, such as from
+// cron. Leftover .part files are only swept when opts.RootDir is set.
+func Maintain(ctx context.Context, opts Options, vacuum bool) (MaintenanceReport, error) {
+	store, err := db.Open(opts.DataDir)
+	if err != nil {
+		return MaintenanceReport{}, err
+	}
+	defer store.Close()
+	a := &App{
+		opts:   opts,
+		store:  store,
+		logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: parseLogLevel(opts.LogLevel)})),
+	}
+	if opts.RootDir != "" {
+		if a.rootAbs, err = filepath.Abs(opts.RootDir); err != nil {
+			return MaintenanceReport{}, err
+		}
+	}
+	rep := a.maintain(ctx, a.effectiveSettings(), vacuum)
+	return rep, ctx.Err()
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestMaintainPurgesExpiredData(t *testing.T) {
+	root := t.TempDir()
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{rootAbs: root, store: store, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	now := time.Now()
+	for _, s := range []db.Session{
+		{Token: "old", CSRFToken: "c", ExpiresAt: now.Add(-time.Minute)},
+		{Token: "live", CSRFToken: "c", ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := store.CreateSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []db.ShareLink{
+		{Token: "gone", Mode: "browse", ExpiresAt: now.Add(-expiredLinkGrace - time.Hour)},
+		{Token: "recent", Mode: "browse", ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := store.CreateShareLink(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.RegisterFailedLogin("stale"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DB().Exec(`UPDATE login_attempts SET updated_at = datetime('now', '-2 days')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordAudit(nil, "old", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DB().Exec(`UPDATE audit_logs SET created_at = datetime('now', '-40 days')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordAudit(nil, "new", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := filepath.Join(root, "a.txt.AbCd_-12.part")
+	fresh := filepath.Join(root, "b.txt.XyZw0987.part")
+	other := filepath.Join(root, "notes.part")
+	for _, p := range []string{stale, fresh, other} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := now.Add(-orphanPartAge - time.Hour)
+	for _, p := range []string{stale, other} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep := a.maintain(context.Background(), db.AppSettings{AuditRetention: "720h"}, true)
+	want := MaintenanceReport{Sessions: 1, ShareLinks: 1, LoginAttempts: 1, AuditLogs: 1, PartFiles: 1, Vacuumed: true}
+	if rep != want {
+		t.Fatalf("report = %+v, want %+v", rep, want)
+	}
+	if _, err := store.GetSession("live"); err != nil {
+		t.Fatalf("live session purged: %v", err)
+	}
+	if _, err := store.GetShareLink("recent"); err != nil {
+		t.Fatalf("recently expired link purged: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("stale part file kept")
+	}
+	for _, p := range []string{fresh, other} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("%s removed", filepath.Base(p))
+		}
+	}
+}
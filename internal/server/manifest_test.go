@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/dirsync"
+)
+
+func newSyncTestApp(t *testing.T) (*App, *httptest.Server) {
+	t.Helper()
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	a := &App{store: store, rootAbs: t.TempDir(), opts: Options{BasePath: "/", AuthMode: config.AuthOff}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/me", a.handleMe)
+	mux.HandleFunc("/api/manifest", a.handleManifest)
+	mux.HandleFunc("/api/download", a.handleDownload)
+	mux.HandleFunc("/api/upload", a.handleUpload)
+	mux.HandleFunc("/api/mkdir", a.handleMkdir)
+	mux.HandleFunc("/api/delete", a.handleDelete)
+	srv := httptest.NewServer(a.sessionMiddleware(mux))
+	t.Cleanup(srv.Close)
+	return a, srv
+}
+
+func writeTree(t *testing.T, root string, files map[string]string, dirs ...string) {
+	t.Helper()
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	a, srv := newSyncTestApp(t)
+	writeTree(t, a.rootAbs, map[string]string{"docs/a.txt": "hello", "docs/sub/b.txt": "x"}, "docs/empty")
+
+	get := func(query string) (int, map[string]json.RawMessage) {
+		res, err := http.Get(srv.URL + "/api/manifest?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var out map[string]json.RawMessage
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+
+	code, out := get("path=docs&hash=1")
+	if code != http.StatusOK {
+		t.Fatalf("manifest: %d", code)
+	}
+	var entries []dirsync.Entry
+	_ = json.Unmarshal(out["entries"], &entries)
+	byPath := map[string]dirsync.Entry{}
+	for _, e := range entries {
+		byPath[e.Path] = e
+	}
+	if len(entries) != 4 || !byPath["empty"].IsDir || !byPath["sub"].IsDir {
+		t.Fatalf("entries = %+v", entries)
+	}
+	// sha256("hello")
+	if got := byPath["a.txt"].SHA256; got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("a.txt hash = %q", got)
+	}
+
+	if code, out := get("path=missing"); code != http.StatusOK || string(out["exists"]) != "false" {
+		t.Fatalf("missing directory: %d %s", code, out["exists"])
+	}
+	if code, _ := get("path=docs/a.txt"); code != http.StatusBadRequest {
+		t.Fatalf("manifest of a file: %d", code)
+	}
+
+	// A file that can't be read fails the manifest rather than losing its
+	// hash.
+	if os.Geteuid() != 0 {
+		if err := os.Chmod(filepath.Join(a.rootAbs, "docs", "sub", "b.txt"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if code, _ := get("path=docs&hash=1"); code != http.StatusInternalServerError {
+			t.Fatalf("unreadable file: %d", code)
+		}
+	}
+	if _, err := buildManifest(filepath.Join(a.rootAbs, "nope"), true, nil); err == nil {
+		t.Fatal("manifest of a missing root succeeded")
+	}
+}
+
+func TestSyncPushPull(t *testing.T) {
+	a, srv := newSyncTestApp(t)
+	settings, _ := a.store.GetAppSettings()
+	settings.CollisionPolicy = config.CollisionOverwrite
+	if err := a.store.SetAppSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	c, err := dirsync.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Session(); err != nil {
+		t.Fatal(err)
+	}
+
+	local := t.TempDir()
+	writeTree(t, local, map[string]string{"a.txt": "one", "nested/b.txt": "two"}, "empty/inner")
+	writeTree(t, a.rootAbs, map[string]string{"backup/stale.txt": "old"})
+	sum, err := dirsync.Run(c, dirsync.Options{LocalDir: local, RemotePath: "backup", Direction: dirsync.DirectionPush, Delete: true})
+	if err != nil || len(sum.Errors) > 0 {
+		t.Fatalf("push: %+v, %v", sum, err)
+	}
+	if sum.Copied != 2 || sum.Created != 3 || sum.Deleted != 1 {
+		t.Fatalf("push summary = %+v", sum)
+	}
+	if data, _ := os.ReadFile(filepath.Join(a.rootAbs, "backup", "nested", "b.txt")); string(data) != "two" {
+		t.Fatalf("pushed nested/b.txt = %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(a.rootAbs, "backup", "empty", "inner")); err != nil || !info.IsDir() {
+		t.Fatalf("empty directory not pushed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(a.rootAbs, "backup", "stale.txt")); !os.IsNotExist(err) {
+		t.Fatalf("stale file not pruned: %v", err)
+	}
+
+	// Pushing again has nothing to do.
+	sum, err = dirsync.Run(c, dirsync.Options{LocalDir: local, RemotePath: "backup", Direction: dirsync.DirectionPush})
+	if err != nil || sum.Copied+sum.Updated+sum.Created+sum.Deleted != 0 {
+		t.Fatalf("second push: %+v, %v", sum, err)
+	}
+
+	pulled := filepath.Join(t.TempDir(), "copy")
+	sum, err = dirsync.Run(c, dirsync.Options{LocalDir: pulled, RemotePath: "backup", Direction: dirsync.DirectionPull})
+	if err != nil || len(sum.Errors) > 0 {
+		t.Fatalf("pull: %+v, %v", sum, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(pulled, "a.txt")); string(data) != "one" {
+		t.Fatalf("pulled a.txt = %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(pulled, "empty", "inner")); err != nil || !info.IsDir() {
+		t.Fatalf("empty directory not pulled: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// Network areas each have their own allow and deny list.
+const (
+	netGuestBrowse = "guest_browse"
+	netGuestUpload = "guest_upload"
+	netLogin       = "login"
+	netShare       = "share"
+	netAdmin       = "admin"
+)
+
+// networkLists returns the allow and deny list of area.
+func networkLists(settings db.AppSettings, area string) (allow, deny string) {
+	switch area {
+	case netGuestBrowse:
+		return settings.NetGuestBrowseAllow, settings.NetGuestBrowseDeny
+	case netGuestUpload:
+		return settings.NetGuestUploadAllow, settings.NetGuestUploadDeny
+	case netLogin:
+		return settings.NetLoginAllow, settings.NetLoginDeny
+	case netShare:
+		return settings.NetShareAllow, settings.NetShareDeny
+	case netAdmin:
+		return settings.NetAdminAllow, settings.NetAdminDeny
+	}
+	return "", ""
+}
+
+// parseTrustedProxies parses the configured trusted proxies. None are
+// trusted by default, not even on this machine: any local process could
+// otherwise pose as any client.
+func parseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	return util.ParseIPList(strings.Join(list, ","))
+}
+
+// fromTrustedProxy reports whether the request came straight from one of the
+// trusted proxies, whose X-Forwarded-* headers may then be believed.
+func (a *App) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	return err == nil && util.IPListContains(a.trustedProxies, peer.Unmap())
+}
+
+// clientIP derives the address a request came from. X-Forwarded-For is only
+// believed as far as it was written by trusted proxies: the client is the
+// last hop that isn't one, since anything before it may be made up.
+func (a *App) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	client := peer.Unmap()
+	if !util.IPListContains(a.trustedProxies, client) {
+		return client.String()
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap()
+		if !util.IPListContains(a.trustedProxies, client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// networkAllows reports whether the request's client may use area. A list
+// that doesn't parse, which validation should have prevented, allows nobody.
+func (a *App) networkAllows(r *http.Request, settings db.AppSettings, area string) bool {
+	allowList, denyList := networkLists(settings, area)
+	if strings.TrimSpace(allowList) == "" && strings.TrimSpace(denyList) == "" {
+		return true
+	}
+	ip, err := netip.ParseAddr(remoteIP(r))
+	if err != nil {
+		return false
+	}
+	deny, err := util.ParseIPList(denyList)
+	if err != nil {
+		a.logger.Warn("invalid network list", "area", area, "error", err)
+		return false
+	}
+	if util.IPListContains(deny, ip) {
+		return false
+	}
+	allow, err := util.ParseIPList(allowList)
+	if err != nil {
+		a.logger.Warn("invalid network list", "area", area, "error", err)
+		return false
+	}
+	return len(allow) == 0 || util.IPListContains(allow, ip)
+}
+
+// networkArea returns the network area a request path belongs to, or "" for
+// paths only the guest lists, through permissionsFor, restrict.
+func (a *App) networkArea(p string) string {
+	switch {
+	case p == a.route("/admin") || strings.HasPrefix(p, a.route("/api/admin/")):
+		return netAdmin
+	case p == a.route("/login") || p == a.route("/register") || p == a.route("/api/register") ||
+		strings.HasPrefix(p, a.route("/api/passkeys/login/")):
+		return netLogin
+	case strings.HasPrefix(p, a.route("/s/")):
+		return netShare
+	}
+	return ""
+}
+
+// networkGate records the request's client address for remoteIP and turns
+// away clients the network lists don't allow into the area the request is
+// for, before a session is made for them.
+func (a *App) networkGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), ctxClientIPKey, a.clientIP(r)))
+		if area := a.networkArea(r.URL.Path); area != "" && !a.networkAllows(r, a.cachedSettings(), area) {
+			a.writeError(w, http.StatusForbidden, "not available from your network")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validNetworkLists checks every network list of settings, returning the
+// setting name of the first invalid one.
+func validNetworkLists(settings db.AppSettings) (string, error) {
+	for _, area := range []string{netGuestBrowse, netGuestUpload, netLogin, netShare, netAdmin} {
+		allow, deny := networkLists(settings, area)
+		if _, err := util.ParseIPList(allow); err != nil {
+			return "net_" + area + "_allow", err
+		}
+		if _, err := util.ParseIPList(deny); err != nil {
+			return "net_" + area + "_deny", err
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{trustedProxies: trusted}
+	for _, c := range []struct {
+		peer, forwarded, want string
+	}{
+		{"192.0.2.7:4000", "", "192.0.2.7"},
+		// Only a trusted proxy may say who the client is.
+		{"192.0.2.7:4000", "10.9.9.9", "192.0.2.7"},
+		{"10.0.0.2:4000", "198.51.100.4", "198.51.100.4"},
+		// A client can prepend anything; the hop the proxy added counts.
+		{"10.0.0.2:4000", "203.0.113.66, 198.51.100.4", "198.51.100.4"},
+		{"10.0.0.2:4000", "198.51.100.4, 10.0.0.3", "198.51.100.4"},
+		{"10.0.0.2:4000", "garbage", "10.0.0.2"},
+		{"[::ffff:10.0.0.2]:4000", "2001:db8::5", "2001:db8::5"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.peer
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := a.clientIP(r); got != c.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", c.peer, c.forwarded, got, c.want)
+		}
+	}
+}
+
+func TestClientIPTrustsNoProxiesByDefault(t *testing.T) {
+	trusted, err := parseTrustedProxies(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{trustedProxies: trusted}
+	for _, peer := range []string{"127.0.0.1:4000", "[::1]:4000"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = peer
+		r.Header.Set("X-Forwarded-For", "198.51.100.4")
+		if got := a.clientIP(r); got == "198.51.100.4" {
+			t.Errorf("X-Forwarded-For from %s believed without trusted_proxies", peer)
+		}
+	}
+}
+
+func TestNetworkLists(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/", AuthMode: config.AuthOn}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	settings, _ := store.GetAppSettings()
+	settings.GuestMode = config.GuestUpload
+	settings.NetAdminAllow = "192.168.1.0/24"
+	settings.NetShareDeny = "192.168.1.66"
+	settings.NetGuestUploadAllow = "192.168.1.0/24"
+	settings.NetGuestBrowseDeny = "203.0.113.0/24"
+	if err := store.SetAppSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	var perms Permissions
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		perms = a.permissionsFor(r, a.effectiveSettings())
+	})
+	handler := a.networkGate(a.sessionMiddleware(mux))
+	get := func(ip, path string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	for _, c := range []struct {
+		ip, path string
+		code     int
+	}{
+		{"192.168.1.10", "/api/admin/settings", http.StatusOK},
+		{"192.168.2.10", "/api/admin/settings", http.StatusForbidden},
+		{"192.168.2.10", "/admin", http.StatusForbidden},
+		{"192.168.2.10", "/s/token", http.StatusOK},
+		{"192.168.1.66", "/s/token", http.StatusForbidden},
+		{"192.168.1.66", "/login", http.StatusOK},
+	} {
+		if got := get(c.ip, c.path); got != c.code {
+			t.Errorf("%s %s: %d, want %d", c.ip, c.path, got, c.code)
+		}
+	}
+
+	for _, c := range []struct {
+		ip             string
+		browse, upload bool
+	}{
+		{"192.168.1.10", true, true},
+		{"198.51.100.1", true, false},
+		{"203.0.113.9", false, false},
+	} {
+		get(c.ip, "/")
+		if perms.CanBrowse != c.browse || perms.CanUpload != c.upload {
+			t.Errorf("guest from %s: %+v", c.ip, perms)
+		}
+	}
+}
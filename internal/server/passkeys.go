@@ -0,0 +1,510 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+const (
+	ceremonyRegister     = "register"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "second_factor"
+
+	ceremonyTTL = 5 * time.Minute
+)
+
+// ceremony is a WebAuthn ceremony in progress. A second-factor ceremony
+// starts without data, when the password has been checked, and remembers
+// who is signing in.
+type ceremony struct {
+	data     *webauthn.SessionData
+	userID   int64
+	remember bool
+	expires  time.Time
+}
+
+// ceremonyStore holds ceremonies in progress by kind and session token. The
+// zero value is ready to use.
+type ceremonyStore struct {
+	mu sync.Mutex
+	m  map[string]ceremony
+}
+
+func (c *ceremonyStore) put(kind, token string, v ceremony) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]ceremony{}
+	}
+	now := time.Now()
+	for k, old := range c.m {
+		if now.After(old.expires) {
+			delete(c.m, k)
+		}
+	}
+	v.expires = now.Add(ceremonyTTL)
+	c.m[kind+"|"+token] = v
+}
+
+func (c *ceremonyStore) get(kind, token string) (ceremony, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[kind+"|"+token]
+	if !ok || time.Now().After(v.expires) {
+		return ceremony{}, false
+	}
+	return v, true
+}
+
+func (c *ceremonyStore) take(kind, token string) (ceremony, bool) {
+	v, ok := c.get(kind, token)
+	c.mu.Lock()
+	delete(c.m, kind+"|"+token)
+	c.mu.Unlock()
+	return v, ok
+}
+
+// passkeyUser presents a user and their passkeys to the WebAuthn library.
+type passkeyUser struct {
+	user  db.User
+	keys  []db.Passkey
+	creds []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return userHandle(u.user.ID) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.creds }
+func (u *passkeyUser) WebAuthnIcon() string                       { return "" }
+
+// passkey returns the stored passkey with the given credential ID.
+func (u *passkeyUser) passkey(credentialID []byte) (db.Passkey, bool) {
+	for _, k := range u.keys {
+		if bytes.Equal(k.CredentialID, credentialID) {
+			return k, true
+		}
+	}
+	return db.Passkey{}, false
+}
+
+// userHandle is the WebAuthn user handle of a user: their ID, so it reveals
+// nothing else about them.
+func userHandle(id int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+func (a *App) loadPasskeyUser(u db.User) (*passkeyUser, error) {
+	keys, err := a.store.ListPasskeys(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	pu := &passkeyUser{user: u, keys: keys}
+	for _, k := range keys {
+		var c webauthn.Credential
+		if err := json.Unmarshal(k.Credential, &c); err != nil {
+			return nil, err
+		}
+		pu.creds = append(pu.creds, c)
+	}
+	return pu, nil
+}
+
+var errPasskeyHost = errors.New("passkeys need the server to be opened by host name, not IP address")
+
+// relyingParty describes this server to authenticators: as the configured
+// passkey origin if there is one, otherwise as the host the request was made
+// to. X-Forwarded-Proto is only believed from trusted proxies. Browsers only
+// offer passkeys to host names in a secure context, i.e. over HTTPS or on
+// localhost.
+func (a *App) relyingParty(r *http.Request) (*webauthn.WebAuthn, error) {
+	if a.opts.PasskeyOrigin != "" {
+		rpID, origin, err := config.ParsePasskeyOrigin(a.opts.PasskeyOrigin)
+		if err != nil {
+			return nil, err
+		}
+		return webauthn.New(&webauthn.Config{RPID: rpID, RPDisplayName: "sharehere", RPOrigins: []string{origin}})
+	}
+	hostname := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		hostname = h
+	}
+	hostname = strings.Trim(hostname, "[]")
+	if net.ParseIP(hostname) != nil {
+		return nil, errPasskeyHost
+	}
+	scheme := "http"
+	if a.opts.HTTPS || r.TLS != nil || (a.fromTrustedProxy(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+		scheme = "https"
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          hostname,
+		RPDisplayName: "sharehere",
+		RPOrigins:     []string{scheme + "://" + r.Host},
+	})
+}
+
+func (a *App) handlePasskeysPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.currentUser(r) == nil {
+		http.Redirect(w, r, a.route("/login"), http.StatusSeeOther)
+		return
+	}
+	data := map[string]any{
+		"BasePath":   a.templateBasePath(),
+		"Version":    a.opts.Version,
+		"Restricted": a.currentSession(r).Restriction == restrictPasskeyEnroll,
+	}
+	if err := a.templates.ExecuteTemplate(w, "passkeys.html", data); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "render failed")
+	}
+}
+
+func (a *App) handlePasskeys(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	keys, err := a.store.ListPasskeys(u.ID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list passkeys")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"passkeys": keys, "policy": a.effectiveSettings().PasskeyPolicy})
+}
+
+func (a *App) handlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	rp, err := a.relyingParty(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pu, err := a.loadPasskeyUser(*u)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to load passkeys")
+		return
+	}
+	exclude := make([]protocol.CredentialDescriptor, 0, len(pu.creds))
+	for _, c := range pu.creds {
+		exclude = append(exclude, c.Descriptor())
+	}
+	options, data, err := rp.BeginRegistration(pu,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation))
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to start registration")
+		return
+	}
+	a.ceremonies.put(ceremonyRegister, a.currentSession(r).Token, ceremony{data: data, userID: u.ID})
+	a.writeJSON(w, http.StatusOK, options)
+}
+
+// handlePasskeyRegisterFinish takes the authenticator's response as the body
+// and the passkey's name in the "name" query parameter.
+func (a *App) handlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	c, ok := a.ceremonies.take(ceremonyRegister, a.currentSession(r).Token)
+	if !ok || c.userID != u.ID {
+		a.writeError(w, http.StatusBadRequest, "no registration in progress")
+		return
+	}
+	rp, err := a.relyingParty(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid passkey response")
+		return
+	}
+	pu, err := a.loadPasskeyUser(*u)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to load passkeys")
+		return
+	}
+	cred, err := rp.CreateCredential(pu, *c.data, parsed)
+	if err != nil {
+		a.logger.Info("passkey registration refused", "user", u.Username, "error", err)
+		a.writeError(w, http.StatusBadRequest, "passkey could not be verified")
+		return
+	}
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to save passkey")
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	id, err := a.store.CreatePasskey(db.Passkey{UserID: u.ID, CredentialID: cred.ID, Name: name, Credential: raw})
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to save passkey")
+		return
+	}
+	_ = a.store.LiftSessionRestriction(u.ID, restrictPasskeyEnroll)
+	_ = a.store.RecordAudit(&u.ID, "passkey.add", u.Username, name)
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "id": id})
+}
+
+func (a *App) handlePasskeyDelete(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if err := a.store.DeletePasskey(req.ID, u.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.writeError(w, http.StatusNotFound, "passkey not found")
+		} else {
+			a.writeError(w, http.StatusInternalServerError, "failed to delete passkey")
+		}
+		return
+	}
+	_ = a.store.RecordAudit(&u.ID, "passkey.remove", u.Username, "")
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handlePasskeyLoginBegin starts a passkey sign-in: for the user whose
+// password was just checked when a second factor is pending, otherwise for
+// whoever's passkey the browser offers.
+func (a *App) handlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	if a.opts.AuthMode == config.AuthOff {
+		a.writeError(w, http.StatusBadRequest, "authentication is off")
+		return
+	}
+	rp, err := a.relyingParty(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	token := a.currentSession(r).Token
+	if pending, ok := a.ceremonies.get(ceremonySecondFactor, token); ok {
+		user, err := a.store.GetUserByID(pending.userID)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "sign in again")
+			return
+		}
+		pu, err := a.loadPasskeyUser(user)
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to load passkeys")
+			return
+		}
+		options, data, err := rp.BeginLogin(pu, webauthn.WithUserVerification(protocol.VerificationPreferred))
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to start sign-in")
+			return
+		}
+		pending.data = data
+		a.ceremonies.put(ceremonySecondFactor, token, pending)
+		a.writeJSON(w, http.StatusOK, options)
+		return
+	}
+	options, data, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to start sign-in")
+		return
+	}
+	remember := r.URL.Query().Get("remember") == "1"
+	a.ceremonies.put(ceremonyLogin, token, ceremony{data: data, remember: remember})
+	a.writeJSON(w, http.StatusOK, options)
+}
+
+func (a *App) handlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	rp, err := a.relyingParty(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key := remoteIP(r) + "|passkey"
+	if locked, retryAfter, err := a.store.CheckLoginAllowed(key); err == nil && locked {
+		a.writeError(w, http.StatusTooManyRequests, "too many attempts, retry in "+retryAfter.Round(time.Second).String())
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid passkey response")
+		return
+	}
+
+	token := a.currentSession(r).Token
+	var pu *passkeyUser
+	var cred *webauthn.Credential
+	c, secondFactor := a.ceremonies.take(ceremonySecondFactor, token)
+	if secondFactor && c.data == nil {
+		// The password was checked but the passkey step never started.
+		secondFactor = false
+	}
+	if secondFactor {
+		var user db.User
+		if user, err = a.store.GetUserByID(c.userID); err == nil {
+			if pu, err = a.loadPasskeyUser(user); err == nil {
+				cred, err = rp.ValidateLogin(pu, *c.data, parsed)
+			}
+		}
+	} else {
+		var ok bool
+		if c, ok = a.ceremonies.take(ceremonyLogin, token); !ok {
+			a.writeError(w, http.StatusBadRequest, "no sign-in in progress")
+			return
+		}
+		cred, err = rp.ValidateDiscoverableLogin(func(rawID, handle []byte) (webauthn.User, error) {
+			k, err := a.store.GetPasskeyByCredentialID(rawID)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(handle, userHandle(k.UserID)) {
+				return nil, errors.New("user handle mismatch")
+			}
+			user, err := a.store.GetUserByID(k.UserID)
+			if err != nil {
+				return nil, err
+			}
+			pu, err = a.loadPasskeyUser(user)
+			return pu, err
+		}, *c.data, parsed)
+	}
+	if err == nil && cred.Authenticator.CloneWarning {
+		err = errors.New("signature counter went backwards; the authenticator may be cloned")
+	}
+	if err != nil || pu == nil || pu.user.Disabled {
+		lock, _ := a.store.RegisterFailedLogin(key)
+		_ = a.store.RecordAudit(nil, "login.failed", "", "passkey")
+		a.logger.Info("passkey sign-in refused", "error", err)
+		msg := "passkey sign-in failed"
+		if lock > 0 {
+			msg += ", locked for " + lock.Round(time.Second).String()
+		}
+		a.writeError(w, http.StatusUnauthorized, msg)
+		return
+	}
+	_ = a.store.ResetLoginAttempts(key)
+	if k, ok := pu.passkey(cred.ID); ok {
+		if raw, err := json.Marshal(cred); err == nil {
+			_ = a.store.UpdatePasskeyUse(k.ID, raw)
+		}
+	}
+	method := "passkey"
+	if secondFactor {
+		method = "password+passkey"
+	}
+	restriction, redirect := "", a.route("/")
+	if pu.user.MustChangePassword {
+		restriction, redirect = restrictPasswordChange, a.restrictionPage(restrictPasswordChange)
+	}
+	if err := a.signIn(w, r, pu.user, c.remember, restriction, method); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "session failure")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "redirect": redirect})
+}
+
+// handleAdminResetPasskeys deletes all passkeys of a user who lost theirs.
+func (a *App) handleAdminResetPasskeys(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	target, err := a.store.GetUserByUsername(req.Username)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	n, err := a.store.DeleteUserPasskeys(target.ID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to reset passkeys")
+		return
+	}
+	if u := a.currentUser(r); u != nil {
+		_ = a.store.RecordAudit(&u.ID, "admin.passkey.reset", target.Username, "")
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "removed": n})
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+// softAuthenticator plays the part of a security key for origin
+// http://example.com, the host httptest requests are made to.
+type softAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	handle  []byte
+	counter uint32
+}
+
+const softOrigin = "http://example.com"
+
+var b64 = base64.RawURLEncoding
+
+func (s *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte("example.com"))
+	out := append([]byte{}, rpHash[:]...)
+	out = append(out, flags)
+	out = binary.BigEndian.AppendUint32(out, s.counter)
+	return append(out, attested...)
+}
+
+func clientData(t *testing.T, typ string, options []byte) []byte {
+	var opts struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &opts); err != nil || opts.PublicKey.Challenge == "" {
+		t.Fatalf("options %s: %v", options, err)
+	}
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": opts.PublicKey.Challenge, "origin": softOrigin})
+	return data
+}
+
+func (s *softAuthenticator) create(t *testing.T, options []byte) []byte {
+	cose, err := cbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: s.key.X.FillBytes(make([]byte, 32)), -3: s.key.Y.FillBytes(make([]byte, 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(s.id)))
+	attested = append(append(attested, s.id...), cose...)
+	att, err := cbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": s.authData(0x45, attested)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id": b64.EncodeToString(s.id), "rawId": b64.EncodeToString(s.id), "type": "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", options)),
+			"attestationObject": b64.EncodeToString(att),
+		},
+	})
+	return body
+}
+
+func (s *softAuthenticator) get(t *testing.T, options []byte) []byte {
+	s.counter++
+	auth := s.authData(0x05, nil)
+	cd := clientData(t, "webauthn.get", options)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, auth...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id": b64.EncodeToString(s.id), "rawId": b64.EncodeToString(s.id), "type": "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(cd),
+			"authenticatorData": b64.EncodeToString(auth),
+			"signature":         b64.EncodeToString(sig),
+			"userHandle":        b64.EncodeToString(s.handle),
+		},
+	})
+	return body
+}
+
+func TestPasskeyEnrolAndSignIn(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/"}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	alice, err := store.CreateUser("alice", "x", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for _, s := range []db.Session{
+		{Token: "enrol", UserID: &alice, CSRFToken: "c", ExpiresAt: expires, Restriction: restrictPasskeyEnroll},
+		{Token: "anon", CSRFToken: "c", ExpiresAt: expires},
+	} {
+		if err := store.CreateSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/list", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/passkeys/register/begin", a.handlePasskeyRegisterBegin)
+	mux.HandleFunc("/api/passkeys/register/finish", a.handlePasskeyRegisterFinish)
+	mux.HandleFunc("/api/passkeys/login/begin", a.handlePasskeyLoginBegin)
+	mux.HandleFunc("/api/passkeys/login/finish", a.handlePasskeyLoginFinish)
+	handler := a.sessionMiddleware(mux)
+	do := func(token, method, path string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		r.Header.Set("X-CSRF-Token", "c")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := do("enrol", "GET", "/api/list", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("restricted session listing files: %d", rec.Code)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sa := &softAuthenticator{key: key, id: []byte("credential-1"), handle: userHandle(alice)}
+	rec := do("enrol", "POST", "/api/passkeys/register/begin", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register begin: %d %s", rec.Code, rec.Body)
+	}
+	rec = do("enrol", "POST", "/api/passkeys/register/finish?name=laptop", sa.create(t, rec.Body.Bytes()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register finish: %d %s", rec.Code, rec.Body)
+	}
+	if keys, err := store.ListPasskeys(alice); err != nil || len(keys) != 1 || keys[0].Name != "laptop" {
+		t.Fatalf("passkeys = %+v, %v", keys, err)
+	}
+	if rec := do("enrol", "GET", "/api/list", nil); rec.Code != http.StatusOK {
+		t.Fatalf("restriction not lifted after enrolling: %d", rec.Code)
+	}
+
+	rec = do("anon", "POST", "/api/passkeys/login/begin", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login begin: %d %s", rec.Code, rec.Body)
+	}
+	rec = do("anon", "POST", "/api/passkeys/login/finish", sa.get(t, rec.Body.Bytes()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login finish: %d %s", rec.Code, rec.Body)
+	}
+	var signedIn *db.Session
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName && c.Value != "anon" {
+			s, err := store.GetSession(c.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signedIn = &s
+		}
+	}
+	if signedIn == nil || signedIn.UserID == nil || *signedIn.UserID != alice {
+		t.Fatalf("passkey sign-in did not sign in alice: %+v", signedIn)
+	}
+	if keys, _ := store.ListPasskeys(alice); keys[0].LastUsedAt == nil {
+		t.Fatal("last use not recorded")
+	}
+
+	// Each ceremony is good for one sign-in.
+	rec = do("anon", "POST", "/api/passkeys/login/finish", sa.get(t, []byte(`{"publicKey":{"challenge":"AAAA"}}`)))
+	if rec.Code == http.StatusOK {
+		t.Fatal("sign-in without a ceremony succeeded")
+	}
+}
+
+func TestRelyingPartyRejectsIPHosts(t *testing.T) {
+	a := &App{}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "192.168.1.10:8080"
+	if _, err := a.relyingParty(r); err != errPasskeyHost {
+		t.Fatalf("IP host: %v", err)
+	}
+	r.Host = "files.lan:8080"
+	if _, err := a.relyingParty(r); err != nil {
+		t.Fatalf("host name: %v", err)
+	}
+}
+
+func TestRelyingPartyOrigin(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{trustedProxies: trusted}
+	origin := func(peer, host string) string {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = peer + ":5000"
+		r.Host = host
+		r.Header.Set("X-Forwarded-Proto", "https")
+		rp, err := a.relyingParty(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp.Config.RPID + " " + strings.Join(rp.Config.RPOrigins, ",")
+	}
+	// Only a trusted proxy may say the request came over HTTPS.
+	if got := origin("192.0.2.7", "files.lan:8080"); got != "files.lan http://files.lan:8080" {
+		t.Fatalf("untrusted peer: %s", got)
+	}
+	if got := origin("10.0.0.1", "files.lan"); got != "files.lan https://files.lan" {
+		t.Fatalf("trusted proxy: %s", got)
+	}
+	// A configured origin wins over whatever the request says.
+	a.opts.PasskeyOrigin = "https://files.example.com"
+	if got := origin("192.0.2.7", "evil.example"); got != "files.example.com https://files.example.com" {
+		t.Fatalf("configured origin: %s", got)
+	}
+}
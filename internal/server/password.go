@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func (a *App) handlePasswordPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.currentUser(r) == nil {
+		http.Redirect(w, r, a.route("/login"), http.StatusSeeOther)
+		return
+	}
+	data := map[string]any{
+		"BasePath":   a.templateBasePath(),
+		"Version":    a.opts.Version,
+		"Restricted": a.currentSession(r).Restriction == restrictPasswordChange,
+	}
+	if err := a.templates.ExecuteTemplate(w, "password.html", data); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "render failed")
+	}
+}
+
+// handleChangePassword lets a signed-in user change their own password,
+// which also lifts a temporary password's restriction. The current password
+// is checked like a sign-in, lockout included, so a stolen session can't be
+// used to guess it.
+func (a *App) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if u.Source != db.UserSourceLocal {
+		a.writeError(w, http.StatusBadRequest, "directory users change their password in the directory")
+		return
+	}
+	var req struct {
+		Current  string `json:"current"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	key := fmt.Sprintf("%s|%s", remoteIP(r), u.Username)
+	if locked, retryAfter, err := a.store.CheckLoginAllowed(key); err == nil && locked {
+		a.writeError(w, http.StatusTooManyRequests, fmt.Sprintf("too many attempts, retry in %s", retryAfter.Round(time.Second)))
+		return
+	}
+	if ok, err := auth.VerifyPassword(u.PasswordHash, req.Current); err != nil || !ok {
+		_, _ = a.store.RegisterFailedLogin(key)
+		_ = a.store.RecordAudit(&u.ID, "password.change.failed", u.Username, "")
+		a.writeError(w, http.StatusForbidden, "current password is wrong")
+		return
+	}
+	_ = a.store.ResetLoginAttempts(key)
+	if req.Password == req.Current {
+		a.writeError(w, http.StatusBadRequest, "choose a password different from the current one")
+		return
+	}
+	settings := a.effectiveSettings()
+	if err := settings.PasswordPolicy().Check(u.Username, req.Password); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The temporary password's restriction gives way to the passkey
+	// policy's, if it has one for this user.
+	restriction := ""
+	if settings.PasskeyPolicy == config.PasskeyRequired {
+		if n, err := a.store.CountPasskeys(u.ID); err == nil && n == 0 {
+			restriction = restrictPasskeyEnroll
+		}
+	}
+	if err := a.store.ChangeOwnPassword(u.ID, hash, a.currentSession(r).Token, restriction); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+	_ = a.store.RecordAudit(&u.ID, "password.change", u.Username, "")
+	redirect := a.route("/")
+	if restriction != "" {
+		redirect = a.restrictionPage(restriction)
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "redirect": redirect})
+}
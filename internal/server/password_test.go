@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestTemporaryPasswordMustBeChanged(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/"}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if _, err := store.CreateUser("alice", "x", auth.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	temp, _ := auth.HashPassword("temporary-pw")
+	if err := store.SetTemporaryPassword("alice", temp); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := store.GetUserByUsername("alice")
+	if err != nil || !alice.MustChangePassword {
+		t.Fatalf("alice = %+v, %v", alice, err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for _, s := range []db.Session{
+		{Token: "here", UserID: &alice.ID, CSRFToken: "c", ExpiresAt: expires, Restriction: restrictPasswordChange},
+		{Token: "elsewhere", UserID: &alice.ID, CSRFToken: "c", ExpiresAt: expires},
+	} {
+		if err := store.CreateSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/list", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/password", a.handleChangePassword)
+	handler := a.sessionMiddleware(mux)
+	do := func(token, method, path string, body any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		r := httptest.NewRequest(method, path, bytes.NewReader(raw))
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		r.Header.Set("X-CSRF-Token", "c")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := do("here", "GET", "/api/list", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("restricted session listing files: %d", rec.Code)
+	}
+	for _, c := range []struct {
+		current, next string
+		code          int
+	}{
+		{"wrong-password", "a-much-better-pw", http.StatusForbidden},
+		{"temporary-pw", "temporary-pw", http.StatusBadRequest},
+		{"temporary-pw", "short", http.StatusBadRequest},
+		{"temporary-pw", "my-alice-password", http.StatusBadRequest},
+	} {
+		if rec := do("here", "POST", "/api/password", map[string]string{"current": c.current, "password": c.next}); rec.Code != c.code {
+			t.Errorf("%s -> %s: %d %s, want %d", c.current, c.next, rec.Code, rec.Body, c.code)
+		}
+	}
+	if rec := do("here", "POST", "/api/password", map[string]string{"current": "temporary-pw", "password": "a-much-better-pw"}); rec.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do("here", "GET", "/api/list", nil); rec.Code != http.StatusOK {
+		t.Fatalf("restriction not lifted: %d", rec.Code)
+	}
+	if _, err := store.GetSession("elsewhere"); err == nil {
+		t.Fatal("other session survived the password change")
+	}
+	alice, _ = store.GetUserByUsername("alice")
+	if ok, _ := auth.VerifyPassword(alice.PasswordHash, "a-much-better-pw"); !ok || alice.MustChangePassword {
+		t.Fatalf("password not changed: %+v", alice)
+	}
+}
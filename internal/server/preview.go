@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Preview size limits. Anything larger is cut off and flagged as truncated.
+const (
+	previewTextLimit     = 128 * 1024
+	previewMarkdownLimit = 512 * 1024
+	previewHexLimit      = 4 * 1024
+	previewTablePageSize = 100
+	previewTableMaxCols  = 200
+	previewTableMaxCell  = 1024
+	// previewTableScanLimit bounds how far into a CSV file paging may read.
+	previewTableScanLimit = 64 << 20
+)
+
+// markdownRenderer leaves goldmark's safe defaults in place: raw HTML is
+// dropped and javascript:/data: style link targets are not rendered, so the
+// output can be inserted into the page as-is.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+var codeFormatter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.TabWidth(4))
+
+// inlineSafeType reports whether a content type may be served inline from
+// the share origin. Only types browsers never execute script in qualify, so
+// HTML and SVG files are always downloaded.
+func inlineSafeType(contentType string) bool {
+	ct, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case ct == "application/pdf":
+		return true
+	case strings.HasPrefix(ct, "audio/"), strings.HasPrefix(ct, "video/"):
+		return true
+	case strings.HasPrefix(ct, "image/") && ct != "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// fileContentType prefers the extension's registered type and falls back to
+// sniffing the first bytes.
+func fileContentType(name string, head []byte) string {
+	if ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(head)
+}
+
+func (a *App) inlineURL(rel string) string {
+	return a.route("/api/download") + "?path=" + url.QueryEscape(rel) + "&inline=1"
+}
+
+// buildPreview classifies a regular file and returns the descriptor the UI
+// renders. f is positioned at the start of the file.
+func (a *App) buildPreview(rel, abs string, info os.FileInfo, f *os.File, page int) (map[string]any, error) {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(abs))
+	sniffed := http.DetectContentType(head)
+	ct := fileContentType(abs, head)
+	mediaType, _, _ := mime.ParseMediaType(ct)
+
+	switch {
+	case ext == ".md" || ext == ".markdown":
+		return previewMarkdown(f)
+	case ext == ".csv" || ext == ".tsv":
+		return previewTable(f, ext, page)
+	case mediaType == "application/pdf" || sniffed == "application/pdf":
+		return map[string]any{"type": "pdf", "src": a.inlineURL(rel), "size": info.Size()}, nil
+	case strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"):
+		return map[string]any{"type": strings.SplitN(mediaType, "/", 2)[0], "mime": mediaType, "src": a.inlineURL(rel), "size": info.Size()}, nil
+	case strings.HasPrefix(sniffed, "image/"):
+		return map[string]any{"type": "image", "src": a.inlineURL(rel)}, nil
+	case isTextPreviewType(sniffed, ext):
+		return previewText(f, abs)
+	}
+	return previewHex(f, info.Size())
+}
+
+func readLimited(f io.Reader, limit int) ([]byte, bool) {
+	buf := make([]byte, limit+1)
+	n, _ := io.ReadFull(f, buf)
+	if n > limit {
+		return trimToRune(buf[:limit]), true
+	}
+	return buf[:n], false
+}
+
+// trimToRune drops a trailing partial UTF-8 sequence left by a byte limit.
+func trimToRune(b []byte) []byte {
+	for i := 0; i < utf8.UTFMax && len(b) > 0; i++ {
+		if utf8.Valid(b) {
+			return b
+		}
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func previewMarkdown(f io.Reader) (map[string]any, error) {
+	src, truncated := readLimited(f, previewMarkdownLimit)
+	var out bytes.Buffer
+	if err := markdownRenderer.Convert(src, &out); err != nil {
+		return nil, err
+	}
+	return map[string]any{"type": "markdown", "html": out.String(), "truncated": truncated}, nil
+}
+
+// previewText returns highlighted HTML when the file name or content maps to
+// a known language, and plain text otherwise.
+func previewText(f io.Reader, abs string) (map[string]any, error) {
+	src, truncated := readLimited(f, previewTextLimit)
+	content := string(src)
+	lexer := lexers.Match(filepath.Base(abs))
+	if lexer == nil {
+		lexer = lexers.Analyse(content)
+	}
+	if lexer == nil || lexer.Config().Name == "plaintext" {
+		return map[string]any{"type": "text", "content": content, "truncated": truncated}, nil
+	}
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return map[string]any{"type": "text", "content": content, "truncated": truncated}, nil
+	}
+	var out bytes.Buffer
+	if err := codeFormatter.Format(&out, styles.Fallback, iterator); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"type":      "code",
+		"language":  lexer.Config().Name,
+		"html":      out.String(),
+		"truncated": truncated,
+	}, nil
+}
+
+func previewHex(f io.Reader, size int64) (map[string]any, error) {
+	buf, truncated := readLimited(f, previewHexLimit)
+	return map[string]any{"type": "hex", "content": hex.Dump(buf), "truncated": truncated, "size": size}, nil
+}
+
+// previewTable renders one page of a CSV or TSV file. The first record is
+// used as the header on every page.
+func previewTable(f io.Reader, ext string, page int) (map[string]any, error) {
+	if page < 0 {
+		page = 0
+	}
+	cr := csv.NewReader(bufio.NewReader(io.LimitReader(f, previewTableScanLimit)))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	if ext == ".tsv" {
+		cr.Comma = '\t'
+	}
+	clip := func(record []string) []string {
+		if len(record) > previewTableMaxCols {
+			record = record[:previewTableMaxCols]
+		}
+		for i, cell := range record {
+			if len(cell) > previewTableMaxCell {
+				record[i] = string(trimToRune([]byte(cell[:previewTableMaxCell]))) + "…"
+			}
+		}
+		return record
+	}
+	columns, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return map[string]any{"type": "table", "columns": []string{}, "rows": [][]string{}, "page": 0, "pageSize": previewTablePageSize, "hasMore": false}, nil
+	}
+	if err != nil {
+		return previewTableFallback(err)
+	}
+	columns = clip(columns)
+
+	skip := page * previewTablePageSize
+	rows := make([][]string, 0, previewTablePageSize)
+	hasMore := false
+	for index := 0; ; index++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return previewTableFallback(err)
+		}
+		if index < skip {
+			continue
+		}
+		if len(rows) == previewTablePageSize {
+			hasMore = true
+			break
+		}
+		rows = append(rows, clip(record))
+	}
+	return map[string]any{
+		"type":     "table",
+		"columns":  columns,
+		"rows":     rows,
+		"page":     page,
+		"pageSize": previewTablePageSize,
+		"hasMore":  hasMore,
+	}, nil
+}
+
+func previewTableFallback(err error) (map[string]any, error) {
+	return map[string]any{"type": "text", "content": "Could not parse table: " + err.Error(), "truncated": false}, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func previewFor(t *testing.T, name, content string, page int) map[string]any {
+	t.Helper()
+	root := t.TempDir()
+	abs := filepath.Join(root, name)
+	if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	a := &App{rootAbs: root}
+	desc, err := a.buildPreview(name, abs, info, f, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestPreviewMarkdownIsSanitized(t *testing.T) {
+	desc := previewFor(t, "r.md", "# Hi\n\n<script>alert(1)</script>\n\n[x](javascript:alert(1))\n", 0)
+	html, _ := desc["html"].(string)
+	if desc["type"] != "markdown" || !strings.Contains(html, "<h1>Hi</h1>") {
+		t.Fatalf("unexpected markdown preview: %v", desc)
+	}
+	if strings.Contains(html, "<script") || strings.Contains(html, "javascript:") {
+		t.Fatalf("markdown output not sanitized: %s", html)
+	}
+}
+
+func TestPreviewTablePaging(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("id\tname\n")
+	for i := 0; i < 150; i++ {
+		fmt.Fprintf(&b, "%d\tn%d\n", i, i)
+	}
+	desc := previewFor(t, "t.tsv", b.String(), 1)
+	rows, _ := desc["rows"].([][]string)
+	if desc["type"] != "table" || len(rows) != 50 || rows[0][0] != "100" || desc["hasMore"] != false {
+		t.Fatalf("unexpected table page: type=%v rows=%d hasMore=%v", desc["type"], len(rows), desc["hasMore"])
+	}
+}
+
+func TestPreviewKinds(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		want    string
+	}{
+		"main.go":   {"package main\n\nfunc main() {}\n", "code"},
+		"notes.txt": {"just words\n", "text"},
+		"blob.bin":  {"\x00\x01\x02\xff", "hex"},
+		"doc.pdf":   {"%PDF-1.4\n", "pdf"},
+		"song.mp3":  {"ID3", "audio"},
+	}
+	for name, tc := range cases {
+		if got := previewFor(t, name, tc.content, 0)["type"]; got != tc.want {
+			t.Fatalf("%s preview type = %v, want %s", name, got, tc.want)
+		}
+	}
+	if inlineSafeType("image/svg+xml") || inlineSafeType("text/html") || !inlineSafeType("video/mp4") {
+		t.Fatalf("unexpected inline policy")
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+	"github.com/matthewsawatzky/sharehere/internal/virusscan"
+)
+
+const virusScanTimeout = 2 * time.Minute
+
+var errInfected = errors.New("infected")
+
+func (a *App) quarantineDir() string {
+	return filepath.Join(a.opts.DataDir, "quarantine")
+}
+
+// virusScanner returns the scanner configured in settings, or nil when
+// scanning is off. A clamd address takes precedence over a command.
+func virusScanner(settings db.AppSettings) (virusscan.Scanner, error) {
+	if addr := strings.TrimSpace(settings.VirusScanClamd); addr != "" {
+		return virusscan.ParseClamdAddress(addr)
+	}
+	if cmdline := strings.TrimSpace(settings.VirusScanCommand); cmdline != "" {
+		return virusscan.Command{Cmdline: cmdline}, nil
+	}
+	return nil, nil
+}
+
+// scanFile scans abs, an upload destined for rel, and records the outcome in
+// the audit log. Infected files are moved to quarantine and reported as
+// errInfected; a failed scan returns the scanner's error and leaves the file
+// alone.
+func (a *App) scanFile(sc virusscan.Scanner, abs, rel string, uploadedBy *int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), virusScanTimeout)
+	defer cancel()
+	res, err := sc.Scan(ctx, abs)
+	if err != nil {
+		a.logger.Warn("virus scan failed", "file", rel, "error", err)
+		_ = a.store.RecordAudit(uploadedBy, "virus.error", rel, err.Error())
+		return err
+	}
+	if !res.Infected {
+		_ = a.store.RecordAudit(uploadedBy, "virus.clean", rel, "")
+		return nil
+	}
+	a.logger.Warn("infected upload quarantined", "file", rel, "signature", res.Signature)
+	_ = a.store.RecordAudit(uploadedBy, "virus.infected", rel, res.Signature)
+	if err := a.quarantine(abs, rel, res.Signature, uploadedBy); err != nil {
+		a.logger.Error("quarantine failed; removing infected file", "file", rel, "error", err)
+		_ = os.Remove(abs)
+	}
+	return errInfected
+}
+
+// scanPlaced scans a file that is already in place, for the asynchronous
+// mode.
+func (a *App) scanPlaced(sc virusscan.Scanner, abs string, uploadedBy *int64) {
+	rel, err := util.RelPathFromRoot(a.rootAbs, abs)
+	if err != nil {
+		return
+	}
+	go func() {
+		_ = a.scanFile(sc, abs, rel, uploadedBy)
+		a.du.refresh()
+	}()
+}
+
+// quarantine moves abs out of the share into the quarantine directory.
+func (a *App) quarantine(abs, rel, signature string, uploadedBy *int64) error {
+	info, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(a.quarantineDir(), 0o700); err != nil {
+		return err
+	}
+	blob, err := util.RandomToken(18)
+	if err != nil {
+		return err
+	}
+	blobPath := filepath.Join(a.quarantineDir(), blob)
+	if err := os.Rename(abs, blobPath); err != nil {
+		// The data dir may be on another filesystem.
+		if err := copyVersionBlob(abs, blobPath); err != nil {
+			return err
+		}
+		if err := os.Remove(abs); err != nil {
+			_ = os.Remove(blobPath)
+			return err
+		}
+	}
+	q := db.QuarantinedFile{Blob: blob, Path: rel, Size: info.Size(), Signature: signature, UploadedBy: uploadedBy}
+	if _, err := a.store.CreateQuarantinedFile(q); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *App) handleAdminQuarantine(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	files, err := a.store.ListQuarantinedFiles()
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list quarantine")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+// quarantinedFromRequest decodes {"id": n} and loads that quarantined file,
+// answering the request itself on failure.
+func (a *App) quarantinedFromRequest(w http.ResponseWriter, r *http.Request) (db.QuarantinedFile, bool) {
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return db.QuarantinedFile{}, false
+	}
+	q, err := a.store.GetQuarantinedFile(req.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.writeError(w, http.StatusNotFound, "quarantined file not found")
+		} else {
+			a.writeError(w, http.StatusInternalServerError, "failed to load quarantined file")
+		}
+		return db.QuarantinedFile{}, false
+	}
+	return q, true
+}
+
+// handleAdminQuarantineRelease puts a quarantined file back where it was
+// uploaded to, next to any file that has taken its name since.
+func (a *App) handleAdminQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	q, ok := a.quarantinedFromRequest(w, r)
+	if !ok {
+		return
+	}
+	targetAbs, err := a.resolvePath(q.Path)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid original path")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(targetAbs), 0o755); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "mkdir failed")
+		return
+	}
+	in, err := os.Open(filepath.Join(a.quarantineDir(), q.Blob))
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "quarantined file is missing")
+		return
+	}
+	tmp, err := writePartFile(targetAbs, in, nil, nil)
+	in.Close()
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "release failed")
+		return
+	}
+	dest, err := placeCollisionPath(tmp, targetAbs, config.CollisionRename)
+	if err != nil {
+		_ = os.Remove(tmp)
+		a.writeError(w, http.StatusInternalServerError, "release failed")
+		return
+	}
+	a.dropQuarantined(q)
+	rel, _ := util.RelPathFromRoot(a.rootAbs, dest)
+	if u := a.currentUser(r); u != nil {
+		_ = a.store.RecordAudit(&u.ID, "admin.quarantine.release", rel, q.Signature)
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": rel})
+}
+
+func (a *App) handleAdminQuarantineDelete(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	q, ok := a.quarantinedFromRequest(w, r)
+	if !ok {
+		return
+	}
+	a.dropQuarantined(q)
+	if u := a.currentUser(r); u != nil {
+		_ = a.store.RecordAudit(&u.ID, "admin.quarantine.delete", q.Path, strconv.FormatInt(q.ID, 10))
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) dropQuarantined(q db.QuarantinedFile) {
+	if err := a.store.DeleteQuarantinedFile(q.ID); err != nil {
+		a.logger.Warn("delete quarantine entry failed", "id", q.ID, "error", err)
+		return
+	}
+	if err := os.Remove(filepath.Join(a.quarantineDir(), q.Blob)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		a.logger.Warn("delete quarantined file failed", "id", q.ID, "error", err)
+	}
+}
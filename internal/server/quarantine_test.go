@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestSyncScanQuarantinesInfectedUpload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scan command uses sh")
+	}
+	root := t.TempDir()
+	dataDir := t.TempDir()
+	store, err := db.Open(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{rootAbs: root, store: store, opts: Options{DataDir: dataDir}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, content := range map[string]string{"ok.txt": "hello", "bad.txt": "EICAR"} {
+		fw, _ := mw.CreateFormFile("files", name)
+		_, _ = fw.Write([]byte(content))
+	}
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/api/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	settings := db.AppSettings{
+		MaxUploadSizeMB:  1,
+		CollisionPolicy:  config.CollisionRename,
+		VirusScanCommand: `if grep -q EICAR "$SHAREHERE_FILE"; then echo Test-Signature; exit 1; fi`,
+		VirusScanSync:    true,
+	}
+	res, err := a.consumeMultipartUpload(httptest.NewRecorder(), r, settings, "", false, pathScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Uploaded) != 1 || res.Uploaded[0] != "ok.txt" {
+		t.Fatalf("uploaded = %v", res.Uploaded)
+	}
+	if len(res.Rejected) != 1 || res.Rejected[0].Reason != rejectInfected {
+		t.Fatalf("rejected = %+v", res.Rejected)
+	}
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 1 {
+		t.Fatalf("share root holds %d entries, want only ok.txt", len(entries))
+	}
+
+	files, err := store.ListQuarantinedFiles()
+	if err != nil || len(files) != 1 || files[0].Path != "bad.txt" || files[0].Signature != "Test-Signature" {
+		t.Fatalf("quarantine = %+v, %v", files, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(a.quarantineDir(), files[0].Blob)); err != nil || string(data) != "EICAR" {
+		t.Fatalf("quarantined blob = %q, %v", data, err)
+	}
+}
+
+func TestSyncScanFailureRefusesUpload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scan command uses sh")
+	}
+	root := t.TempDir()
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{rootAbs: root, store: store, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("files", "a.txt")
+	_, _ = fw.Write([]byte("data"))
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/api/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	settings := db.AppSettings{MaxUploadSizeMB: 1, CollisionPolicy: config.CollisionRename, VirusScanCommand: "exit 2", VirusScanSync: true}
+	res, err := a.consumeMultipartUpload(httptest.NewRecorder(), r, settings, "", false, pathScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Uploaded) != 0 || len(res.Rejected) != 1 || res.Rejected[0].Reason != rejectScanFailed {
+		t.Fatalf("result = %+v", res)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Fatalf("share root not empty: %v", entries)
+	}
+}
+
+func TestUploadNameStaysFreeDuringScan(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scan command uses sh")
+	}
+	root := t.TempDir()
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{rootAbs: root, store: store, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("files", "a.txt")
+	_, _ = fw.Write([]byte("data"))
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/api/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	// The scanner records what the folder looks like while it runs.
+	seen := filepath.Join(t.TempDir(), "seen")
+	settings := db.AppSettings{
+		MaxUploadSizeMB:  1,
+		CollisionPolicy:  config.CollisionRename,
+		VirusScanCommand: `ls -a "$(dirname "$SHAREHERE_FILE")" > ` + seen,
+		VirusScanSync:    true,
+	}
+	res, err := a.consumeMultipartUpload(httptest.NewRecorder(), r, settings, "", false, pathScope{})
+	if err != nil || len(res.Uploaded) != 1 {
+		t.Fatalf("upload = %+v, %v", res, err)
+	}
+	during, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := 0
+	for _, name := range strings.Fields(string(during)) {
+		if name == "a.txt" {
+			t.Fatal("a.txt existed before the scan finished")
+		}
+		if strings.HasSuffix(name, ".part") {
+			parts++
+			if page, _ := paginateList([]*listItem{{name: name}}, listQuery{IncludeHidden: true}); len(page.Entries) != 0 {
+				t.Fatalf("listing shows %s", name)
+			}
+		}
+	}
+	if parts != 1 {
+		t.Fatalf("scanned folder held %q", during)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "data" {
+		t.Fatalf("a.txt = %q", data)
+	}
+}
+
+func TestSyncScanQuarantinesInfectedMember(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scan command uses sh")
+	}
+	root := t.TempDir()
+	dataDir := t.TempDir()
+	store, err := db.Open(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{rootAbs: root, store: store, opts: Options{DataDir: dataDir}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	archive := filepath.Join(t.TempDir(), "a.zip")
+	writeTestZip(t, archive, map[string]string{"ok.txt": "hello", "sub/bad.txt": "EICAR"})
+
+	sc, err := virusScanner(db.AppSettings{VirusScanCommand: `if grep -q EICAR "$SHAREHERE_FILE"; then echo Test-Signature; exit 1; fi`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := extractArchive(archive, "zip", extractJob{
+		destAbs: filepath.Join(root, "out"),
+		destRel: "out",
+		policy:  config.CollisionRename,
+		limits:  archiveLimits{members: 10, extractBytes: 1 << 20},
+		scan:    func(tmp, rel string) error { return a.scanFile(sc, tmp, rel, nil) },
+	})
+	if res.err != nil || res.files != 1 || len(res.issues) != 1 || !strings.Contains(res.issues[0], "quarantined") {
+		t.Fatalf("extractArchive() = %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(root, "out", "sub", "bad.txt")); !os.IsNotExist(err) {
+		t.Fatalf("infected member was extracted: %v", err)
+	}
+	files, err := store.ListQuarantinedFiles()
+	if err != nil || len(files) != 1 || files[0].Path != "out/sub/bad.txt" {
+		t.Fatalf("quarantine = %+v, %v", files, err)
+	}
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// defaultInvitationExpiry is how long an invitation lasts unless the admin
+// says otherwise.
+const defaultInvitationExpiry = 72 * time.Hour
+
+// usernameRe is what a username picked at sign-up must look like, so that it
+// also works as a home directory name.
+var usernameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// registrationClosed reports why nobody can sign up here, or "". Local
+// accounts are useless while only admins may sign in with one.
+func (a *App) registrationClosed() string {
+	if a.opts.AuthMode == config.AuthOff {
+		return "accounts are off on this server"
+	}
+	if a.localAuthenticator().AdminsOnly {
+		return "accounts come from the directory on this server"
+	}
+	return ""
+}
+
+func (a *App) handleRegisterPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.opts.AuthMode == config.AuthOff || !a.currentPrincipal(r).Anonymous {
+		http.Redirect(w, r, a.route("/"), http.StatusSeeOther)
+		return
+	}
+	invite := r.URL.Query().Get("invite")
+	data := map[string]any{
+		"BasePath":  a.templateBasePath(),
+		"Version":   a.opts.Version,
+		"CSRFToken": a.currentSession(r).CSRFToken,
+		"Invite":    invite,
+	}
+	switch {
+	case a.registrationClosed() != "":
+		data["Closed"] = "Sign-up is off: " + a.registrationClosed() + "."
+	case invite != "":
+		if _, err := a.store.GetUsableInvitation(invite); err != nil {
+			data["Closed"] = "This invitation is invalid, was already used or has expired."
+		}
+	case !a.effectiveSettings().OpenRegistration:
+		data["Closed"] = "Sign-up is by invitation only."
+	default:
+		data["NeedsApproval"] = true
+	}
+	if err := a.templates.ExecuteTemplate(w, "register.html", data); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "render failed")
+	}
+}
+
+// handleRegister creates an account for an anonymous visitor: signed in
+// right away when they hold an invitation, otherwise disabled until an admin
+// approves it, if open registration is on.
+func (a *App) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	if msg := a.registrationClosed(); msg != "" {
+		a.writeError(w, http.StatusForbidden, "sign-up is off: "+msg)
+		return
+	}
+	if !a.currentPrincipal(r).Anonymous {
+		a.writeError(w, http.StatusBadRequest, "already signed in")
+		return
+	}
+	var req struct {
+		Invite   string `json:"invite"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	settings := a.effectiveSettings()
+	if req.Invite == "" && !settings.OpenRegistration {
+		a.writeError(w, http.StatusForbidden, "sign-up is by invitation only")
+		return
+	}
+	req.Username = strings.TrimSpace(strings.ToLower(req.Username))
+	if !usernameRe.MatchString(req.Username) {
+		a.writeError(w, http.StatusBadRequest, "usernames are 1-64 lower-case letters, digits, dots, dashes or underscores")
+		return
+	}
+	if _, err := a.store.GetUserByUsername(req.Username); err == nil {
+		a.writeError(w, http.StatusBadRequest, "that username is taken")
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		a.writeError(w, http.StatusInternalServerError, "failed to check username")
+		return
+	}
+	if err := settings.PasswordPolicy().Check(req.Username, req.Password); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Invite == "" {
+		id, err := a.store.CreatePendingUser(req.Username, hash, auth.RoleUser)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, "failed to create user")
+			return
+		}
+		_ = a.store.RecordAudit(&id, "user.register.pending", req.Username, remoteIP(r))
+		a.notifyAdmins("user.pending", fmt.Sprintf("%s signed up and awaits approval", req.Username))
+		a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "pending": true})
+		return
+	}
+
+	inv, id, err := a.store.RedeemInvitation(req.Invite, req.Username, hash)
+	if errors.Is(err, db.ErrInvitationUnusable) {
+		a.writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, "failed to create user")
+		return
+	}
+	_ = a.store.RecordAudit(&id, "user.register", req.Username, "invitation="+tokenHint(inv.Token)+" role="+inv.Role)
+	if inv.CreateHome {
+		a.createHomeDir(settings, req.Username)
+	}
+	user, err := a.store.GetUserByUsername(req.Username)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "session failure")
+		return
+	}
+	restriction := ""
+	if settings.PasskeyPolicy == config.PasskeyRequired {
+		restriction = restrictPasskeyEnroll
+	}
+	if err := a.signIn(w, r, user, false, restriction, "invitation"); err != nil {
+		a.writeError(w, http.StatusInternalServerError, "session failure")
+		return
+	}
+	redirect := a.route("/")
+	if restriction != "" {
+		redirect = a.restrictionPage(restriction)
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "redirect": redirect})
+}
+
+// notifyAdmins leaves a notification for every active admin.
+func (a *App) notifyAdmins(kind, message string) {
+	users, err := a.store.ListUsers()
+	if err != nil {
+		a.logger.Warn("list admins failed", "error", err)
+		return
+	}
+	for _, u := range users {
+		if u.Role != auth.RoleAdmin || u.Disabled {
+			continue
+		}
+		if err := a.store.CreateNotification(db.Notification{UserID: u.ID, Kind: kind, Message: message}); err != nil {
+			a.logger.Warn("notify admin failed", "user", u.Username, "error", err)
+		}
+	}
+}
+
+func (a *App) handleAdminInvitations(w http.ResponseWriter, r *http.Request) {
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		items, err := a.store.ListInvitations()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to list invitations")
+			return
+		}
+		a.writeJSON(w, http.StatusOK, map[string]any{"invitations": items})
+	case http.MethodPost:
+		if !a.verifyCSRF(w, r) {
+			return
+		}
+		var req struct {
+			Role       string `json:"role"`
+			Expiry     string `json:"expiry"`
+			CreateHome bool   `json:"create_home"`
+		}
+		if err := decodeJSONBody(r, &req); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid payload")
+			return
+		}
+		switch req.Role {
+		case auth.RoleAdmin, auth.RoleUser:
+		case "":
+			req.Role = auth.RoleUser
+		default:
+			a.writeError(w, http.StatusBadRequest, "invalid role")
+			return
+		}
+		ttl := defaultInvitationExpiry
+		if strings.TrimSpace(req.Expiry) != "" {
+			d, err := time.ParseDuration(req.Expiry)
+			if err != nil || d <= 0 {
+				a.writeError(w, http.StatusBadRequest, "invalid expiry")
+				return
+			}
+			ttl = d
+		}
+		token, err := util.RandomToken(18)
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to create invitation")
+			return
+		}
+		var createdBy *int64
+		if u := a.currentUser(r); u != nil {
+			createdBy = &u.ID
+		}
+		inv := db.Invitation{Token: token, Role: req.Role, CreateHome: req.CreateHome, CreatedBy: createdBy, ExpiresAt: time.Now().Add(ttl)}
+		if err := a.store.CreateInvitation(inv); err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to create invitation")
+			return
+		}
+		_ = a.store.RecordAudit(createdBy, "admin.invitation.create", tokenHint(token), fmt.Sprintf("role=%s expires=%s home=%t", req.Role, inv.ExpiresAt.UTC().Format(time.RFC3339), req.CreateHome))
+		a.writeJSON(w, http.StatusOK, map[string]any{"token": token, "url": a.invitationPath(token), "expires_at": inv.ExpiresAt})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		a.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// tokenHint is enough of an invitation token to tell invitations apart in
+// the audit log without the log handing out live invitations.
+func tokenHint(token string) string {
+	if len(token) > 8 {
+		return token[:8] + "…"
+	}
+	return token
+}
+
+// invitationPath is where the holder of token signs up.
+func (a *App) invitationPath(token string) string {
+	return a.route("/register") + "?invite=" + token
+}
+
+func (a *App) handleAdminDeleteInvitation(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if err := a.store.DeleteInvitation(req.Token); errors.Is(err, sql.ErrNoRows) {
+		a.writeError(w, http.StatusNotFound, "invitation not found")
+		return
+	} else if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to delete invitation")
+		return
+	}
+	if u := a.currentUser(r); u != nil {
+		_ = a.store.RecordAudit(&u.ID, "admin.invitation.delete", tokenHint(req.Token), "")
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleAdminApproveUser settles a pending sign-up: approving enables the
+// account, rejecting removes it.
+func (a *App) handleAdminApproveUser(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Approve  bool   `json:"approve"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	target, err := a.store.GetUserByUsername(req.Username)
+	if err != nil || !target.Pending {
+		a.writeError(w, http.StatusNotFound, "no such pending user")
+		return
+	}
+	action := "admin.user.reject"
+	if req.Approve {
+		action = "admin.user.approve"
+		err = a.store.ApproveUser(target.Username)
+		if err == nil {
+			a.ensureHomeDir(settings, target.Username)
+		}
+	} else {
+		err = a.store.DeleteUser(target.Username)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to update user")
+		return
+	}
+	if u := a.currentUser(r); u != nil {
+		_ = a.store.RecordAudit(&u.ID, action, target.Username, "")
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/webui"
+)
+
+func TestRegistration(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tmpl, err := template.ParseFS(webui.FS, "templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	a := &App{store: store, templates: tmpl, rootAbs: root, opts: Options{BasePath: "/", AuthMode: config.AuthOn}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	adminID, err := store.CreateUser("root", "x", auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	if err := store.CreateSession(db.Session{Token: "admin", UserID: &adminID, CSRFToken: "c", ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/register", a.handleRegister)
+	mux.HandleFunc("/api/admin/invitations", a.handleAdminInvitations)
+	mux.HandleFunc("/api/admin/users/approve", a.handleAdminApproveUser)
+	handler := a.sessionMiddleware(mux)
+	anon := 0
+	do := func(token, path string, body any) *httptest.ResponseRecorder {
+		if token == "" {
+			// Signing up rotates the session, so each visitor gets a fresh one.
+			anon++
+			token = "anon-" + string(rune('a'+anon))
+			if err := store.CreateSession(db.Session{Token: token, CSRFToken: "c", ExpiresAt: expires}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		raw, _ := json.Marshal(body)
+		r := httptest.NewRequest("POST", path, bytes.NewReader(raw))
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+		r.Header.Set("X-CSRF-Token", "c")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := do("admin", "/api/admin/invitations", map[string]any{"role": "amdin"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invitation with a misspelt role: %d", rec.Code)
+	}
+	rec := do("admin", "/api/admin/invitations", map[string]any{"role": auth.RoleAdmin, "expiry": "1h", "create_home": true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create invitation: %d %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+
+	signUp := map[string]string{"invite": created.Token, "username": "Bob", "password": "correct horse battery"}
+	if rec := do("", "/api/register", signUp); rec.Code != http.StatusOK {
+		t.Fatalf("redeem invitation: %d %s", rec.Code, rec.Body)
+	}
+	bob, err := store.GetUserByUsername("bob")
+	if err != nil || bob.Role != auth.RoleAdmin || bob.Disabled {
+		t.Fatalf("bob = %+v, %v", bob, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "home", "bob")); err != nil {
+		t.Fatalf("home directory not created: %v", err)
+	}
+	signUp["username"] = "carol"
+	if rec := do("", "/api/register", signUp); rec.Code != http.StatusForbidden {
+		t.Fatalf("invitation redeemed twice: %d", rec.Code)
+	}
+
+	expired := db.Invitation{Token: "expired-token", Role: auth.RoleUser, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := store.CreateInvitation(expired); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do("", "/api/register", map[string]string{"invite": expired.Token, "username": "carol", "password": "staple of the day"}); rec.Code != http.StatusForbidden {
+		t.Fatalf("expired invitation redeemed: %d", rec.Code)
+	}
+
+	// Without an invitation, sign-up needs open registration and approval.
+	open := map[string]string{"username": "dave", "password": "purple monkey dishwasher"}
+	if rec := do("", "/api/register", open); rec.Code != http.StatusForbidden {
+		t.Fatalf("sign-up with registration closed: %d", rec.Code)
+	}
+	settings, _ := store.GetAppSettings()
+	settings.OpenRegistration = true
+	if err := store.SetAppSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do("", "/api/register", open); rec.Code != http.StatusOK {
+		t.Fatalf("open sign-up: %d %s", rec.Code, rec.Body)
+	}
+	dave, err := store.GetUserByUsername("dave")
+	if err != nil || !dave.Pending || !dave.Disabled || dave.Role != auth.RoleUser {
+		t.Fatalf("dave = %+v, %v", dave, err)
+	}
+	if rec := postLogin(t, a, "dave", "purple monkey dishwasher"); rec.Code == http.StatusSeeOther {
+		t.Fatal("pending user signed in")
+	}
+	if rec := do("admin", "/api/admin/users/approve", map[string]any{"username": "dave", "approve": true}); rec.Code != http.StatusOK {
+		t.Fatalf("approve: %d %s", rec.Code, rec.Body)
+	}
+	if dave, _ := store.GetUserByUsername("dave"); dave.Pending || dave.Disabled {
+		t.Fatalf("dave not approved: %+v", dave)
+	}
+	if rec := do("admin", "/api/admin/users/approve", map[string]any{"username": "dave", "approve": false}); rec.Code != http.StatusNotFound {
+		t.Fatalf("rejected an approved user: %d", rec.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+// Session restrictions limit a signed-in session to what it takes to lift
+// them.
+const (
+	// restrictPasskeyEnroll is for users without a passkey when passkeys
+	// are required: they may only register one.
+	restrictPasskeyEnroll = "passkey_enroll"
+	// restrictPasswordChange is for users signed in with a temporary
+	// password: they may only change it.
+	restrictPasswordChange = "password_change"
+)
+
+// restrictionPage is where a session with restriction goes to lift it.
+func (a *App) restrictionPage(restriction string) string {
+	if restriction == restrictPasswordChange {
+		return a.route("/password")
+	}
+	return a.route("/passkeys")
+}
+
+// allowedWhileRestricted reports whether a session with restriction may make
+// request r: only what it takes to lift the restriction or sign out.
+func (a *App) allowedWhileRestricted(r *http.Request, restriction string) bool {
+	switch r.URL.Path {
+	case a.route("/login"), a.route("/logout"), a.route("/api/me"):
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, a.route("/static/")) {
+		return true
+	}
+	switch restriction {
+	case restrictPasskeyEnroll:
+		return r.URL.Path == a.route("/passkeys") || strings.HasPrefix(r.URL.Path, a.route("/api/passkeys"))
+	case restrictPasswordChange:
+		return r.URL.Path == a.route("/password") || r.URL.Path == a.route("/api/password")
+	}
+	return false
+}
+
+// enforceRestriction answers requests a restricted session may not make and
+// reports whether it did.
+func (a *App) enforceRestriction(w http.ResponseWriter, r *http.Request, session db.Session) bool {
+	if session.Restriction == "" || a.allowedWhileRestricted(r, session.Restriction) {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, a.route("/api/")) {
+		msg := "register a passkey first"
+		if session.Restriction == restrictPasswordChange {
+			msg = "change your password first"
+		}
+		a.writeError(w, http.StatusForbidden, msg)
+	} else {
+		http.Redirect(w, r, a.restrictionPage(session.Restriction), http.StatusSeeOther)
+	}
+	return true
+}
@@ -10,11 +10,13 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/matthewsawatzky/sharehere/internal/auth"
@@ -35,9 +37,11 @@ const (
 type ctxKey string
 
 const (
-	ctxSessionKey   ctxKey = "session"
-	ctxUserKey      ctxKey = "user"
-	ctxPrincipalKey ctxKey = "principal"
+	ctxSessionKey      ctxKey = "session"
+	ctxUserKey         ctxKey = "user"
+	ctxPrincipalKey    ctxKey = "principal"
+	ctxClientIPKey     ctxKey = "client_ip"
+	ctxImpersonatorKey ctxKey = "impersonator"
 )
 
 type App struct {
@@ -47,6 +51,17 @@ type App struct {
 	templates *template.Template
 	static    http.Handler
 	rootAbs   string
+	du        *duScanner
+	// ceremonies holds passkey sign-ins and registrations in progress.
+	ceremonies ceremonyStore
+	// authn checks sign-in passwords; nil means local accounts only.
+	authn auth.Authenticator
+	// trustedProxies may say who the client is in X-Forwarded-For.
+	trustedProxies []netip.Prefix
+	limits         trafficLimits
+	// settings caches the settings for the checks run on every request;
+	// see cachedSettings.
+	settings atomic.Pointer[db.AppSettings]
 }
 
 func Run(ctx context.Context, opts Options) error {
@@ -109,7 +124,20 @@ func Run(ctx context.Context, opts Options) error {
 		templates: tmpl,
 		static:    http.FileServer(http.FS(staticFS)),
 		rootAbs:   rootAbs,
+		du:        newDUScanner(rootAbs, store, logger),
 	}
+	if app.trustedProxies, err = parseTrustedProxies(opts.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	if opts.LDAP.Enabled() {
+		directory, err := auth.NewLDAP(opts.LDAP)
+		if err != nil {
+			return err
+		}
+		app.authn = auth.Chain{directory, app.localAuthenticator()}
+	}
+	go app.du.run(ctx)
+	go app.maintenanceLoop(ctx)
 
 	mux := http.NewServeMux()
 	mux.Handle(app.route("/static/"), http.StripPrefix(app.route("/static/"), app.static))
@@ -117,31 +145,71 @@ func Run(ctx context.Context, opts Options) error {
 	mux.HandleFunc(app.route("/login"), app.handleLogin)
 	mux.HandleFunc(app.route("/logout"), app.handleLogout)
 	mux.HandleFunc(app.route("/admin"), app.handleAdminPage)
+	mux.HandleFunc(app.route("/passkeys"), app.handlePasskeysPage)
+	mux.HandleFunc(app.route("/password"), app.handlePasswordPage)
+	mux.HandleFunc(app.route("/register"), app.handleRegisterPage)
+	mux.HandleFunc(app.route("/impersonate/stop"), app.handleStopImpersonation)
 
 	mux.HandleFunc(app.route("/api/me"), app.handleMe)
 	mux.HandleFunc(app.route("/api/themes"), app.handleThemes)
 	mux.HandleFunc(app.route("/api/list"), app.handleList)
+	mux.HandleFunc(app.route("/api/manifest"), app.handleManifest)
 	mux.HandleFunc(app.route("/api/download"), app.handleDownload)
 	mux.HandleFunc(app.route("/api/preview"), app.handlePreview)
+	mux.HandleFunc(app.route("/api/checksum"), app.handleChecksum)
+	mux.HandleFunc(app.route("/api/du"), app.handleDiskUsage)
+	mux.HandleFunc(app.route("/api/versions"), app.handleVersions)
+	mux.HandleFunc(app.route("/api/versions/download"), app.handleVersionDownload)
+	mux.HandleFunc(app.route("/api/versions/restore"), app.changesTree(app.handleVersionRestore))
 	mux.HandleFunc(app.route("/api/zip"), app.handleZip)
-	mux.HandleFunc(app.route("/api/upload"), app.handleUpload)
-	mux.HandleFunc(app.route("/api/delete"), app.handleDelete)
-	mux.HandleFunc(app.route("/api/rename"), app.handleRename)
+	mux.HandleFunc(app.route("/api/upload"), app.changesTree(app.handleUpload))
+	mux.HandleFunc(app.route("/api/delete"), app.changesTree(app.handleDelete))
+	mux.HandleFunc(app.route("/api/rename"), app.changesTree(app.handleRename))
+	mux.HandleFunc(app.route("/api/mkdir"), app.changesTree(app.handleMkdir))
+	mux.HandleFunc(app.route("/api/move"), app.changesTree(app.handleMove))
+	mux.HandleFunc(app.route("/api/copy"), app.changesTree(app.handleCopy))
+	mux.HandleFunc(app.route("/api/extract"), app.changesTree(app.handleExtract))
 	mux.HandleFunc(app.route("/api/share/create"), app.handleCreateShareLink)
 	mux.HandleFunc(app.route("/api/share/revoke"), app.handleRevokeShareLink)
+	mux.HandleFunc(app.route("/api/notifications"), app.handleNotifications)
+	mux.HandleFunc(app.route("/api/sessions"), app.handleSessions)
+	mux.HandleFunc(app.route("/api/sessions/revoke"), app.handleSessionRevoke)
+	mux.HandleFunc(app.route("/api/password"), app.handleChangePassword)
+	mux.HandleFunc(app.route("/api/register"), app.handleRegister)
+	mux.HandleFunc(app.route("/api/passkeys"), app.handlePasskeys)
+	mux.HandleFunc(app.route("/api/passkeys/register/begin"), app.handlePasskeyRegisterBegin)
+	mux.HandleFunc(app.route("/api/passkeys/register/finish"), app.handlePasskeyRegisterFinish)
+	mux.HandleFunc(app.route("/api/passkeys/delete"), app.handlePasskeyDelete)
+	mux.HandleFunc(app.route("/api/passkeys/login/begin"), app.handlePasskeyLoginBegin)
+	mux.HandleFunc(app.route("/api/passkeys/login/finish"), app.handlePasskeyLoginFinish)
+	mux.HandleFunc(app.route("/api/notifications/read"), app.handleNotificationsRead)
 
 	mux.HandleFunc(app.route("/api/admin/settings"), app.handleAdminSettings)
 	mux.HandleFunc(app.route("/api/admin/users"), app.handleAdminUsers)
+	mux.HandleFunc(app.route("/api/admin/sessions"), app.handleAdminSessions)
+	mux.HandleFunc(app.route("/api/admin/sessions/revoke"), app.handleAdminSessionRevoke)
 	mux.HandleFunc(app.route("/api/admin/users/create"), app.handleAdminCreateUser)
 	mux.HandleFunc(app.route("/api/admin/users/password"), app.handleAdminSetPassword)
 	mux.HandleFunc(app.route("/api/admin/users/disable"), app.handleAdminDisableUser)
 	mux.HandleFunc(app.route("/api/admin/users/delete"), app.handleAdminDeleteUser)
+	mux.HandleFunc(app.route("/api/admin/users/approve"), app.handleAdminApproveUser)
+	mux.HandleFunc(app.route("/api/admin/impersonate"), app.handleAdminImpersonate)
+	mux.HandleFunc(app.route("/api/admin/invitations"), app.handleAdminInvitations)
+	mux.HandleFunc(app.route("/api/admin/invitations/delete"), app.handleAdminDeleteInvitation)
+	mux.HandleFunc(app.route("/api/admin/passkeys/reset"), app.handleAdminResetPasskeys)
 	mux.HandleFunc(app.route("/api/admin/links"), app.handleAdminLinks)
+	mux.HandleFunc(app.route("/api/admin/dropboxes"), app.handleAdminDropBoxes)
+	mux.HandleFunc(app.route("/api/admin/dropboxes/delete"), app.handleAdminDeleteDropBox)
+	mux.HandleFunc(app.route("/api/admin/quarantine"), app.handleAdminQuarantine)
+	mux.HandleFunc(app.route("/api/admin/quarantine/release"), app.changesTree(app.handleAdminQuarantineRelease))
+	mux.HandleFunc(app.route("/api/admin/quarantine/delete"), app.handleAdminQuarantineDelete)
 	mux.HandleFunc(app.route("/api/admin/audit"), app.handleAdminAudit)
+	mux.HandleFunc(app.route("/api/admin/throttle"), app.handleAdminThrottle)
 
-	mux.HandleFunc(app.route("/s/"), app.handleShare)
+	mux.HandleFunc(app.route("/s/"), app.changesTree(app.handleShare))
 
-	handler := app.recoverer(app.securityHeaders(app.sessionMiddleware(mux)))
+	app.limits.started = time.Now()
+	handler := app.recoverer(app.securityHeaders(app.networkGate(app.rateLimitIP(app.sessionMiddleware(app.throttle(mux))))))
 	addr := net.JoinHostPort(opts.Bind, strconv.Itoa(opts.Port))
 	httpServer := &http.Server{
 		Addr:              addr,
@@ -205,6 +273,17 @@ func (a *App) securityHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// changesTree wraps handlers that may modify files so the disk usage scanner
+// picks up the change without waiting for its next interval.
+func (a *App) changesTree(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r)
+		if r.Method == http.MethodPost {
+			a.du.refresh()
+		}
+	}
+}
+
 func (a *App) recoverer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -225,6 +304,10 @@ func (a *App) sessionMiddleware(next http.Handler) http.Handler {
 			token = cookie.Value
 		}
 		session, err := a.store.GetSession(token)
+		var impersonator db.User
+		if err == nil && session.ImpersonatorID != nil {
+			impersonator, err = a.impersonatorOf(session)
+		}
 		if err != nil {
 			session, err = a.newAnonymousSession(r)
 			if err != nil {
@@ -238,7 +321,7 @@ func (a *App) sessionMiddleware(next http.Handler) http.Handler {
 			a.setSessionCookie(w, session)
 		} else {
 			expires := time.Now().Add(anonTTL)
-			if session.UserID != nil {
+			if session.UserID != nil || session.ImpersonatorID != nil {
 				if session.Remember {
 					expires = time.Now().Add(rememberTTL)
 				} else {
@@ -266,6 +349,15 @@ func (a *App) sessionMiddleware(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, ctxPrincipalKey, principal)
 		if u != nil {
 			ctx = context.WithValue(ctx, ctxUserKey, *u)
+			if a.enforceRestriction(w, r, session) {
+				return
+			}
+		}
+		if session.ImpersonatorID != nil {
+			ctx = context.WithValue(ctx, ctxImpersonatorKey, impersonator)
+			if a.enforceImpersonation(w, r, session, impersonator, principal) {
+				return
+			}
 		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -289,10 +381,11 @@ func (a *App) newAnonymousSession(r *http.Request) (db.Session, error) {
 	}, nil
 }
 
+// remoteIP returns the client address networkGate derived, or the peer's
+// address for requests that didn't pass through it.
 func remoteIP(r *http.Request) string {
-	if fwd := strings.TrimSpace(r.Header.Get("X-Forwarded-For")); fwd != "" {
-		parts := strings.Split(fwd, ",")
-		return strings.TrimSpace(parts[0])
+	if ip, ok := r.Context().Value(ctxClientIPKey).(string); ok {
+		return ip
 	}
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
@@ -370,6 +463,13 @@ func (a *App) currentUser(r *http.Request) *db.User {
 }
 
 func (a *App) effectiveSettings() db.AppSettings {
+	s, _ := a.loadSettings()
+	return s
+}
+
+// loadSettings reads the settings, with the server's read-only flag applied.
+// If they can't be read it returns the defaults and the error.
+func (a *App) loadSettings() (db.AppSettings, error) {
 	s, err := a.store.GetAppSettings()
 	if err != nil {
 		return db.AppSettings{
@@ -378,11 +478,32 @@ func (a *App) effectiveSettings() db.AppSettings {
 			CollisionPolicy:    config.CollisionRename,
 			DefaultShareExpiry: "24h",
 			Theme:              "light",
-		}
+		}, err
 	}
 	if a.opts.ReadOnly {
 		s.ReadOnly = true
 	}
+	return s, nil
+}
+
+// cachedSettings returns the settings for the network lists and traffic
+// limits, which are checked before anything else on every request. They are
+// kept in memory so that a flood of requests causes no database reads, and
+// reloaded when an admin saves the settings.
+func (a *App) cachedSettings() db.AppSettings {
+	if s := a.settings.Load(); s != nil {
+		return *s
+	}
+	return a.refreshSettings()
+}
+
+// refreshSettings reloads the settings behind cachedSettings. Defaults
+// returned after a failed read are not kept.
+func (a *App) refreshSettings() db.AppSettings {
+	s, err := a.loadSettings()
+	if err == nil {
+		a.settings.Store(&s)
+	}
 	return s
 }
 
@@ -425,10 +546,24 @@ func (a *App) permissionsFor(r *http.Request, settings db.AppSettings) Permissio
 			perms.CanRename = false
 			perms.CanUpload = false
 		}
+		if settings.HomeDirsEnabled {
+			if home, err := util.HomeDirRel(homeDirsBase(settings), principal.Username); err == nil {
+				perms.Home = home
+				perms.HomeOnly = settings.UsersSeeOnlyHome && !perms.CanAdmin
+			}
+		}
 		return perms
 	}
 
-	switch settings.GuestMode {
+	// Guests from networks the lists keep out get less or nothing.
+	mode := settings.GuestMode
+	if mode == config.GuestUpload && !a.networkAllows(r, settings, netGuestUpload) {
+		mode = config.GuestRead
+	}
+	if mode != config.GuestOff && !a.networkAllows(r, settings, netGuestBrowse) {
+		mode = config.GuestOff
+	}
+	switch mode {
 	case config.GuestRead:
 		perms.CanBrowse = true
 	case config.GuestUpload:
@@ -517,35 +652,6 @@ func (a *App) resolvePath(rel string) (string, error) {
 	return util.SafeJoin(a.rootAbs, rel)
 }
 
-func (a *App) listDir(rel string) ([]fileEntry, error) {
-	abs, err := a.resolvePath(rel)
-	if err != nil {
-		return nil, err
-	}
-	entries, err := os.ReadDir(abs)
-	if err != nil {
-		return nil, err
-	}
-	items := make([]fileEntry, 0, len(entries))
-	for _, e := range entries {
-		info, err := e.Info()
-		if err != nil {
-			continue
-		}
-		itemRel := path.Join(rel, e.Name())
-		itemRel = strings.TrimPrefix(itemRel, "/")
-		items = append(items, fileEntry{
-			Name:    e.Name(),
-			RelPath: util.NormalizeRelPath(itemRel),
-			IsDir:   e.IsDir(),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
-			Ext:     strings.ToLower(filepath.Ext(e.Name())),
-		})
-	}
-	return items, nil
-}
-
 func buildBreadcrumbs(rel string) []breadcrumb {
 	rel = util.NormalizeRelPath(rel)
 	crumbs := []breadcrumb{{Name: "/", Path: ""}}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+// sessionView is a session as listed to users, marking the one the request
+// came in on.
+type sessionView struct {
+	db.SessionInfo
+	Current bool `json:"current"`
+}
+
+func (a *App) sessionViews(r *http.Request, sessions []db.SessionInfo) []sessionView {
+	current := ""
+	if token := a.currentSession(r).Token; token != "" {
+		current = db.SessionID(token)
+	}
+	out := make([]sessionView, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, sessionView{SessionInfo: s, Current: s.ID == current})
+	}
+	return out
+}
+
+type sessionRevokeRequest struct {
+	ID string `json:"id"`
+	// Others signs out every other session of the user instead of one.
+	Others bool `json:"others"`
+	// Username, for admins, signs out every session of that user.
+	Username string `json:"username"`
+}
+
+func (a *App) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	sessions, err := a.store.ListSessions(u.ID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list sessions")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"sessions": a.sessionViews(r, sessions)})
+}
+
+// handleSessionRevoke signs out one of the user's own sessions, or with
+// "others" all but the current one.
+func (a *App) handleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	u := a.currentUser(r)
+	if u == nil {
+		a.writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	var req sessionRevokeRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if req.Others {
+		n, err := a.store.DeleteUserSessions(u.ID, a.currentSession(r).Token)
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to revoke sessions")
+			return
+		}
+		_ = a.store.RecordAudit(&u.ID, "session.revoke", u.Username, "others:"+strconv.Itoa(n))
+		a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": n})
+		return
+	}
+	if _, err := a.store.DeleteSessionByID(req.ID, u.ID); err != nil {
+		a.writeSessionRevokeError(w, err)
+		return
+	}
+	_ = a.store.RecordAudit(&u.ID, "session.revoke", u.Username, req.ID)
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": 1})
+}
+
+func (a *App) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	sessions, err := a.store.ListSessions(0)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, "failed to list sessions")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"sessions": a.sessionViews(r, sessions)})
+}
+
+// handleAdminSessionRevoke signs out any session by ID, or every session of
+// a user.
+func (a *App) handleAdminSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	var req sessionRevokeRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		a.writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	var actor *int64
+	if u := a.currentUser(r); u != nil {
+		actor = &u.ID
+	}
+	if req.Username != "" {
+		target, err := a.store.GetUserByUsername(req.Username)
+		if err != nil {
+			a.writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		n, err := a.store.DeleteUserSessions(target.ID, "")
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, "failed to revoke sessions")
+			return
+		}
+		_ = a.store.RecordAudit(actor, "admin.session.revoke", target.Username, "all:"+strconv.Itoa(n))
+		a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": n})
+		return
+	}
+	s, err := a.store.DeleteSessionByID(req.ID, 0)
+	if err != nil {
+		a.writeSessionRevokeError(w, err)
+		return
+	}
+	_ = a.store.RecordAudit(actor, "admin.session.revoke", s.Username, req.ID)
+	a.writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": 1})
+}
+
+func (a *App) writeSessionRevokeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		a.writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	a.writeError(w, http.StatusInternalServerError, "failed to revoke session")
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestSessionRevocation(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	alice, err := store.CreateUser("alice", "x", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.CreateUser("bob", "x", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for _, s := range []db.Session{
+		{Token: "a1", UserID: &alice, CSRFToken: "c", ExpiresAt: expires},
+		{Token: "a2", UserID: &alice, CSRFToken: "c", ExpiresAt: expires},
+		{Token: "b1", UserID: &bob, CSRFToken: "c", ExpiresAt: expires},
+		{Token: "guest", CSRFToken: "c", ExpiresAt: expires},
+	} {
+		if err := store.CreateSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := store.ListSessions(0)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListSessions(0) = %d sessions, %v", len(all), err)
+	}
+	// Users can only revoke their own sessions.
+	if _, err := store.DeleteSessionByID(db.SessionID("b1"), alice); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("revoking another user's session: %v", err)
+	}
+	if s, err := store.DeleteSessionByID(db.SessionID("a2"), alice); err != nil || s.Username != "alice" {
+		t.Fatalf("DeleteSessionByID = %+v, %v", s, err)
+	}
+	if _, err := store.GetSession("a2"); err == nil {
+		t.Fatal("revoked session still valid")
+	}
+
+	if err := store.SetUserPassword("alice", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSession("a1"); err == nil {
+		t.Fatal("session survived a password change")
+	}
+	if err := store.SetUserDisabled("bob", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSession("b1"); err != nil {
+		t.Fatal("enabling a user ended their session")
+	}
+	if err := store.SetUserDisabled("bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSession("b1"); err == nil {
+		t.Fatal("session survived disabling the user")
+	}
+	if _, err := store.GetSession("guest"); err != nil {
+		t.Fatalf("guest session ended: %v", err)
+	}
+}
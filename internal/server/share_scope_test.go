@@ -1,6 +1,16 @@
 package server
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
 
 func TestResolveScopedSharePath(t *testing.T) {
 	v, err := resolveScopedSharePath("docs", "images")
@@ -14,3 +24,34 @@ func TestResolveScopedSharePath(t *testing.T) {
 		t.Fatalf("expected scope escape to fail")
 	}
 }
+
+func TestShareBrowseLinksAreLinkRelative(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs", "images"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "images", "a.png"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{rootAbs: root, opts: Options{BasePath: "/"}}
+	link := db.ShareLink{Token: "tok", Path: "docs"}
+	browse := func(p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.handleShareBrowse(rec, httptest.NewRequest("GET", "/s/tok?p="+url.QueryEscape(p), nil), link)
+		return rec
+	}
+
+	body := browse("images").Body.String()
+	for _, want := range []string{`href="/s/tok?p=images%2Fa.png&amp;download=1"`, `href="/s/tok?p="`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing lacks %s:\n%s", want, body)
+		}
+	}
+	// Following a listed link lands on the file, not on docs/docs/....
+	if rec := browse("images/a.png"); rec.Code != http.StatusOK || rec.Body.String() != "x" {
+		t.Fatalf("listed file: %d %q", rec.Code, rec.Body)
+	}
+	if rec := browse("images/../../secret"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("'..' in a share path: %d", rec.Code)
+	}
+}
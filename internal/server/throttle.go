@@ -0,0 +1,393 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+const (
+	// throttleChunk is how much a throttled transfer moves between waits.
+	throttleChunk = 32 * 1024
+	// bucketIdle is how long an untouched request bucket is kept; by then
+	// it has refilled anyway.
+	bucketIdle = 10 * time.Minute
+)
+
+// tokenBucket holds up to burst tokens, refilling at rate per second.
+// Tokens may go into debt, which later takers wait out.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// take removes n tokens if the bucket holds them, or reports how long until
+// it does.
+func (b *tokenBucket) take(now time.Time, rate, burst, n float64) time.Duration {
+	b.refill(now, rate, burst)
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+	return time.Duration((n - b.tokens) / rate * float64(time.Second))
+}
+
+// reserve removes n tokens, into debt if need be, and returns how long to
+// wait until they would have been there.
+func (b *tokenBucket) reserve(now time.Time, rate, burst, n float64) time.Duration {
+	b.refill(now, rate, burst)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// throttleStats counts requests the limits turned away or slowed down since
+// the server started.
+type throttleStats struct {
+	RateLimitedIP     int64     `json:"rate_limited_ip"`
+	RateLimitedUser   int64     `json:"rate_limited_user"`
+	DownloadsRejected int64     `json:"downloads_rejected"`
+	DownloadsSlowed   int64     `json:"downloads_slowed"`
+	UploadsSlowed     int64     `json:"uploads_slowed"`
+	ActiveDownloads   int       `json:"active_downloads"`
+	Since             time.Time `json:"since"`
+}
+
+// trafficLimits is the state behind request rate limits, bandwidth caps and
+// concurrent download limits. The zero value is ready to use.
+type trafficLimits struct {
+	mu        sync.Mutex
+	requests  map[string]*tokenBucket
+	lastPrune time.Time
+	// downloadTotal and uploadTotal are the server-wide bandwidth buckets.
+	downloadTotal tokenBucket
+	uploadTotal   tokenBucket
+	downloads     map[string]int
+	started       time.Time
+
+	rateLimitedIP     atomic.Int64
+	rateLimitedUser   atomic.Int64
+	downloadsRejected atomic.Int64
+	downloadsSlowed   atomic.Int64
+	uploadsSlowed     atomic.Int64
+}
+
+// allowRequest takes a token from the request bucket of key, or reports how
+// long until one is there.
+func (l *trafficLimits) allowRequest(key string, perMinute, burst int) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.requests == nil {
+		l.requests = make(map[string]*tokenBucket)
+	}
+	if now.Sub(l.lastPrune) > bucketIdle {
+		for k, b := range l.requests {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.requests, k)
+			}
+		}
+		l.lastPrune = now
+	}
+	b := l.requests[key]
+	if b == nil {
+		b = &tokenBucket{}
+		l.requests[key] = b
+	}
+	return b.take(now, float64(perMinute)/60, float64(max(burst, 1)), 1)
+}
+
+// startDownload counts a download of client against limit, reporting false
+// if the client already has limit downloads running. done must be called
+// when an admitted download ends.
+func (l *trafficLimits) startDownload(client string, limit int) (done func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.downloads == nil {
+		l.downloads = make(map[string]int)
+	}
+	if limit > 0 && l.downloads[client] >= limit {
+		return nil, false
+	}
+	l.downloads[client]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.downloads[client]--; l.downloads[client] <= 0 {
+			delete(l.downloads, client)
+		}
+	}, true
+}
+
+func (l *trafficLimits) stats() throttleStats {
+	l.mu.Lock()
+	active := 0
+	for _, n := range l.downloads {
+		active += n
+	}
+	l.mu.Unlock()
+	return throttleStats{
+		RateLimitedIP:     l.rateLimitedIP.Load(),
+		RateLimitedUser:   l.rateLimitedUser.Load(),
+		DownloadsRejected: l.downloadsRejected.Load(),
+		DownloadsSlowed:   l.downloadsSlowed.Load(),
+		UploadsSlowed:     l.uploadsSlowed.Load(),
+		ActiveDownloads:   active,
+		Since:             l.started,
+	}
+}
+
+// transferLimit is a bandwidth cap of rate bytes per second on a bucket.
+type transferLimit struct {
+	bucket *tokenBucket
+	rate   float64
+}
+
+// transferThrottle paces one transfer under its own cap and the server-wide
+// one. slowed is called the first time it has to wait.
+type transferThrottle struct {
+	ctx     context.Context
+	traffic *trafficLimits
+	limits  []transferLimit
+	slowed  func()
+}
+
+func (t *transferThrottle) wait(n int) error {
+	now := time.Now()
+	var d time.Duration
+	t.traffic.mu.Lock()
+	for _, l := range t.limits {
+		// A second's worth may pass at once, and always a whole chunk.
+		burst := max(l.rate, throttleChunk)
+		d = max(d, l.bucket.reserve(now, l.rate, burst, float64(n)))
+	}
+	t.traffic.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	if t.slowed != nil {
+		t.slowed()
+		t.slowed = nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledWriter paces a response body.
+type throttledWriter struct {
+	http.ResponseWriter
+	throttle *transferThrottle
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		if err := w.throttle.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttledBody paces a request body.
+type throttledBody struct {
+	io.ReadCloser
+	throttle *transferThrottle
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.throttle.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// newTransferThrottle returns the throttle for one transfer capped at perConn
+// and, together with all others, total KiB/s, or nil if neither is set.
+func (a *App) newTransferThrottle(ctx context.Context, total *tokenBucket, perConn, totalKiB int, slowed func()) *transferThrottle {
+	t := &transferThrottle{ctx: ctx, traffic: &a.limits, slowed: slowed}
+	if perConn > 0 {
+		t.limits = append(t.limits, transferLimit{bucket: &tokenBucket{}, rate: float64(perConn) * 1024})
+	}
+	if totalKiB > 0 {
+		t.limits = append(t.limits, transferLimit{bucket: total, rate: float64(totalKiB) * 1024})
+	}
+	if len(t.limits) == 0 {
+		return nil
+	}
+	return t
+}
+
+// isDownload reports whether a request fetches file contents. That includes
+// POST /api/zip, which streams an archive of a selection.
+func (a *App) isDownload(r *http.Request) bool {
+	if r.Method == http.MethodPost && r.URL.Path == a.route("/api/zip") {
+		return true
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	switch r.URL.Path {
+	case a.route("/api/download"), a.route("/api/zip"), a.route("/api/preview"), a.route("/api/versions/download"):
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, a.route("/s/"))
+}
+
+// isUpload reports whether a request carries files to store.
+func (a *App) isUpload(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	return r.URL.Path == a.route("/api/upload") ||
+		(strings.HasPrefix(r.URL.Path, a.route("/s/")) && strings.HasSuffix(r.URL.Path, "/upload"))
+}
+
+func (a *App) tooManyRequests(w http.ResponseWriter, retry time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+	a.writeError(w, http.StatusTooManyRequests, message)
+}
+
+// rateLimitIP limits requests per client address. It runs before sessions
+// are looked up and reads the cached settings, so that a flood doesn't reach
+// the database.
+func (a *App) rateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, a.route("/static/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if settings := a.cachedSettings(); settings.RateLimitIP > 0 {
+			if retry := a.limits.allowRequest("ip:"+remoteIP(r), settings.RateLimitIP, settings.RateLimitBurst); retry > 0 {
+				a.limits.rateLimitedIP.Add(1)
+				a.tooManyRequests(w, retry, "too many requests")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// throttle applies the limits that depend on who is asking: requests per
+// signed-in user, concurrent downloads per client, and bandwidth.
+func (a *App) throttle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, a.route("/static/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		settings := a.cachedSettings()
+		principal := a.currentPrincipal(r)
+		client := "ip:" + remoteIP(r)
+		if !principal.Anonymous && a.opts.AuthMode != config.AuthOff {
+			client = "user:" + strconv.FormatInt(principal.UserID, 10)
+			if settings.RateLimitUser > 0 {
+				if retry := a.limits.allowRequest(client, settings.RateLimitUser, settings.RateLimitBurst); retry > 0 {
+					a.limits.rateLimitedUser.Add(1)
+					a.tooManyRequests(w, retry, "too many requests")
+					return
+				}
+			}
+		}
+		if a.isDownload(r) {
+			done, ok := a.limits.startDownload(client, settings.DownloadsPerClient)
+			if !ok {
+				a.limits.downloadsRejected.Add(1)
+				a.tooManyRequests(w, time.Second, "too many downloads at once; wait for one to finish")
+				return
+			}
+			defer done()
+			if t := a.newTransferThrottle(r.Context(), &a.limits.downloadTotal, settings.DownloadRateKiB, settings.DownloadTotalKiB,
+				func() { a.limits.downloadsSlowed.Add(1) }); t != nil {
+				w = &throttledWriter{ResponseWriter: w, throttle: t}
+			}
+		}
+		if a.isUpload(r) {
+			if t := a.newTransferThrottle(r.Context(), &a.limits.uploadTotal, settings.UploadRateKiB, settings.UploadTotalKiB,
+				func() { a.limits.uploadsSlowed.Add(1) }); t != nil {
+				r.Body = &throttledBody{ReadCloser: r.Body, throttle: t}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validThrottleSettings reports the first limit of settings that is
+// negative, or "".
+func validThrottleSettings(settings db.AppSettings) string {
+	for _, f := range []struct {
+		key string
+		v   int
+	}{
+		{"rate_limit_ip", settings.RateLimitIP},
+		{"rate_limit_user", settings.RateLimitUser},
+		{"rate_limit_burst", settings.RateLimitBurst},
+		{"download_rate_kib", settings.DownloadRateKiB},
+		{"download_total_kib", settings.DownloadTotalKiB},
+		{"upload_rate_kib", settings.UploadRateKiB},
+		{"upload_total_kib", settings.UploadTotalKiB},
+		{"downloads_per_client", settings.DownloadsPerClient},
+	} {
+		if f.v < 0 {
+			return f.key
+		}
+	}
+	return ""
+}
+
+func (a *App) handleAdminThrottle(w http.ResponseWriter, r *http.Request) {
+	if !a.enforceMethod(w, r, http.MethodGet) {
+		return
+	}
+	settings := a.effectiveSettings()
+	perms := a.permissionsFor(r, settings)
+	if !a.requireAdmin(w, r, perms) {
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]any{"stats": a.limits.stats()})
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestRequestRateLimits(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/", AuthMode: config.AuthOn}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	settings, _ := store.GetAppSettings()
+	settings.RateLimitIP = 60
+	settings.RateLimitBurst = 2
+	settings.DownloadsPerClient = 1
+	if err := store.SetAppSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/list", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) { <-release })
+	handler := a.rateLimitIP(a.sessionMiddleware(a.throttle(mux)))
+	get := func(ip, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := get("192.0.2.1", "/api/list"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: %d", i, rec.Code)
+		}
+	}
+	rec := get("192.0.2.1", "/api/list")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("request past the burst: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("192.0.2.2", "/api/list"); rec.Code != http.StatusOK {
+		t.Fatalf("other address limited too: %d", rec.Code)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		get("192.0.2.3", "/api/download")
+		close(done)
+	}()
+	for a.limits.stats().ActiveDownloads == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if rec := get("192.0.2.3", "/api/download"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second download at once: %d", rec.Code)
+	}
+	close(release)
+	<-done
+
+	stats := a.limits.stats()
+	if stats.RateLimitedIP != 1 || stats.DownloadsRejected != 1 || stats.ActiveDownloads != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRateLimitSettingsAreCached(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{store: store, opts: Options{BasePath: "/", AuthMode: config.AuthOn}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	settings, _ := store.GetAppSettings()
+	settings.RateLimitIP = 60
+	settings.RateLimitBurst = 1
+	if err := store.SetAppSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	handler := a.networkGate(a.rateLimitIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	get := func() int {
+		r := httptest.NewRequest("GET", "/api/list", nil)
+		r.RemoteAddr = "192.0.2.1:5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("first request: %d", code)
+	}
+	// With the database gone the limit still holds: it comes from memory.
+	store.Close()
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst without the database: %d", code)
+	}
+
+	// Saved settings take effect once reloaded.
+	store, err = db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a.store = store
+	a.refreshSettings()
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("request after the limit was switched off: %d", code)
+	}
+}
+
+func TestZipSelectionIsThrottled(t *testing.T) {
+	store, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{store: store, opts: Options{BasePath: "/", AuthMode: config.AuthOn}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	settings, _ := store.GetAppSettings()
+	settings.DownloadsPerClient = 1
+	settings.DownloadTotalKiB = 256
+	if err := store.SetAppSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/zip", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(make([]byte, 384*1024)) })
+	handler := a.rateLimitIP(a.sessionMiddleware(a.throttle(mux)))
+	post := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/zip", nil)
+		r.RemoteAddr = "192.0.2.9:5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	start := time.Now()
+	done := make(chan time.Duration)
+	go func() {
+		post()
+		done <- time.Since(start)
+	}()
+	for a.limits.stats().ActiveDownloads == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if rec := post(); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second selection ZIP at once: %d", rec.Code)
+	}
+	if took := <-done; took < 400*time.Millisecond {
+		t.Fatalf("384 KiB selection ZIP at 256 KiB/s took %s", took)
+	}
+	if stats := a.limits.stats(); stats.DownloadsRejected != 1 || stats.DownloadsSlowed != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestBandwidthThrottle(t *testing.T) {
+	a := &App{}
+	slowed := 0
+	th := a.newTransferThrottle(context.Background(), &a.limits.downloadTotal, 0, 256, func() { slowed++ })
+	rec := httptest.NewRecorder()
+	w := &throttledWriter{ResponseWriter: rec, throttle: th}
+
+	// The first second's worth goes out at once, the rest at 256 KiB/s.
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 384*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 400*time.Millisecond || took > 2*time.Second {
+		t.Fatalf("384 KiB at 256 KiB/s took %s", took)
+	}
+	if rec.Body.Len() != 384*1024 || slowed != 1 {
+		t.Fatalf("wrote %d bytes, slowed %d times", rec.Body.Len(), slowed)
+	}
+
+	// Uploads are paced the same way and stop when the request goes away.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := &throttledBody{
+		ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, 1024*1024))),
+		throttle:   a.newTransferThrottle(ctx, &a.limits.uploadTotal, 1, 0, nil),
+	}
+	if _, err := io.Copy(io.Discard, body); err == nil {
+		t.Fatal("throttled upload outlived its request")
+	}
+}
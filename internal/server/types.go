@@ -2,6 +2,8 @@ package server
 
 import (
 	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/auth"
 )
 
 type Options struct {
@@ -22,6 +24,15 @@ type Options struct {
 	GuestMode        string
 	GuestModeSet     bool
 	ReadOnlySet      bool
+	LDAP             auth.LDAPConfig
+	TrustedProxies   []string
+	// PasskeyOrigin fixes the passkey relying party; see
+	// config.Config.PasskeyOrigin.
+	PasskeyOrigin    string
+	// ArchiveEntries and ExtractSizeMB bound archive browsing and
+	// extraction; zero means the config defaults.
+	ArchiveEntries   int
+	ExtractSizeMB    int64
 }
 
 type Permissions struct {
@@ -32,6 +43,10 @@ type Permissions struct {
 	CanShare  bool `json:"canShare"`
 	CanAdmin  bool `json:"canAdmin"`
 	ReadOnly  bool `json:"readonly"`
+	// Home is the caller's home directory when home directories are on;
+	// with HomeOnly it is also the browse root.
+	Home     string `json:"home,omitempty"`
+	HomeOnly bool   `json:"homeOnly,omitempty"`
 }
 
 type fileEntry struct {
@@ -41,6 +56,18 @@ type fileEntry struct {
 	Size    int64     `json:"size"`
 	ModTime time.Time `json:"modTime"`
 	Ext     string    `json:"ext"`
+	// Files and SizePending only apply to directories: Size then holds the
+	// recursive size from the disk usage scanner, or 0 while it is unknown.
+	Files       int64 `json:"files,omitempty"`
+	SizePending bool  `json:"sizePending,omitempty"`
+}
+
+type manifestEntry struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256,omitempty"`
 }
 
 type breadcrumb struct {
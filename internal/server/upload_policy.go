@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// Reasons reported in uploadRejection.Reason.
+const (
+	rejectInvalidName    = "invalid_name"
+	rejectNameNotAllowed = "name_not_allowed"
+	rejectNameDenied     = "name_denied"
+	rejectTypeNotAllowed = "type_not_allowed"
+	rejectTypeDenied     = "type_denied"
+	rejectTooManyFiles   = "too_many_files"
+	rejectPathTooDeep    = "path_too_deep"
+	rejectInfected       = "infected"
+	rejectScanFailed     = "scan_failed"
+)
+
+// uploadRejection says why a file was refused by the upload policy. Reason is
+// one of the reject* codes; Detail holds what the rule matched on, such as
+// the sniffed content type or the exceeded limit.
+type uploadRejection struct {
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+	Message string `json:"message"`
+}
+
+func rejection(name, reason, detail, format string, args ...any) *uploadRejection {
+	return &uploadRejection{Name: name, Reason: reason, Detail: detail, Message: fmt.Sprintf(format, args...)}
+}
+
+// uploadPolicy holds the upload rules from the settings that are checked for
+// every file.
+type uploadPolicy struct {
+	allowRe    *regexp.Regexp
+	denyRe     *regexp.Regexp
+	allowTypes []string
+	denyTypes  []string
+	maxFiles   int
+	maxDepth   int
+	normalize  bool
+}
+
+func newUploadPolicy(settings db.AppSettings) (uploadPolicy, error) {
+	p := uploadPolicy{
+		allowTypes: util.ParseMIMEPatterns(settings.UploadAllowTypes),
+		denyTypes:  util.ParseMIMEPatterns(settings.UploadDenyTypes),
+		maxFiles:   settings.UploadMaxFiles,
+		maxDepth:   settings.UploadMaxDepth,
+		normalize:  settings.UploadNormalizeNames,
+	}
+	var err error
+	if strings.TrimSpace(settings.UploadAllowRegex) != "" {
+		if p.allowRe, err = regexp.Compile(settings.UploadAllowRegex); err != nil {
+			return uploadPolicy{}, errors.New("invalid allow regex")
+		}
+	}
+	if strings.TrimSpace(settings.UploadDenyRegex) != "" {
+		if p.denyRe, err = regexp.Compile(settings.UploadDenyRegex); err != nil {
+			return uploadPolicy{}, errors.New("invalid deny regex")
+		}
+	}
+	return p, nil
+}
+
+// count refuses the n-th file of a request beyond the file limit.
+func (p uploadPolicy) count(name string, n int) *uploadRejection {
+	if p.maxFiles > 0 && n > p.maxFiles {
+		return rejection(name, rejectTooManyFiles, strconv.Itoa(p.maxFiles), "too many files in one upload (max %d): %s", p.maxFiles, name)
+	}
+	return nil
+}
+
+// name returns the name to store an uploaded file under, normalized if the
+// settings ask for it, or why the name is refused.
+func (p uploadPolicy) name(filename string) (string, *uploadRejection) {
+	if filename == "." || filename == "" {
+		return "", rejection(filename, rejectInvalidName, "", "invalid filename")
+	}
+	if p.normalize {
+		normalized, err := util.NormalizeFilename(filename)
+		if err != nil {
+			return "", rejection(filename, rejectInvalidName, err.Error(), "invalid filename: %s", filename)
+		}
+		filename = normalized
+	}
+	if p.allowRe != nil && !p.allowRe.MatchString(filename) {
+		return "", rejection(filename, rejectNameNotAllowed, "", "rejected by allow policy: %s", filename)
+	}
+	if p.denyRe != nil && p.denyRe.MatchString(filename) {
+		return "", rejection(filename, rejectNameDenied, "", "rejected by deny policy: %s", filename)
+	}
+	return filename, nil
+}
+
+// depth refuses files whose root-relative path has more segments than
+// allowed.
+func (p uploadPolicy) depth(name, rel string) *uploadRejection {
+	if p.maxDepth <= 0 {
+		return nil
+	}
+	if n := strings.Count(rel, "/") + 1; n > p.maxDepth {
+		return rejection(name, rejectPathTooDeep, strconv.Itoa(p.maxDepth), "path too deep (max %d levels): %s", p.maxDepth, rel)
+	}
+	return nil
+}
+
+// sniff reads the start of src to check its real content type against the
+// type rules, and returns a reader that replays what it consumed.
+func (p uploadPolicy) sniff(name string, src io.Reader) (io.Reader, *uploadRejection, error) {
+	if len(p.allowTypes) == 0 && len(p.denyTypes) == 0 {
+		return src, nil, nil
+	}
+	head := make([]byte, util.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil, err
+	}
+	head = head[:n]
+	ctype := util.DetectContentType(head)
+	if len(p.allowTypes) > 0 && !util.MatchMIME(p.allowTypes, ctype) {
+		return nil, rejection(name, rejectTypeNotAllowed, ctype, "rejected by type policy: %s is %s", name, ctype), nil
+	}
+	if util.MatchMIME(p.denyTypes, ctype) {
+		return nil, rejection(name, rejectTypeDenied, ctype, "rejected by type policy: %s is %s", name, ctype), nil
+	}
+	return io.MultiReader(bytes.NewReader(head), src), nil, nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/config"
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestUploadPolicyRejections(t *testing.T) {
+	root := t.TempDir()
+	a := &App{rootAbs: root}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("path", "a/b")
+	for _, f := range []struct{ name, data string }{
+		{"photo.jpg", "\x7fELF\x02\x01\x01"},
+		{"ok.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"},
+		{"CON.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"},
+		{"third.png", "\x89PNG\r\n\x1a\n"},
+	} {
+		fw, _ := mw.CreateFormFile("files", f.name)
+		_, _ = fw.Write([]byte(f.data))
+	}
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/api/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	settings := db.AppSettings{
+		MaxUploadSizeMB:      1,
+		CollisionPolicy:      config.CollisionRename,
+		UploadAllowTypes:     "image/*",
+		UploadMaxFiles:       3,
+		UploadNormalizeNames: true,
+	}
+	res, err := a.consumeMultipartUpload(httptest.NewRecorder(), r, settings, "", false, pathScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Uploaded) != 2 || res.Uploaded[0] != "a/b/ok.png" || res.Uploaded[1] != "a/b/CON_.png" {
+		t.Fatalf("uploaded = %q", res.Uploaded)
+	}
+	if len(res.Rejected) != 2 || len(res.Errors) != 2 {
+		t.Fatalf("rejected = %+v, errors = %q", res.Rejected, res.Errors)
+	}
+	if got := res.Rejected[0]; got.Reason != rejectTypeNotAllowed || got.Detail != "application/x-elf" {
+		t.Fatalf("first rejection = %+v", got)
+	}
+	if got := res.Rejected[1]; got.Reason != rejectTooManyFiles || got.Name != "third.png" {
+		t.Fatalf("second rejection = %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "b", "photo.jpg")); !os.IsNotExist(err) {
+		t.Fatalf("rejected file written: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a", "b", "ok.png")); len(data) != 16 {
+		t.Fatalf("sniffed file stored %d bytes", len(data))
+	}
+}
+
+func TestUploadPolicyDepth(t *testing.T) {
+	p := uploadPolicy{maxDepth: 2}
+	if rej := p.depth("x", "a/x"); rej != nil {
+		t.Fatalf("depth 2 refused: %+v", rej)
+	}
+	if rej := p.depth("x", "a/b/x"); rej == nil || rej.Reason != rejectPathTooDeep {
+		t.Fatalf("depth 3 = %+v", rej)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+	"github.com/matthewsawatzky/sharehere/internal/util"
+)
+
+// replaceHook is called with the absolute path of a file that is about to be
+// replaced. The returned function must be called once the replacement has
+// either succeeded (true) or been abandoned (false). A nil hook does nothing.
+type replaceHook func(abs string) func(ok bool)
+
+func (h replaceHook) before(abs string) func(bool) {
+	if h == nil {
+		return func(bool) {}
+	}
+	return h(abs)
+}
+
+func (a *App) versionsDir() string {
+	return filepath.Join(a.opts.DataDir, "versions")
+}
+
+func versionMaxAge(settings db.AppSettings) time.Duration {
+	d, err := time.ParseDuration(settings.VersionMaxAge)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// versionHook returns the hook that keeps overwritten files as versions, or
+// nil when versioning is off.
+func (a *App) versionHook(settings db.AppSettings, createdBy *int64) replaceHook {
+	if !settings.VersioningEnabled {
+		return nil
+	}
+	return func(abs string) func(bool) {
+		v, err := a.captureVersion(abs, createdBy)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				a.logger.Warn("keep file version failed", "path", abs, "error", err)
+			}
+			return func(bool) {}
+		}
+		return func(ok bool) {
+			if !ok {
+				a.removeVersion(v)
+				return
+			}
+			a.pruneVersions(v.Path, settings)
+		}
+	}
+}
+
+// captureVersion stores the current content of abs in the versions store. A
+// hard link is used when the data dir shares a filesystem with the root, so
+// keeping a version costs no copy; the replacement is then written to a new
+// inode by writeUploadedFile's rename.
+func (a *App) captureVersion(abs string, createdBy *int64) (db.FileVersion, error) {
+	info, err := os.Lstat(abs)
+	if err != nil {
+		return db.FileVersion{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return db.FileVersion{}, os.ErrNotExist
+	}
+	rel, err := util.RelPathFromRoot(a.rootAbs, abs)
+	if err != nil {
+		return db.FileVersion{}, err
+	}
+	if err := os.MkdirAll(a.versionsDir(), 0o700); err != nil {
+		return db.FileVersion{}, err
+	}
+	blob, err := util.RandomToken(18)
+	if err != nil {
+		return db.FileVersion{}, err
+	}
+	blobPath := filepath.Join(a.versionsDir(), blob)
+	if err := os.Link(abs, blobPath); err != nil {
+		if err := copyVersionBlob(abs, blobPath); err != nil {
+			return db.FileVersion{}, err
+		}
+	}
+	v := db.FileVersion{Path: rel, Blob: blob, Size: info.Size(), ModTime: info.ModTime(), CreatedBy: createdBy}
+	if v.ID, err = a.store.CreateFileVersion(v); err != nil {
+		_ = os.Remove(blobPath)
+		return db.FileVersion{}, err
+	}
+	return v, nil
+}
+
+func copyVersionBlob(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func (a *App) removeVersion(v db.FileVersion) {
+	if err := a.store.DeleteFileVersion(v.ID); err != nil {
+		a.logger.Warn("delete file version failed", "id", v.ID, "error", err)
+		return
+	}
+	if err := os.Remove(filepath.Join(a.versionsDir(), v.Blob)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		a.logger.Warn("delete version blob failed", "id", v.ID, "error", err)
+	}
+}
+
+// pruneVersions applies the retention settings to the versions of one file:
+// at most VersionKeep are kept, and none older than VersionMaxAge.
+func (a *App) pruneVersions(rel string, settings db.AppSettings) {
+	versions, err := a.store.ListFileVersions(rel)
+	if err != nil {
+		return
+	}
+	maxAge := versionMaxAge(settings)
+	for i, v := range versions {
+		tooMany := settings.VersionKeep > 0 && int64(i) >= settings.VersionKeep
+		tooOld := maxAge > 0 && time.Since(v.CreatedAt) > maxAge
+		if tooMany || tooOld {
+			a.removeVersion(v)
+		}
+	}
+}
+
+// pruneAllVersions applies the retention settings to every stored version
+// and returns how many were removed.
+func (a *App) pruneAllVersions(settings db.AppSettings) int {
+	versions, err := a.store.ListAllFileVersions()
+	if err != nil {
+		return 0
+	}
+	byPath := map[string]int64{}
+	for _, v := range versions {
+		byPath[v.Path]++
+	}
+	maxAge := versionMaxAge(settings)
+	removed := 0
+	for _, v := range versions {
+		tooMany := settings.VersionKeep > 0 && byPath[v.Path] > settings.VersionKeep
+		tooOld := maxAge > 0 && time.Since(v.CreatedAt) > maxAge
+		if tooMany || tooOld {
+			a.removeVersion(v)
+			byPath[v.Path]--
+			removed++
+		}
+	}
+	return removed
+}
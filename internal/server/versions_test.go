@@ -0,0 +1,55 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewsawatzky/sharehere/internal/db"
+)
+
+func TestVersionHookKeepsAndPrunes(t *testing.T) {
+	root := t.TempDir()
+	dataDir := t.TempDir()
+	store, err := db.Open(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{rootAbs: root, store: store, opts: Options{DataDir: dataDir}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	settings := db.AppSettings{VersioningEnabled: true, VersionKeep: 2}
+	keep := a.versionHook(settings, nil)
+
+	target := filepath.Join(root, "notes.txt")
+	if err := os.WriteFile(target, []byte("v0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"v1", "v2", "v3"} {
+		finish := keep.before(target)
+		err := writeUploadedFile(target, strings.NewReader(body), nil)
+		finish(err == nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := store.ListFileVersions("notes.txt")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("versions = %d, %v", len(versions), err)
+	}
+	got, err := os.ReadFile(filepath.Join(a.versionsDir(), versions[0].Blob))
+	if err != nil || string(got) != "v2" {
+		t.Fatalf("newest version = %q, %v", got, err)
+	}
+
+	// An abandoned replacement must not leave a version behind.
+	keep.before(target)(false)
+	if versions, _ := store.ListFileVersions("notes.txt"); len(versions) != 2 {
+		t.Fatalf("expected abandoned version to be dropped, have %d", len(versions))
+	}
+	if n := a.pruneAllVersions(db.AppSettings{VersionKeep: 1}); n != 1 {
+		t.Fatalf("pruneAllVersions() = %d", n)
+	}
+}
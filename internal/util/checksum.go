@@ -0,0 +1,65 @@
+package util
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChecksumAlgorithms lists the digest names accepted by NewChecksum.
+var ChecksumAlgorithms = []string{"sha256", "sha1", "md5", "blake2b"}
+
+// NewChecksum returns a hash for one of ChecksumAlgorithms. blake2b is the
+// 256-bit variant.
+func NewChecksum(algo string) (hash.Hash, error) {
+	switch strings.ToLower(strings.TrimSpace(algo)) {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	case "blake2b":
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+}
+
+// ChecksumReader streams r through algo and returns the lowercase hex digest.
+func ChecksumReader(algo string, r io.Reader) (string, error) {
+	h, err := NewChecksum(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseChecksum splits an "algo:hexdigest" value. A bare 64-character digest
+// is treated as sha256.
+func ParseChecksum(value string) (algo, digest string, err error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	algo, digest, found := strings.Cut(value, ":")
+	if !found {
+		algo, digest = "sha256", value
+	}
+	h, err := NewChecksum(algo)
+	if err != nil {
+		return "", "", err
+	}
+	raw, err := hex.DecodeString(digest)
+	if err != nil || len(raw) != h.Size() {
+		return "", "", fmt.Errorf("invalid %s digest", algo)
+	}
+	return algo, digest, nil
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChecksumReader(t *testing.T) {
+	tests := map[string]string{
+		"sha256":  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"sha1":    "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+		"md5":     "5d41402abc4b2a76b9719d911017c592",
+		"blake2b": "324dcf027dd4a30a932c441f365a25e86b173defa4b8e58948253471b81b72cf",
+	}
+	for algo, want := range tests {
+		got, err := ChecksumReader(algo, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		if got != want {
+			t.Fatalf("%s digest = %s, want %s", algo, got, want)
+		}
+	}
+	if _, err := ChecksumReader("crc32", strings.NewReader("hello")); err == nil {
+		t.Fatalf("expected unsupported algorithm error")
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	algo, digest, err := ParseChecksum("MD5:5D41402ABC4B2A76B9719D911017C592")
+	if err != nil || algo != "md5" || digest != "5d41402abc4b2a76b9719d911017c592" {
+		t.Fatalf("ParseChecksum() = %q, %q, %v", algo, digest, err)
+	}
+	if algo, _, err := ParseChecksum("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"); err != nil || algo != "sha256" {
+		t.Fatalf("bare digest should default to sha256, got %q, %v", algo, err)
+	}
+	if _, _, err := ParseChecksum("sha1:abcd"); err == nil {
+		t.Fatalf("expected short digest to be rejected")
+	}
+}
@@ -0,0 +1,25 @@
+package util
+
+import "fmt"
+
+// DiskSpace describes the filesystem holding a path. Available is what an
+// unprivileged user can still write, which may be less than Free.
+type DiskSpace struct {
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`
+	Available uint64 `json:"available"`
+}
+
+// FormatBytes renders n with a binary unit, e.g. "1.5 GiB".
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
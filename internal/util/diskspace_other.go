@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package util
+
+import "errors"
+
+// GetDiskSpace is not implemented on this platform.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	return DiskSpace{}, errors.New("disk space is not available on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// GetDiskSpace reports the size and free space of the filesystem at path.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	bsize := uint64(st.Bsize)
+	return DiskSpace{
+		Total:     uint64(st.Blocks) * bsize,
+		Free:      uint64(st.Bfree) * bsize,
+		Available: uint64(st.Bavail) * bsize,
+	}, nil
+}
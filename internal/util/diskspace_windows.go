@@ -0,0 +1,18 @@
+//go:build windows
+
+package util
+
+import "golang.org/x/sys/windows"
+
+// GetDiskSpace reports the size and free space of the volume holding path.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &available, &total, &free); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{Total: total, Free: free, Available: available}, nil
+}
@@ -3,8 +3,11 @@ package util
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 	"sort"
+	"strings"
+	"unicode"
 )
 
 func buildURL(scheme, host string, port int, basePath string) string {
@@ -70,3 +73,42 @@ func DiscoverURLs(bind string, port int, https bool, basePath string) []string {
 	sort.Strings(urls)
 	return urls
 }
+
+// ParseIPList parses a list of CIDR prefixes and plain addresses separated by
+// commas or white space, such as "192.168.1.0/24, 10.0.0.7 fd00::/8". A plain
+// address stands for itself alone.
+func ParseIPList(s string) ([]netip.Prefix, error) {
+	var list []netip.Prefix
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if strings.Contains(f, "/") {
+			p, err := netip.ParsePrefix(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q", f)
+			}
+			if p.Addr().Is4In6() {
+				p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+			}
+			list = append(list, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", f)
+		}
+		addr = addr.Unmap()
+		list = append(list, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return list, nil
+}
+
+// IPListContains reports whether ip is in one of the networks of list.
+// IPv4-mapped IPv6 addresses count as the IPv4 address.
+func IPListContains(list []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range list {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
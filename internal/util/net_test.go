@@ -0,0 +1,36 @@
+package util
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseIPList(t *testing.T) {
+	list, err := ParseIPList("192.168.1.0/24, 10.0.0.7\nfd00::/8  ::ffff:172.16.0.0/112")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"192.168.1.200":    true,
+		"192.168.2.1":      false,
+		"10.0.0.7":         true,
+		"10.0.0.8":         false,
+		"::ffff:10.0.0.7":  true,
+		"fd12::1":          true,
+		"2001:db8::1":      false,
+		"172.16.3.4":       true,
+		"::ffff:192.0.2.1": false,
+	} {
+		if got := IPListContains(list, netip.MustParseAddr(ip)); got != want {
+			t.Errorf("IPListContains(%s) = %t, want %t", ip, got, want)
+		}
+	}
+	if list, err := ParseIPList(" ,\t"); err != nil || len(list) != 0 {
+		t.Fatalf("empty list = %v, %v", list, err)
+	}
+	for _, bad := range []string{"192.168.1.0/33", "example.com", "10.0.0.1/8/8"} {
+		if _, err := ParseIPList(bad); err == nil {
+			t.Errorf("ParseIPList(%q) accepted", bad)
+		}
+	}
+}
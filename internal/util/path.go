@@ -7,6 +7,9 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // NormalizeRelPath normalizes user input into a slash-separated, rooted-relative path.
@@ -102,3 +105,46 @@ func RelPathFromRoot(root, absolute string) (string, error) {
 	}
 	return rel, nil
 }
+
+// HomeDirRel returns the root-relative home directory of username under base.
+// The username must be usable as a single path segment.
+func HomeDirRel(base, username string) (string, error) {
+	name := strings.TrimSpace(username)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return "", fmt.Errorf("username %q cannot be used as a directory name", username)
+	}
+	return NormalizeRelPath(path.Join(NormalizeRelPath(base), name)), nil
+}
+
+// windowsReserved are device names Windows refuses as file names, with or
+// without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// NormalizeFilename makes name portable: Unicode NFC, characters Windows
+// forbids and control characters replaced with "_", trailing dots and spaces
+// trimmed, and reserved device names such as "CON.txt" suffixed with "_".
+func NormalizeFilename(name string) (string, error) {
+	name = norm.NFC.String(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return "", errors.New("empty file name")
+	}
+	stem, ext, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = stem + "_"
+		if ext != "" {
+			name += "." + ext
+		}
+	}
+	return name, nil
+}
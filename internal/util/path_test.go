@@ -31,3 +31,34 @@ func TestSafeJoinSymlinkEscape(t *testing.T) {
 		t.Fatalf("expected symlink escape to be rejected")
 	}
 }
+
+func TestHomeDirRel(t *testing.T) {
+	if got, err := HomeDirRel("/home/", "alice"); err != nil || got != "home/alice" {
+		t.Fatalf("HomeDirRel() = %q, %v", got, err)
+	}
+	for _, bad := range []string{"", "..", "a/b", `a\b`} {
+		if _, err := HomeDirRel("home", bad); err == nil {
+			t.Fatalf("HomeDirRel(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestNormalizeFilename(t *testing.T) {
+	cases := map[string]string{
+		"report.pdf":     "report.pdf",
+		"cafe\u0301.txt": "caf\u00e9.txt",
+		`a:b?c*.txt`:     "a_b_c_.txt",
+		"notes. . ":      "notes",
+		"CON":            "CON_",
+		"con.tar.gz":     "con_.tar.gz",
+		"console.log":    "console.log",
+	}
+	for in, want := range cases {
+		if got, err := NormalizeFilename(in); err != nil || got != want {
+			t.Errorf("NormalizeFilename(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := NormalizeFilename(" .. "); err == nil {
+		t.Fatal("empty name accepted")
+	}
+}
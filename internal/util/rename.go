@@ -0,0 +1,33 @@
+package util
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// RenameNoReplace renames oldpath to newpath unless something already exists
+// there, in which case it returns an error matching fs.ErrExist. Unlike a
+// check followed by os.Rename, two callers racing for the same newpath can't
+// both succeed.
+func RenameNoReplace(oldpath, newpath string) error {
+	return renameNoReplace(oldpath, newpath)
+}
+
+// renameNoReplaceFallback claims newpath with a hard link, which fails if
+// the name is taken, and then drops the old name. Directories can't be
+// linked, and neither can files on some filesystems; for those the check
+// and the rename are separate steps.
+func renameNoReplaceFallback(oldpath, newpath string) error {
+	err := os.Link(oldpath, newpath)
+	if err == nil {
+		return os.Remove(oldpath)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if _, err := os.Lstat(newpath); err == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrExist}
+	}
+	return os.Rename(oldpath, newpath)
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package util
+
+func renameNoReplace(oldpath, newpath string) error {
+	return renameNoReplaceFallback(oldpath, newpath)
+}
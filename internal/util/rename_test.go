@@ -0,0 +1,50 @@
+package util
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	taken := filepath.Join(dir, "taken")
+	for _, p := range []string{src, taken} {
+		if err := os.WriteFile(p, []byte(filepath.Base(p)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RenameNoReplace(src, taken); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("rename onto a file: %v", err)
+	}
+	if data, _ := os.ReadFile(taken); string(data) != "taken" {
+		t.Fatalf("existing file replaced: %q", data)
+	}
+	if err := RenameNoReplace(src, filepath.Join(dir, "free")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Fatalf("source left behind: %v", err)
+	}
+
+	for _, fn := range []func(string, string) error{RenameNoReplace, renameNoReplaceFallback} {
+		from, to := filepath.Join(dir, "d1"), filepath.Join(dir, "d2")
+		if err := os.Mkdir(from, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(to, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fn(from, to); !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("rename onto an empty directory: %v", err)
+		}
+		_ = os.Remove(to)
+		if err := fn(from, to); err != nil {
+			t.Fatalf("rename of a directory: %v", err)
+		}
+		_ = os.Remove(to)
+	}
+}
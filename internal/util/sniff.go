@@ -0,0 +1,92 @@
+package util
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SniffLen is how many leading bytes DetectContentType looks at.
+const SniffLen = 512
+
+// signatures are magic numbers http.DetectContentType doesn't know, mostly
+// executables and archive formats that upload policies care about.
+var signatures = []struct {
+	offset int
+	magic  []byte
+	mime   string
+}{
+	{0, []byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{0, []byte("\x7fELF"), "application/x-elf"},
+	{0, []byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{0, []byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{0, []byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xca\xfe\xba\xbe"), "application/java-vm"},
+	{0, []byte("#!"), "text/x-shellscript"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{0, []byte("SQLite format 3\x00"), "application/vnd.sqlite3"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "application/x-ole-storage"},
+	{257, []byte("ustar"), "application/x-tar"},
+}
+
+// DetectContentType returns the MIME type of a file from its first bytes,
+// without parameters. It knows the signatures of http.DetectContentType plus
+// executables, scripts and more archive formats.
+func DetectContentType(head []byte) string {
+	for _, s := range signatures {
+		if len(head) >= s.offset+len(s.magic) && bytes.Equal(head[s.offset:s.offset+len(s.magic)], s.magic) {
+			return s.mime
+		}
+	}
+	return BaseMIME(http.DetectContentType(head))
+}
+
+// BaseMIME strips parameters such as charset from a MIME type.
+func BaseMIME(v string) string {
+	if t, _, err := mime.ParseMediaType(v); err == nil {
+		return t
+	}
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(v, ";", 2)[0]))
+}
+
+// ParseMIMEPatterns splits a comma-separated list of MIME types or "type/*"
+// wildcards, as used by the upload type settings.
+func ParseMIMEPatterns(v string) []string {
+	out := make([]string, 0)
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ValidMIMEPattern reports whether p is "type/subtype", "type/*" or "*/*".
+func ValidMIMEPattern(p string) bool {
+	major, minor, ok := strings.Cut(p, "/")
+	if !ok || major == "" || minor == "" || strings.ContainsAny(p, " ;,") {
+		return false
+	}
+	return major != "*" || minor == "*"
+}
+
+// MatchMIME reports whether the MIME type t matches any of patterns.
+func MatchMIME(patterns []string, t string) bool {
+	t = BaseMIME(t)
+	major, _, _ := strings.Cut(t, "/")
+	for _, p := range patterns {
+		switch {
+		case p == "*/*", p == t:
+			return true
+		case strings.HasSuffix(p, "/*") && strings.TrimSuffix(p, "/*") == major:
+			return true
+		}
+	}
+	return false
+}
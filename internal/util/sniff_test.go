@@ -0,0 +1,42 @@
+package util
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	cases := map[string]string{
+		"MZ\x90\x00\x03\x00":        "application/vnd.microsoft.portable-executable",
+		"\x7fELF\x02\x01":           "application/x-elf",
+		"\x89PNG\r\n\x1a\n\x00\x00": "image/png",
+		"%PDF-1.7\n":                "application/pdf",
+		"#!/bin/sh\necho hi\n":      "text/x-shellscript",
+		"plain words":               "text/plain",
+		"\x00\x01\x02\x03\x04\x05":  "application/octet-stream",
+	}
+	for head, want := range cases {
+		if got := DetectContentType([]byte(head)); got != want {
+			t.Errorf("DetectContentType(%q) = %q, want %q", head, got, want)
+		}
+	}
+}
+
+func TestMatchMIME(t *testing.T) {
+	patterns := ParseMIMEPatterns(" image/* , Application/PDF,")
+	if len(patterns) != 2 {
+		t.Fatalf("patterns = %q", patterns)
+	}
+	for mime, want := range map[string]bool{
+		"image/png":                 true,
+		"application/pdf":           true,
+		"text/plain; charset=utf-8": false,
+		"imagex/png":                false,
+	} {
+		if got := MatchMIME(patterns, mime); got != want {
+			t.Errorf("MatchMIME(%q) = %v, want %v", mime, got, want)
+		}
+	}
+	for p, want := range map[string]bool{"image/*": true, "*/*": true, "*/png": false, "image": false, "a/b;c": false} {
+		if got := ValidMIMEPattern(p); got != want {
+			t.Errorf("ValidMIMEPattern(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package virusscan checks files with an external scanner: a shell command
+// or a ClamAV daemon.
+package virusscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of a successful scan. Signature names what was found
+// in an infected file, when the scanner says so.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner scans the file at path.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (Result, error)
+}
+
+// Command runs a shell command with the file in $SHAREHERE_FILE. Exit code 0
+// means clean and 1 infected, as with clamscan; the first line of output of
+// an infected scan is taken as the signature. Any other exit is an error.
+type Command struct {
+	Cmdline string
+}
+
+func (c Command) Scan(ctx context.Context, path string) (Result, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.Cmdline)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", c.Cmdline)
+	}
+	cmd.Env = append(os.Environ(), "SHAREHERE_FILE="+path)
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	err := cmd.Run()
+	if err == nil {
+		return Result{}, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		line, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+		return Result{Infected: true, Signature: strings.TrimSpace(line)}, nil
+	}
+	return Result{}, fmt.Errorf("scan command: %w", err)
+}
+
+// Clamd streams files to a ClamAV daemon with the INSTREAM command.
+type Clamd struct {
+	Network string
+	Address string
+}
+
+// ParseClamdAddress accepts "unix:/path/to/clamd.sock", "tcp:host:port", a
+// bare socket path starting with "/" or a bare "host:port".
+func ParseClamdAddress(v string) (Clamd, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case strings.HasPrefix(v, "unix:"):
+		return Clamd{Network: "unix", Address: strings.TrimPrefix(v, "unix:")}, nil
+	case strings.HasPrefix(v, "tcp:"):
+		v = strings.TrimPrefix(v, "tcp:")
+	case strings.HasPrefix(v, "/"):
+		return Clamd{Network: "unix", Address: v}, nil
+	}
+	if _, _, err := net.SplitHostPort(v); err != nil {
+		return Clamd{}, fmt.Errorf("invalid clamd address %q", v)
+	}
+	return Clamd{Network: "tcp", Address: v}, nil
+}
+
+const clamdChunk = 64 * 1024
+
+func (c Clamd) Scan(ctx context.Context, path string) (Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	}
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, err := f.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				return Result{}, fmt.Errorf("clamd: %w", werr)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	// Replies to z-prefixed commands end with a NUL.
+	reply, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadString(0)
+	if err != nil && reply == "" {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	_, status, ok := strings.Cut(reply, ": ")
+	if !ok {
+		return Result{}, fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	}
+	return Result{}, fmt.Errorf("clamd: %s", status)
+}
@@ -0,0 +1,117 @@
+package virusscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCommandExitCodes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	file := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if res, err := (Command{Cmdline: `test -f "$SHAREHERE_FILE"`}).Scan(ctx, file); err != nil || res.Infected {
+		t.Fatalf("clean = %+v, %v", res, err)
+	}
+	if res, err := (Command{Cmdline: "echo Test.Virus; exit 1"}).Scan(ctx, file); err != nil || !res.Infected || res.Signature != "Test.Virus" {
+		t.Fatalf("infected = %+v, %v", res, err)
+	}
+	if _, err := (Command{Cmdline: "exit 2"}).Scan(ctx, file); err == nil {
+		t.Fatal("exit 2 not reported as an error")
+	}
+}
+
+func TestParseClamdAddress(t *testing.T) {
+	cases := map[string]Clamd{
+		"unix:/run/clamd.sock": {Network: "unix", Address: "/run/clamd.sock"},
+		"/run/clamd.sock":      {Network: "unix", Address: "/run/clamd.sock"},
+		"tcp:127.0.0.1:3310":   {Network: "tcp", Address: "127.0.0.1:3310"},
+		"localhost:3310":       {Network: "tcp", Address: "localhost:3310"},
+	}
+	for in, want := range cases {
+		if got, err := ParseClamdAddress(in); err != nil || got != want {
+			t.Errorf("ParseClamdAddress(%q) = %+v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseClamdAddress("clamd"); err == nil {
+		t.Fatal("address without port accepted")
+	}
+}
+
+// fakeClamd answers INSTREAM requests, reporting streams that contain
+// "EICAR" as infected.
+func fakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var n uint32
+					if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+						return
+					}
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+						return
+					}
+				}
+				reply := "stream: OK\x00"
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					reply = "stream: Eicar-Test-Signature FOUND\x00"
+				}
+				_, _ = conn.Write([]byte(reply))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+	scanner, err := ParseClamdAddress(fakeClamd(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean")
+	infected := filepath.Join(dir, "infected")
+	_ = os.WriteFile(clean, bytes.Repeat([]byte("a"), 200*1024), 0o644)
+	_ = os.WriteFile(infected, []byte("X5O!P%@AP EICAR"), 0o644)
+	ctx := context.Background()
+	if res, err := scanner.Scan(ctx, clean); err != nil || res.Infected {
+		t.Fatalf("clean = %+v, %v", res, err)
+	}
+	if res, err := scanner.Scan(ctx, infected); err != nil || !res.Infected || res.Signature != "Eicar-Test-Signature" {
+		t.Fatalf("infected = %+v, %v", res, err)
+	}
+	if _, err := parseClamdReply("stream: INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Fatal("error reply not reported")
+	}
+}
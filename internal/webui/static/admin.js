@@ -7,8 +7,19 @@
     maxUploadSizeMB: document.getElementById("maxUploadSizeMB"),
     uploadAllowRegex: document.getElementById("uploadAllowRegex"),
     uploadDenyRegex: document.getElementById("uploadDenyRegex"),
+    uploadAllowTypes: document.getElementById("uploadAllowTypes"),
+    uploadDenyTypes: document.getElementById("uploadDenyTypes"),
+    uploadMaxFiles: document.getElementById("uploadMaxFiles"),
+    uploadMaxDepth: document.getElementById("uploadMaxDepth"),
+    uploadNormalizeNames: document.getElementById("uploadNormalizeNames"),
     uploadSubdir: document.getElementById("uploadSubdir"),
     collisionPolicy: document.getElementById("collisionPolicy"),
+    versioningEnabled: document.getElementById("versioningEnabled"),
+    versionKeep: document.getElementById("versionKeep"),
+    versionMaxAge: document.getElementById("versionMaxAge"),
+    homeDirsEnabled: document.getElementById("homeDirsEnabled"),
+    homeDirsPath: document.getElementById("homeDirsPath"),
+    usersSeeOnlyHome: document.getElementById("usersSeeOnlyHome"),
     defaultShareExpiry: document.getElementById("defaultShareExpiry"),
     allowDelete: document.getElementById("allowDelete"),
     allowRename: document.getElementById("allowRename"),
@@ -16,14 +27,59 @@
     theme: document.getElementById("theme"),
     themeOverridesJSON: document.getElementById("themeOverridesJSON"),
     virusScanCommand: document.getElementById("virusScanCommand"),
+    virusScanClamd: document.getElementById("virusScanClamd"),
+    virusScanSync: document.getElementById("virusScanSync"),
+    passkeyPolicy: document.getElementById("passkeyPolicy"),
+    passwordMinLength: document.getElementById("passwordMinLength"),
+    passwordMinClasses: document.getElementById("passwordMinClasses"),
+    passwordDenyUsername: document.getElementById("passwordDenyUsername"),
+    passwordBreachedList: document.getElementById("passwordBreachedList"),
+    openRegistration: document.getElementById("openRegistration"),
+    netGuestBrowseAllow: document.getElementById("netGuestBrowseAllow"),
+    netGuestBrowseDeny: document.getElementById("netGuestBrowseDeny"),
+    netGuestUploadAllow: document.getElementById("netGuestUploadAllow"),
+    netGuestUploadDeny: document.getElementById("netGuestUploadDeny"),
+    netLoginAllow: document.getElementById("netLoginAllow"),
+    netLoginDeny: document.getElementById("netLoginDeny"),
+    netShareAllow: document.getElementById("netShareAllow"),
+    netShareDeny: document.getElementById("netShareDeny"),
+    netAdminAllow: document.getElementById("netAdminAllow"),
+    netAdminDeny: document.getElementById("netAdminDeny"),
+    rateLimitIP: document.getElementById("rateLimitIP"),
+    rateLimitUser: document.getElementById("rateLimitUser"),
+    rateLimitBurst: document.getElementById("rateLimitBurst"),
+    downloadRateKiB: document.getElementById("downloadRateKiB"),
+    downloadTotalKiB: document.getElementById("downloadTotalKiB"),
+    uploadRateKiB: document.getElementById("uploadRateKiB"),
+    uploadTotalKiB: document.getElementById("uploadTotalKiB"),
+    downloadsPerClient: document.getElementById("downloadsPerClient"),
+    maintenanceInterval: document.getElementById("maintenanceInterval"),
+    vacuumInterval: document.getElementById("vacuumInterval"),
+    auditRetention: document.getElementById("auditRetention"),
     saveSettings: document.getElementById("saveSettings"),
     settingsStatus: document.getElementById("settingsStatus"),
     newUsername: document.getElementById("newUsername"),
     newRole: document.getElementById("newRole"),
     newPassword: document.getElementById("newPassword"),
     createUser: document.getElementById("createUser"),
+    viewAsGuest: document.getElementById("viewAsGuest"),
+    viewAsChanges: document.getElementById("viewAsChanges"),
     userRows: document.getElementById("userRows"),
+    newInviteRole: document.getElementById("newInviteRole"),
+    newInviteExpiry: document.getElementById("newInviteExpiry"),
+    newInviteHome: document.getElementById("newInviteHome"),
+    createInvite: document.getElementById("createInvite"),
+    inviteLink: document.getElementById("inviteLink"),
+    inviteRows: document.getElementById("inviteRows"),
+    sessionRows: document.getElementById("sessionRows"),
     linkRows: document.getElementById("linkRows"),
+    newDropBoxPath: document.getElementById("newDropBoxPath"),
+    newDropBoxPerUploader: document.getElementById("newDropBoxPerUploader"),
+    createDropBox: document.getElementById("createDropBox"),
+    dropBoxRows: document.getElementById("dropBoxRows"),
+    quarantineRows: document.getElementById("quarantineRows"),
+    refreshThrottle: document.getElementById("refreshThrottle"),
+    throttleRows: document.getElementById("throttleRows"),
     refreshAudit: document.getElementById("refreshAudit"),
     auditRows: document.getElementById("auditRows")
   };
@@ -70,8 +126,19 @@
     els.maxUploadSizeMB.value = s.max_upload_size_mb;
     els.uploadAllowRegex.value = s.upload_allow_regex;
     els.uploadDenyRegex.value = s.upload_deny_regex;
+    els.uploadAllowTypes.value = s.upload_allow_types || "";
+    els.uploadDenyTypes.value = s.upload_deny_types || "";
+    els.uploadMaxFiles.value = s.upload_max_files ?? 0;
+    els.uploadMaxDepth.value = s.upload_max_depth ?? 0;
+    els.uploadNormalizeNames.checked = !!s.upload_normalize_names;
     els.uploadSubdir.value = s.upload_subdir;
     els.collisionPolicy.value = s.collision_policy;
+    els.versioningEnabled.checked = !!s.versioning_enabled;
+    els.versionKeep.value = s.version_keep ?? 10;
+    els.versionMaxAge.value = s.version_max_age || "";
+    els.homeDirsEnabled.checked = !!s.home_dirs_enabled;
+    els.homeDirsPath.value = s.home_dirs_path || "home";
+    els.usersSeeOnlyHome.checked = !!s.users_see_only_home;
     els.defaultShareExpiry.value = s.default_share_expiry;
     els.allowDelete.checked = !!s.allow_delete;
     els.allowRename.checked = !!s.allow_rename;
@@ -79,6 +146,35 @@
     els.theme.value = s.theme;
     els.themeOverridesJSON.value = s.theme_overrides_json || "{}";
     els.virusScanCommand.value = s.virus_scan_command || "";
+    els.virusScanClamd.value = s.virus_scan_clamd || "";
+    els.virusScanSync.checked = !!s.virus_scan_sync;
+    els.passkeyPolicy.value = s.passkey_policy || "optional";
+    els.passwordMinLength.value = s.password_min_length || 8;
+    els.passwordMinClasses.value = String(s.password_min_classes || 0);
+    els.passwordDenyUsername.checked = !!s.password_deny_username;
+    els.passwordBreachedList.value = s.password_breached_list || "";
+    els.openRegistration.checked = !!s.open_registration;
+    els.netGuestBrowseAllow.value = s.net_guest_browse_allow || "";
+    els.netGuestBrowseDeny.value = s.net_guest_browse_deny || "";
+    els.netGuestUploadAllow.value = s.net_guest_upload_allow || "";
+    els.netGuestUploadDeny.value = s.net_guest_upload_deny || "";
+    els.netLoginAllow.value = s.net_login_allow || "";
+    els.netLoginDeny.value = s.net_login_deny || "";
+    els.netShareAllow.value = s.net_share_allow || "";
+    els.netShareDeny.value = s.net_share_deny || "";
+    els.netAdminAllow.value = s.net_admin_allow || "";
+    els.netAdminDeny.value = s.net_admin_deny || "";
+    els.rateLimitIP.value = s.rate_limit_ip || 0;
+    els.rateLimitUser.value = s.rate_limit_user || 0;
+    els.rateLimitBurst.value = s.rate_limit_burst || 0;
+    els.downloadRateKiB.value = s.download_rate_kib || 0;
+    els.downloadTotalKiB.value = s.download_total_kib || 0;
+    els.uploadRateKiB.value = s.upload_rate_kib || 0;
+    els.uploadTotalKiB.value = s.upload_total_kib || 0;
+    els.downloadsPerClient.value = s.downloads_per_client || 0;
+    els.maintenanceInterval.value = s.maintenance_interval || "";
+    els.vacuumInterval.value = s.vacuum_interval || "";
+    els.auditRetention.value = s.audit_retention || "";
   }
 
   async function saveSettings() {
@@ -87,15 +183,55 @@
       max_upload_size_mb: Number(els.maxUploadSizeMB.value || 1024),
       upload_allow_regex: els.uploadAllowRegex.value,
       upload_deny_regex: els.uploadDenyRegex.value,
+      upload_allow_types: els.uploadAllowTypes.value,
+      upload_deny_types: els.uploadDenyTypes.value,
+      upload_max_files: Number(els.uploadMaxFiles.value || 0),
+      upload_max_depth: Number(els.uploadMaxDepth.value || 0),
+      upload_normalize_names: els.uploadNormalizeNames.checked,
       upload_subdir: els.uploadSubdir.value,
       collision_policy: els.collisionPolicy.value,
+      versioning_enabled: els.versioningEnabled.checked,
+      version_keep: Number(els.versionKeep.value || 0),
+      version_max_age: els.versionMaxAge.value,
+      home_dirs_enabled: els.homeDirsEnabled.checked,
+      home_dirs_path: els.homeDirsPath.value,
+      users_see_only_home: els.usersSeeOnlyHome.checked,
       default_share_expiry: els.defaultShareExpiry.value,
       allow_delete: els.allowDelete.checked,
       allow_rename: els.allowRename.checked,
       read_only: els.readOnly.checked,
       theme: els.theme.value,
       theme_overrides_json: els.themeOverridesJSON.value,
-      virus_scan_command: els.virusScanCommand.value
+      virus_scan_command: els.virusScanCommand.value,
+      virus_scan_clamd: els.virusScanClamd.value,
+      virus_scan_sync: els.virusScanSync.checked,
+      passkey_policy: els.passkeyPolicy.value,
+      password_min_length: Number(els.passwordMinLength.value || 8),
+      password_min_classes: Number(els.passwordMinClasses.value || 0),
+      password_deny_username: els.passwordDenyUsername.checked,
+      password_breached_list: els.passwordBreachedList.value,
+      open_registration: els.openRegistration.checked,
+      net_guest_browse_allow: els.netGuestBrowseAllow.value,
+      net_guest_browse_deny: els.netGuestBrowseDeny.value,
+      net_guest_upload_allow: els.netGuestUploadAllow.value,
+      net_guest_upload_deny: els.netGuestUploadDeny.value,
+      net_login_allow: els.netLoginAllow.value,
+      net_login_deny: els.netLoginDeny.value,
+      net_share_allow: els.netShareAllow.value,
+      net_share_deny: els.netShareDeny.value,
+      net_admin_allow: els.netAdminAllow.value,
+      net_admin_deny: els.netAdminDeny.value,
+      rate_limit_ip: Number(els.rateLimitIP.value || 0),
+      rate_limit_user: Number(els.rateLimitUser.value || 0),
+      rate_limit_burst: Number(els.rateLimitBurst.value || 0),
+      download_rate_kib: Number(els.downloadRateKiB.value || 0),
+      download_total_kib: Number(els.downloadTotalKiB.value || 0),
+      upload_rate_kib: Number(els.uploadRateKiB.value || 0),
+      upload_total_kib: Number(els.uploadTotalKiB.value || 0),
+      downloads_per_client: Number(els.downloadsPerClient.value || 0),
+      maintenance_interval: els.maintenanceInterval.value,
+      vacuum_interval: els.vacuumInterval.value,
+      audit_retention: els.auditRetention.value
     };
     await api("/api/admin/settings", {
       method: "POST",
@@ -108,8 +244,9 @@
 
   function rowForUser(u) {
     const tr = document.createElement("tr");
-    tr.innerHTML = `<td>${u.username}</td><td>${u.role}</td><td>${u.disabled ? "disabled" : "active"}</td><td></td>`;
-    const actions = tr.children[3];
+    const status = u.pending ? "awaiting approval" : u.disabled ? "disabled" : "active";
+    tr.innerHTML = `<td>${u.username}</td><td>${u.role}</td><td>${u.source || "local"}</td><td>${status}</td><td></td>`;
+    const actions = tr.children[4];
     const wrap = document.createElement("div");
     wrap.className = "row";
 
@@ -117,7 +254,7 @@
     passwd.className = "button ghost";
     passwd.textContent = "Set password";
     passwd.onclick = async () => {
-      const value = window.prompt(`New password for ${u.username}`);
+      const value = window.prompt(`Temporary password for ${u.username} (they choose their own when they next sign in)`);
       if (!value) return;
       await api("/api/admin/users/password", {
         method: "POST",
@@ -139,6 +276,19 @@
       await loadUsers();
     };
 
+    const passkeys = document.createElement("button");
+    passkeys.className = "button ghost";
+    passkeys.textContent = "Reset passkeys";
+    passkeys.onclick = async () => {
+      if (!window.confirm(`Remove all passkeys of ${u.username}?`)) return;
+      const result = await api("/api/admin/passkeys/reset", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ username: u.username })
+      });
+      window.alert(`Removed ${result.removed} passkey(s)`);
+    };
+
     const remove = document.createElement("button");
     remove.className = "button ghost";
     remove.textContent = "Remove";
@@ -152,7 +302,41 @@
       await loadUsers();
     };
 
-    wrap.append(passwd, toggle, remove);
+    const viewAs = document.createElement("button");
+    viewAs.className = "button ghost";
+    viewAs.textContent = "View as";
+    viewAs.onclick = () => startViewAs({ username: u.username }).catch((e) => window.alert(e.message || e));
+
+    const settle = (approve) => async () => {
+      if (!approve && !window.confirm(`Reject and remove ${u.username}?`)) return;
+      await api("/api/admin/users/approve", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ username: u.username, approve })
+      });
+      await loadUsers();
+    };
+
+    if (u.pending) {
+      const approve = document.createElement("button");
+      approve.className = "button";
+      approve.textContent = "Approve";
+      approve.onclick = settle(true);
+      const reject = document.createElement("button");
+      reject.className = "button ghost";
+      reject.textContent = "Reject";
+      reject.onclick = settle(false);
+      wrap.append(approve, reject);
+    } else if (u.source === "ldap") {
+      // Directory users' passwords live in the directory.
+      wrap.append(toggle, passkeys, remove);
+    } else {
+      wrap.append(passwd, toggle, passkeys, remove);
+    }
+    // Other admins can't be viewed as; their view is the admin's own.
+    if (!u.pending && !u.disabled && u.role !== "admin") {
+      wrap.prepend(viewAs);
+    }
     actions.appendChild(wrap);
     return tr;
   }
@@ -163,6 +347,17 @@
     result.users.forEach((u) => els.userRows.appendChild(rowForUser(u)));
   }
 
+  // startViewAs switches this browser to a session as another user or a
+  // guest; the banner on the file browser leads back here.
+  async function startViewAs(target) {
+    const result = await api("/api/admin/impersonate", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(Object.assign({ allow_changes: els.viewAsChanges.checked }, target))
+    });
+    window.location.href = result.redirect;
+  }
+
   async function createUser() {
     const payload = {
       username: els.newUsername.value,
@@ -179,6 +374,70 @@
     await loadUsers();
   }
 
+  function inviteURL(path) {
+    return new URL(path, window.location.origin).href;
+  }
+
+  function rowForInvite(inv) {
+    const tr = document.createElement("tr");
+    const expires = new Date(inv.expires_at);
+    let status = "open";
+    if (inv.used_at) {
+      status = `used by ${inv.used_by || "?"}`;
+    } else if (expires < new Date()) {
+      status = "expired";
+    }
+    tr.innerHTML = `<td><code></code></td><td>${inv.role}</td><td>${inv.create_home ? "yes" : "no"}</td><td>${expires.toLocaleString()}</td><td></td><td></td>`;
+    tr.children[0].firstChild.textContent = `${inv.token.slice(0, 8)}…`;
+    tr.children[4].textContent = status;
+
+    const wrap = document.createElement("div");
+    wrap.className = "row";
+    if (status === "open") {
+      const copy = document.createElement("button");
+      copy.className = "button ghost";
+      copy.textContent = "Copy link";
+      copy.onclick = async () => {
+        const url = inviteURL(`${basePath}/register?invite=${encodeURIComponent(inv.token)}`);
+        await navigator.clipboard.writeText(url).catch(() => window.prompt("Invitation link", url));
+      };
+      wrap.appendChild(copy);
+    }
+    const revoke = document.createElement("button");
+    revoke.className = "button ghost";
+    revoke.textContent = status === "open" ? "Revoke" : "Remove";
+    revoke.onclick = async () => {
+      if (status === "open" && !window.confirm("Revoke this invitation?")) return;
+      await api("/api/admin/invitations/delete", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token: inv.token })
+      });
+      await loadInvites();
+    };
+    wrap.appendChild(revoke);
+    tr.children[5].appendChild(wrap);
+    return tr;
+  }
+
+  async function loadInvites() {
+    const result = await api("/api/admin/invitations");
+    els.inviteRows.innerHTML = "";
+    result.invitations.forEach((inv) => els.inviteRows.appendChild(rowForInvite(inv)));
+  }
+
+  async function createInvite() {
+    const result = await api("/api/admin/invitations", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ role: els.newInviteRole.value, expiry: els.newInviteExpiry.value, create_home: els.newInviteHome.checked })
+    });
+    els.inviteLink.textContent = `Send this link to the invitee: ${inviteURL(result.url)}`;
+    els.newInviteExpiry.value = "";
+    els.newInviteHome.checked = false;
+    await loadInvites();
+  }
+
   function rowForLink(link) {
     const tr = document.createElement("tr");
     const expires = new Date(link.expires_at).toLocaleString();
@@ -211,12 +470,163 @@
     return tr;
   }
 
+  function rowForSession(s) {
+    const tr = document.createElement("tr");
+    const created = new Date(s.created_at).toLocaleString();
+    const seen = new Date(s.last_seen_at).toLocaleString();
+    tr.innerHTML = `<td></td><td></td><td class="small"></td><td>${created}</td><td>${seen}</td><td></td>`;
+    tr.children[0].textContent = s.current ? `${s.username} (you)` : s.username;
+    tr.children[1].textContent = s.ip || "";
+    tr.children[2].textContent = s.user_agent || "";
+
+    const wrap = document.createElement("div");
+    wrap.className = "row";
+    const revoke = document.createElement("button");
+    revoke.className = "button ghost";
+    revoke.textContent = "Sign out";
+    revoke.onclick = async () => {
+      await api("/api/admin/sessions/revoke", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: s.id })
+      });
+      if (s.current) {
+        window.location.href = `${basePath}/login`;
+        return;
+      }
+      await loadSessions();
+    };
+    const revokeAll = document.createElement("button");
+    revokeAll.className = "button ghost";
+    revokeAll.textContent = "Sign out user everywhere";
+    revokeAll.onclick = async () => {
+      if (!window.confirm(`Sign ${s.username} out of every device?`)) return;
+      await api("/api/admin/sessions/revoke", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ username: s.username })
+      });
+      await loadSessions();
+    };
+    wrap.append(revoke, revokeAll);
+    tr.children[5].appendChild(wrap);
+    return tr;
+  }
+
+  async function loadSessions() {
+    const result = await api("/api/admin/sessions");
+    els.sessionRows.innerHTML = "";
+    result.sessions.forEach((s) => els.sessionRows.appendChild(rowForSession(s)));
+  }
+
   async function loadLinks() {
     const result = await api("/api/admin/links");
     els.linkRows.innerHTML = "";
     result.links.forEach((l) => els.linkRows.appendChild(rowForLink(l)));
   }
 
+  function rowForDropBox(box) {
+    const tr = document.createElement("tr");
+    const created = new Date(box.created_at).toLocaleString();
+    tr.innerHTML = `<td><code>${box.path}</code></td><td>${box.per_uploader ? "yes" : "no"}</td><td>${created}</td><td></td>`;
+    const remove = document.createElement("button");
+    remove.className = "button ghost";
+    remove.textContent = "Remove";
+    remove.onclick = async () => {
+      if (!window.confirm(`Stop using ${box.path} as a drop box? Its contents become visible again.`)) return;
+      await api("/api/admin/dropboxes/delete", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ path: box.path })
+      });
+      await loadDropBoxes();
+    };
+    tr.children[3].appendChild(remove);
+    return tr;
+  }
+
+  async function loadDropBoxes() {
+    const result = await api("/api/admin/dropboxes");
+    els.dropBoxRows.innerHTML = "";
+    result.dropBoxes.forEach((b) => els.dropBoxRows.appendChild(rowForDropBox(b)));
+  }
+
+  async function createDropBox() {
+    await api("/api/admin/dropboxes", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ path: els.newDropBoxPath.value, perUploader: els.newDropBoxPerUploader.checked })
+    });
+    els.newDropBoxPath.value = "";
+    els.newDropBoxPerUploader.checked = false;
+    await loadDropBoxes();
+  }
+
+  function rowForQuarantined(q) {
+    const tr = document.createElement("tr");
+    const created = new Date(q.created_at).toLocaleString();
+    tr.innerHTML = `<td><code></code></td><td></td><td>${q.size}</td><td></td><td>${created}</td><td></td>`;
+    tr.children[0].firstChild.textContent = q.path;
+    tr.children[1].textContent = q.signature || "unknown";
+    tr.children[3].textContent = q.username || "anonymous";
+
+    const wrap = document.createElement("div");
+    wrap.className = "row";
+    const release = document.createElement("button");
+    release.className = "button ghost";
+    release.textContent = "Release";
+    release.onclick = async () => {
+      if (!window.confirm(`Release ${q.path}? It was flagged as ${q.signature || "infected"}.`)) return;
+      await api("/api/admin/quarantine/release", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: q.id })
+      });
+      await loadQuarantine();
+    };
+    const remove = document.createElement("button");
+    remove.className = "button ghost";
+    remove.textContent = "Delete";
+    remove.onclick = async () => {
+      if (!window.confirm(`Delete ${q.path} for good?`)) return;
+      await api("/api/admin/quarantine/delete", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: q.id })
+      });
+      await loadQuarantine();
+    };
+    wrap.append(release, remove);
+    tr.children[5].appendChild(wrap);
+    return tr;
+  }
+
+  async function loadQuarantine() {
+    const result = await api("/api/admin/quarantine");
+    els.quarantineRows.innerHTML = "";
+    result.files.forEach((q) => els.quarantineRows.appendChild(rowForQuarantined(q)));
+  }
+
+  async function loadThrottle() {
+    const { stats } = await api("/api/admin/throttle");
+    const rows = [
+      ["Requests refused, per-address limit", stats.rate_limited_ip],
+      ["Requests refused, per-user limit", stats.rate_limited_user],
+      ["Downloads refused, too many at once", stats.downloads_rejected],
+      ["Downloads slowed by bandwidth caps", stats.downloads_slowed],
+      ["Uploads slowed by bandwidth caps", stats.uploads_slowed],
+      ["Downloads running now", stats.active_downloads]
+    ];
+    els.throttleRows.innerHTML = "";
+    rows.forEach(([label, value]) => {
+      const tr = document.createElement("tr");
+      tr.innerHTML = "<td></td><td></td>";
+      tr.children[0].textContent = label;
+      tr.children[1].textContent = String(value);
+      els.throttleRows.appendChild(tr);
+    });
+  }
+
   async function loadAudit() {
     const result = await api("/api/admin/audit?limit=200");
     els.auditRows.innerHTML = "";
@@ -239,11 +649,20 @@
     await loadThemes();
     await loadSettings();
     await loadUsers();
+    await loadInvites();
+    await loadSessions();
     await loadLinks();
+    await loadDropBoxes();
+    await loadQuarantine();
+    await loadThrottle();
     await loadAudit();
 
     els.saveSettings.onclick = () => saveSettings().catch((e) => window.alert(e.message || e));
     els.createUser.onclick = () => createUser().catch((e) => window.alert(e.message || e));
+    els.viewAsGuest.onclick = () => startViewAs({ guest: true }).catch((e) => window.alert(e.message || e));
+    els.createInvite.onclick = () => createInvite().catch((e) => window.alert(e.message || e));
+    els.createDropBox.onclick = () => createDropBox().catch((e) => window.alert(e.message || e));
+    els.refreshThrottle.onclick = () => loadThrottle().catch((e) => window.alert(e.message || e));
     els.refreshAudit.onclick = () => loadAudit().catch((e) => window.alert(e.message || e));
   }
 
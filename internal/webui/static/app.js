@@ -10,7 +10,37 @@
     gridView: document.getElementById("gridView"),
     listView: document.getElementById("listView"),
     entrySummary: document.getElementById("entrySummary"),
+    usageSummary: document.getElementById("usageSummary"),
+    usageBtn: document.getElementById("usageBtn"),
+    usageModal: document.getElementById("usageModal"),
+    usageMeta: document.getElementById("usageMeta"),
+    usageBars: document.getElementById("usageBars"),
+    usageUp: document.getElementById("usageUp"),
+    usageOpen: document.getElementById("usageOpen"),
+    usageClose: document.getElementById("usageClose"),
+    versionsModal: document.getElementById("versionsModal"),
+    versionsTitle: document.getElementById("versionsTitle"),
+    versionsMeta: document.getElementById("versionsMeta"),
+    versionsList: document.getElementById("versionsList"),
+    versionsClose: document.getElementById("versionsClose"),
+    conflictModal: document.getElementById("conflictModal"),
+    conflictList: document.getElementById("conflictList"),
+    conflictApply: document.getElementById("conflictApply"),
+    conflictCancel: document.getElementById("conflictCancel"),
+    selectionBar: document.getElementById("selectionBar"),
+    selectionCount: document.getElementById("selectionCount"),
+    selectAll: document.getElementById("selectAll"),
+    selZipBtn: document.getElementById("selZipBtn"),
+    selMoveBtn: document.getElementById("selMoveBtn"),
+    selCopyBtn: document.getElementById("selCopyBtn"),
+    selShareBtn: document.getElementById("selShareBtn"),
+    selDeleteBtn: document.getElementById("selDeleteBtn"),
+    selClearBtn: document.getElementById("selClearBtn"),
     previewPane: document.getElementById("previewPane"),
+    checksumPane: document.getElementById("checksumPane"),
+    checksumAlgo: document.getElementById("checksumAlgo"),
+    checksumBtn: document.getElementById("checksumBtn"),
+    checksumValue: document.getElementById("checksumValue"),
     searchInput: document.getElementById("searchInput"),
     sortSelect: document.getElementById("sortSelect"),
     refreshBtn: document.getElementById("refreshBtn"),
@@ -19,7 +49,9 @@
     gridViewBtn: document.getElementById("gridViewBtn"),
     uploadPanel: document.getElementById("uploadPanel"),
     toggleUploadBtn: document.getElementById("toggleUploadBtn"),
+    newFolderBtn: document.getElementById("newFolderBtn"),
     downloadFolderLink: document.getElementById("downloadFolderLink"),
+    archiveFormat: document.getElementById("archiveFormat"),
     dropZone: document.getElementById("dropZone"),
     fileInput: document.getElementById("fileInput"),
     uploadProgress: document.getElementById("uploadProgress"),
@@ -34,7 +66,14 @@
     remoteBase: document.getElementById("remoteBase"),
     logoutForm: document.getElementById("logoutForm"),
     logoutCsrf: document.getElementById("logoutCsrf"),
-    adminLink: document.getElementById("adminLink")
+    impersonationBanner: document.getElementById("impersonationBanner"),
+    impersonationText: document.getElementById("impersonationText"),
+    impersonationCsrf: document.getElementById("impersonationCsrf"),
+    adminLink: document.getElementById("adminLink"),
+    notificationsBtn: document.getElementById("notificationsBtn"),
+    sessionsBtn: document.getElementById("sessionsBtn"),
+    passkeysLink: document.getElementById("passkeysLink"),
+    passwordLink: document.getElementById("passwordLink")
   };
 
   const storageKeys = {
@@ -45,10 +84,24 @@
   const state = {
     me: null,
     entries: [],
+    total: 0,
+    hiddenCount: 0,
+    nextCursor: "",
+    listSeq: 0,
+    loadingMore: false,
+    rowHeight: { list: 41, grid: 96 },
     path: qs.get("path") || "",
     selectedRelPath: "",
+    checksumRelPath: "",
+    selection: new Set(),
+    lastSelectedIndex: -1,
+    archive: "",
+    dropBox: null,
+    notifications: [],
+    usagePath: "",
     showHidden: false,
     viewMode: "list",
+    archiveFormat: "zip",
     uploadVisible: false
   };
 
@@ -130,6 +183,9 @@
         state.viewMode = data.viewMode;
       }
       state.showHidden = data.showHidden === true;
+      if (typeof data.archiveFormat === "string" && data.archiveFormat) {
+        state.archiveFormat = data.archiveFormat;
+      }
     } catch (_) {
       // ignore corrupt local storage
     }
@@ -138,7 +194,8 @@
   function saveUIPreferences() {
     localStorage.setItem(storageKeys.uiPrefs, JSON.stringify({
       viewMode: state.viewMode,
-      showHidden: state.showHidden
+      showHidden: state.showHidden,
+      archiveFormat: state.archiveFormat
     }));
   }
 
@@ -149,13 +206,13 @@
     els.listViewBtn.classList.toggle("active", state.viewMode === "list");
     els.gridViewBtn.classList.toggle("active", state.viewMode === "grid");
     saveUIPreferences();
+    renderEntries();
   }
 
   function setShowHidden(showHidden) {
     state.showHidden = !!showHidden;
     els.showHiddenToggle.checked = state.showHidden;
     saveUIPreferences();
-    renderEntries();
   }
 
   function setUploadVisibility(visible) {
@@ -166,9 +223,44 @@
     }
   }
 
+  function isArchiveName(name) {
+    return /\.(zip|tar|tar\.gz|tgz|tar\.zst|tzst)$/i.test(name || "");
+  }
+
+  async function extractArchive(entry) {
+    const suggested = entry.relPath.replace(/\.(zip|tar|tar\.gz|tgz|tar\.zst|tzst)$/i, "");
+    const destination = window.prompt("Extract into folder (path from share root)", suggested);
+    if (destination === null) {
+      return;
+    }
+    const result = await postJSON("/api/extract", { path: entry.relPath, destination: destination.trim() });
+    const lines = [`Extracted ${result.files} file(s) into /${result.destination}`].concat(result.errors || []);
+    window.alert(lines.join("\n"));
+    await loadList(state.path);
+  }
+
+  function archiveURL(relPath) {
+    return `${basePath}/api/zip?path=${encodeURIComponent(relPath)}&format=${encodeURIComponent(state.archiveFormat)}`;
+  }
+
+  function setArchiveFormat(format) {
+    const known = Array.from(els.archiveFormat.options).some((option) => option.value === format);
+    state.archiveFormat = known ? format : "zip";
+    els.archiveFormat.value = state.archiveFormat;
+    refreshPathActions();
+    saveUIPreferences();
+  }
+
   function refreshPathActions() {
-    const encoded = encodeURIComponent(state.path || "");
-    els.downloadFolderLink.href = `${basePath}/api/zip?path=${encoded}`;
+    els.downloadFolderLink.href = archiveURL(state.path || "");
+    els.downloadFolderLink.classList.toggle("hidden", !!state.archive);
+    els.usageBtn.classList.toggle("hidden", !!state.archive);
+    const canUpload = !!state.me?.permissions?.canUpload && !state.archive;
+    els.newFolderBtn.classList.toggle("hidden", !canUpload);
+    els.toggleUploadBtn.classList.toggle("hidden", !canUpload);
+    if (!canUpload) {
+      setUploadVisibility(false);
+    }
   }
 
   function formatSize(bytes) {
@@ -183,6 +275,92 @@
     return `${n.toFixed(1)} ${units[i]}`;
   }
 
+  // sizeLabel shows a folder's recursive size once the server has scanned it.
+  function sizeLabel(entry) {
+    if (!entry.isDir) {
+      return formatSize(entry.size);
+    }
+    if (entry.sizePending) {
+      return "…";
+    }
+    return entry.files ? `${formatSize(entry.size)} (${entry.files} files)` : formatSize(entry.size);
+  }
+
+  function diskText(disk) {
+    return disk ? `Disk: ${formatSize(disk.available)} free of ${formatSize(disk.total)}` : "";
+  }
+
+  async function loadUsageSummary() {
+    if (state.archive) {
+      els.usageSummary.textContent = "";
+      return;
+    }
+    const data = await api(`/api/du?path=${encodeURIComponent(state.path)}&depth=0`);
+    const parts = [];
+    if (data.usage) {
+      parts.push(`This folder: ${formatSize(data.usage.size)} in ${data.usage.files} files`);
+    } else {
+      parts.push("Calculating folder sizes…");
+    }
+    const disk = diskText(data.disk);
+    if (disk) {
+      parts.push(disk);
+    }
+    els.usageSummary.textContent = parts.join(" | ");
+  }
+
+  function usageRow(node, total) {
+    const row = document.createElement(node.isDir ? "button" : "div");
+    row.className = `usage-row${node.isDir ? " is-dir" : ""}`;
+    if (node.isDir) {
+      row.type = "button";
+      row.addEventListener("click", () => {
+        openUsage(node.path).catch((err) => window.alert(String(err.message || err)));
+      });
+    }
+    const fill = document.createElement("div");
+    fill.className = "usage-fill";
+    fill.style.width = `${total > 0 ? Math.max(0.5, (node.size / total) * 100) : 0}%`;
+    const name = document.createElement("span");
+    name.textContent = node.other ? `(${node.files} ${node.name})` : node.isDir ? `${node.name}/` : node.name;
+    const size = document.createElement("span");
+    size.className = "muted";
+    size.textContent = node.isDir ? `${formatSize(node.size)} · ${node.files} files` : formatSize(node.size);
+    row.appendChild(fill);
+    row.appendChild(name);
+    row.appendChild(size);
+    return row;
+  }
+
+  // openUsage shows the disk usage explorer for a folder: one bar per child,
+  // scaled to the folder's total. Clicking a folder drills into it.
+  async function openUsage(pathValue) {
+    const data = await api(`/api/du?path=${encodeURIComponent(pathValue || "")}&depth=1`);
+    state.usagePath = data.path || "";
+    els.usageUp.disabled = state.usagePath === "";
+    els.usageBars.innerHTML = "";
+    const where = state.usagePath || "/";
+    if (!data.usage) {
+      els.usageMeta.textContent = `Still scanning ${where}; try again shortly.`;
+    } else {
+      const meta = [`${where}: ${formatSize(data.usage.size)} in ${data.usage.files} files and ${data.usage.dirs || 0} folders`];
+      if (data.scanning) {
+        meta.push("rescan in progress");
+      }
+      const disk = diskText(data.disk);
+      if (disk) {
+        meta.push(disk);
+      }
+      els.usageMeta.textContent = meta.join(" | ");
+      (data.usage.children || []).forEach((child) => {
+        els.usageBars.appendChild(usageRow(child, data.usage.size));
+      });
+    }
+    if (!els.usageModal.open) {
+      els.usageModal.showModal();
+    }
+  }
+
   function copyText(value) {
     navigator.clipboard.writeText(value).catch(() => {});
   }
@@ -217,53 +395,39 @@
     });
   }
 
-  function sortEntries(entries) {
-    const mode = els.sortSelect.value;
-    const list = [...entries];
-    if (mode === "date") {
-      list.sort((a, b) => new Date(b.modTime).getTime() - new Date(a.modTime).getTime());
-    } else if (mode === "size") {
-      list.sort((a, b) => b.size - a.size);
-    } else {
-      list.sort((a, b) => a.name.localeCompare(b.name));
-    }
-    list.sort((a, b) => Number(b.isDir) - Number(a.isDir));
-    return list;
-  }
-
-  function filterBySearch(entries) {
-    const q = (els.searchInput.value || "").trim().toLowerCase();
-    if (!q) {
-      return entries;
-    }
-    return entries.filter((entry) => entry.name.toLowerCase().includes(q));
-  }
-
   function isHiddenEntry(entry) {
     return entry.name.startsWith(".");
   }
 
+  // currentEntries returns the entries fetched so far, already sorted and
+  // filtered by the server.
   function currentEntries() {
-    let list = sortEntries(state.entries);
-    if (!state.showHidden) {
-      list = list.filter((entry) => !isHiddenEntry(entry));
-    }
-    return filterBySearch(list);
+    return state.entries;
   }
 
-  function renderEntrySummary(visibleCount) {
-    const total = state.entries.length;
-    const hiddenCount = state.entries.filter(isHiddenEntry).length;
+  function renderEntrySummary() {
     const current = state.path || "/";
+    const filter = (els.searchInput.value || "").trim();
 
-    if (total === 0) {
-      els.entrySummary.textContent = `Path ${current} is empty.`;
+    if (state.dropBox && !state.dropBox.owned && state.total === 0) {
+      els.entrySummary.textContent = `${current} is a drop box: files you upload here are only visible to its owner.`;
       return;
     }
 
-    let summary = `${visibleCount} of ${total} items in ${current}`;
-    if (!state.showHidden && hiddenCount > 0) {
-      summary += ` (${hiddenCount} hidden)`;
+    if (state.total === 0) {
+      els.entrySummary.textContent = filter ? `No items in ${current} match "${filter}".` : `Path ${current} is empty.`;
+      return;
+    }
+
+    let summary = `${state.total} items in ${current}`;
+    if (filter) {
+      summary = `${state.total} items in ${current} match "${filter}"`;
+    }
+    if (state.entries.length < state.total) {
+      summary += ` (${state.entries.length} loaded)`;
+    }
+    if (!state.showHidden && state.hiddenCount > 0) {
+      summary += ` (${state.hiddenCount} hidden)`;
     }
     els.entrySummary.textContent = summary;
   }
@@ -307,8 +471,9 @@
     if (!expiry) {
       return;
     }
-    const mode = window.prompt("Mode: browse, download, upload", "browse") || "browse";
-    const payload = { path: entry.relPath, expiry, mode };
+    const mode = window.prompt("Mode: browse, download, upload, dropbox", "browse") || "browse";
+    const perUploader = mode === "dropbox" && window.confirm("Put each uploader's files in their own subfolder?");
+    const payload = { path: entry.relPath, expiry, mode, perUploader };
     const result = await api("/api/share/create", {
       method: "POST",
       headers: { "Content-Type": "application/json" },
@@ -318,21 +483,172 @@
     window.alert(`Share link created and copied:\n${result.url}`);
   }
 
-  async function preview(entry) {
+  function resetChecksum(entry) {
+    state.checksumRelPath = entry ? entry.relPath : "";
+    els.checksumValue.textContent = "";
+    els.checksumPane.classList.toggle("hidden", !entry);
+  }
+
+  async function computeChecksum() {
+    if (!state.checksumRelPath) {
+      return;
+    }
+    const algo = els.checksumAlgo.value;
+    els.checksumValue.textContent = "Computing...";
+    try {
+      const result = await api(`/api/checksum?path=${encodeURIComponent(state.checksumRelPath)}&algo=${encodeURIComponent(algo)}`);
+      els.checksumValue.textContent = `${result.algo}: ${result.checksum}`;
+    } catch (err) {
+      els.checksumValue.textContent = String(err.message || err);
+    }
+  }
+
+  function previewNote(text) {
+    const note = document.createElement("p");
+    note.className = "preview-note";
+    note.textContent = text;
+    return note;
+  }
+
+  function mediaElement(tag, result) {
+    const media = document.createElement(tag);
+    media.controls = true;
+    media.preload = "metadata";
+    const source = document.createElement("source");
+    source.src = result.src;
+    source.type = result.mime;
+    media.appendChild(source);
+    return media;
+  }
+
+  function previewTable(entry, result) {
+    const wrap = document.createElement("div");
+    const table = document.createElement("table");
+    table.className = "preview-table";
+    const head = document.createElement("tr");
+    (result.columns || []).forEach((column) => {
+      const th = document.createElement("th");
+      th.textContent = column;
+      head.appendChild(th);
+    });
+    const thead = document.createElement("thead");
+    thead.appendChild(head);
+    const tbody = document.createElement("tbody");
+    (result.rows || []).forEach((row) => {
+      const tr = document.createElement("tr");
+      row.forEach((cell) => {
+        const td = document.createElement("td");
+        td.textContent = cell;
+        tr.appendChild(td);
+      });
+      tbody.appendChild(tr);
+    });
+    table.appendChild(thead);
+    table.appendChild(tbody);
+    wrap.appendChild(table);
+
+    const pager = document.createElement("div");
+    pager.className = "preview-pager";
+    const first = result.page * result.pageSize + 1;
+    const label = document.createElement("span");
+    label.textContent = result.rows.length ? `Rows ${first}–${first + result.rows.length - 1}` : "No rows";
+    if (result.page > 0) {
+      const prev = document.createElement("button");
+      prev.type = "button";
+      prev.className = "button ghost";
+      prev.textContent = "Previous";
+      prev.addEventListener("click", () => preview(entry, result.page - 1));
+      pager.appendChild(prev);
+    }
+    pager.appendChild(label);
+    if (result.hasMore) {
+      const next = document.createElement("button");
+      next.type = "button";
+      next.className = "button ghost";
+      next.textContent = "Next";
+      next.addEventListener("click", () => preview(entry, result.page + 1));
+      pager.appendChild(next);
+    }
+    wrap.appendChild(pager);
+    return wrap;
+  }
+
+  // renderPreview draws a descriptor from /api/preview. Markdown and code
+  // arrive as HTML that the server has already sanitized or escaped.
+  function renderPreview(entry, result) {
+    els.previewPane.innerHTML = "";
+    const pane = els.previewPane;
+    switch (result.type) {
+      case "image": {
+        const img = document.createElement("img");
+        img.alt = entry.name;
+        img.src = result.src;
+        pane.appendChild(img);
+        break;
+      }
+      case "pdf": {
+        const frame = document.createElement("iframe");
+        frame.title = entry.name;
+        frame.src = result.src;
+        pane.appendChild(frame);
+        const open = document.createElement("a");
+        open.href = result.src;
+        open.target = "_blank";
+        open.rel = "noopener";
+        open.textContent = "Open PDF in a new tab";
+        pane.appendChild(open);
+        break;
+      }
+      case "audio":
+      case "video":
+        pane.appendChild(mediaElement(result.type, result));
+        break;
+      case "markdown": {
+        const body = document.createElement("div");
+        body.className = "markdown-body";
+        body.innerHTML = result.html;
+        pane.appendChild(body);
+        break;
+      }
+      case "code": {
+        const code = document.createElement("div");
+        code.innerHTML = result.html;
+        pane.appendChild(code);
+        pane.appendChild(previewNote(`Highlighted as ${result.language}`));
+        break;
+      }
+      case "table":
+        pane.appendChild(previewTable(entry, result));
+        break;
+      case "hex":
+        pane.textContent = result.content;
+        pane.appendChild(previewNote(`Hex dump of the first bytes (${formatSize(result.size)} total)`));
+        break;
+      case "text":
+        pane.textContent = result.content;
+        break;
+      default:
+        pane.textContent = "No preview available for this file type.";
+    }
+    if (result.truncated) {
+      pane.appendChild(previewNote("Preview truncated. Download the file to see all of it."));
+    }
+  }
+
+  async function preview(entry, page = 0) {
     if (entry.isDir) {
       navigate(entry.relPath);
       return;
     }
+    if (state.archive) {
+      window.location.href = `${basePath}/api/download?path=${encodeURIComponent(entry.relPath)}`;
+      return;
+    }
+    resetChecksum(entry);
 
     try {
-      const result = await api(`/api/preview?path=${encodeURIComponent(entry.relPath)}`);
-      if (result.type === "image") {
-        els.previewPane.innerHTML = `<img alt="preview" src="${basePath}/api/download?path=${encodeURIComponent(entry.relPath)}" />`;
-      } else if (result.type === "text") {
-        els.previewPane.textContent = result.content;
-      } else {
-        els.previewPane.textContent = "No preview available for this file type.";
-      }
+      const result = await api(`/api/preview?path=${encodeURIComponent(entry.relPath)}&page=${page}`);
+      renderPreview(entry, result);
       state.selectedRelPath = entry.relPath;
       renderCommandsForSelection();
     } catch (err) {
@@ -374,6 +690,211 @@
     return a;
   }
 
+  function selectedPaths() {
+    return Array.from(state.selection);
+  }
+
+  function renderSelection() {
+    const count = state.selection.size;
+    const perms = state.me?.permissions || {};
+    els.selectionBar.classList.toggle("hidden", count === 0 || !!state.archive);
+    els.selectAll.classList.toggle("hidden", !!state.archive);
+    els.selectionCount.textContent = `${count} selected`;
+    els.selMoveBtn.classList.toggle("hidden", !perms.canRename);
+    els.selCopyBtn.classList.toggle("hidden", !perms.canUpload);
+    els.selShareBtn.classList.toggle("hidden", !perms.canShare);
+    els.selDeleteBtn.classList.toggle("hidden", !perms.canDelete);
+
+    const visible = currentEntries();
+    const selectedVisible = visible.filter((entry) => state.selection.has(entry.relPath)).length;
+    els.selectAll.checked = visible.length > 0 && selectedVisible === visible.length;
+    els.selectAll.indeterminate = selectedVisible > 0 && selectedVisible < visible.length;
+
+    document.querySelectorAll("[data-rel-path]").forEach((node) => {
+      const selected = state.selection.has(node.dataset.relPath);
+      node.classList.toggle("selected", selected);
+      const box = node.querySelector(".select-box");
+      if (box) {
+        box.checked = selected;
+      }
+    });
+  }
+
+  function clearSelection() {
+    state.selection.clear();
+    state.lastSelectedIndex = -1;
+    renderSelection();
+  }
+
+  // toggleSelection handles a checkbox click; with shift held it applies the
+  // new state to every visible entry between the last click and this one.
+  function toggleSelection(entry, checked, shiftKey) {
+    const visible = currentEntries();
+    const index = visible.findIndex((value) => value.relPath === entry.relPath);
+    if (shiftKey && state.lastSelectedIndex >= 0 && index >= 0) {
+      const [from, to] = index < state.lastSelectedIndex ? [index, state.lastSelectedIndex] : [state.lastSelectedIndex, index];
+      visible.slice(from, to + 1).forEach((value) => {
+        if (checked) {
+          state.selection.add(value.relPath);
+        } else {
+          state.selection.delete(value.relPath);
+        }
+      });
+    } else if (checked) {
+      state.selection.add(entry.relPath);
+    } else {
+      state.selection.delete(entry.relPath);
+    }
+    state.lastSelectedIndex = index;
+    renderSelection();
+  }
+
+  function selectBox(entry) {
+    const box = document.createElement("input");
+    box.type = "checkbox";
+    box.className = "select-box";
+    box.checked = state.selection.has(entry.relPath);
+    box.setAttribute("aria-label", `Select ${entry.name}`);
+    box.addEventListener("click", (event) => {
+      event.stopPropagation();
+      toggleSelection(entry, box.checked, event.shiftKey);
+    });
+    return box;
+  }
+
+  function downloadSelectionZip() {
+    const form = document.createElement("form");
+    form.method = "POST";
+    form.action = `${basePath}/api/zip`;
+    form.className = "hidden";
+    const fields = [["_csrf", state.me?.csrfToken || ""], ["format", state.archiveFormat]];
+    selectedPaths().forEach((relPath) => fields.push(["paths", relPath]));
+    fields.forEach(([name, value]) => {
+      const input = document.createElement("input");
+      input.type = "hidden";
+      input.name = name;
+      input.value = value;
+      form.appendChild(input);
+    });
+    document.body.appendChild(form);
+    form.submit();
+    form.remove();
+  }
+
+  async function shareSelection() {
+    const expiry = window.prompt("Expiry duration", "24h");
+    if (!expiry) {
+      return;
+    }
+    const mode = window.prompt("Mode: browse, download, upload, dropbox", "browse") || "browse";
+    const perUploader = mode === "dropbox" && window.confirm("Put each uploader's files in their own subfolder?");
+    const result = await postJSON("/api/share/create", { paths: selectedPaths(), expiry, mode, perUploader });
+    const lines = (result.links || []).map((link) => `${link.path}: ${link.url}`);
+    if (lines.length) {
+      copyText(lines.join("\n"));
+    }
+    window.alert([`Created ${lines.length} share link(s), copied to clipboard:`, ...lines, ...(result.errors || [])].join("\n"));
+  }
+
+  async function deleteSelection() {
+    const paths = selectedPaths();
+    if (!window.confirm(`Delete ${paths.length} selected item(s)?`)) {
+      return;
+    }
+    const result = await postJSON("/api/delete", { paths });
+    reportBatch(result);
+    clearSelection();
+    await loadList(state.path);
+  }
+
+  // openVersions lists the kept versions of a file with download and restore
+  // actions. Restoring keeps the current content as a version too.
+  async function openVersions(entry) {
+    const data = await api(`/api/versions?path=${encodeURIComponent(entry.relPath)}`);
+    const versions = data.versions || [];
+    els.versionsTitle.textContent = `Versions of ${entry.name}`;
+    els.versionsList.innerHTML = "";
+    if (versions.length === 0) {
+      els.versionsMeta.textContent = data.versioning ? "No earlier versions have been kept yet." : "Versioning is turned off; no earlier versions are kept.";
+    } else {
+      els.versionsMeta.textContent = `${versions.length} earlier version${versions.length === 1 ? "" : "s"}, newest first.`;
+    }
+    versions.forEach((version) => {
+      const row = document.createElement("div");
+      row.className = "usage-row";
+      const label = document.createElement("span");
+      label.textContent = `${new Date(version.created_at).toLocaleString()} · ${formatSize(version.size)}`;
+      const actions = document.createElement("span");
+      actions.className = "row";
+      const download = document.createElement("a");
+      download.className = "button ghost";
+      download.href = `${basePath}/api/versions/download?id=${version.id}`;
+      download.textContent = "Download";
+      actions.appendChild(download);
+      if (state.me?.permissions?.canUpload) {
+        const restore = document.createElement("button");
+        restore.type = "button";
+        restore.className = "button ghost";
+        restore.textContent = "Restore";
+        restore.addEventListener("click", async () => {
+          if (!window.confirm(`Replace ${entry.name} with the version from ${new Date(version.created_at).toLocaleString()}?`)) {
+            return;
+          }
+          try {
+            await postJSON("/api/versions/restore", { id: version.id });
+            els.versionsModal.close();
+            await loadList(state.path);
+          } catch (err) {
+            window.alert(String(err.message || err));
+          }
+        });
+        actions.appendChild(restore);
+      }
+      row.appendChild(label);
+      row.appendChild(actions);
+      els.versionsList.appendChild(row);
+    });
+    els.versionsModal.showModal();
+  }
+
+  function postJSON(path, payload) {
+    return api(path, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(payload)
+    });
+  }
+
+  function reportBatch(result) {
+    if (result?.errors?.length) {
+      window.alert(result.errors.join("\n"));
+    }
+  }
+
+  async function transferEntries(op, relPaths) {
+    const verb = op === "move" ? "Move" : "Copy";
+    const destination = window.prompt(`${verb} to folder (path from share root)`, state.path || "");
+    if (destination === null) {
+      return;
+    }
+    const result = await postJSON(`/api/${op}`, { paths: relPaths, destination: destination.trim() });
+    reportBatch(result);
+    if (op === "move") {
+      relPaths.forEach((relPath) => state.selection.delete(relPath));
+    }
+    await loadList(state.path);
+  }
+
+  async function createFolder() {
+    const name = window.prompt("New folder name");
+    if (!name || !name.trim()) {
+      return;
+    }
+    const relPath = state.path ? `${state.path}/${name.trim()}` : name.trim();
+    await postJSON("/api/mkdir", { path: relPath });
+    await loadList(state.path);
+  }
+
   function buildActionMenu(entry) {
     const details = document.createElement("details");
     details.className = "action-menu";
@@ -392,10 +913,34 @@
       menu.appendChild(actionButton("Preview file", async () => preview(entry)));
     }
 
+    if (state.archive) {
+      // Members of an archive are read-only: offer downloads of files only.
+      if (!entry.isDir) {
+        menu.appendChild(actionLink("Download", `${basePath}/api/download?path=${encodeURIComponent(entry.relPath)}`));
+      }
+      menu.appendChild(actionButton("Copy name", async () => copyText(entry.name)));
+      menu.appendChild(actionButton("Copy path", async () => copyText(entry.relPath || "")));
+      details.appendChild(summary);
+      details.appendChild(menu);
+      return details;
+    }
+
     menu.appendChild(actionLink("Download", `${basePath}/api/download?path=${encodeURIComponent(entry.relPath)}`));
 
+    if (!entry.isDir && isArchiveName(entry.name)) {
+      menu.appendChild(actionButton("Browse archive", async () => navigate(`${entry.relPath}!`)));
+      if (state.me?.permissions?.canUpload) {
+        menu.appendChild(actionButton("Extract…", async () => extractArchive(entry)));
+      }
+    }
+
+    if (!entry.isDir) {
+      menu.appendChild(actionButton("Versions", async () => openVersions(entry)));
+    }
+
     if (entry.isDir) {
-      menu.appendChild(actionLink("Download ZIP", `${basePath}/api/zip?path=${encodeURIComponent(entry.relPath)}`));
+      menu.appendChild(actionLink(`Download ${state.archiveFormat}`, archiveURL(entry.relPath)));
+      menu.appendChild(actionLink("Checksums (SHA-256)", `${basePath}/api/checksum?path=${encodeURIComponent(entry.relPath)}&algo=sha256`));
     }
 
     menu.appendChild(actionButton("Copy name", async () => copyText(entry.name)));
@@ -425,6 +970,14 @@
       }));
     }
 
+    if (state.me?.permissions?.canRename) {
+      menu.appendChild(actionButton("Move to…", async () => transferEntries("move", [entry.relPath])));
+    }
+
+    if (state.me?.permissions?.canUpload) {
+      menu.appendChild(actionButton("Copy to…", async () => transferEntries("copy", [entry.relPath])));
+    }
+
     if (state.me?.permissions?.canDelete) {
       menu.appendChild(actionButton("Delete", async () => {
         if (!window.confirm(`Delete ${entry.name}?`)) {
@@ -447,6 +1000,13 @@
   function rowFor(entry) {
     const tr = document.createElement("tr");
     tr.className = "gh-row";
+    tr.dataset.relPath = entry.relPath;
+
+    const selectCell = document.createElement("td");
+    selectCell.className = "select-cell";
+    if (!state.archive) {
+      selectCell.appendChild(selectBox(entry));
+    }
 
     const nameCell = document.createElement("td");
     const fileCell = document.createElement("div");
@@ -482,7 +1042,7 @@
     nameCell.appendChild(fileCell);
 
     const sizeCell = document.createElement("td");
-    sizeCell.textContent = entry.isDir ? "-" : formatSize(entry.size);
+    sizeCell.textContent = sizeLabel(entry);
 
     const modCell = document.createElement("td");
     modCell.textContent = new Date(entry.modTime).toLocaleString();
@@ -490,6 +1050,7 @@
     const actionsCell = document.createElement("td");
     actionsCell.appendChild(buildActionMenu(entry));
 
+    tr.appendChild(selectCell);
     tr.appendChild(nameCell);
     tr.appendChild(sizeCell);
     tr.appendChild(modCell);
@@ -500,6 +1061,7 @@
   function cardFor(entry) {
     const card = document.createElement("article");
     card.className = "file-card";
+    card.dataset.relPath = entry.relPath;
 
     const head = document.createElement("div");
     head.className = "file-card-head";
@@ -524,6 +1086,9 @@
       }
     });
 
+    if (!state.archive) {
+      left.appendChild(selectBox(entry));
+    }
     left.appendChild(icon);
     left.appendChild(name);
     head.appendChild(left);
@@ -535,7 +1100,7 @@
 
     const meta = document.createElement("p");
     meta.className = "file-card-meta muted small";
-    const sizeText = entry.isDir ? "-" : formatSize(entry.size);
+    const sizeText = sizeLabel(entry);
     const modText = new Date(entry.modTime).toLocaleString();
     meta.textContent = `Size: ${sizeText} | Updated: ${modText}`;
 
@@ -544,27 +1109,165 @@
     return card;
   }
 
-  function renderEntries() {
-    const entries = currentEntries();
-    renderEntrySummary(entries.length);
+  const listPageSize = 500;
+  const overscanRows = 8;
+
+  function listURL(pathValue, cursor) {
+    const params = new URLSearchParams({
+      path: pathValue || "",
+      sort: els.sortSelect.value || "name",
+      hidden: state.showHidden ? "1" : "0",
+      limit: String(listPageSize)
+    });
+    const filter = (els.searchInput.value || "").trim();
+    if (filter) {
+      params.set("q", filter);
+    }
+    if (cursor) {
+      params.set("cursor", cursor);
+    }
+    return `/api/list?${params.toString()}`;
+  }
 
-    els.fileRows.innerHTML = "";
-    els.gridView.innerHTML = "";
+  function spacerFor(view, height) {
+    if (view === "list") {
+      const tr = document.createElement("tr");
+      tr.className = "virtual-spacer";
+      const td = document.createElement("td");
+      td.colSpan = 5;
+      td.style.height = `${height}px`;
+      tr.appendChild(td);
+      return tr;
+    }
+    const div = document.createElement("div");
+    div.className = "virtual-spacer";
+    div.style.height = `${height}px`;
+    return div;
+  }
 
-    entries.forEach((entry) => {
-      els.fileRows.appendChild(rowFor(entry));
-      els.gridView.appendChild(cardFor(entry));
+  function gridColumns() {
+    const template = window.getComputedStyle(els.gridView).gridTemplateColumns || "";
+    return Math.max(1, template.split(" ").filter(Boolean).length);
+  }
+
+  // renderEntries draws only the rows near the viewport. Spacers stand in for
+  // the rest, sized from the server's total so the scrollbar covers the whole
+  // folder, and scrolling towards unloaded rows fetches the next page.
+  function renderEntries() {
+    renderEntrySummary();
+    const view = state.viewMode;
+    const container = view === "list" ? els.fileRows : els.gridView;
+    const other = view === "list" ? els.gridView : els.fileRows;
+    other.innerHTML = "";
+
+    const columns = view === "list" ? 1 : gridColumns();
+    const gap = view === "list" ? 0 : parseFloat(window.getComputedStyle(els.gridView).rowGap) || 0;
+    const rowHeight = state.rowHeight[view];
+    const totalRows = Math.ceil(state.total / columns);
+    const top = container.getBoundingClientRect().top + window.scrollY;
+    const first = Math.max(0, Math.floor((window.scrollY - top) / rowHeight) - overscanRows);
+    const last = Math.min(totalRows, Math.ceil((window.scrollY + window.innerHeight - top) / rowHeight) + overscanRows);
+
+    const startIndex = Math.min(first * columns, state.entries.length);
+    const endIndex = Math.min(last * columns, state.entries.length);
+    const renderedRows = Math.ceil((endIndex - startIndex) / columns);
+    const beforeRows = Math.floor(startIndex / columns);
+    const afterRows = Math.max(0, totalRows - beforeRows - renderedRows);
+
+    const fragment = document.createDocumentFragment();
+    if (beforeRows > 0) {
+      fragment.appendChild(spacerFor(view, beforeRows * rowHeight - gap));
+    }
+    state.entries.slice(startIndex, endIndex).forEach((entry) => {
+      fragment.appendChild(view === "list" ? rowFor(entry) : cardFor(entry));
     });
+    if (afterRows > 0) {
+      fragment.appendChild(spacerFor(view, afterRows * rowHeight - gap));
+    }
+    container.replaceChildren(fragment);
+    renderSelection();
+
+    const sample = container.querySelector("[data-rel-path]");
+    if (sample) {
+      const measured = sample.getBoundingClientRect().height + gap;
+      if (measured > 0 && Math.abs(measured - rowHeight) > 1) {
+        state.rowHeight[view] = measured;
+        scheduleRender();
+      }
+    }
+    if (last * columns > state.entries.length && state.nextCursor) {
+      loadMore().catch((err) => {
+        els.entrySummary.textContent = `Failed to load more: ${err.message || err}`;
+      });
+    }
+  }
+
+  let renderPending = false;
+  function scheduleRender() {
+    if (renderPending) {
+      return;
+    }
+    renderPending = true;
+    window.requestAnimationFrame(() => {
+      renderPending = false;
+      renderEntries();
+    });
+  }
+
+  async function loadMore() {
+    if (state.loadingMore || !state.nextCursor) {
+      return;
+    }
+    state.loadingMore = true;
+    const seq = state.listSeq;
+    try {
+      const data = await api(listURL(state.path, state.nextCursor));
+      if (seq !== state.listSeq) {
+        return;
+      }
+      state.entries = state.entries.concat(data.entries || []);
+      state.total = data.total ?? state.entries.length;
+      state.hiddenCount = data.hiddenCount || 0;
+      state.nextCursor = data.nextCursor || "";
+    } finally {
+      state.loadingMore = false;
+    }
+    scheduleRender();
   }
 
   async function loadList(pathValue) {
-    const data = await api(`/api/list?path=${encodeURIComponent(pathValue || "")}`);
+    const seq = ++state.listSeq;
+    const data = await api(listURL(pathValue, ""));
+    if (seq !== state.listSeq) {
+      return;
+    }
+    if ((data.path || "") !== state.path) {
+      state.selection.clear();
+      state.lastSelectedIndex = -1;
+    }
     state.path = data.path || "";
     state.entries = data.entries || [];
+    state.total = data.total ?? state.entries.length;
+    state.hiddenCount = data.hiddenCount || 0;
+    state.nextCursor = data.nextCursor || "";
+    state.loadingMore = false;
+    state.archive = data.archive || "";
+    state.dropBox = data.dropBox || null;
+    if (!state.nextCursor) {
+      const present = new Set(state.entries.map((entry) => entry.relPath));
+      state.selection.forEach((relPath) => {
+        if (!present.has(relPath)) {
+          state.selection.delete(relPath);
+        }
+      });
+    }
 
     refreshPathActions();
     renderBreadcrumbs(data.breadcrumbs || []);
     renderEntries();
+    loadUsageSummary().catch(() => {
+      els.usageSummary.textContent = "";
+    });
 
     if (state.selectedRelPath && !state.entries.find((entry) => entry.relPath === state.selectedRelPath)) {
       state.selectedRelPath = "";
@@ -578,43 +1281,129 @@
     history.replaceState({}, "", nextURL);
   }
 
-  async function uploadFiles(fileList) {
-    if (!fileList.length) {
-      return;
-    }
-
+  // sendUpload posts files to the current folder. choices maps a file name to
+  // a collision policy sent as a per-file override.
+  function sendUpload(files, choices) {
     const form = new FormData();
     form.append("path", state.path || "");
-    Array.from(fileList).forEach((file) => form.append("files", file));
-
-    const xhr = new XMLHttpRequest();
-    xhr.open("POST", `${basePath}/api/upload`);
-    if (state.me?.csrfToken) {
-      xhr.setRequestHeader("X-CSRF-Token", state.me.csrfToken);
-    }
-
-    xhr.upload.onprogress = (event) => {
-      if (!event.lengthComputable) {
-        return;
+    files.forEach((file) => {
+      if (choices?.has(file.name)) {
+        form.append("collision", choices.get(file.name));
       }
-      const pct = Math.round((event.loaded / event.total) * 100);
-      els.uploadProgress.textContent = `Uploading ${pct}%`;
-    };
+      form.append("files", file);
+    });
 
-    xhr.onload = async () => {
-      if (xhr.status >= 200 && xhr.status < 300) {
-        els.uploadProgress.textContent = "Upload complete";
-        await loadList(state.path);
-      } else {
-        els.uploadProgress.textContent = `Upload failed: ${xhr.responseText}`;
+    return new Promise((resolve, reject) => {
+      const xhr = new XMLHttpRequest();
+      xhr.open("POST", `${basePath}/api/upload`);
+      if (state.me?.csrfToken) {
+        xhr.setRequestHeader("X-CSRF-Token", state.me.csrfToken);
       }
-    };
+      xhr.upload.onprogress = (event) => {
+        if (!event.lengthComputable) {
+          return;
+        }
+        const pct = Math.round((event.loaded / event.total) * 100);
+        els.uploadProgress.textContent = `Uploading ${pct}%`;
+      };
+      xhr.onload = () => {
+        if (xhr.status >= 200 && xhr.status < 300) {
+          try {
+            resolve(JSON.parse(xhr.responseText));
+          } catch {
+            resolve({});
+          }
+        } else {
+          reject(new Error(xhr.responseText));
+        }
+      };
+      xhr.onerror = () => reject(new Error("network error"));
+      xhr.send(form);
+    });
+  }
 
-    xhr.onerror = () => {
-      els.uploadProgress.textContent = "Upload failed due to network error";
-    };
+  // resolveConflicts asks how to handle each file refused under the "ask"
+  // policy and resolves to a map of file name to override; skipped files are
+  // left out.
+  function resolveConflicts(conflicts) {
+    const canReplace = !!state.me?.permissions?.canDelete;
+    const options = [["rename", "Keep both"], ["skip", "Skip"]];
+    if (canReplace) {
+      options.unshift(["overwrite", "Replace"]);
+    }
+    els.conflictList.innerHTML = "";
+    const selects = conflicts.map((conflict) => {
+      const row = document.createElement("div");
+      row.className = "usage-row";
+      const label = document.createElement("span");
+      label.textContent = `${conflict.name} · ${formatSize(conflict.size)} · ${new Date(conflict.modTime).toLocaleString()}`;
+      const select = document.createElement("select");
+      options.forEach(([value, text]) => {
+        const option = document.createElement("option");
+        option.value = value;
+        option.textContent = text;
+        select.appendChild(option);
+      });
+      select.value = "rename";
+      row.appendChild(label);
+      row.appendChild(select);
+      els.conflictList.appendChild(row);
+      return [conflict.name, select];
+    });
 
-    xhr.send(form);
+    return new Promise((resolve) => {
+      const done = (apply) => {
+        els.conflictApply.onclick = null;
+        els.conflictCancel.onclick = null;
+        els.conflictModal.onclose = null;
+        els.conflictModal.close();
+        const choices = new Map();
+        if (apply) {
+          selects.forEach(([name, select]) => {
+            if (select.value !== "skip") {
+              choices.set(name, select.value);
+            }
+          });
+        }
+        resolve(choices);
+      };
+      els.conflictApply.onclick = () => done(true);
+      els.conflictCancel.onclick = () => done(false);
+      els.conflictModal.onclose = () => done(false);
+      els.conflictModal.showModal();
+    });
+  }
+
+  async function uploadFiles(fileList) {
+    if (!fileList.length) {
+      return;
+    }
+    const files = Array.from(fileList);
+    try {
+      let result = await sendUpload(files, null);
+      let errors = result.errors || [];
+      let receipt = result.receipt || [];
+      const asked = (result.conflicts || []).filter((conflict) => conflict.policy === "ask");
+      if (asked.length) {
+        const askedNames = new Set(asked.map((conflict) => conflict.name));
+        errors = errors.filter((msg) => !askedNames.has(msg.replace(/^already exists: /, "")));
+        const choices = await resolveConflicts(asked);
+        const retry = files.filter((file) => choices.has(file.name));
+        if (retry.length) {
+          result = await sendUpload(retry, choices);
+          errors = errors.concat(result.errors || []);
+          receipt = receipt.concat(result.receipt || []);
+        }
+      }
+      let status = errors.length ? `Upload finished with errors: ${errors.join("; ")}` : "Upload complete";
+      if (receipt.length) {
+        status += `. Received: ${receipt.map((item) => `${item.name} (${formatSize(item.size)})`).join(", ")}`;
+      }
+      els.uploadProgress.textContent = status;
+      await loadList(state.path);
+    } catch (err) {
+      els.uploadProgress.textContent = `Upload failed: ${err.message || err}`;
+    }
   }
 
   function navigate(nextPath) {
@@ -625,14 +1414,20 @@
 
   function setupUploadUI() {
     if (!state.me?.permissions?.canUpload) {
+      els.newFolderBtn.classList.add("hidden");
       els.toggleUploadBtn.classList.add("hidden");
       setUploadVisibility(false);
       return;
     }
 
+    els.newFolderBtn.classList.remove("hidden");
     els.toggleUploadBtn.classList.remove("hidden");
     setUploadVisibility(false);
 
+    els.newFolderBtn.addEventListener("click", () => {
+      createFolder().catch((err) => window.alert(String(err.message || err)));
+    });
+
     els.dropZone.addEventListener("dragover", (event) => {
       event.preventDefault();
       els.dropZone.classList.add("drag-over");
@@ -662,20 +1457,101 @@
 
     if (me.authenticated) {
       els.logoutForm.classList.remove("hidden");
+      els.sessionsBtn.classList.remove("hidden");
+      els.passkeysLink.classList.remove("hidden");
+      els.passwordLink.classList.remove("hidden");
     }
     if (me.permissions?.canAdmin) {
       els.adminLink.classList.remove("hidden");
     }
+    if (me.impersonation) {
+      const who = me.authenticated ? me.username : "a guest";
+      const changes = me.impersonation.allowChanges ? "changes are allowed and audited" : "changes are blocked";
+      els.impersonationText.textContent = `${me.impersonation.admin}, you are viewing sharehere as ${who}; ${changes}.`;
+      els.impersonationCsrf.value = me.csrfToken || "";
+      els.impersonationBanner.classList.remove("hidden");
+    }
+    // Users confined to their home directory start there.
+    const home = me.permissions?.home;
+    if (me.permissions?.homeOnly && home && state.path !== home && !state.path.startsWith(`${home}/`)) {
+      state.path = home;
+    }
+  }
+
+  async function loadNotifications() {
+    if (!state.me?.authenticated) {
+      return;
+    }
+    const result = await api("/api/notifications");
+    state.notifications = result.notifications || [];
+    els.notificationsBtn.textContent = result.unread ? `Notifications (${result.unread})` : "Notifications";
+    els.notificationsBtn.classList.toggle("hidden", !state.notifications.length);
+  }
+
+  async function showNotifications() {
+    const items = state.notifications;
+    const lines = items.map((n) => `${n.read_at ? "" : "• "}[${new Date(n.created_at).toLocaleString()}] ${n.message}`);
+    window.alert(lines.length ? lines.join("\n") : "No notifications.");
+    if (items.some((n) => !n.read_at)) {
+      await postJSON("/api/notifications/read", { ids: [] });
+    }
+    await loadNotifications();
+  }
+
+  async function showSessions() {
+    const result = await api("/api/sessions");
+    const sessions = result.sessions || [];
+    const lines = sessions.map((s, i) => {
+      const seen = new Date(s.last_seen_at).toLocaleString();
+      return `${i + 1}. ${s.current ? "(this device) " : ""}${s.ip || "unknown IP"} · last seen ${seen}\n   ${s.user_agent || "unknown browser"}`;
+    });
+    const answer = window.prompt(`Signed-in devices:\n${lines.join("\n")}\n\nEnter a number to sign that device out, or "all" for every other device.`, "");
+    if (!answer) {
+      return;
+    }
+    if (answer.trim().toLowerCase() === "all") {
+      const res = await postJSON("/api/sessions/revoke", { others: true });
+      window.alert(`Signed out ${res.revoked} other device(s).`);
+      return;
+    }
+    const picked = sessions[Number(answer) - 1];
+    if (!picked) {
+      window.alert("No such device.");
+      return;
+    }
+    await postJSON("/api/sessions/revoke", { id: picked.id });
+    if (picked.current) {
+      window.location.href = `${basePath}/login`;
+    }
   }
 
   function bindEvents() {
-    els.searchInput.addEventListener("input", renderEntries);
-    els.sortSelect.addEventListener("change", renderEntries);
+    let searchTimer = 0;
+    els.searchInput.addEventListener("input", () => {
+      window.clearTimeout(searchTimer);
+      searchTimer = window.setTimeout(() => navigate(state.path), 250);
+    });
+    els.sortSelect.addEventListener("change", () => navigate(state.path));
+    window.addEventListener("scroll", scheduleRender, { passive: true });
+    window.addEventListener("resize", scheduleRender);
     els.refreshBtn.addEventListener("click", () => navigate(state.path));
+    els.notificationsBtn.addEventListener("click", () => {
+      showNotifications().catch((err) => window.alert(String(err.message || err)));
+    });
+    els.sessionsBtn.addEventListener("click", () => {
+      showSessions().catch((err) => window.alert(String(err.message || err)));
+    });
 
-    els.showHiddenToggle.addEventListener("change", () => setShowHidden(els.showHiddenToggle.checked));
+    els.showHiddenToggle.addEventListener("change", () => {
+      setShowHidden(els.showHiddenToggle.checked);
+      navigate(state.path);
+    });
     els.listViewBtn.addEventListener("click", () => setViewMode("list"));
     els.gridViewBtn.addEventListener("click", () => setViewMode("grid"));
+    els.archiveFormat.addEventListener("change", () => {
+      setArchiveFormat(els.archiveFormat.value);
+      renderEntries();
+    });
     els.toggleUploadBtn.addEventListener("click", () => setUploadVisibility(!state.uploadVisible));
 
     [els.remoteUser, els.remoteHost, els.remotePort, els.remoteBase].forEach((el) => {
@@ -683,8 +1559,38 @@
       el.addEventListener("blur", saveRemoteParams);
     });
 
+    els.checksumBtn.addEventListener("click", computeChecksum);
+
+    const runSelection = (handler) => () => {
+      handler().catch((err) => window.alert(String(err.message || err)));
+    };
+    els.selectAll.addEventListener("change", () => {
+      currentEntries().forEach((entry) => {
+        if (els.selectAll.checked) {
+          state.selection.add(entry.relPath);
+        } else {
+          state.selection.delete(entry.relPath);
+        }
+      });
+      state.lastSelectedIndex = -1;
+      renderSelection();
+    });
+    els.selZipBtn.addEventListener("click", downloadSelectionZip);
+    els.selMoveBtn.addEventListener("click", runSelection(() => transferEntries("move", selectedPaths())));
+    els.selCopyBtn.addEventListener("click", runSelection(() => transferEntries("copy", selectedPaths())));
+    els.selShareBtn.addEventListener("click", runSelection(shareSelection));
+    els.selDeleteBtn.addEventListener("click", runSelection(deleteSelection));
+    els.selClearBtn.addEventListener("click", clearSelection);
     els.copyCmd.addEventListener("click", () => copyText(els.commandText.textContent || ""));
     els.closeCmd.addEventListener("click", () => els.commandModal.close());
+    els.usageBtn.addEventListener("click", runSelection(() => openUsage(state.path)));
+    els.usageUp.addEventListener("click", runSelection(() => openUsage(state.usagePath.split("/").slice(0, -1).join("/"))));
+    els.usageOpen.addEventListener("click", () => {
+      els.usageModal.close();
+      navigate(state.usagePath);
+    });
+    els.usageClose.addEventListener("click", () => els.usageModal.close());
+    els.versionsClose.addEventListener("click", () => els.versionsModal.close());
 
     document.addEventListener("click", (event) => {
       document.querySelectorAll(".action-menu[open]").forEach((menu) => {
@@ -702,10 +1608,12 @@
 
     setViewMode(state.viewMode);
     setShowHidden(state.showHidden);
+    setArchiveFormat(state.archiveFormat);
 
     await loadMe();
     setupUploadUI();
     await loadList(state.path);
+    loadNotifications().catch(() => {});
   }
 
   init().catch((err) => {
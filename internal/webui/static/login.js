@@ -0,0 +1,47 @@
+(() => {
+  const boot = window.SHAREHERE_BOOT || { basePath: "" };
+  const basePath = boot.basePath === "/" ? "" : (boot.basePath || "");
+  const webauthn = window.SharehereWebAuthn;
+  const els = {
+    passkeyBtn: document.getElementById("passkeyBtn"),
+    passkeyError: document.getElementById("passkeyError"),
+    remember: document.querySelector("input[name=remember]"),
+    csrf: document.querySelector("input[name=_csrf]")
+  };
+  if (!els.passkeyBtn) {
+    return;
+  }
+  if (!webauthn.supported()) {
+    els.passkeyBtn.disabled = true;
+    els.passkeyError.textContent = "This browser does not support passkeys.";
+    return;
+  }
+
+  async function post(path, body) {
+    const res = await fetch(basePath + path, {
+      method: "POST",
+      headers: { "Content-Type": "application/json", "X-CSRF-Token": els.csrf.value },
+      body: body === undefined ? undefined : JSON.stringify(body)
+    });
+    if (!res.ok) {
+      const body = await res.json().catch(() => ({}));
+      throw new Error(body.error || `request failed: ${res.status}`);
+    }
+    return res.json();
+  }
+
+  async function signIn() {
+    els.passkeyError.textContent = "";
+    const remember = els.remember && els.remember.checked ? "?remember=1" : "";
+    const options = await post(`/api/passkeys/login/begin${remember}`);
+    const assertion = await webauthn.get(options);
+    const result = await post("/api/passkeys/login/finish", assertion);
+    window.location.href = result.redirect || `${basePath}/`;
+  }
+
+  els.passkeyBtn.addEventListener("click", () => {
+    signIn().catch((err) => {
+      els.passkeyError.textContent = String(err.message || err).trim();
+    });
+  });
+})();
@@ -0,0 +1,104 @@
+(() => {
+  const boot = window.SHAREHERE_BOOT || { basePath: "" };
+  const basePath = boot.basePath === "/" ? "" : (boot.basePath || "");
+  const webauthn = window.SharehereWebAuthn;
+  const state = { csrfToken: "" };
+  const els = {
+    passkeyName: document.getElementById("passkeyName"),
+    addPasskey: document.getElementById("addPasskey"),
+    passkeyError: document.getElementById("passkeyError"),
+    passkeyRows: document.getElementById("passkeyRows"),
+    logoutCsrf: document.getElementById("logoutCsrf")
+  };
+
+  async function api(path, opts = {}) {
+    const method = (opts.method || "GET").toUpperCase();
+    const headers = Object.assign({ Accept: "application/json" }, opts.headers || {});
+    if (method !== "GET") {
+      headers["X-CSRF-Token"] = state.csrfToken;
+    }
+    const res = await fetch(basePath + path, Object.assign({}, opts, { method, headers }));
+    if (res.status === 401) {
+      window.location.href = `${basePath}/login`;
+      throw new Error("unauthorized");
+    }
+    if (!res.ok) {
+      const body = await res.json().catch(() => ({}));
+      throw new Error(body.error || `request failed: ${res.status}`);
+    }
+    return res.json();
+  }
+
+  function formatDate(value) {
+    return value ? new Date(value).toLocaleString() : "never";
+  }
+
+  function rowForPasskey(k) {
+    const tr = document.createElement("tr");
+    ["name", "created_at", "last_used_at"].forEach((field) => {
+      const td = document.createElement("td");
+      td.textContent = field === "name" ? k.name : formatDate(k[field]);
+      tr.appendChild(td);
+    });
+    const actions = document.createElement("td");
+    const remove = document.createElement("button");
+    remove.className = "button ghost";
+    remove.textContent = "Remove";
+    remove.onclick = async () => {
+      if (!window.confirm(`Remove the passkey "${k.name}"?`)) return;
+      await api("/api/passkeys/delete", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: k.id })
+      });
+      await loadPasskeys();
+    };
+    actions.appendChild(remove);
+    tr.appendChild(actions);
+    return tr;
+  }
+
+  async function loadPasskeys() {
+    const result = await api("/api/passkeys");
+    els.passkeyRows.innerHTML = "";
+    (result.passkeys || []).forEach((k) => els.passkeyRows.appendChild(rowForPasskey(k)));
+  }
+
+  async function addPasskey() {
+    els.passkeyError.textContent = "";
+    const options = await api("/api/passkeys/register/begin", { method: "POST" });
+    const attestation = await webauthn.create(options);
+    const name = encodeURIComponent(els.passkeyName.value.trim());
+    await api(`/api/passkeys/register/finish?name=${name}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(attestation)
+    });
+    els.passkeyName.value = "";
+    if (boot.restricted) {
+      window.location.href = `${basePath}/`;
+      return;
+    }
+    await loadPasskeys();
+  }
+
+  async function init() {
+    const me = await api("/api/me");
+    state.csrfToken = me.csrfToken || "";
+    els.logoutCsrf.value = state.csrfToken;
+    if (!webauthn.supported()) {
+      els.addPasskey.disabled = true;
+      els.passkeyError.textContent = "This browser does not support passkeys.";
+    }
+    els.addPasskey.addEventListener("click", () => {
+      addPasskey().catch((err) => {
+        els.passkeyError.textContent = String(err.message || err).trim();
+      });
+    });
+    await loadPasskeys();
+  }
+
+  init().catch((err) => {
+    els.passkeyError.textContent = String(err.message || err).trim();
+  });
+})();
@@ -0,0 +1,65 @@
+(() => {
+  const boot = window.SHAREHERE_BOOT || { basePath: "" };
+  const basePath = boot.basePath === "/" ? "" : (boot.basePath || "");
+  const state = { csrfToken: "" };
+  const els = {
+    form: document.getElementById("passwordForm"),
+    current: document.getElementById("currentPassword"),
+    next: document.getElementById("newPassword"),
+    repeat: document.getElementById("repeatPassword"),
+    error: document.getElementById("passwordError"),
+    status: document.getElementById("passwordStatus"),
+    logoutCsrf: document.getElementById("logoutCsrf")
+  };
+
+  async function api(path, opts = {}) {
+    const method = (opts.method || "GET").toUpperCase();
+    const headers = Object.assign({ Accept: "application/json" }, opts.headers || {});
+    if (method !== "GET") {
+      headers["X-CSRF-Token"] = state.csrfToken;
+    }
+    const res = await fetch(basePath + path, Object.assign({}, opts, { method, headers }));
+    if (res.status === 401) {
+      window.location.href = `${basePath}/login`;
+      throw new Error("unauthorized");
+    }
+    if (!res.ok) {
+      const body = await res.json().catch(() => ({}));
+      throw new Error(body.error || `request failed: ${res.status}`);
+    }
+    return res.json();
+  }
+
+  async function changePassword() {
+    els.error.textContent = "";
+    if (els.next.value !== els.repeat.value) {
+      throw new Error("The new passwords do not match.");
+    }
+    const result = await api("/api/password", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ current: els.current.value, password: els.next.value })
+    });
+    els.form.reset();
+    els.status.textContent = "Password changed. Your other devices were signed out.";
+    setTimeout(() => {
+      window.location.href = result.redirect || `${basePath}/`;
+    }, 1200);
+  }
+
+  async function init() {
+    const me = await api("/api/me");
+    state.csrfToken = me.csrfToken || "";
+    els.logoutCsrf.value = state.csrfToken;
+    els.form.addEventListener("submit", (ev) => {
+      ev.preventDefault();
+      changePassword().catch((err) => {
+        els.error.textContent = String(err.message || err).trim();
+      });
+    });
+  }
+
+  init().catch((err) => {
+    els.error.textContent = String(err.message || err).trim();
+  });
+})();
@@ -0,0 +1,48 @@
+(() => {
+  const boot = window.SHAREHERE_BOOT || { basePath: "" };
+  const basePath = boot.basePath === "/" ? "" : (boot.basePath || "");
+  const els = {
+    form: document.getElementById("registerForm"),
+    username: document.getElementById("username"),
+    password: document.getElementById("password"),
+    repeat: document.getElementById("repeatPassword"),
+    error: document.getElementById("registerError"),
+    status: document.getElementById("registerStatus")
+  };
+  if (!els.form) {
+    return;
+  }
+
+  async function register() {
+    els.error.textContent = "";
+    if (els.password.value !== els.repeat.value) {
+      throw new Error("The passwords do not match.");
+    }
+    const res = await fetch(`${basePath}/api/register`, {
+      method: "POST",
+      headers: {
+        Accept: "application/json",
+        "Content-Type": "application/json",
+        "X-CSRF-Token": boot.csrfToken || ""
+      },
+      body: JSON.stringify({ invite: boot.invite || "", username: els.username.value, password: els.password.value })
+    });
+    const body = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      throw new Error(body.error || `request failed: ${res.status}`);
+    }
+    if (body.pending) {
+      els.form.hidden = true;
+      els.status.textContent = "Account created. You can sign in once an admin approves it.";
+      return;
+    }
+    window.location.href = body.redirect || `${basePath}/`;
+  }
+
+  els.form.addEventListener("submit", (ev) => {
+    ev.preventDefault();
+    register().catch((err) => {
+      els.error.textContent = String(err.message || err).trim();
+    });
+  });
+})();
@@ -5,10 +5,37 @@
   const dz = document.getElementById("dropZone");
   const fileInput = document.getElementById("fileInput");
   const out = document.getElementById("uploadProgress");
+  const receipt = document.getElementById("uploadReceipt");
+  const nameInput = document.getElementById("uploaderName");
+  const emailInput = document.getElementById("uploaderEmail");
+
+  function formatSize(bytes) {
+    if (bytes < 1024) return `${bytes} B`;
+    const units = ["KB", "MB", "GB", "TB"];
+    let n = bytes / 1024;
+    let i = 0;
+    while (n >= 1024 && i < units.length - 1) {
+      n /= 1024;
+      i += 1;
+    }
+    return `${n.toFixed(1)} ${units[i]}`;
+  }
+
+  // showReceipt lists the files a drop box accepted from this visitor.
+  function showReceipt(result) {
+    (result.receipt || []).forEach((item) => {
+      const li = document.createElement("li");
+      li.textContent = `${new Date().toLocaleString()} · ${item.name} · ${formatSize(item.size)}`;
+      receipt.appendChild(li);
+    });
+  }
 
   function upload(files) {
     if (!files || !files.length) return;
     const form = new FormData();
+    // The uploader's details must precede the files they describe.
+    if (nameInput) form.append("name", nameInput.value);
+    if (emailInput) form.append("email", emailInput.value);
     Array.from(files).forEach((f) => form.append("files", f));
     const xhr = new XMLHttpRequest();
     xhr.open("POST", `${basePath}/s/${encodeURIComponent(token)}/upload`);
@@ -18,7 +45,15 @@
     };
     xhr.onload = () => {
       if (xhr.status >= 200 && xhr.status < 300) {
-        out.textContent = "Upload complete";
+        let result = {};
+        try {
+          result = JSON.parse(xhr.responseText);
+        } catch {
+          result = {};
+        }
+        const errors = result.errors || [];
+        out.textContent = errors.length ? `Upload finished with errors: ${errors.join("; ")}` : "Upload complete";
+        showReceipt(result);
       } else {
         out.textContent = `Upload failed: ${xhr.responseText}`;
       }
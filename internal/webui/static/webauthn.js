@@ -0,0 +1,69 @@
+// Helpers shared by the login and passkeys pages for talking to
+// navigator.credentials, which wants ArrayBuffers where the server speaks
+// base64url.
+window.SharehereWebAuthn = (() => {
+  function toBuffer(value) {
+    const b64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = b64 + "===".slice((b64.length + 3) % 4);
+    return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0)).buffer;
+  }
+
+  function toBase64URL(buffer) {
+    const bytes = new Uint8Array(buffer);
+    let s = "";
+    bytes.forEach((b) => {
+      s += String.fromCharCode(b);
+    });
+    return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+  }
+
+  function decodeDescriptors(list) {
+    return (list || []).map((c) => Object.assign({}, c, { id: toBuffer(c.id) }));
+  }
+
+  function supported() {
+    return Boolean(window.PublicKeyCredential && navigator.credentials);
+  }
+
+  // create runs a registration ceremony with the options from
+  // /api/passkeys/register/begin and returns the response to send back.
+  async function create(options) {
+    const pk = Object.assign({}, options.publicKey);
+    pk.challenge = toBuffer(pk.challenge);
+    pk.user = Object.assign({}, pk.user, { id: toBuffer(pk.user.id) });
+    pk.excludeCredentials = decodeDescriptors(pk.excludeCredentials);
+    const cred = await navigator.credentials.create({ publicKey: pk });
+    return {
+      id: cred.id,
+      rawId: toBase64URL(cred.rawId),
+      type: cred.type,
+      response: {
+        clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+        attestationObject: toBase64URL(cred.response.attestationObject),
+        transports: cred.response.getTransports ? cred.response.getTransports() : []
+      }
+    };
+  }
+
+  // get runs a sign-in ceremony with the options from
+  // /api/passkeys/login/begin and returns the response to send back.
+  async function get(options) {
+    const pk = Object.assign({}, options.publicKey);
+    pk.challenge = toBuffer(pk.challenge);
+    pk.allowCredentials = decodeDescriptors(pk.allowCredentials);
+    const cred = await navigator.credentials.get({ publicKey: pk });
+    return {
+      id: cred.id,
+      rawId: toBase64URL(cred.rawId),
+      type: cred.type,
+      response: {
+        clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+        authenticatorData: toBase64URL(cred.response.authenticatorData),
+        signature: toBase64URL(cred.response.signature),
+        userHandle: cred.response.userHandle ? toBase64URL(cred.response.userHandle) : ""
+      }
+    };
+  }
+
+  return { supported, create, get };
+})();